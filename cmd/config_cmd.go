@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/mailer"
+)
+
+var checkJSON, checkFetchTemplates bool
+
+func configCmd() *cobra.Command {
+	var configCmd = &cobra.Command{
+		Use: "config",
+	}
+
+	configCmd.AddCommand(&configCheckCmd)
+
+	configCheckCmd.Flags().BoolVar(&checkJSON, "json", false, "Print the report as JSON")
+	configCheckCmd.Flags().BoolVar(&checkFetchTemplates, "fetch-templates", false, "Download and compile the email templates")
+
+	return configCmd
+}
+
+var configCheckCmd = cobra.Command{
+	Use:  "check",
+	Long: "Validate the configuration without starting the server. Exits with a non-zero status if errors are found.",
+	Run:  configCheck,
+}
+
+func configCheck(cmd *cobra.Command, args []string) {
+	report := conf.Check(cmd.Context(), configFile, conf.CheckOptions{
+		FetchTemplates:   checkFetchTemplates,
+		DefaultTemplates: mailer.DefaultTemplates(),
+	})
+
+	if checkJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		for _, finding := range report.Findings {
+			fmt.Printf("%-7s [%s] %s\n", finding.Severity, finding.Section, finding.Message)
+		}
+		fmt.Printf("%d error(s), %d warning(s)\n", report.Count(conf.CheckError), report.Count(conf.CheckWarning))
+	}
+
+	if report.HasErrors() {
+		os.Exit(1)
+	}
+}
@@ -4,23 +4,60 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/gobuffalo/pop/v6"
 	"github.com/gobuffalo/pop/v6/logging"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/supabase/auth/internal/conf"
 )
 
-var migrateCmd = cobra.Command{
-	Use:  "migrate",
-	Long: "Migrate database strucutures. This will create new tables and add missing columns and indexes.",
+var migrateDryRun bool
+var migrateSteps int
+
+func migrateCmd() *cobra.Command {
+	var migrateCmd = &cobra.Command{
+		Use:  "migrate",
+		Long: "Migrate database strucutures. This will create new tables and add missing columns and indexes.",
+		Run:  migrate,
+	}
+
+	migrateCmd.AddCommand(&migrateUpCmd, &migrateDownCmd, &migrateStatusCmd)
+
+	migrateUpCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Print the SQL of the pending migrations without applying them")
+	migrateDownCmd.Flags().IntVar(&migrateSteps, "steps", 1, "Number of applied migrations to roll back")
+
+	return migrateCmd
+}
+
+var migrateUpCmd = cobra.Command{
+	Use:  "up",
+	Long: "Apply all pending migrations.",
 	Run:  migrate,
 }
 
-func migrate(cmd *cobra.Command, args []string) {
-	globalConfig := loadGlobalConfig(cmd.Context())
+var migrateDownCmd = cobra.Command{
+	Use:  "down",
+	Long: "Roll back the most recently applied migrations. Fails without changing anything if one of them has no down migration.",
+	Run:  migrateDown,
+}
+
+var migrateStatusCmd = cobra.Command{
+	Use:  "status",
+	Long: "List the migrations and whether they are applied.",
+	Run:  migrateStatus,
+}
+
+// migrationLockName identifies the advisory lock held while migrating, so
+// that replicas starting at the same time apply the migrations one after
+// the other.
+const migrationLockName = "gotrue_migrations"
 
+func openMigrator(globalConfig *conf.GlobalConfiguration) (*pop.Connection, pop.FileMigrator) {
 	if globalConfig.DB.Driver == "" && globalConfig.DB.URL != "" {
 		u, err := url.Parse(globalConfig.DB.URL)
 		if err != nil {
@@ -70,7 +107,6 @@ func migrate(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Fatalf("%+v", errors.Wrap(err, "opening db connection"))
 	}
-	defer db.Close()
 
 	if err := db.Open(); err != nil {
 		log.Fatalf("%+v", errors.Wrap(err, "checking database connection"))
@@ -81,31 +117,287 @@ func migrate(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Fatalf("%+v", errors.Wrap(err, "creating db migrator"))
 	}
-	log.Debugf("before status")
 
-	if log.Level == logrus.DebugLevel {
-		err = mig.Status(os.Stdout)
+	// turn off schema dump
+	mig.SchemaPath = ""
+
+	sort.Sort(mig.UpMigrations)
+	sort.Sort(mig.DownMigrations)
+
+	for _, migrations := range []pop.Migrations{mig.UpMigrations.Migrations, mig.DownMigrations.Migrations} {
+		for i := range migrations {
+			migrations[i].Runner = withoutTransaction(db, migrations[i].Runner)
+		}
+	}
+
+	return db, mig
+}
+
+// noTransactionMarker starts migrations that must run outside of a
+// transaction, such as ones creating indexes concurrently.
+const noTransactionMarker = "-- no-transaction"
+
+// withoutTransaction runs the migrations starting with the
+// noTransactionMarker on db instead of the migration's transaction, one
+// statement at a time, as statements sent together run in a transaction.
+// Other migrations are run by runner.
+func withoutTransaction(db *pop.Connection, runner func(pop.Migration, *pop.Connection) error) func(pop.Migration, *pop.Connection) error {
+	return func(mf pop.Migration, tx *pop.Connection) error {
+		content, err := migrationContent(db, mf)
 		if err != nil {
-			log.Fatalf("%+v", errors.Wrap(err, "migration status"))
+			return err
+		}
+
+		if !strings.HasPrefix(content, noTransactionMarker) {
+			return runner(mf, tx)
 		}
+
+		for _, statement := range strings.Split(content, ";") {
+			if isSQLComment(statement) {
+				continue
+			}
+
+			if err := db.RawQuery(statement).Exec(); err != nil {
+				return fmt.Errorf("error executing %s, sql: %s: %w", mf.Path, statement, err)
+			}
+		}
+
+		return nil
 	}
+}
 
-	// turn off schema dump
-	mig.SchemaPath = ""
+// isSQLComment reports whether statement only holds comments.
+func isSQLComment(statement string) bool {
+	for _, line := range strings.Split(statement, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+
+	return true
+}
 
-	err = mig.Up()
+// withMigrationLock runs fn while holding the migration lock. The lock is
+// a transaction level advisory lock, so it is released even if the process
+// dies while migrating.
+func withMigrationLock(db *pop.Connection, namespace string, fn func() error) error {
+	lock, err := db.NewTransaction()
 	if err != nil {
-		log.Fatalf("%v", errors.Wrap(err, "running db migrations"))
-	} else {
-		log.Infof("GoTrue migrations applied successfully")
+		return errors.Wrap(err, "starting migration lock transaction")
+	}
+	defer func() {
+		_ = lock.TX.Rollback()
+	}()
+
+	logrus.Debugf("Waiting for the migration lock")
+	if err := lock.RawQuery("select pg_advisory_xact_lock(hashtext(?))", migrationLockName+":"+namespace).Exec(); err != nil {
+		return errors.Wrap(err, "acquiring migration lock")
 	}
 
-	log.Debugf("after status")
+	return fn()
+}
 
-	if log.Level == logrus.DebugLevel {
-		err = mig.Status(os.Stdout)
+// appliedMigrations returns the applied versions, or none if the migration
+// table doesn't exist yet.
+func appliedMigrations(db *pop.Connection) (map[string]bool, error) {
+	mtn := db.MigrationTableName()
+
+	var exists bool
+	if err := db.Store.Get(&exists, "select to_regclass($1) is not null", mtn); err != nil {
+		return nil, errors.Wrap(err, "checking migration table")
+	}
+
+	applied := make(map[string]bool)
+	if !exists {
+		return applied, nil
+	}
+
+	var versions []string
+	if err := db.Store.Select(&versions, fmt.Sprintf("select version from %s", mtn)); err != nil {
+		return nil, errors.Wrap(err, "reading applied migrations")
+	}
+
+	for _, version := range versions {
+		applied[version] = true
+	}
+
+	return applied, nil
+}
+
+func migrate(cmd *cobra.Command, args []string) {
+	globalConfig := loadGlobalConfig(cmd.Context())
+	log := logrus.StandardLogger()
+
+	db, mig := openMigrator(globalConfig)
+	defer db.Close()
+
+	if migrateDryRun {
+		if err := printPendingMigrations(db, mig); err != nil {
+			log.Fatalf("%+v", errors.Wrap(err, "printing pending migrations"))
+		}
+		return
+	}
+
+	err := withMigrationLock(db, globalConfig.DB.Namespace, func() error {
+		if log.Level == logrus.DebugLevel {
+			log.Debugf("before status")
+			if err := mig.Status(os.Stdout); err != nil {
+				return errors.Wrap(err, "migration status")
+			}
+		}
+
+		if err := mig.Up(); err != nil {
+			return errors.Wrap(err, "running db migrations")
+		}
+
+		if log.Level == logrus.DebugLevel {
+			log.Debugf("after status")
+			if err := mig.Status(os.Stdout); err != nil {
+				return errors.Wrap(err, "migration status")
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Infof("GoTrue migrations applied successfully")
+}
+
+func printPendingMigrations(db *pop.Connection, mig pop.FileMigrator) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, mf := range mig.UpMigrations.Migrations {
+		if applied[mf.Version] {
+			continue
+		}
+
+		content, err := migrationContent(db, mf)
 		if err != nil {
-			log.Fatalf("%+v", errors.Wrap(err, "migration status"))
+			return err
+		}
+
+		fmt.Printf("-- %s_%s\n%s\n\n", mf.Version, mf.Name, content)
+		pending += 1
+	}
+
+	logrus.Infof("%d pending migration(s)", pending)
+
+	return nil
+}
+
+func migrationContent(db *pop.Connection, mf pop.Migration) (string, error) {
+	f, err := os.Open(mf.Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return pop.MigrationContent(mf, db, f, true)
+}
+
+func migrateDown(cmd *cobra.Command, args []string) {
+	globalConfig := loadGlobalConfig(cmd.Context())
+	log := logrus.StandardLogger()
+
+	if migrateSteps < 1 {
+		log.Fatalf("--steps must be at least 1")
+	}
+
+	db, mig := openMigrator(globalConfig)
+	defer db.Close()
+
+	downs := make(map[string]pop.Migration)
+	for _, mf := range mig.DownMigrations.Migrations {
+		downs[mf.Version] = mf
+	}
+
+	err := withMigrationLock(db, globalConfig.DB.Namespace, func() error {
+		mtn := db.MigrationTableName()
+
+		var versions []string
+		if err := db.Store.Select(&versions, fmt.Sprintf("select version from %s order by version desc limit $1", mtn), migrateSteps); err != nil {
+			return errors.Wrap(err, "reading applied migrations")
 		}
+
+		// every migration is checked before rolling back any of them, so
+		// that the database isn't left half way
+		rollback := make([]pop.Migration, 0, len(versions))
+		for _, version := range versions {
+			mf, ok := downs[version]
+			if !ok {
+				return fmt.Errorf("migration %s has no down migration and can't be rolled back", version)
+			}
+			rollback = append(rollback, mf)
+		}
+
+		for _, mf := range rollback {
+			err := db.Transaction(func(tx *pop.Connection) error {
+				if err := mf.Run(tx); err != nil {
+					return err
+				}
+
+				if err := tx.RawQuery(fmt.Sprintf("delete from %s where version = ?", mtn), mf.Version).Exec(); err != nil {
+					return errors.Wrapf(err, "deleting migration version %s", mf.Version)
+				}
+
+				return nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "rolling back migration %s_%s", mf.Version, mf.Name)
+			}
+
+			log.Infof("Rolled back %s_%s", mf.Version, mf.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func migrateStatus(cmd *cobra.Command, args []string) {
+	globalConfig := loadGlobalConfig(cmd.Context())
+	log := logrus.StandardLogger()
+
+	db, mig := openMigrator(globalConfig)
+	defer db.Close()
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	reversible := make(map[string]bool)
+	for _, mf := range mig.DownMigrations.Migrations {
+		reversible[mf.Version] = true
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "Version\tName\tStatus\tDown")
+	for _, mf := range mig.UpMigrations.Migrations {
+		status := "pending"
+		if applied[mf.Version] {
+			status = "applied"
+		}
+
+		down := "no"
+		if reversible[mf.Version] {
+			down = "yes"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", mf.Version, mf.Name, status, down)
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Fatalf("%+v", err)
 	}
 }
@@ -3,10 +3,14 @@ package cmd
 import (
 	"context"
 	"net"
+	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/supabase/auth/internal/api"
+	"github.com/supabase/auth/internal/auditlog"
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/storage"
 	"github.com/supabase/auth/internal/utilities"
@@ -32,10 +36,111 @@ func serve(ctx context.Context) {
 	}
 	defer db.Close()
 
-	api := api.NewAPIWithVersion(config, db, utilities.Version)
+	if config.AuditLog.Export.Enabled {
+		sink, err := auditlog.NewSink(&config.AuditLog.Export)
+		if err != nil {
+			logrus.WithError(err).Fatal("unable to create audit log export sink")
+		}
+
+		go auditlog.NewExporter(&config.AuditLog.Export, db, sink).Run(ctx)
+	}
+
+	api := api.NewReloadableAPI(config, db, utilities.Version)
+
+	go watchConfig(ctx, api)
+	go api.RunBackgroundJobs(ctx)
 
 	addr := net.JoinHostPort(config.API.Host, config.API.Port)
 	logrus.Infof("GoTrue API started on: %s", addr)
 
 	api.ListenAndServe(ctx, addr)
 }
+
+// reloadConfig is set while serve watches the configuration, to request a
+// reload.
+var reloadConfig atomic.Pointer[func()]
+
+// Reload asks the running command to reload its configuration, as on SIGHUP.
+// It reports false if the command can't reload its configuration and should
+// shut down instead.
+func Reload() bool {
+	reload := reloadConfig.Load()
+	if reload == nil {
+		return false
+	}
+
+	(*reload)()
+
+	return true
+}
+
+// configWatchInterval is how often the env file is checked for changes.
+const configWatchInterval = 10 * time.Second
+
+// watchConfig reloads the configuration when Reload is called or
+// when the env file is modified. Configuration overrides are reloaded
+// periodically, so that changes made through other instances are applied.
+func watchConfig(ctx context.Context, a *api.ReloadableAPI) {
+	log := logrus.WithField("component", "config")
+
+	filename := configFile
+	if filename == "" {
+		filename = ".env"
+	}
+
+	modTime := func() time.Time {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+
+	hup := make(chan struct{}, 1)
+	reload := func() {
+		select {
+		case hup <- struct{}{}:
+		default:
+			// a reload is already pending
+		}
+	}
+	reloadConfig.Store(&reload)
+	defer reloadConfig.Store(nil)
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	lastModified := modTime()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+			log.Info("received SIGHUP, reloading configuration")
+
+		case <-ticker.C:
+			if err := a.ReloadOverrides(ctx); err != nil {
+				log.WithError(err).Error("unable to apply configuration overrides")
+			}
+
+			modified := modTime()
+			if modified.Equal(lastModified) {
+				continue
+			}
+			lastModified = modified
+			log.Infof("%s changed, reloading configuration", filename)
+		}
+
+		config, err := conf.ReloadGlobal(configFile)
+		if err != nil {
+			log.WithError(err).Error("configuration reload rejected, invalid configuration")
+			continue
+		}
+
+		if err := a.Reload(config); err != nil {
+			log.WithError(err).Error("configuration reload rejected, invalid configuration")
+		}
+	}
+}
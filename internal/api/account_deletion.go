@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	mail "github.com/supabase/auth/internal/mailer"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// accountDeletionSweepInterval is how often the API looks for users whose
+// scheduled deletion is due.
+const accountDeletionSweepInterval = time.Minute
+
+// UserDeleteParams are the parameters the UserDelete endpoint accepts.
+type UserDeleteParams struct {
+	Nonce string `json:"nonce"`
+}
+
+// UserDelete schedules the deletion of the user's account after the
+// configured grace period, and signs the user out everywhere. Signing in
+// again before the account is deleted cancels the deletion.
+func (a *API) UserDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.config
+	user := getUser(ctx)
+	session := getSession(ctx)
+
+	params := &UserDeleteParams{}
+	body, err := getBodyBytes(r)
+	if err != nil {
+		return internalServerError("Could not read body").WithInternalError(err)
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, params); err != nil {
+			return badRequestError(ErrorCodeBadJSON, "Could not read params: %v", err)
+		}
+	}
+
+	// a session that verified a second factor is recent proof of identity,
+	// otherwise the user must reauthenticate
+	requireNonce := session == nil || !session.IsAAL2()
+	if requireNonce && params.Nonce == "" {
+		return badRequestError(ErrorCodeReauthenticationNeeded, "Account deletion requires reauthentication")
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if requireNonce {
+			if terr := a.verifyReauthentication(params.Nonce, tx, config, user); terr != nil {
+				return terr
+			}
+		}
+
+		if user.DeletionScheduledAt == nil {
+			if terr := user.ScheduleDeletion(tx, a.Now().Add(config.AccountDeletion.GracePeriod)); terr != nil {
+				return internalServerError("Database error scheduling account deletion").WithInternalError(terr)
+			}
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, user, models.UserDeletionRequestedAction, "", map[string]interface{}{
+			"delete_at": user.DeletionScheduledAt,
+		}); terr != nil {
+			return terr
+		}
+
+		if terr := models.Logout(tx, user.ID); terr != nil {
+			return internalServerError("Error logging out user").WithInternalError(terr)
+		}
+
+		// API keys could be exchanged for tokens without signing in
+		if terr := models.RevokeAPIKeysForUser(tx, user.ID); terr != nil {
+			return internalServerError("Error revoking user's API keys").WithInternalError(terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	a.notifyAccountDeletion(r, user, mail.AccountDeletionScheduled)
+
+	a.clearCookieTokens(config, w)
+	return sendJSON(w, http.StatusOK, user)
+}
+
+// cancelAccountDeletion cancels the scheduled deletion of the user's account.
+func (a *API) cancelAccountDeletion(r *http.Request, tx *storage.Connection, user *models.User) error {
+	deleteAt := user.DeletionScheduledAt
+
+	if terr := user.CancelDeletion(tx); terr != nil {
+		return internalServerError("Database error cancelling account deletion").WithInternalError(terr)
+	}
+
+	return models.NewAuditLogEntry(r, tx, user, models.UserDeletionCancelledAction, "", map[string]interface{}{
+		"delete_at": deleteAt,
+	})
+}
+
+// notifyAccountDeletion emails the user about the deletion of their account.
+// Failures are only logged, as the change has already been recorded in the
+// audit log.
+func (a *API) notifyAccountDeletion(r *http.Request, user *models.User, emailActionType string) {
+	if user.GetEmail() == "" {
+		return
+	}
+
+	if err := a.sendEmail(r, nil, user, emailActionType, "", "", ""); err != nil {
+		observability.GetLogEntry(r).Entry.WithError(err).WithField("email_action_type", emailActionType).Warn("Error sending account deletion notification")
+	}
+}
+
+// deleteScheduledUsers deletes the users whose scheduled deletion is due. It
+// returns the number of deleted users.
+func (a *API) deleteScheduledUsers(r *http.Request) (int, error) {
+	config := a.config.AccountDeletion
+	db := a.db.WithContext(r.Context())
+	log := observability.GetLogEntry(r).Entry
+	now := a.Now()
+
+	users, err := models.FindUsersDueForDeletion(db, now, config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, candidate := range users {
+		var notified *models.User
+
+		err := db.Transaction(func(tx *storage.Connection) error {
+			user, terr := models.LockUserDueForDeletion(tx, candidate.ID, now)
+			if terr != nil {
+				if models.IsNotFoundError(terr) {
+					// signed in since, or is handled by another
+					// instance
+					return nil
+				}
+				return terr
+			}
+
+			if terr := models.NewAuditLogEvent(r, tx, &models.AuditEvent{
+				Action:    models.UserDeletedAction,
+				Actor:     user,
+				ActorType: models.AuditActorSystem,
+				Traits: map[string]interface{}{
+					"reason":       "deletion_requested",
+					"scheduled_at": user.DeletionScheduledAt,
+				},
+			}); terr != nil {
+				return terr
+			}
+
+			// soft deletion obfuscates the email address the
+			// notification is sent to
+			beforeDeletion := *user
+
+			if terr := deleteUser(tx, user, config.SoftDelete); terr != nil {
+				return terr
+			}
+
+			notified = &beforeDeletion
+			return nil
+		})
+		if err != nil {
+			log.WithError(err).WithField("user_id", candidate.ID).Warn("unable to delete user")
+			continue
+		}
+
+		if notified != nil {
+			deleted += 1
+			a.notifyAccountDeletion(r, notified, mail.AccountDeleted)
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/models"
+)
+
+type AccountDeletionTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+}
+
+func TestAccountDeletion(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &AccountDeletionTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *AccountDeletionTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	ts.Config.AccountDeletion = conf.AccountDeletionConfiguration{
+		Enabled:     true,
+		GracePeriod: 14 * 24 * time.Hour,
+		SoftDelete:  true,
+		BatchSize:   100,
+	}
+}
+
+func (ts *AccountDeletionTestSuite) createUser(email string) *models.User {
+	u, err := models.NewUser("", email, "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	now := time.Now()
+	u.EmailConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	return u
+}
+
+func (ts *AccountDeletionTestSuite) deleteUser(token string, params map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	if params != nil {
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(params))
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "http://localhost/user", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *AccountDeletionTestSuite) TestUserDeleteDisabled() {
+	ts.Config.AccountDeletion.Enabled = false
+
+	u := ts.createUser("test@example.com")
+	token, _, err := ts.API.generateAccessToken(httptest.NewRequest(http.MethodPost, "/", nil), ts.API.db, u, nil, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+
+	w := ts.deleteUser(token, nil)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+}
+
+func (ts *AccountDeletionTestSuite) TestUserDeleteWithReauthentication() {
+	u := ts.createUser("test@example.com")
+	session, err := models.NewSession(u.ID, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(session))
+
+	token, _, err := ts.API.generateAccessToken(httptest.NewRequest(http.MethodPost, "/", nil), ts.API.db, u, &session.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+
+	apiKey, _ := models.NewAPIKey(u.ID, "script", "", nil)
+	require.NoError(ts.T(), ts.API.db.Create(apiKey))
+
+	w := ts.deleteUser(token, nil)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), string(ErrorCodeReauthenticationNeeded))
+
+	now := time.Now()
+	u.ReauthenticationToken = crypto.GenerateTokenHash(u.GetEmail(), "123456")
+	u.ReauthenticationSentAt = &now
+	require.NoError(ts.T(), ts.API.db.Update(u))
+
+	w = ts.deleteUser(token, map[string]interface{}{"nonce": "654321"})
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+
+	w = ts.deleteUser(token, map[string]interface{}{"nonce": "123456"})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	u, err = models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), u.DeletionScheduledAt)
+	require.WithinDuration(ts.T(), now.Add(ts.Config.AccountDeletion.GracePeriod), *u.DeletionScheduledAt, time.Minute)
+	require.Nil(ts.T(), u.DeletedAt)
+
+	_, err = models.FindSessionByID(ts.API.db, session.ID, false)
+	require.True(ts.T(), models.IsNotFoundError(err), "expected the user to be signed out")
+
+	keys, err := models.FindAPIKeysByUserID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.Empty(ts.T(), keys, "expected the user's API keys to be revoked")
+}
+
+func (ts *AccountDeletionTestSuite) TestUserDeleteWithAAL2() {
+	u := ts.createUser("test@example.com")
+	session, err := models.NewSession(u.ID, nil)
+	require.NoError(ts.T(), err)
+	aal2 := models.AAL2.String()
+	session.AAL = &aal2
+	require.NoError(ts.T(), ts.API.db.Create(session))
+
+	token, _, err := ts.API.generateAccessToken(httptest.NewRequest(http.MethodPost, "/", nil), ts.API.db, u, &session.ID, models.TOTPSignIn)
+	require.NoError(ts.T(), err)
+
+	w := ts.deleteUser(token, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	u, err = models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), u.DeletionScheduledAt)
+}
+
+func (ts *AccountDeletionTestSuite) TestSignInCancelsDeletion() {
+	u := ts.createUser("test@example.com")
+	require.NoError(ts.T(), u.ScheduleDeletion(ts.API.db, time.Now().Add(time.Hour)))
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email":    "test@example.com",
+		"password": "password",
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token?grant_type=password", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	u, err := models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.Nil(ts.T(), u.DeletionScheduledAt)
+}
+
+func (ts *AccountDeletionTestSuite) TestAPIKeyCancelsDeletion() {
+	ts.Config.APIKeys.Enabled = true
+	defer func() {
+		ts.Config.APIKeys.Enabled = false
+	}()
+
+	u := ts.createUser("test@example.com")
+	apiKey, key := models.NewAPIKey(u.ID, "script", "", nil)
+	require.NoError(ts.T(), ts.API.db.Create(apiKey))
+	require.NoError(ts.T(), u.ScheduleDeletion(ts.API.db, time.Now().Add(time.Hour)))
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"api_key": key,
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token?grant_type=api_key", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	u, err := models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.Nil(ts.T(), u.DeletionScheduledAt)
+}
+
+func (ts *AccountDeletionTestSuite) TestDeleteScheduledUsersWithSendEmailHook() {
+	require.NoError(ts.T(), ts.API.db.RawQuery(`
+		create table if not exists send_email_hook_inputs (input jsonb);
+		create or replace function send_email_hook_test(input jsonb)
+		returns json as $$
+		begin
+			insert into send_email_hook_inputs (input) values (input);
+			return '{}'::json;
+		end; $$ language plpgsql;`).Exec())
+	require.NoError(ts.T(), ts.API.db.RawQuery("delete from send_email_hook_inputs").Exec())
+
+	hook := ts.Config.Hook.SendEmail
+	defer func() {
+		ts.Config.Hook.SendEmail = hook
+	}()
+	ts.Config.Hook.SendEmail = conf.ExtensibilityPointConfiguration{
+		Enabled: true,
+		URI:     "pg-functions://postgres/auth/send_email_hook_test",
+	}
+	require.NoError(ts.T(), ts.Config.Hook.SendEmail.PopulateExtensibilityPoint())
+
+	due := ts.createUser("due@example.com")
+	require.NoError(ts.T(), due.ScheduleDeletion(ts.API.db, time.Now().Add(-time.Minute)))
+
+	// runs outside of any request, like the background job
+	ts.API.sweepScheduledDeletions(context.Background())
+
+	u, err := models.FindUserByID(ts.API.db, due.ID)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), u.DeletedAt, "expected the user to be soft deleted")
+
+	var siteURL string
+	require.NoError(ts.T(), ts.API.db.RawQuery("select input->'email_data'->>'site_url' from send_email_hook_inputs").First(&siteURL))
+	require.Equal(ts.T(), ts.Config.API.ExternalURL, siteURL)
+}
+
+func (ts *AccountDeletionTestSuite) TestDeleteScheduledUsers() {
+	now := time.Now()
+
+	due := ts.createUser("due@example.com")
+	require.NoError(ts.T(), due.ScheduleDeletion(ts.API.db, now.Add(-time.Minute)))
+
+	notDue := ts.createUser("not-due@example.com")
+	require.NoError(ts.T(), notDue.ScheduleDeletion(ts.API.db, now.Add(time.Hour)))
+
+	notScheduled := ts.createUser("not-scheduled@example.com")
+
+	deleted, err := ts.API.deleteScheduledUsers(httptest.NewRequest(http.MethodPost, "/", nil))
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), 1, deleted)
+
+	u, err := models.FindUserByID(ts.API.db, due.ID)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), u.DeletedAt, "expected the user to be soft deleted")
+	require.NotEqual(ts.T(), "due@example.com", u.GetEmail())
+
+	for _, id := range []*models.User{notDue, notScheduled} {
+		u, err := models.FindUserByID(ts.API.db, id.ID)
+		require.NoError(ts.T(), err)
+		require.Nil(ts.T(), u.DeletedAt)
+	}
+
+	// soft deleted users are not deleted again
+	deleted, err = ts.API.deleteScheduledUsers(httptest.NewRequest(http.MethodPost, "/", nil))
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), 0, deleted)
+
+	ts.Config.AccountDeletion.SoftDelete = false
+	require.NoError(ts.T(), notDue.ScheduleDeletion(ts.API.db, now.Add(-time.Minute)))
+
+	// the background job deletes users outside of any request
+	ts.API.sweepScheduledDeletions(context.Background())
+
+	_, err = models.FindUserByID(ts.API.db, notDue.ID)
+	require.True(ts.T(), models.IsNotFoundError(err), "expected the user to be deleted")
+}
@@ -3,7 +3,9 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -104,12 +106,23 @@ func (a *API) adminUsers(w http.ResponseWriter, r *http.Request) error {
 		return badRequestError(ErrorCodeValidationFailed, "Bad Pagination Parameters: %v", err).WithInternalError(err)
 	}
 
-	sortParams, err := sort(r, map[string]bool{models.CreatedAt: true}, []models.SortField{{Name: models.CreatedAt, Dir: models.Descending}})
+	sortFields := adminUsersSortFields
+	if pageParams.Keyset {
+		sortFields = models.KeysetColumns
+	}
+
+	sortParams, err := sort(r, sortFields, []models.SortField{{Name: models.CreatedAt, Dir: models.Descending}})
 	if err != nil {
 		return badRequestError(ErrorCodeValidationFailed, "Bad Sort Parameters: %v", err)
 	}
+	if pageParams.Keyset && len(sortParams.Fields) > 1 {
+		return badRequestError(ErrorCodeValidationFailed, "Bad Sort Parameters: cursor pagination supports only one sort field")
+	}
 
-	filter := r.URL.Query().Get("filter")
+	filter, err := parseAdminUsersFilter(r)
+	if err != nil {
+		return badRequestError(ErrorCodeValidationFailed, "Bad Filter Parameters: %v", err)
+	}
 
 	users, err := models.FindUsersInAudience(db, aud, pageParams, sortParams, filter)
 	if err != nil {
@@ -123,6 +136,77 @@ func (a *API) adminUsers(w http.ResponseWriter, r *http.Request) error {
 	})
 }
 
+var adminUsersSortFields = map[string]bool{
+	models.CreatedAt:    true,
+	models.UpdatedAt:    true,
+	models.LastSignInAt: true,
+	"email":             true,
+	"phone":             true,
+}
+
+// parseAdminUsersFilter reads the structured user filters from the query
+// string. The app_metadata parameter may be repeated and takes the form
+// key:value, where value is decoded as JSON if possible and used as a plain
+// string otherwise.
+func parseAdminUsersFilter(r *http.Request) (*models.UserFilter, error) {
+	params := r.URL.Query()
+	filter := &models.UserFilter{
+		Query:    params.Get("filter"),
+		Provider: params.Get("provider"),
+	}
+
+	bools := map[string]**bool{
+		"confirmed":    &filter.Confirmed,
+		"banned":       &filter.Banned,
+		"is_anonymous": &filter.IsAnonymous,
+		"has_mfa":      &filter.HasMFA,
+	}
+	for name, dst := range bools {
+		if value := params.Get(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a boolean", name)
+			}
+			*dst = &b
+		}
+	}
+
+	times := map[string]**time.Time{
+		"created_after":       &filter.CreatedAfter,
+		"created_before":      &filter.CreatedBefore,
+		"last_sign_in_after":  &filter.LastSignInAfter,
+		"last_sign_in_before": &filter.LastSignInBefore,
+	}
+	for name, dst := range times {
+		if value := params.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*dst = &t
+		}
+	}
+
+	for _, pair := range params["app_metadata"] {
+		key, value, ok := strings.Cut(pair, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("app_metadata must be of the form key:value")
+		}
+
+		if filter.AppMetaData == nil {
+			filter.AppMetaData = make(map[string]interface{})
+		}
+
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+			decoded = value
+		}
+		filter.AppMetaData[key] = decoded
+	}
+
+	return filter, nil
+}
+
 // adminUserGet returns information about a single user
 func (a *API) adminUserGet(w http.ResponseWriter, r *http.Request) error {
 	user := getUser(r.Context())
@@ -497,40 +581,46 @@ func (a *API) adminUserDelete(w http.ResponseWriter, r *http.Request) error {
 			return internalServerError("Error recording audit log entry").WithInternalError(terr)
 		}
 
-		if params.ShouldSoftDelete {
-			if user.DeletedAt != nil {
-				// user has been soft deleted already
-				return nil
-			}
-			if terr := user.SoftDeleteUser(tx); terr != nil {
-				return internalServerError("Error soft deleting user").WithInternalError(terr)
-			}
+		return deleteUser(tx, user, params.ShouldSoftDelete)
+	})
+	if err != nil {
+		return err
+	}
 
-			if terr := user.SoftDeleteUserIdentities(tx); terr != nil {
-				return internalServerError("Error soft deleting user identities").WithInternalError(terr)
-			}
+	return sendJSON(w, http.StatusOK, map[string]interface{}{})
+}
 
-			// hard delete all associated factors
-			if terr := models.DeleteFactorsByUserId(tx, user.ID); terr != nil {
-				return internalServerError("Error deleting user's factors").WithInternalError(terr)
-			}
-			// hard delete all associated sessions
-			if terr := models.Logout(tx, user.ID); terr != nil {
-				return internalServerError("Error deleting user's sessions").WithInternalError(terr)
-			}
-		} else {
-			if terr := tx.Destroy(user); terr != nil {
-				return internalServerError("Database error deleting user").WithInternalError(terr)
-			}
+// deleteUser deletes the user, or soft deletes it which keeps the user but
+// obfuscates its personal information and removes its factors and sessions.
+func deleteUser(tx *storage.Connection, user *models.User, softDelete bool) error {
+	if !softDelete {
+		if terr := tx.Destroy(user); terr != nil {
+			return internalServerError("Database error deleting user").WithInternalError(terr)
 		}
+		return nil
+	}
 
+	if user.DeletedAt != nil {
+		// user has been soft deleted already
 		return nil
-	})
-	if err != nil {
-		return err
+	}
+	if terr := user.SoftDeleteUser(tx); terr != nil {
+		return internalServerError("Error soft deleting user").WithInternalError(terr)
 	}
 
-	return sendJSON(w, http.StatusOK, map[string]interface{}{})
+	if terr := user.SoftDeleteUserIdentities(tx); terr != nil {
+		return internalServerError("Error soft deleting user identities").WithInternalError(terr)
+	}
+
+	// hard delete all associated factors
+	if terr := models.DeleteFactorsByUserId(tx, user.ID); terr != nil {
+		return internalServerError("Error deleting user's factors").WithInternalError(terr)
+	}
+	// hard delete all associated sessions
+	if terr := models.Logout(tx, user.ID); terr != nil {
+		return internalServerError("Error deleting user's sessions").WithInternalError(terr)
+	}
+	return nil
 }
 
 func (a *API) adminUserDeleteFactor(w http.ResponseWriter, r *http.Request) error {
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gofrs/uuid"
 	jwt "github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -225,6 +227,142 @@ func (ts *AdminTestSuite) TestAdminUsers_FilterName() {
 	assert.Equal(ts.T(), "test1@example.com", data.Users[0].GetEmail())
 }
 
+// TestAdminUsers_StructuredFilters tests API /admin/users route with structured filters
+func (ts *AdminTestSuite) TestAdminUsers_StructuredFilters() {
+	confirmed, err := models.NewUser("", "confirmed@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err, "Error making new user")
+	confirmed.AppMetaData = map[string]interface{}{"plan": "pro", "beta": true}
+	require.NoError(ts.T(), ts.API.db.Create(confirmed), "Error creating user")
+	require.NoError(ts.T(), confirmed.Confirm(ts.API.db))
+
+	banned, err := models.NewUser("", "banned@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err, "Error making new user")
+	require.NoError(ts.T(), ts.API.db.Create(banned), "Error creating user")
+	require.NoError(ts.T(), banned.Ban(ts.API.db, time.Hour))
+
+	anonymous, err := models.NewUser("", "", "", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err, "Error making new user")
+	anonymous.IsAnonymous = true
+	require.NoError(ts.T(), ts.API.db.Create(anonymous), "Error creating user")
+
+	cases := []struct {
+		desc     string
+		query    string
+		expected []string
+	}{
+		{
+			desc:     "Confirmed",
+			query:    "confirmed=true",
+			expected: []string{"confirmed@example.com"},
+		},
+		{
+			desc:     "Banned",
+			query:    "banned=true",
+			expected: []string{"banned@example.com"},
+		},
+		{
+			desc:     "Anonymous",
+			query:    "is_anonymous=true",
+			expected: []string{""},
+		},
+		{
+			desc:     "App metadata string",
+			query:    "app_metadata=plan:pro",
+			expected: []string{"confirmed@example.com"},
+		},
+		{
+			desc:     "App metadata JSON",
+			query:    "app_metadata=beta:true&app_metadata=plan:pro",
+			expected: []string{"confirmed@example.com"},
+		},
+		{
+			desc:     "Created in the future",
+			query:    "created_after=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			expected: []string{},
+		},
+		{
+			desc:     "Without MFA",
+			query:    "has_mfa=false&banned=false&is_anonymous=false",
+			expected: []string{"confirmed@example.com"},
+		},
+	}
+
+	for _, c := range cases {
+		ts.Run(c.desc, func() {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/admin/users?"+c.query, nil)
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+			ts.API.handler.ServeHTTP(w, req)
+			require.Equal(ts.T(), http.StatusOK, w.Code)
+
+			data := AdminListUsersResponse{}
+			require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+
+			emails := []string{}
+			for _, u := range data.Users {
+				emails = append(emails, u.GetEmail())
+			}
+			assert.ElementsMatch(ts.T(), c.expected, emails)
+		})
+	}
+}
+
+// TestAdminUsers_InvalidFilters tests API /admin/users route with malformed filters
+func (ts *AdminTestSuite) TestAdminUsers_InvalidFilters() {
+	for _, query := range []string{
+		"confirmed=maybe",
+		"created_after=yesterday",
+		"app_metadata=plan",
+		"cursor=&page=2",
+		"cursor=&sort=email",
+		"cursor=not-a-cursor",
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/users?"+query, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+		ts.API.handler.ServeHTTP(w, req)
+		assert.Equal(ts.T(), http.StatusBadRequest, w.Code, query)
+	}
+}
+
+// TestAdminUsers_CursorPagination tests API /admin/users route with keyset pagination
+func (ts *AdminTestSuite) TestAdminUsers_CursorPagination() {
+	for i, email := range []string{"test1@example.com", "test2@example.com", "test3@example.com"} {
+		u, err := models.NewUser("", email, "test", ts.Config.JWT.Aud, nil)
+		require.NoError(ts.T(), err, "Error making new user")
+		u.CreatedAt = time.Now().Add(time.Duration(i) * time.Minute)
+		require.NoError(ts.T(), ts.API.db.Create(u), "Error creating user")
+	}
+
+	emails := []string{}
+	next := "/admin/users?per_page=2&sort=created_at+asc&cursor="
+	for next != "" {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, next, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusOK, w.Code)
+		require.Empty(ts.T(), w.Header().Get("X-Total-Count"))
+
+		data := AdminListUsersResponse{}
+		require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+		for _, u := range data.Users {
+			emails = append(emails, u.GetEmail())
+		}
+
+		next = ""
+		if link := w.Header().Get("Link"); link != "" {
+			require.True(ts.T(), strings.HasSuffix(link, `>; rel="next"`))
+			next = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+	}
+
+	assert.Equal(ts.T(), []string{"test1@example.com", "test2@example.com", "test3@example.com"}, emails)
+}
+
 // TestAdminUserCreate tests API /admin/user route (POST)
 func (ts *AdminTestSuite) TestAdminUserCreate() {
 	cases := []struct {
@@ -822,3 +960,137 @@ func (ts *AdminTestSuite) TestAdminUserUpdateFactor() {
 	}
 
 }
+
+// TestAdminUserImpersonate tests API /admin/users/<user_id>/impersonate
+func (ts *AdminTestSuite) TestAdminUserImpersonate() {
+	u, err := models.NewUser("", "test-impersonate@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err, "Error making new user")
+	require.NoError(ts.T(), ts.API.db.Create(u), "Error creating user")
+
+	impersonate := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/impersonate", u.ID), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	ts.Config.Impersonation.Enabled = false
+	require.Equal(ts.T(), http.StatusNotFound, impersonate().Code)
+
+	ts.Config.Impersonation.Enabled = true
+	ts.Config.Impersonation.Duration = 10 * time.Minute
+	defer func() {
+		ts.Config.Impersonation.Enabled = false
+	}()
+
+	w := impersonate()
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	var token AccessTokenResponse
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&token))
+	require.Empty(ts.T(), token.RefreshToken)
+	require.LessOrEqual(ts.T(), token.ExpiresAt, time.Now().Add(10*time.Minute).Unix())
+
+	claims := &AccessTokenClaims{}
+	_, err = jwt.NewParser().ParseWithClaims(token.Token, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(ts.Config.JWT.Secret), nil
+	})
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), u.ID.String(), claims.Subject)
+	require.NotNil(ts.T(), claims.Actor)
+	require.Equal(ts.T(), "supabase_admin", claims.Actor.Subject)
+
+	// the session can't change credentials
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"password": "new-password",
+	}))
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/user", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token))
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	// but it can be revoked by logging out
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token))
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code)
+
+	sessionID, err := uuid.FromString(claims.SessionId)
+	require.NoError(ts.T(), err)
+	_, err = models.FindSessionByID(ts.API.db, sessionID, false)
+	require.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func (ts *AdminTestSuite) TestAdminScopes() {
+	u, err := models.NewUser("", "test-scopes@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role:  "supabase_admin",
+		Scope: "users:read",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+
+	cases := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/admin/users", http.StatusOK},
+		{http.MethodGet, fmt.Sprintf("/admin/users/%s", u.ID), http.StatusOK},
+		{http.MethodGet, fmt.Sprintf("/admin/users/%s/factors", u.ID), http.StatusOK},
+		{http.MethodPut, fmt.Sprintf("/admin/users/%s", u.ID), http.StatusForbidden},
+		{http.MethodDelete, fmt.Sprintf("/admin/users/%s", u.ID), http.StatusForbidden},
+		{http.MethodPost, fmt.Sprintf("/admin/users/%s/impersonate", u.ID), http.StatusForbidden},
+		{http.MethodPost, "/admin/generate_link", http.StatusForbidden},
+		{http.MethodGet, "/admin/audit", http.StatusForbidden},
+		{http.MethodGet, "/admin/sso/providers", http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		ts.Run(c.method+" "+c.path, func() {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(c.method, c.path, bytes.NewBufferString("{}"))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			ts.API.handler.ServeHTTP(w, req)
+			require.Equal(ts.T(), c.code, w.Code, w.Body.String())
+
+			if c.code == http.StatusForbidden {
+				data := HTTPError{}
+				require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+				require.Equal(ts.T(), ErrorCodeInsufficientScope, data.ErrorCode)
+			}
+		})
+	}
+
+	// the user hasn't been deleted
+	_, err = models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+
+	// generated links sign the user in, so they need the impersonate scope
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role:  "supabase_admin",
+		Scope: "users:write",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"type":  "magiclink",
+		"email": u.GetEmail(),
+	}))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/admin/generate_link", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code, w.Body.String())
+}
@@ -2,12 +2,20 @@ package api
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/gofrs/uuid"
+	"github.com/supabase/auth/internal/hooks"
 	"github.com/supabase/auth/internal/metering"
 	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
 )
 
+// anonymousUserSweepInterval is how often the API looks for anonymous users
+// to remind or delete when the anonymous user expiry hook is enabled.
+const anonymousUserSweepInterval = time.Minute
+
 func (a *API) SignupAnonymously(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	config := a.config
@@ -35,6 +43,16 @@ func (a *API) SignupAnonymously(w http.ResponseWriter, r *http.Request) error {
 
 	var token *AccessTokenResponse
 	err = db.Transaction(func(tx *storage.Connection) error {
+		if maxSessions := config.External.AnonymousUsers.MaxSessionsPerIP; maxSessions > 0 && grantParams.IP != "" {
+			sessions, terr := models.CountActiveAnonymousSessionsByIP(tx, grantParams.IP)
+			if terr != nil {
+				return terr
+			}
+			if sessions >= maxSessions {
+				return tooManyRequestsError(ErrorCodeOverAnonymousSessionLimit, "Too many anonymous sessions from this IP address")
+			}
+		}
+
 		var terr error
 		newUser, terr = a.signupNewUser(tx, newUser)
 		if terr != nil {
@@ -44,15 +62,275 @@ func (a *API) SignupAnonymously(w http.ResponseWriter, r *http.Request) error {
 		if terr != nil {
 			return terr
 		}
+		if terr := models.NewAuditLogEntry(r, tx, newUser, models.UserSignedUpAction, "", map[string]interface{}{
+			"provider": params.Provider,
+		}); terr != nil {
+			return terr
+		}
 		if terr := a.setCookieTokens(config, token, false, w); terr != nil {
 			return terr
 		}
 		return nil
 	})
 	if err != nil {
+		if httpErr, ok := err.(*HTTPError); ok {
+			return httpErr
+		}
 		return internalServerError("Database error creating anonymous user").WithInternalError(err)
 	}
 
 	metering.RecordLogin("anonymous", newUser.ID)
 	return sendJSON(w, http.StatusOK, token)
 }
+
+// MergeAnonymousUserParams are the parameters of MergeAnonymousUser.
+type MergeAnonymousUserParams struct {
+	AnonymousAccessToken string `json:"anonymous_access_token"`
+}
+
+// MergeAnonymousUser merges an anonymous user into the signed in user, for
+// when an anonymous user signs in to an account that already exists. The
+// anonymous user's identities and user metadata are moved to the signed in
+// user, the user_merged hook lets the application reassign its own data,
+// and the anonymous user is then deleted.
+func (a *API) MergeAnonymousUser(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := a.config
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+
+	params := &MergeAnonymousUserParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.AnonymousAccessToken == "" {
+		return badRequestError(ErrorCodeValidationFailed, "anonymous_access_token is required")
+	}
+
+	anonymousCtx, err := a.parseJWTClaims(params.AnonymousAccessToken, r)
+	if err != nil {
+		return err
+	}
+	claims := getClaims(anonymousCtx)
+
+	if !claims.IsAnonymous {
+		return unprocessableEntityError(ErrorCodeUserNotAnonymous, "Only anonymous users can be merged")
+	}
+
+	if claims.Confirmation != nil {
+		// the proof of possession can't be checked for a token sent
+		// in the body
+		return unprocessableEntityError(ErrorCodeInvalidDPoPProof, "Access tokens bound to a DPoP key can't be merged")
+	}
+
+	if aud, _ := claims.GetAudience(); len(aud) == 0 || aud[0] != user.Aud {
+		return forbiddenError(ErrorCodeUnexpectedAudience, "Token audience doesn't match the user's audience")
+	}
+
+	anonymousUserID, err := uuid.FromString(claims.Subject)
+	if err != nil {
+		return badRequestError(ErrorCodeBadJWT, "invalid claim: sub claim must be a UUID").WithInternalError(err)
+	}
+
+	if anonymousUserID == user.ID {
+		return unprocessableEntityError(ErrorCodeUserNotAnonymous, "A user can't be merged into itself")
+	}
+
+	sessionID, err := uuid.FromString(claims.SessionId)
+	if err != nil {
+		return forbiddenError(ErrorCodeBadJWT, "invalid claim: session_id claim must be a UUID").WithInternalError(err)
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		anonymousUser, terr := models.FindUserByID(tx, anonymousUserID)
+		if terr != nil {
+			if models.IsNotFoundError(terr) {
+				return forbiddenError(ErrorCodeUserNotFound, "User from sub claim in JWT does not exist")
+			}
+			return internalServerError("Database error finding user").WithInternalError(terr)
+		}
+
+		if !anonymousUser.IsAnonymous {
+			return unprocessableEntityError(ErrorCodeUserNotAnonymous, "Only anonymous users can be merged")
+		}
+
+		if _, terr := models.FindSessionByID(tx, sessionID, false); terr != nil {
+			if models.IsNotFoundError(terr) {
+				return forbiddenError(ErrorCodeSessionNotFound, "Session from session_id claim in JWT does not exist")
+			}
+			return internalServerError("Database error finding session").WithInternalError(terr)
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, user, models.UserMergedAction, "", map[string]interface{}{
+			"anonymous_user_id": anonymousUser.ID,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		if terr := user.MergeAnonymousUser(tx, anonymousUser); terr != nil {
+			return internalServerError("Database error merging users").WithInternalError(terr)
+		}
+
+		if config.Hook.UserMerged.Enabled {
+			input := hooks.UserMergedInput{
+				AnonymousUserID: anonymousUser.ID,
+				UserID:          user.ID,
+			}
+			output := hooks.UserMergedOutput{}
+			if terr := a.invokeHook(tx, r, &input, &output, config.Hook.UserMerged.URI); terr != nil {
+				return terr
+			}
+		}
+
+		if terr := tx.Destroy(anonymousUser); terr != nil {
+			return internalServerError("Database error deleting anonymous user").WithInternalError(terr)
+		}
+
+		if terr := tx.Reload(user); terr != nil {
+			return internalServerError("Database error loading user").WithInternalError(terr)
+		}
+
+		if terr := tx.Load(user, "Identities"); terr != nil {
+			return internalServerError("Database error loading user identities").WithInternalError(terr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, user)
+}
+
+// expireAnonymousUsers calls the anonymous user expiry hook for anonymous
+// users entering the reminder period, and deletes anonymous users that
+// haven't been active for the retention period unless the hook rejects it.
+// It returns the number of deleted users.
+func (a *API) expireAnonymousUsers(r *http.Request) (int, error) {
+	config := a.config
+	anonymous := config.External.AnonymousUsers
+	hookURI := config.Hook.AnonymousUserExpiry.URI
+	db := a.db.WithContext(r.Context())
+	log := observability.GetLogEntry(r).Entry
+	now := a.Now()
+
+	input := func(tx *storage.Connection, user *models.User, event string) (*hooks.AnonymousUserExpiryInput, error) {
+		lastActiveAt, err := user.LastActiveAt(tx)
+		if err != nil {
+			return nil, err
+		}
+		return &hooks.AnonymousUserExpiryInput{
+			UserID:       user.ID,
+			Event:        event,
+			CreatedAt:    user.CreatedAt,
+			LastActiveAt: lastActiveAt,
+			DeleteAt:     lastActiveAt.Add(anonymous.Retention),
+		}, nil
+	}
+
+	if anonymous.ReminderBefore > 0 {
+		remindBefore := now.Add(anonymous.ReminderBefore - anonymous.Retention)
+		users, err := models.FindAnonymousUsersToRemind(db, remindBefore, anonymous.CleanupBatchSize)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, candidate := range users {
+			err := db.Transaction(func(tx *storage.Connection) error {
+				user, terr := models.LockAnonymousUserToRemind(tx, candidate.ID, remindBefore)
+				if terr != nil {
+					if models.IsNotFoundError(terr) {
+						// became active, or is reminded by
+						// another instance
+						return nil
+					}
+					return terr
+				}
+
+				reminder, terr := input(tx, user, hooks.AnonymousUserExpiryReminder)
+				if terr != nil {
+					return terr
+				}
+
+				output := hooks.AnonymousUserExpiryOutput{}
+				if terr := a.invokeHook(nil, r, reminder, &output, hookURI); terr != nil {
+					log.WithError(terr).WithField("user_id", user.ID).Warn("anonymous user expiry hook failed to remind")
+					return nil
+				}
+
+				return user.MarkAnonymousUserReminded(tx, now)
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	lastActiveBefore := now.Add(-anonymous.Retention)
+	users, err := models.FindExpiredAnonymousUsers(db, lastActiveBefore, anonymous.CleanupBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, candidate := range users {
+		var userDeleted bool
+
+		err := db.Transaction(func(tx *storage.Connection) error {
+			user, terr := models.LockExpiredAnonymousUser(tx, candidate.ID, lastActiveBefore)
+			if terr != nil {
+				if models.IsNotFoundError(terr) {
+					// became active, or is handled by
+					// another instance
+					return nil
+				}
+				return terr
+			}
+
+			deletion, terr := input(tx, user, hooks.AnonymousUserExpiryDeletion)
+			if terr != nil {
+				return terr
+			}
+
+			output := hooks.AnonymousUserExpiryOutput{}
+			if terr := a.invokeHook(tx, r, deletion, &output, hookURI); terr != nil {
+				return terr
+			}
+
+			if output.Decision == hooks.HookRejection {
+				return user.RetainAnonymousUser(tx, now.Add(anonymous.Retention))
+			}
+
+			if terr := models.NewAuditLogEvent(r, tx, &models.AuditEvent{
+				Action:    models.UserDeletedAction,
+				Actor:     user,
+				ActorType: models.AuditActorSystem,
+				Traits: map[string]interface{}{
+					"reason":         "anonymous_user_expired",
+					"last_active_at": deletion.LastActiveAt,
+				},
+			}); terr != nil {
+				return terr
+			}
+
+			if terr := tx.Destroy(user); terr != nil {
+				return terr
+			}
+
+			userDeleted = true
+			return nil
+		})
+		if err != nil {
+			log.WithError(err).WithField("user_id", candidate.ID).Warn("unable to expire anonymous user")
+			continue
+		}
+
+		if userDeleted {
+			deleted += 1
+		}
+	}
+
+	return deleted, nil
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid"
 	jwt "github.com/golang-jwt/jwt/v5"
@@ -14,8 +15,10 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/hooks"
 	mail "github.com/supabase/auth/internal/mailer"
 	"github.com/supabase/auth/internal/models"
+	"gopkg.in/h2non/gock.v1"
 )
 
 type AnonymousTestSuite struct {
@@ -306,3 +309,250 @@ func (ts *AnonymousTestSuite) TestAdminUpdateAnonymousUser() {
 		})
 	}
 }
+
+func (ts *AnonymousTestSuite) TestMergeAnonymousUser() {
+	ts.Config.External.AnonymousUsers.Enabled = true
+
+	signIn := func(path string, body map[string]interface{}) *AccessTokenResponse {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+		req := httptest.NewRequest(http.MethodPost, path, &buffer)
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+		token := &AccessTokenResponse{}
+		require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+		return token
+	}
+
+	merge := func(accessToken, anonymousAccessToken string) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"anonymous_access_token": anonymousAccessToken,
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "/user/merge", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, map[string]interface{}{
+		"name": "Permanent",
+	})
+	require.NoError(ts.T(), err)
+	now := time.Now()
+	u.EmailConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	i, err := models.NewIdentity(u, "email", map[string]interface{}{
+		"sub":   u.ID.String(),
+		"email": u.GetEmail(),
+	})
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(i))
+
+	anonymous := signIn("/signup", map[string]interface{}{
+		"data": map[string]interface{}{
+			"name": "Anonymous",
+			"cart": "cart-id",
+		},
+	})
+	permanent := signIn("/token?grant_type=password", map[string]interface{}{
+		"email":    "test@example.com",
+		"password": "password",
+	})
+
+	// a permanent user can't be merged
+	w := merge(anonymous.Token, permanent.Token)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	other := signIn("/token?grant_type=password", map[string]interface{}{
+		"email":    "test@example.com",
+		"password": "password",
+	})
+	w = merge(permanent.Token, other.Token)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+
+	w = merge(permanent.Token, anonymous.Token)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	data := &models.User{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), u.ID, data.ID)
+	require.Equal(ts.T(), "Permanent", data.UserMetaData["name"])
+	require.Equal(ts.T(), "cart-id", data.UserMetaData["cart"])
+
+	_, err = models.FindUserByID(ts.API.db, anonymous.User.ID)
+	require.True(ts.T(), models.IsNotFoundError(err), "expected the anonymous user to be deleted")
+
+	logs, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{
+		Actions: []string{string(models.UserMergedAction)},
+	}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), logs, 1)
+
+	// the anonymous user can't be merged twice
+	w = merge(permanent.Token, anonymous.Token)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+}
+
+func (ts *AnonymousTestSuite) TestMaxSessionsPerIP() {
+	ts.Config.External.AnonymousUsers.Enabled = true
+	ts.Config.External.AnonymousUsers.MaxSessionsPerIP = 2
+	defer func() {
+		ts.Config.External.AnonymousUsers.MaxSessionsPerIP = 0
+	}()
+
+	signup := func(ip string) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{}))
+
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/signup", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("My-Custom-Header", "10.0.0.1")
+		req.RemoteAddr = ip + ":1234"
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(ts.T(), http.StatusOK, signup("192.0.2.10").Code)
+	require.Equal(ts.T(), http.StatusOK, signup("192.0.2.10").Code)
+
+	w := signup("192.0.2.10")
+	require.Equal(ts.T(), http.StatusTooManyRequests, w.Code)
+	require.Contains(ts.T(), w.Body.String(), string(ErrorCodeOverAnonymousSessionLimit))
+
+	require.Equal(ts.T(), http.StatusOK, signup("192.0.2.11").Code)
+}
+
+func (ts *AnonymousTestSuite) TestExpireAnonymousUsers() {
+	originalAnonymousUsers := ts.Config.External.AnonymousUsers
+	originalHook := ts.Config.Hook.AnonymousUserExpiry
+
+	ts.Config.External.AnonymousUsers.Enabled = true
+	ts.Config.External.AnonymousUsers.Retention = 30 * 24 * time.Hour
+	ts.Config.External.AnonymousUsers.ReminderBefore = 7 * 24 * time.Hour
+	ts.Config.External.AnonymousUsers.CleanupBatchSize = 100
+	ts.Config.Hook.AnonymousUserExpiry = conf.ExtensibilityPointConfiguration{
+		Enabled:         true,
+		URI:             "http://localhost:54321/functions/v1/anonymous-user-expiry",
+		HTTPHookSecrets: []string{"v1,whsec_aWxpa2VzdXBhYmFzZXZlcnltdWNoYW5kaWhvcGV5b3Vkb3Rvbw=="},
+	}
+
+	defer func() {
+		ts.Config.External.AnonymousUsers = originalAnonymousUsers
+		ts.Config.Hook.AnonymousUserExpiry = originalHook
+		gock.OffAll()
+	}()
+
+	models.TruncateAll(ts.API.db)
+
+	createAnonymousUser := func(lastActiveAt time.Time) *models.User {
+		u, err := models.NewUser("", "", "", ts.Config.JWT.Aud, nil)
+		require.NoError(ts.T(), err)
+		u.IsAnonymous = true
+		u.LastSignInAt = &lastActiveAt
+		require.NoError(ts.T(), ts.API.db.Create(u))
+		return u
+	}
+
+	var inputs []hooks.AnonymousUserExpiryInput
+	hook := func(response map[string]interface{}) {
+		gock.New(ts.Config.Hook.AnonymousUserExpiry.URI).
+			Post("/").
+			MatchType("json").
+			AddMatcher(func(req *http.Request, _ *gock.Request) (bool, error) {
+				var input hooks.AnonymousUserExpiryInput
+				err := json.NewDecoder(req.Body).Decode(&input)
+				inputs = append(inputs, input)
+				return true, err
+			}).
+			Reply(http.StatusOK).
+			JSON(response)
+	}
+
+	now := time.Now()
+
+	// signed in long ago, but has kept refreshing their session since
+	active := createAnonymousUser(now.Add(-40 * 24 * time.Hour))
+	token, err := models.GrantAuthenticatedUser(ts.API.db, active, models.GrantParams{})
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.RawQuery("update users set last_sign_in_at = ?, created_at = ? where id = ?", now.Add(-40*24*time.Hour), now.Add(-40*24*time.Hour), active.ID).Exec())
+	require.NoError(ts.T(), ts.API.db.RawQuery("update sessions set created_at = ? where user_id = ?", now.Add(-40*24*time.Hour), active.ID).Exec())
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"refresh_token": token.Token,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token?grant_type=refresh_token", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	reminded := createAnonymousUser(now.Add(-25 * 24 * time.Hour))
+
+	hook(map[string]interface{}{})
+	deleted, err := ts.API.expireAnonymousUsers(httptest.NewRequest(http.MethodPost, "/", nil))
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), 0, deleted)
+	require.Len(ts.T(), inputs, 1)
+	require.Equal(ts.T(), reminded.ID, inputs[0].UserID)
+	require.Equal(ts.T(), hooks.AnonymousUserExpiryReminder, inputs[0].Event)
+
+	u, err := models.FindUserByID(ts.API.db, reminded.ID)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), u.AnonymousRemindedAt)
+
+	// reminders are only sent once
+	inputs = nil
+	deleted, err = ts.API.expireAnonymousUsers(httptest.NewRequest(http.MethodPost, "/", nil))
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), 0, deleted)
+	require.Empty(ts.T(), inputs)
+
+	require.NoError(ts.T(), ts.API.db.RawQuery("delete from users where id = ?", reminded.ID).Exec())
+
+	// the hook can reject the deletion
+	retained := createAnonymousUser(now.Add(-40 * 24 * time.Hour))
+	require.NoError(ts.T(), retained.MarkAnonymousUserReminded(ts.API.db, now.Add(-time.Hour)))
+
+	inputs = nil
+	hook(map[string]interface{}{"decision": "reject"})
+	deleted, err = ts.API.expireAnonymousUsers(httptest.NewRequest(http.MethodPost, "/", nil))
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), 0, deleted)
+	require.Len(ts.T(), inputs, 1)
+	require.Equal(ts.T(), hooks.AnonymousUserExpiryDeletion, inputs[0].Event)
+
+	u, err = models.FindUserByID(ts.API.db, retained.ID)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), u.AnonymousRetainedUntil)
+
+	expired := createAnonymousUser(now.Add(-40 * 24 * time.Hour))
+	require.NoError(ts.T(), expired.MarkAnonymousUserReminded(ts.API.db, now.Add(-time.Hour)))
+
+	inputs = nil
+	hook(map[string]interface{}{})
+	deleted, err = ts.API.expireAnonymousUsers(httptest.NewRequest(http.MethodPost, "/", nil))
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), 1, deleted)
+	require.Len(ts.T(), inputs, 1)
+	require.Equal(ts.T(), expired.ID, inputs[0].UserID)
+
+	_, err = models.FindUserByID(ts.API.db, expired.ID)
+	require.True(ts.T(), models.IsNotFoundError(err))
+
+	_, err = models.FindUserByID(ts.API.db, active.ID)
+	require.NoError(ts.T(), err)
+}
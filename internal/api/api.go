@@ -1,12 +1,12 @@
 package api
 
 import (
+	"context"
 	"net/http"
 	"regexp"
+	"slices"
 	"time"
 
-	"github.com/didip/tollbooth/v5"
-	"github.com/didip/tollbooth/v5/limiter"
 	"github.com/rs/cors"
 	"github.com/sebest/xff"
 	"github.com/sirupsen/logrus"
@@ -35,6 +35,16 @@ type API struct {
 
 	hibpClient *hibp.PwnedClient
 
+	// configOverridesChanged is called after an admin changed the
+	// configuration overrides, to apply them to the running server.
+	configOverridesChanged func(ctx context.Context)
+
+	// limiters are the rate limiters of the routes, by name.
+	limiters *limiterCache
+
+	// cleanup removes stale rows after requests, if enabled.
+	cleanup *models.Cleanup
+
 	// overrideTime can be used to override the clock used by handlers. Should only be used in tests!
 	overrideTime func() time.Time
 }
@@ -52,25 +62,38 @@ func NewAPI(globalConfig *conf.GlobalConfiguration, db *storage.Connection) *API
 	return NewAPIWithVersion(globalConfig, db, defaultVersion)
 }
 
-func (a *API) deprecationNotices() {
-	config := a.config
+// deprecationNotices logs the deprecation notices of the configuration,
+// unless previous already logged the same ones.
+func (a *API) deprecationNotices(previous *API) {
+	notices := a.config.DeprecationNotices()
+	if previous != nil && slices.Equal(notices, previous.config.DeprecationNotices()) {
+		return
+	}
 
 	log := logrus.WithField("component", "api")
 
-	if config.JWT.AdminGroupName != "" {
-		log.Warn("DEPRECATION NOTICE: GOTRUE_JWT_ADMIN_GROUP_NAME not supported by Supabase's GoTrue, will be removed soon")
-	}
-
-	if config.JWT.DefaultGroupName != "" {
-		log.Warn("DEPRECATION NOTICE: GOTRUE_JWT_DEFAULT_GROUP_NAME not supported by Supabase's GoTrue, will be removed soon")
+	for _, notice := range notices {
+		log.Warn("DEPRECATION NOTICE: " + notice)
 	}
 }
 
 // NewAPIWithVersion creates a new REST API using the specified version
 func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Connection, version string) *API {
-	api := &API{config: globalConfig, db: db, version: version}
+	return newAPIFrom(globalConfig, db, version, nil)
+}
 
-	if api.config.Password.HIBP.Enabled {
+// newAPIFrom creates a new REST API. If previous is set, the new API takes
+// over its in-memory state: rate limiters and the pwned passwords cache, so
+// that applying a new configuration doesn't reset them. The database cleanup
+// is kept too if the new configuration doesn't change it.
+func newAPIFrom(globalConfig *conf.GlobalConfiguration, db *storage.Connection, version string, previous *API) *API {
+	api := &API{config: globalConfig, db: db, version: version, limiters: newLimiterCache()}
+
+	if previous != nil {
+		api.limiters = previous.limiters
+		// the password configuration can't be reloaded
+		api.hibpClient = previous.hibpClient
+	} else if api.config.Password.HIBP.Enabled {
 		httpClient := &http.Client{
 			// all HIBP API requests should finish quickly to avoid
 			// unnecessary slowdowns
@@ -90,7 +113,7 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 		}
 	}
 
-	api.deprecationNotices()
+	api.deprecationNotices(previous)
 
 	xffmw, _ := xff.Default()
 	logger := observability.NewStructuredLogger(logrus.StandardLogger(), globalConfig)
@@ -100,6 +123,7 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 	r.UseBypass(logger)
 	r.UseBypass(xffmw.Handler)
 	r.UseBypass(recoverer)
+	r.UseBypass(auditContext)
 
 	if globalConfig.API.MaxRequestDuration > 0 {
 		r.UseBypass(timeoutMiddleware(globalConfig.API.MaxRequestDuration))
@@ -111,8 +135,13 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 	}
 
 	if globalConfig.DB.CleanupEnabled {
-		cleanup := models.NewCleanup(globalConfig)
-		r.UseBypass(api.databaseCleanup(cleanup))
+		if previous != nil && previous.cleanup != nil && previous.cleanup.Matches(globalConfig) {
+			api.cleanup = previous.cleanup
+		} else {
+			api.cleanup = models.NewCleanup(globalConfig)
+		}
+
+		r.UseBypass(api.databaseCleanup(api.cleanup))
 	}
 
 	r.Get("/health", api.HealthCheck)
@@ -133,16 +162,12 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 		r.Get("/authorize", api.ExternalProviderRedirect)
 
 		sharedLimiter := api.limitEmailOrPhoneSentHandler()
-		r.With(sharedLimiter).With(api.requireAdminCredentials).Post("/invite", api.Invite)
+		r.With(sharedLimiter).With(api.requireAdminCredentials).With(api.requireAdminScope(adminScopeUsersWrite)).Post("/invite", api.Invite)
 		r.With(sharedLimiter).With(api.verifyCaptcha).Route("/signup", func(r *router) {
 			// rate limit per hour
-			limitAnonymousSignIns := tollbooth.NewLimiter(api.config.RateLimitAnonymousUsers/(60*60), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(int(api.config.RateLimitAnonymousUsers)).SetMethods([]string{"POST"})
+			limitAnonymousSignIns := api.limiter("anonymous_sign_ins", api.config.RateLimitAnonymousUsers/(60*60), time.Hour, int(api.config.RateLimitAnonymousUsers), "POST")
 
-			limitSignups := tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30)
+			limitSignups := api.limiter("signup", api.config.RateLimitOtp/(60*5), time.Hour, 30)
 
 			r.Post("/", func(w http.ResponseWriter, r *http.Request) error {
 				params := &SignupParams{}
@@ -172,85 +197,104 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 		})
 		r.With(api.limitHandler(
 			// Allow requests at the specified rate per 5 minutes
-			tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
+			api.limiter("recover", api.config.RateLimitOtp/(60*5), time.Hour, 30),
 		)).With(sharedLimiter).With(api.verifyCaptcha).With(api.requireEmailProvider).Post("/recover", api.Recover)
 
 		r.With(api.limitHandler(
 			// Allow requests at the specified rate per 5 minutes
-			tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
+			api.limiter("resend", api.config.RateLimitOtp/(60*5), time.Hour, 30),
 		)).With(sharedLimiter).With(api.verifyCaptcha).Post("/resend", api.Resend)
 
 		r.With(api.limitHandler(
 			// Allow requests at the specified rate per 5 minutes
-			tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
+			api.limiter("magiclink", api.config.RateLimitOtp/(60*5), time.Hour, 30),
 		)).With(sharedLimiter).With(api.verifyCaptcha).Post("/magiclink", api.MagicLink)
 
 		r.With(api.limitHandler(
 			// Allow requests at the specified rate per 5 minutes
-			tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
+			api.limiter("otp", api.config.RateLimitOtp/(60*5), time.Hour, 30),
 		)).With(sharedLimiter).With(api.verifyCaptcha).Post("/otp", api.Otp)
 
 		r.With(api.limitHandler(
 			// Allow requests at the specified rate per 5 minutes.
-			tollbooth.NewLimiter(api.config.RateLimitTokenRefresh/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
+			api.limiter("token", api.config.RateLimitTokenRefresh/(60*5), time.Hour, 30),
 		)).With(api.verifyCaptcha).Post("/token", api.Token)
 
 		r.With(api.limitHandler(
 			// Allow requests at the specified rate per 5 minutes.
-			tollbooth.NewLimiter(api.config.RateLimitVerify/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
+			api.limiter("verify", api.config.RateLimitVerify/(60*5), time.Hour, 30),
 		)).Route("/verify", func(r *router) {
 			r.Get("/", api.Verify)
 			r.Post("/", api.Verify)
 		})
 
-		r.With(api.requireAuthentication).Post("/logout", api.Logout)
+		r.Route("/device", func(r *router) {
+			r.Use(api.requireDeviceAuthorizationEnabled)
+
+			r.With(api.limitHandler(
+				// Allow requests at the specified rate per 5 minutes
+				api.limiter("device_code", api.config.RateLimitOtp/(60*5), time.Hour, 30),
+			)).Post("/code", api.DeviceCode)
+
+			r.With(api.requireAuthentication).With(api.requireNotAnonymous).With(api.requireNotImpersonated).With(api.requireNotAPIKey).With(api.requireNotExchanged).Post("/verify", api.DeviceVerify)
+		})
+
+		r.With(api.requireAuthentication).With(api.requireNotAPIKey).Post("/logout", api.Logout)
 
-		r.With(api.requireAuthentication).Route("/reauthenticate", func(r *router) {
+		r.With(api.requireAuthentication).With(api.requireNotImpersonated).Route("/reauthenticate", func(r *router) {
 			r.Get("/", api.Reauthenticate)
 		})
 
 		r.With(api.requireAuthentication).Route("/user", func(r *router) {
 			r.Get("/", api.UserGet)
-			r.With(api.limitHandler(
+			r.With(api.requireNotImpersonated).With(api.requireNotAPIKey).With(api.requireNotExchanged).With(api.limitHandler(
 				// Allow requests at the specified rate per 5 minutes
-				tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-					DefaultExpirationTTL: time.Hour,
-				}).SetBurst(30),
+				api.limiter("user_update", api.config.RateLimitOtp/(60*5), time.Hour, 30),
 			)).With(sharedLimiter).Put("/", api.UserUpdate)
 
 			r.Route("/identities", func(r *router) {
 				r.Use(api.requireManualLinkingEnabled)
+				r.Use(api.requireNotImpersonated)
+				r.Use(api.requireNotAPIKey)
+				r.Use(api.requireNotExchanged)
 				r.Get("/authorize", api.LinkIdentity)
 				r.Delete("/{identity_id}", api.DeleteIdentity)
 			})
+
+			r.With(api.requireNotAnonymous).With(api.requireNotImpersonated).With(api.requireNotAPIKey).With(api.requireNotExchanged).Post("/merge", api.MergeAnonymousUser)
+			r.With(api.requireAccountDeletionEnabled).With(api.requireNotImpersonated).With(api.requireNotAPIKey).With(api.requireNotExchanged).Delete("/", api.UserDelete)
+			r.With(api.requireNotImpersonated).With(api.requireNotAPIKey).With(api.requireNotExchanged).Get("/export", api.UserExport)
+
+			r.Route("/api_keys", func(r *router) {
+				r.Use(api.requireAPIKeysEnabled)
+				r.Use(api.requireNotAnonymous)
+				r.Use(api.requireNotImpersonated)
+				r.Use(api.requireNotAPIKey)
+				r.Use(api.requireNotExchanged)
+				r.Get("/", api.UserAPIKeysList)
+				r.Post("/", api.UserAPIKeyCreate)
+				r.Delete("/{key_id}", api.UserAPIKeyRevoke)
+			})
 		})
 
+		r.With(api.requireAPIKeysEnabled).With(api.limitHandler(
+			// Allow requests at the specified rate per 5 minutes.
+			api.limiter("api_keys_verify", api.config.RateLimitTokenRefresh/(60*5), time.Hour, 30),
+		)).Post("/api_keys/verify", api.APIKeyVerify)
+
 		r.With(api.requireAuthentication).Route("/factors", func(r *router) {
 			r.Use(api.requireNotAnonymous)
+			r.Use(api.requireNotImpersonated)
+			r.Use(api.requireNotAPIKey)
+			r.Use(api.requireNotExchanged)
 			r.Post("/", api.EnrollFactor)
 			r.Route("/{factor_id}", func(r *router) {
 				r.Use(api.loadFactor)
 
 				r.With(api.limitHandler(
-					tollbooth.NewLimiter(api.config.MFA.RateLimitChallengeAndVerify/60, &limiter.ExpirableOptions{
-						DefaultExpirationTTL: time.Minute,
-					}).SetBurst(30))).Post("/verify", api.VerifyFactor)
+					api.limiter("factor_verify", api.config.MFA.RateLimitChallengeAndVerify/60, time.Minute, 30))).Post("/verify", api.VerifyFactor)
 				r.With(api.limitHandler(
-					tollbooth.NewLimiter(api.config.MFA.RateLimitChallengeAndVerify/60, &limiter.ExpirableOptions{
-						DefaultExpirationTTL: time.Minute,
-					}).SetBurst(30))).Post("/challenge", api.ChallengeFactor)
+					api.limiter("factor_challenge", api.config.MFA.RateLimitChallengeAndVerify/60, time.Minute, 30))).Post("/challenge", api.ChallengeFactor)
 				r.Delete("/", api.UnenrollFactor)
 
 			})
@@ -260,9 +304,7 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 			r.Use(api.requireSAMLEnabled)
 			r.With(api.limitHandler(
 				// Allow requests at the specified rate per 5 minutes.
-				tollbooth.NewLimiter(api.config.RateLimitSso/(60*5), &limiter.ExpirableOptions{
-					DefaultExpirationTTL: time.Hour,
-				}).SetBurst(30),
+				api.limiter("sso", api.config.RateLimitSso/(60*5), time.Hour, 30),
 			)).With(api.verifyCaptcha).Post("/", api.SingleSignOn)
 
 			r.Route("/saml", func(r *router) {
@@ -270,44 +312,95 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 
 				r.With(api.limitHandler(
 					// Allow requests at the specified rate per 5 minutes.
-					tollbooth.NewLimiter(api.config.SAML.RateLimitAssertion/(60*5), &limiter.ExpirableOptions{
-						DefaultExpirationTTL: time.Hour,
-					}).SetBurst(30),
+					api.limiter("saml_acs", api.config.SAML.RateLimitAssertion/(60*5), time.Hour, 30),
 				)).Post("/acs", api.SAMLACS)
 			})
 		})
 
+		r.Route("/ui", func(r *router) {
+			r.Use(api.requireHostedUIEnabled)
+
+			r.Get("/", api.hostedUIRedirect)
+			r.Get("/sign-in", api.hostedUISignInPage)
+			r.Post("/sign-in", api.hostedUISignIn)
+			r.Get("/sign-up", api.hostedUISignUpPage)
+			r.Post("/sign-up", api.hostedUISignUp)
+			r.Get("/recover", api.hostedUIRecoverPage)
+			r.Post("/recover", api.hostedUIRecover)
+			r.Get("/reset-password", api.hostedUIResetPasswordPage)
+			r.Post("/reset-password", api.hostedUIResetPassword)
+			r.Get("/mfa", api.hostedUIMFAPage)
+			r.Post("/mfa", api.hostedUIMFA)
+			r.Get("/consent", api.hostedUIConsentPage)
+			r.Post("/consent", api.hostedUIConsent)
+			r.Get("/device", api.hostedUIDevicePage)
+			r.Post("/device", api.hostedUIDevice)
+		})
+
 		r.Route("/admin", func(r *router) {
 			r.Use(api.requireAdminCredentials)
 
 			r.Route("/audit", func(r *router) {
+				r.Use(api.requireAdminScope(adminScopeAuditRead))
+
 				r.Get("/", api.adminAuditLog)
 			})
 
+			r.Route("/config/overrides", func(r *router) {
+				r.Use(api.requireConfigOverridesEnabled)
+				r.Use(api.requireAdminScope(adminScopeConfigManage))
+
+				r.Get("/", api.adminConfigOverridesList)
+				r.Put("/{key}", api.adminConfigOverrideUpdate)
+				r.Delete("/{key}", api.adminConfigOverrideDelete)
+			})
+
+			r.Route("/service_clients", func(r *router) {
+				r.Use(api.requireServiceClientsEnabled)
+				r.Use(api.requireAdminScope(adminScopeServiceClients))
+
+				r.Get("/", api.adminServiceClientsList)
+				r.Post("/", api.adminServiceClientsCreate)
+
+				r.Route("/{client_id}", func(r *router) {
+					r.Use(api.loadServiceClient)
+
+					r.Get("/", api.adminServiceClientsGet)
+					r.Put("/", api.adminServiceClientsUpdate)
+					r.Delete("/", api.adminServiceClientsRevoke)
+					r.Post("/secret", api.adminServiceClientsRotateSecret)
+				})
+			})
+
 			r.Route("/users", func(r *router) {
-				r.Get("/", api.adminUsers)
-				r.Post("/", api.adminUserCreate)
+				r.With(api.requireAdminScope(adminScopeUsersRead)).Get("/", api.adminUsers)
+				r.With(api.requireAdminScope(adminScopeUsersWrite)).Post("/", api.adminUserCreate)
 
 				r.Route("/{user_id}", func(r *router) {
 					r.Use(api.loadUser)
 					r.Route("/factors", func(r *router) {
-						r.Get("/", api.adminUserGetFactors)
+						r.With(api.requireAdminScope(adminScopeUsersRead)).Get("/", api.adminUserGetFactors)
 						r.Route("/{factor_id}", func(r *router) {
 							r.Use(api.loadFactor)
-							r.Delete("/", api.adminUserDeleteFactor)
-							r.Put("/", api.adminUserUpdateFactor)
+							r.With(api.requireAdminScope(adminScopeFactorsDelete)).Delete("/", api.adminUserDeleteFactor)
+							r.With(api.requireAdminScope(adminScopeUsersWrite)).Put("/", api.adminUserUpdateFactor)
 						})
 					})
 
-					r.Get("/", api.adminUserGet)
-					r.Put("/", api.adminUserUpdate)
-					r.Delete("/", api.adminUserDelete)
+					r.With(api.requireAdminScope(adminScopeUsersRead)).Get("/", api.adminUserGet)
+					r.With(api.requireAdminScope(adminScopeUsersWrite)).Put("/", api.adminUserUpdate)
+					r.With(api.requireAdminScope(adminScopeUsersDelete)).Delete("/", api.adminUserDelete)
+					r.With(api.requireAdminScope(adminScopeUsersImpersonate)).Post("/impersonate", api.adminUserImpersonate)
+					r.With(api.requireAdminScope(adminScopeUsersRead)).With(api.requireAdminScope(adminScopeAuditRead)).Get("/export", api.adminUserExport)
 				})
 			})
 
-			r.Post("/generate_link", api.adminGenerateLink)
+			// links sign the user in, like impersonation
+			r.With(api.requireAdminScope(adminScopeUsersWrite)).With(api.requireAdminScope(adminScopeUsersImpersonate)).Post("/generate_link", api.adminGenerateLink)
 
 			r.Route("/sso", func(r *router) {
+				r.Use(api.requireAdminScope(adminScopeSSOManage))
+
 				r.Route("/providers", func(r *router) {
 					r.Get("/", api.adminSSOProvidersList)
 					r.Post("/", api.adminSSOProvidersCreate)
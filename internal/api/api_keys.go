@@ -0,0 +1,385 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	mail "github.com/supabase/auth/internal/mailer"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+const apiKeyGrantType = "api_key"
+
+// APIKeyParams are the parameters the UserAPIKeyCreate method accepts.
+// Scope is a space separated list of scopes given to the key's access
+// tokens, and the key never expires if ExpiresAt is not set.
+type APIKeyParams struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyResponse is an API key as returned by the /user/api_keys
+// endpoints. The key itself is only returned when it is created.
+type APIKeyResponse struct {
+	*models.APIKey
+	Key string `json:"api_key,omitempty"`
+}
+
+// APIKeyGrantParams are the parameters the APIKeyGrant method accepts.
+type APIKeyGrantParams struct {
+	APIKey string `json:"api_key"`
+}
+
+// APIKeyGrantResponse is the successful response of the api_key grant. No
+// refresh token is issued, the key is exchanged again instead.
+type APIKeyGrantResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	ExpiresAt   int64  `json:"expires_at"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// APIKeyVerifyParams are the parameters the APIKeyVerify method accepts.
+type APIKeyVerifyParams struct {
+	APIKey string `json:"api_key"`
+}
+
+// APIKeyVerifyResponse describes an API key, modelled after the token
+// introspection response of RFC 7662. Only Active is set for keys that
+// can't be used.
+type APIKeyVerifyResponse struct {
+	Active    bool         `json:"active"`
+	ID        *uuid.UUID   `json:"api_key_id,omitempty"`
+	Scope     string       `json:"scope,omitempty"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	User      *models.User `json:"user,omitempty"`
+}
+
+// findActiveAPIKey finds an API key and its user. It returns a nil key,
+// along with the reason, when the key doesn't exist, has expired or its
+// user is banned.
+func findActiveAPIKey(tx *storage.Connection, key string) (*models.APIKey, *models.User, string, error) {
+	if !models.IsAPIKey(key) {
+		return nil, nil, "Invalid API key", nil
+	}
+
+	apiKey, err := models.FindAPIKeyByKey(tx, key)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, nil, "Invalid API key", nil
+		}
+		return nil, nil, "", err
+	}
+
+	if apiKey.IsExpired() {
+		return nil, nil, "API key has expired", nil
+	}
+
+	user, err := models.FindUserByID(tx, apiKey.UserID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if user.IsBanned() {
+		return nil, nil, "User is banned", nil
+	}
+
+	return apiKey, user, "", nil
+}
+
+// UserAPIKeysList lists the API keys of the user.
+func (a *API) UserAPIKeysList(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+
+	keys, err := models.FindAPIKeysByUserID(db, user.ID)
+	if err != nil {
+		return internalServerError("Database error finding API keys").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]interface{}{
+		"api_keys": keys,
+	})
+}
+
+// UserAPIKeyCreate creates an API key for the user. The key is only
+// returned in this response.
+func (a *API) UserAPIKeyCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.config
+	user := getUser(ctx)
+
+	params := &APIKeyParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" {
+		return badRequestError(ErrorCodeValidationFailed, "name is required")
+	}
+
+	scopes := strings.Fields(params.Scope)
+	for _, scope := range scopes {
+		if !isValidScopeToken(scope) {
+			return badRequestError(ErrorCodeValidationFailed, "Invalid scope %q", scope)
+		}
+	}
+
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		return badRequestError(ErrorCodeValidationFailed, "expires_at must be in the future")
+	}
+
+	var apiKey *models.APIKey
+	var key string
+
+	err := db.Transaction(func(tx *storage.Connection) error {
+		keys, terr := models.FindAPIKeysByUserID(tx, user.ID)
+		if terr != nil {
+			return internalServerError("Database error finding API keys").WithInternalError(terr)
+		}
+
+		if len(keys) >= config.APIKeys.MaxPerUser {
+			return unprocessableEntityError(ErrorCodeTooManyAPIKeys, "A user can have at most %d API keys", config.APIKeys.MaxPerUser)
+		}
+
+		apiKey, key = models.NewAPIKey(user.ID, name, strings.Join(scopes, " "), params.ExpiresAt)
+		if terr := tx.Create(apiKey); terr != nil {
+			return internalServerError("Database error creating API key").WithInternalError(terr)
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, user, models.APIKeyCreatedAction, "", map[string]interface{}{
+			"api_key_id": apiKey.ID,
+			"name":       apiKey.Name,
+			"scope":      apiKey.Scope,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusCreated, &APIKeyResponse{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+// UserAPIKeyRevoke deletes an API key of the user. Access tokens already
+// obtained with it stay valid until they expire.
+func (a *API) UserAPIKeyRevoke(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+
+	keyID, err := uuid.FromString(chi.URLParam(r, "key_id"))
+	if err != nil {
+		return notFoundError(ErrorCodeValidationFailed, "key_id must be an UUID")
+	}
+
+	apiKey, err := models.FindAPIKeyByUserIDAndID(db, user.ID, keyID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError(ErrorCodeAPIKeyNotFound, "API key not found")
+		}
+		return internalServerError("Database error finding API key").WithInternalError(err)
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := tx.Destroy(apiKey); terr != nil {
+			return internalServerError("Database error deleting API key").WithInternalError(terr)
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, user, models.APIKeyRevokedAction, "", map[string]interface{}{
+			"api_key_id": apiKey.ID,
+			"name":       apiKey.Name,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, apiKey)
+}
+
+// apiKeySession returns the session of the API key, extended until notAfter,
+// or creates it if the key has none yet. Each key has a single session, so
+// that exchanging a key repeatedly doesn't pile up sessions.
+func apiKeySession(tx *storage.Connection, apiKey *models.APIKey, notAfter time.Time) (*models.Session, error) {
+	if apiKey.SessionID != nil {
+		session, err := models.FindSessionByID(tx, *apiKey.SessionID, false)
+		if err == nil {
+			session.NotAfter = &notAfter
+			if err := tx.UpdateOnly(session, "not_after", "updated_at"); err != nil {
+				return nil, err
+			}
+			return session, nil
+		} else if !models.IsNotFoundError(err) {
+			return nil, err
+		}
+	}
+
+	session, err := models.NewSession(apiKey.UserID, nil)
+	if err != nil {
+		return nil, err
+	}
+	session.NotAfter = &notAfter
+	if err := tx.Create(session); err != nil {
+		return nil, err
+	}
+	if err := models.AddClaimToSession(tx, session.ID, models.APIKeyAuth); err != nil {
+		return nil, err
+	}
+
+	apiKey.SessionID = &session.ID
+	if err := tx.UpdateOnly(apiKey, "session_id", "updated_at"); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// APIKeyGrant exchanges an API key for a short-lived access token carrying
+// the key's scope. The token belongs to the key's own session, which can't
+// be refreshed and whose amr claim names the api_key method.
+func (a *API) APIKeyGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	db := a.db.WithContext(ctx)
+	config := a.config
+
+	if !config.APIKeys.Enabled {
+		return oauthError("unsupported_grant_type", "")
+	}
+
+	params := &APIKeyGrantParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.APIKey == "" {
+		return oauthError("invalid_request", "api_key required")
+	}
+
+	var response *APIKeyGrantResponse
+	var user *models.User
+	deletionCancelled := false
+
+	err := db.Transaction(func(tx *storage.Connection) error {
+		apiKey, u, reason, terr := findActiveAPIKey(tx, params.APIKey)
+		if terr != nil {
+			return internalServerError("Database error finding API key").WithInternalError(terr)
+		}
+		if apiKey == nil {
+			return oauthError("invalid_grant", reason)
+		}
+		user = u
+
+		if terr := apiKey.RecordUse(tx); terr != nil {
+			return internalServerError("Database error updating API key").WithInternalError(terr)
+		}
+
+		notAfter := time.Now().Add(time.Duration(config.APIKeys.Exp) * time.Second)
+
+		session, terr := apiKeySession(tx, apiKey, notAfter)
+		if terr != nil {
+			return internalServerError("Database error creating session").WithInternalError(terr)
+		}
+
+		ac := models.GetAuditContext(ctx)
+		ac.SessionID = &session.ID
+
+		if terr := models.NewAuditLogEntry(r, tx, user, models.LoginAction, "", map[string]interface{}{
+			"provider":   models.APIKeyAuth.String(),
+			"api_key_id": apiKey.ID,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		tokenString, expiresAt, terr := a.generateRestrictedAccessToken(r, tx, user, &session.ID, models.APIKeyAuth, &accessTokenRestrictions{
+			Scope:     apiKey.Scope,
+			ExpiresAt: notAfter,
+		})
+		if terr != nil {
+			if httpErr, ok := terr.(*HTTPError); ok {
+				return httpErr
+			}
+			return internalServerError("error generating jwt token").WithInternalError(terr)
+		}
+
+		response = &APIKeyGrantResponse{
+			AccessToken: tokenString,
+			TokenType:   "bearer",
+			ExpiresIn:   int(time.Until(time.Unix(expiresAt, 0)).Seconds()),
+			ExpiresAt:   expiresAt,
+			Scope:       apiKey.Scope,
+		}
+
+		// like signing in, using a key during the grace period keeps
+		// the account
+		if user.DeletionScheduledAt != nil {
+			deletionCancelled = true
+			return a.cancelAccountDeletion(r, tx, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if deletionCancelled {
+		a.notifyAccountDeletion(r, user, mail.AccountDeletionCancelled)
+	}
+
+	return sendJSON(w, http.StatusOK, response)
+}
+
+// APIKeyVerify lets services that accept API keys directly check a key
+// without exchanging it for an access token.
+func (a *API) APIKeyVerify(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	params := &APIKeyVerifyParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.APIKey == "" {
+		return badRequestError(ErrorCodeValidationFailed, "api_key is required")
+	}
+
+	apiKey, user, _, err := findActiveAPIKey(db, params.APIKey)
+	if err != nil {
+		return internalServerError("Database error finding API key").WithInternalError(err)
+	}
+	if apiKey == nil {
+		return sendJSON(w, http.StatusOK, &APIKeyVerifyResponse{Active: false})
+	}
+
+	if err := apiKey.RecordUse(db); err != nil {
+		return internalServerError("Database error updating API key").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, &APIKeyVerifyResponse{
+		Active:    true,
+		ID:        &apiKey.ID,
+		Scope:     apiKey.Scope,
+		ExpiresAt: apiKey.ExpiresAt,
+		User:      user,
+	})
+}
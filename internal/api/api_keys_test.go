@@ -0,0 +1,279 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+type APIKeysTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	user  *models.User
+	token string
+}
+
+func TestAPIKeys(t *testing.T) {
+	api, config, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, conn *storage.Connection) {
+		if config != nil {
+			config.APIKeys.Enabled = true
+			config.APIKeys.Exp = 300
+			config.APIKeys.MaxPerUser = 2
+		}
+	})
+	require.NoError(t, err)
+
+	ts := &APIKeysTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *APIKeysTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	ts.user = u
+
+	s, err := models.NewSession(u.ID, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(s))
+
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", nil)
+	ts.token, _, err = ts.API.generateAccessToken(req, ts.API.db, u, &s.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+}
+
+func (ts *APIKeysTestSuite) request(method, path, bearer string, body map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *APIKeysTestSuite) createKey(body map[string]interface{}) *APIKeyResponse {
+	w := ts.request(http.MethodPost, "/user/api_keys", ts.token, body)
+	require.Equal(ts.T(), http.StatusCreated, w.Code, w.Body.String())
+
+	res := &APIKeyResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(res))
+	return res
+}
+
+func (ts *APIKeysTestSuite) grant(key string) *httptest.ResponseRecorder {
+	return ts.request(http.MethodPost, "/token?grant_type=api_key", "", map[string]interface{}{
+		"api_key": key,
+	})
+}
+
+func (ts *APIKeysTestSuite) TestCreateAndList() {
+	res := ts.createKey(map[string]interface{}{
+		"name":  "deploy script",
+		"scope": "projects:read",
+	})
+	require.True(ts.T(), strings.HasPrefix(res.Key, res.KeyPrefix))
+	require.Equal(ts.T(), "projects:read", res.Scope)
+
+	w := ts.request(http.MethodGet, "/user/api_keys", ts.token, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	// the key itself is never listed
+	require.NotContains(ts.T(), w.Body.String(), res.Key)
+
+	list := struct {
+		APIKeys []*models.APIKey `json:"api_keys"`
+	}{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&list))
+	require.Len(ts.T(), list.APIKeys, 1)
+	require.Equal(ts.T(), res.ID, list.APIKeys[0].ID)
+}
+
+func (ts *APIKeysTestSuite) TestCreateValidation() {
+	cases := []map[string]interface{}{
+		{"name": ""},
+		{"name": "deploy script", "scope": `projects:"read"`},
+		{"name": "deploy script", "expires_at": time.Now().Add(-time.Hour)},
+	}
+
+	for _, c := range cases {
+		w := ts.request(http.MethodPost, "/user/api_keys", ts.token, c)
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	}
+}
+
+func (ts *APIKeysTestSuite) TestMaxPerUser() {
+	ts.createKey(map[string]interface{}{"name": "one"})
+	ts.createKey(map[string]interface{}{"name": "two"})
+
+	w := ts.request(http.MethodPost, "/user/api_keys", ts.token, map[string]interface{}{"name": "three"})
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+}
+
+func (ts *APIKeysTestSuite) TestAPIKeyGrant() {
+	res := ts.createKey(map[string]interface{}{
+		"name":  "deploy script",
+		"scope": "projects:read",
+	})
+
+	w := ts.grant(res.Key)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	token := &APIKeyGrantResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	require.Equal(ts.T(), "projects:read", token.Scope)
+	require.LessOrEqual(ts.T(), token.ExpiresIn, 300)
+
+	claims := &AccessTokenClaims{}
+	_, err := jwt.ParseWithClaims(token.AccessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(ts.Config.JWT.Secret), nil
+	})
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), ts.user.ID.String(), claims.Subject)
+	require.Equal(ts.T(), "projects:read", claims.Scope)
+	require.Len(ts.T(), claims.AuthenticationMethodReference, 1)
+	require.Equal(ts.T(), models.APIKeyAuth.String(), claims.AuthenticationMethodReference[0].Method)
+
+	w = ts.request(http.MethodGet, "/user", token.AccessToken, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	// a key can't be used to change credentials or create more keys
+	w = ts.request(http.MethodPut, "/user", token.AccessToken, map[string]interface{}{"password": "new-password"})
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	w = ts.request(http.MethodPost, "/user/api_keys", token.AccessToken, map[string]interface{}{"name": "another"})
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	// nor to sign the user out
+	w = ts.request(http.MethodPost, "/logout", token.AccessToken, nil)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	apiKey, err := models.FindAPIKeyByKey(ts.API.db, res.Key)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), apiKey.LastUsedAt)
+	require.NotNil(ts.T(), apiKey.SessionID)
+	require.Equal(ts.T(), apiKey.SessionID.String(), claims.SessionId)
+
+	// exchanging the key again reuses its session
+	w = ts.grant(res.Key)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	again := &APIKeyGrantResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(again))
+
+	againClaims := &AccessTokenClaims{}
+	_, err = jwt.ParseWithClaims(again.AccessToken, againClaims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(ts.Config.JWT.Secret), nil
+	})
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), claims.SessionId, againClaims.SessionId)
+
+	sessions, err := models.FindAllSessionsForUser(ts.API.db, ts.user.ID, false)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), sessions, 2)
+}
+
+func (ts *APIKeysTestSuite) TestAPIKeyGrantRejected() {
+	expired := ts.createKey(map[string]interface{}{"name": "expired"})
+	apiKey, err := models.FindAPIKeyByKey(ts.API.db, expired.Key)
+	require.NoError(ts.T(), err)
+	expiresAt := time.Now().Add(-time.Minute)
+	apiKey.ExpiresAt = &expiresAt
+	require.NoError(ts.T(), ts.API.db.UpdateOnly(apiKey, "expires_at"))
+
+	w := ts.grant(expired.Key)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_grant")
+
+	revoked := ts.createKey(map[string]interface{}{"name": "revoked"})
+	w = ts.request(http.MethodDelete, "/user/api_keys/"+revoked.ID.String(), ts.token, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	w = ts.grant(revoked.Key)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_grant")
+
+	w = ts.grant("ak_unknown")
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_grant")
+}
+
+func (ts *APIKeysTestSuite) TestBannedAndDeletedUsers() {
+	res := ts.createKey(map[string]interface{}{"name": "deploy script"})
+
+	require.NoError(ts.T(), ts.user.Ban(ts.API.db, time.Hour))
+
+	w := ts.grant(res.Key)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_grant")
+
+	require.NoError(ts.T(), ts.user.Ban(ts.API.db, 0))
+	require.NoError(ts.T(), ts.user.SoftDeleteUser(ts.API.db))
+
+	keys, err := models.FindAPIKeysByUserID(ts.API.db, ts.user.ID)
+	require.NoError(ts.T(), err)
+	require.Empty(ts.T(), keys)
+}
+
+func (ts *APIKeysTestSuite) TestAPIKeyVerify() {
+	res := ts.createKey(map[string]interface{}{
+		"name":  "deploy script",
+		"scope": "projects:read",
+	})
+
+	w := ts.request(http.MethodPost, "/api_keys/verify", "", map[string]interface{}{"api_key": res.Key})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	verified := &APIKeyVerifyResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(verified))
+	require.True(ts.T(), verified.Active)
+	require.Equal(ts.T(), "projects:read", verified.Scope)
+	require.Equal(ts.T(), ts.user.ID, verified.User.ID)
+
+	w = ts.request(http.MethodPost, "/api_keys/verify", "", map[string]interface{}{"api_key": "ak_unknown"})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	verified = &APIKeyVerifyResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(verified))
+	require.False(ts.T(), verified.Active)
+	require.Nil(ts.T(), verified.User)
+}
+
+func (ts *APIKeysTestSuite) TestDisabled() {
+	ts.Config.APIKeys.Enabled = false
+	defer func() {
+		ts.Config.APIKeys.Enabled = true
+	}()
+
+	w := ts.request(http.MethodGet, "/user/api_keys", ts.token, nil)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+
+	w = ts.grant("ak_unknown")
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "unsupported_grant_type")
+}
@@ -11,6 +11,7 @@ type APIVersion = time.Time
 var (
 	APIVersionInitial  = time.Time{}
 	APIVersion20240101 = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	APIVersion20240901 = time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
 )
 
 func DetermineClosestAPIVersion(date string) (APIVersion, error) {
@@ -23,6 +24,10 @@ func DetermineClosestAPIVersion(date string) (APIVersion, error) {
 		return APIVersionInitial, err
 	}
 
+	if parsed.Compare(APIVersion20240901) >= 0 {
+		return APIVersion20240901, nil
+	}
+
 	if parsed.Compare(APIVersion20240101) >= 0 {
 		return APIVersion20240101, nil
 	}
@@ -26,4 +26,8 @@ func TestDetermineClosestAPIVersion(t *testing.T) {
 	version, err = DetermineClosestAPIVersion("2024-01-02")
 	require.NoError(t, err)
 	require.Equal(t, APIVersion20240101, version)
+
+	version, err = DetermineClosestAPIVersion("2024-09-01")
+	require.NoError(t, err)
+	require.Equal(t, APIVersion20240901, version)
 }
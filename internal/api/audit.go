@@ -3,8 +3,11 @@ package api
 import (
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
 )
 
 var filterColumnMap = map[string][]string{
@@ -23,20 +26,12 @@ func (a *API) adminAuditLog(w http.ResponseWriter, r *http.Request) error {
 		return badRequestError(ErrorCodeValidationFailed, "Bad Pagination Parameters: %v", err)
 	}
 
-	var col []string
-	var qval string
-	q := r.URL.Query().Get("query")
-	if q != "" {
-		var exists bool
-		qparts := strings.SplitN(q, ":", 2)
-		col, exists = filterColumnMap[qparts[0]]
-		if !exists || len(qparts) < 2 {
-			return badRequestError(ErrorCodeValidationFailed, "Invalid query scope: %s", q)
-		}
-		qval = qparts[1]
+	filter, err := parseAuditLogFilter(r)
+	if err != nil {
+		return err
 	}
 
-	logs, err := models.FindAuditLogEntries(db, col, qval, pageParams)
+	logs, err := models.FindAuditLogEntries(db, filter, pageParams)
 	if err != nil {
 		return internalServerError("Error searching for audit logs").WithInternalError(err)
 	}
@@ -45,3 +40,80 @@ func (a *API) adminAuditLog(w http.ResponseWriter, r *http.Request) error {
 
 	return sendJSON(w, http.StatusOK, logs)
 }
+
+// parseAuditLogFilter reads the audit log filters from the query string.
+// The legacy query=scope:value substring search can be combined with the
+// structured action, actor_id, actor_type, user_id, outcome, ip_address,
+// created_after and created_before filters.
+func parseAuditLogFilter(r *http.Request) (*models.AuditLogFilter, error) {
+	params := r.URL.Query()
+	filter := &models.AuditLogFilter{
+		Actions:   params["action"],
+		IPAddress: params.Get("ip_address"),
+		ActorType: params.Get("actor_type"),
+		Outcome:   params.Get("outcome"),
+	}
+
+	switch models.AuditActorType(filter.ActorType) {
+	case "", models.AuditActorUser, models.AuditActorAdmin, models.AuditActorSystem:
+	default:
+		return nil, badRequestError(ErrorCodeValidationFailed, "actor_type must be one of user, admin or system")
+	}
+
+	switch models.AuditOutcome(filter.Outcome) {
+	case "", models.AuditOutcomeSuccess, models.AuditOutcomeFailure:
+	default:
+		return nil, badRequestError(ErrorCodeValidationFailed, "outcome must be one of success or failure")
+	}
+
+	if q := params.Get("query"); q != "" {
+		var exists bool
+		qparts := strings.SplitN(q, ":", 2)
+		filter.Columns, exists = filterColumnMap[qparts[0]]
+		if !exists || len(qparts) < 2 {
+			return nil, badRequestError(ErrorCodeValidationFailed, "Invalid query scope: %s", q)
+		}
+		filter.Value = qparts[1]
+	}
+
+	ids := map[string]**uuid.UUID{
+		"actor_id": &filter.ActorID,
+		"user_id":  &filter.TargetUserID,
+	}
+	for name, dst := range ids {
+		if value := params.Get(name); value != "" {
+			id, err := uuid.FromString(value)
+			if err != nil {
+				return nil, badRequestError(ErrorCodeValidationFailed, "%s must be an UUID", name)
+			}
+			*dst = &id
+		}
+	}
+
+	times := map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	}
+	for name, dst := range times {
+		if value := params.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, badRequestError(ErrorCodeValidationFailed, "%s must be an RFC 3339 timestamp", name)
+			}
+			*dst = &t
+		}
+	}
+
+	return filter, nil
+}
+
+// auditFailure records a failed action in the audit log. It must not be
+// called while the handler has a transaction open: those are rolled back when
+// the handler returns an error, so the entry is written after them instead.
+// Failing to record the entry does not fail the request.
+func (a *API) auditFailure(r *http.Request, event *models.AuditEvent) {
+	event.Outcome = models.AuditOutcomeFailure
+	if err := models.NewAuditLogEvent(r, a.db.WithContext(r.Context()), event); err != nil {
+		observability.GetLogEntry(r).Entry.WithError(err).Warn("failed to record audit log entry")
+	}
+}
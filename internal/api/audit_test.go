@@ -1,12 +1,15 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/gofrs/uuid"
 	jwt "github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -123,7 +126,134 @@ func (ts *AuditTestSuite) TestAuditFilters() {
 	}
 }
 
-func (ts *AuditTestSuite) prepareDeleteEvent() {
+func (ts *AuditTestSuite) TestAuditStructuredFilters() {
+	userID := ts.prepareDeleteEvent()
+
+	cases := []struct {
+		query    string
+		expected int
+	}{
+		{query: "action=user_deleted", expected: 1},
+		{query: "action=login&action=user_deleted", expected: 1},
+		{query: "action=login", expected: 0},
+		{query: "user_id=" + userID.String(), expected: 1},
+		{query: "user_id=" + uuid.Must(uuid.NewV4()).String(), expected: 0},
+		{query: "created_after=" + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), expected: 1},
+		{query: "created_before=" + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), expected: 0},
+		{query: "cursor=", expected: 1},
+		{query: "actor_type=admin", expected: 1},
+		{query: "actor_type=user", expected: 0},
+		{query: "outcome=success", expected: 1},
+		{query: "outcome=failure", expected: 0},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/audit?"+c.query, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusOK, w.Code, c.query)
+
+		logs := []models.AuditLogEntry{}
+		require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&logs))
+		require.Len(ts.T(), logs, c.expected, c.query)
+	}
+
+	for _, query := range []string{"actor_id=admin", "created_after=yesterday", "query=nothing:here", "actor_type=robot", "outcome=maybe"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/audit?"+query, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func (ts *AuditTestSuite) TestAuditEventPayload() {
+	userID := ts.prepareDeleteEvent()
+
+	logs, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{Actions: []string{string(models.UserDeletedAction)}}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), logs, 1)
+
+	payload := logs[0].Payload
+	assert.Equal(ts.T(), float64(models.AuditLogSchemaVersion), payload["schema_version"])
+	assert.Equal(ts.T(), string(models.AuditActorAdmin), payload["actor_type"])
+	assert.Equal(ts.T(), userID.String(), payload["target_user_id"])
+	assert.Equal(ts.T(), string(models.AuditOutcomeSuccess), payload["outcome"])
+	assert.Equal(ts.T(), string(models.UserDeletedAction), payload["action"])
+	assert.Equal(ts.T(), "Test User", payload["actor_name"])
+	assert.NotEqual(ts.T(), userID.String(), payload["actor_id"])
+}
+
+func (ts *AuditTestSuite) TestAuditFailedVerify() {
+	u, err := models.NewUser("", "test-failed-verify@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"type":  "magiclink",
+		"email": "test-failed-verify@example.com",
+		"token": "123456",
+	}))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/verify", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	// the entry is recorded even though the verification's transaction
+	// was rolled back
+	logs, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{Outcome: string(models.AuditOutcomeFailure)}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), logs, 1)
+
+	payload := logs[0].Payload
+	assert.Equal(ts.T(), float64(models.AuditLogSchemaVersion), payload["schema_version"])
+	assert.Equal(ts.T(), string(models.LoginAction), payload["action"])
+	assert.Equal(ts.T(), u.ID.String(), payload["actor_id"])
+	assert.Equal(ts.T(), "test-failed-verify@example.com", payload["actor_username"])
+	assert.Equal(ts.T(), "magiclink", payload["auth_method"])
+	assert.Equal(ts.T(), "invalid_otp", payload["failure_reason"])
+}
+
+func (ts *AuditTestSuite) TestAuditFailedLogin() {
+	u, err := models.NewUser("", "test-failed-login@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email":    "test-failed-login@example.com",
+		"password": "not-the-password",
+	}))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	logs, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{Outcome: string(models.AuditOutcomeFailure)}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), logs, 1)
+
+	payload := logs[0].Payload
+	assert.Equal(ts.T(), float64(models.AuditLogSchemaVersion), payload["schema_version"])
+	assert.Equal(ts.T(), string(models.LoginAction), payload["action"])
+	assert.Equal(ts.T(), u.ID.String(), payload["actor_id"])
+	assert.Equal(ts.T(), "test-failed-login@example.com", payload["actor_username"])
+	assert.Equal(ts.T(), string(models.AuditActorUser), payload["actor_type"])
+	assert.Equal(ts.T(), models.PasswordGrant.String(), payload["auth_method"])
+	assert.Equal(ts.T(), "invalid_credentials", payload["failure_reason"])
+}
+
+func (ts *AuditTestSuite) prepareDeleteEvent() uuid.UUID {
 	// DELETE USER
 	u, err := models.NewUser("12345678", "test-delete@example.com", "test", ts.Config.JWT.Aud, nil)
 	require.NoError(ts.T(), err, "Error making new user")
@@ -136,4 +266,6 @@ func (ts *AuditTestSuite) prepareDeleteEvent() {
 
 	ts.API.handler.ServeHTTP(w, req)
 	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	return u.ID
 }
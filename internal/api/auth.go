@@ -14,7 +14,7 @@ import (
 
 // requireAuthentication checks incoming requests for tokens presented using the Authorization header
 func (a *API) requireAuthentication(w http.ResponseWriter, r *http.Request) (context.Context, error) {
-	token, err := a.extractBearerToken(r)
+	token, err := a.extractAccessToken(r)
 	config := a.config
 	if err != nil {
 		a.clearCookieTokens(config, w)
@@ -27,6 +27,10 @@ func (a *API) requireAuthentication(w http.ResponseWriter, r *http.Request) (con
 		return ctx, err
 	}
 
+	if err := a.requireDPoPBinding(w, r, token, getClaims(ctx)); err != nil {
+		return nil, err
+	}
+
 	ctx, err = a.maybeLoadUserOrSession(ctx)
 	if err != nil {
 		a.clearCookieTokens(config, w)
@@ -44,6 +48,46 @@ func (a *API) requireNotAnonymous(w http.ResponseWriter, r *http.Request) (conte
 	return ctx, nil
 }
 
+// requireNotImpersonated rejects requests made with a session an admin
+// created on behalf of the user, as those can't change credentials or MFA.
+func (a *API) requireNotImpersonated(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	if session := getSession(ctx); session != nil && session.IsImpersonated() {
+		return nil, forbiddenError(ErrorCodeSessionImpersonated, "Not allowed in an impersonated session")
+	}
+	return ctx, nil
+}
+
+// requireNotAPIKey rejects requests made with an access token obtained with
+// an API key, as a leaked key must not be enough to take over the account.
+func (a *API) requireNotAPIKey(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	claims := getClaims(ctx)
+	for _, amr := range claims.AuthenticationMethodReference {
+		if amr.Method == models.APIKeyAuth.String() {
+			return nil, forbiddenError(ErrorCodeSessionAPIKey, "Not allowed with an API key")
+		}
+	}
+	return ctx, nil
+}
+
+// requireNotExchanged rejects requests made with an access token issued by
+// the token exchange grant, as such a token is restricted to the audience
+// and scope it was exchanged for and must not manage the account.
+func (a *API) requireNotExchanged(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	claims := getClaims(ctx)
+	if claims.Actor != nil {
+		return nil, forbiddenError(ErrorCodeInsufficientScope, "Not allowed with an exchanged token")
+	}
+	for _, amr := range claims.AuthenticationMethodReference {
+		if amr.Method == models.TokenExchange.String() {
+			return nil, forbiddenError(ErrorCodeInsufficientScope, "Not allowed with an exchanged token")
+		}
+	}
+	return ctx, nil
+}
+
 func (a *API) requireAdmin(ctx context.Context) (context.Context, error) {
 	// Find the administrative user
 	claims := getClaims(ctx)
@@ -53,14 +97,95 @@ func (a *API) requireAdmin(ctx context.Context) (context.Context, error) {
 
 	adminRoles := a.config.JWT.AdminRoles
 
+	if claims.ClientID != "" {
+		return a.requireAdminServiceClient(ctx, claims)
+	}
+
 	if isStringInSlice(claims.Role, adminRoles) {
 		// successful authentication
+		models.GetAuditContext(ctx).ActorType = models.AuditActorAdmin
 		return withAdminUser(ctx, &models.User{Role: claims.Role, Email: storage.NullString(claims.Role)}), nil
 	}
 
 	return nil, forbiddenError(ErrorCodeNotAdmin, "User not allowed").WithInternalMessage(fmt.Sprintf("this token needs to have one of the following roles: %v", strings.Join(adminRoles, ", ")))
 }
 
+// requireAdminServiceClient checks a token issued to a service client. The
+// client is looked up on every request, so that revoking it takes effect
+// immediately rather than when its tokens expire.
+func (a *API) requireAdminServiceClient(ctx context.Context, claims *AccessTokenClaims) (context.Context, error) {
+	clientID, err := uuid.FromString(claims.ClientID)
+	if err != nil {
+		return nil, forbiddenError(ErrorCodeBadJWT, "Invalid token")
+	}
+
+	client, err := models.FindServiceClientByID(a.db.WithContext(ctx), clientID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, forbiddenError(ErrorCodeServiceClientRevoked, "Service client has been revoked")
+		}
+		return nil, internalServerError("Database error finding service client").WithInternalError(err)
+	}
+
+	if client.IsRevoked() {
+		return nil, forbiddenError(ErrorCodeServiceClientRevoked, "Service client has been revoked")
+	}
+
+	adminRoles := a.config.JWT.AdminRoles
+	if !isStringInSlice(claims.Role, adminRoles) {
+		return nil, forbiddenError(ErrorCodeNotAdmin, "User not allowed").WithInternalMessage(fmt.Sprintf("this token needs to have one of the following roles: %v", strings.Join(adminRoles, ", ")))
+	}
+
+	ac := models.GetAuditContext(ctx)
+	ac.ActorType = models.AuditActorServiceClient
+	ac.ServiceClientID = &client.ID
+
+	return withAdminUser(ctx, &models.User{Role: claims.Role, Email: storage.NullString(client.Name)}), nil
+}
+
+// Admin scopes narrow down what an admin token can do with the admin API.
+// They're given in the scope claim, usually of a service client's token.
+// Admin tokens without a scope claim, like the service_role key, can do
+// everything.
+const (
+	adminScopeUsersRead        = "users:read"
+	adminScopeUsersWrite       = "users:write"
+	adminScopeUsersDelete      = "users:delete"
+	adminScopeUsersImpersonate = "users:impersonate"
+	adminScopeFactorsDelete    = "factors:delete"
+	adminScopeSSOManage        = "sso:manage"
+	adminScopeAuditRead        = "audit:read"
+	adminScopeConfigManage     = "config:manage"
+	adminScopeServiceClients   = "service_clients:manage"
+	adminScopeTokensExchange   = "tokens:exchange"
+)
+
+// hasAdminScope reports whether the admin token in ctx grants scope.
+func hasAdminScope(ctx context.Context, scope string) bool {
+	claims := getClaims(ctx)
+	if claims == nil {
+		return false
+	}
+
+	if claims.Scope == "" {
+		return true
+	}
+
+	return isStringInSlice(scope, strings.Fields(claims.Scope))
+}
+
+// requireAdminScope only lets admin tokens that grant scope through. It
+// must come after requireAdminCredentials.
+func (a *API) requireAdminScope(scope string) middlewareHandler {
+	return func(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+		ctx := r.Context()
+		if !hasAdminScope(ctx, scope) {
+			return nil, forbiddenError(ErrorCodeInsufficientScope, "This token doesn't have the %s scope", scope)
+		}
+		return ctx, nil
+	}
+}
+
 func (a *API) extractBearerToken(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
 	matches := bearerRegexp.FindStringSubmatch(authHeader)
@@ -71,6 +196,16 @@ func (a *API) extractBearerToken(r *http.Request) (string, error) {
 	return matches[1], nil
 }
 
+// extractAccessToken is like extractBearerToken, but also accepts access
+// tokens sent with the DPoP scheme.
+func (a *API) extractAccessToken(r *http.Request) (string, error) {
+	if matches := dpopRegexp.FindStringSubmatch(r.Header.Get("Authorization")); len(matches) == 2 {
+		return matches[1], nil
+	}
+
+	return a.extractBearerToken(r)
+}
+
 func (a *API) parseJWTClaims(bearer string, r *http.Request) (context.Context, error) {
 	ctx := r.Context()
 	config := a.config
@@ -128,6 +263,15 @@ func (a *API) maybeLoadUserOrSession(ctx context.Context) (context.Context, erro
 			return ctx, err
 		}
 		ctx = withSession(ctx, session)
+
+		ac := models.GetAuditContext(ctx)
+		ac.SessionID = &session.ID
+		if session.IsImpersonated() {
+			ac.Impersonator = *session.Impersonator
+		}
+		if len(claims.AuthenticationMethodReference) > 0 {
+			ac.AuthMethod = claims.AuthenticationMethodReference[0].Method
+		}
 	}
 	return ctx, nil
 }
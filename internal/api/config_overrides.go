@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// ConfigOverrideParams are the parameters adminConfigOverrideUpdate accepts.
+// Value is the JSON value of the overridden field.
+type ConfigOverrideParams struct {
+	Value json.RawMessage `json:"value"`
+}
+
+// ConfigOverrideResponse is a configuration override as returned by the
+// admin API. Values of secrets are never returned.
+type ConfigOverrideResponse struct {
+	*models.ConfigOverride
+
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (a *API) configOverrideResponse(override *models.ConfigOverride) (*ConfigOverrideResponse, error) {
+	response := &ConfigOverrideResponse{ConfigOverride: override}
+	if override.Secret {
+		return response, nil
+	}
+
+	value, err := override.GetValue(a.config.Security.DBEncryption.DecryptionKeys)
+	if err != nil {
+		return nil, internalServerError("Error reading configuration override").WithInternalError(err)
+	}
+	response.Value = value
+
+	return response, nil
+}
+
+// notifyConfigOverridesChanged applies the overrides to the running server
+// right away, instead of waiting for the next periodic reload.
+func (a *API) notifyConfigOverridesChanged(ctx context.Context) {
+	if a.configOverridesChanged != nil {
+		a.configOverridesChanged(ctx)
+	}
+}
+
+// adminConfigOverridesList lists all configuration overrides.
+func (a *API) adminConfigOverridesList(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	overrides, err := models.FindConfigOverrides(db)
+	if err != nil {
+		return internalServerError("Database error finding configuration overrides").WithInternalError(err)
+	}
+
+	responses := make([]*ConfigOverrideResponse, 0, len(overrides))
+	for _, override := range overrides {
+		response, err := a.configOverrideResponse(override)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, response)
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]interface{}{
+		"overrides": responses,
+	})
+}
+
+// adminConfigOverrideUpdate creates or updates the override of a
+// configuration field. The value is validated against the running
+// configuration before being stored.
+func (a *API) adminConfigOverrideUpdate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.config
+
+	key := chi.URLParam(r, "key")
+	if err := conf.ValidateOverrideKey(key); err != nil {
+		return badRequestError(ErrorCodeValidationFailed, err.Error())
+	}
+
+	params := &ConfigOverrideParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if len(params.Value) == 0 || string(params.Value) == "null" {
+		return badRequestError(ErrorCodeValidationFailed, "value is required")
+	}
+
+	if _, err := config.ApplyOverrides(map[string]json.RawMessage{key: params.Value}); err != nil {
+		return badRequestError(ErrorCodeValidationFailed, "Invalid configuration: %v", err)
+	}
+
+	secret := conf.IsSecretOverride(key)
+	if secret && !config.Security.DBEncryption.Encrypt {
+		return badRequestError(ErrorCodeValidationFailed, "Database encryption must be enabled to override %q", key)
+	}
+
+	override := models.NewConfigOverride(key, secret)
+	if err := override.SetValue(params.Value, secret, config.Security.DBEncryption.EncryptionKeyID, config.Security.DBEncryption.EncryptionKey); err != nil {
+		return internalServerError("Error encrypting configuration override").WithInternalError(err)
+	}
+
+	err := db.Transaction(func(tx *storage.Connection) error {
+		if terr := override.Save(tx); terr != nil {
+			return internalServerError("Database error saving configuration override").WithInternalError(terr)
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.ConfigOverrideUpdatedAction, "", map[string]interface{}{
+			"key":    key,
+			"secret": secret,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	a.notifyConfigOverridesChanged(ctx)
+
+	response, err := a.configOverrideResponse(override)
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, response)
+}
+
+// adminConfigOverrideDelete removes the override of a configuration field,
+// restoring the value from the environment.
+func (a *API) adminConfigOverrideDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	key := chi.URLParam(r, "key")
+
+	err := db.Transaction(func(tx *storage.Connection) error {
+		override, terr := models.FindConfigOverrideByKey(tx, key)
+		if terr != nil {
+			if models.IsNotFoundError(terr) {
+				return notFoundError(ErrorCodeConfigOverrideNotFound, "Configuration override not found")
+			}
+			return internalServerError("Database error finding configuration override").WithInternalError(terr)
+		}
+
+		if terr := override.Delete(tx); terr != nil {
+			return internalServerError("Database error deleting configuration override").WithInternalError(terr)
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.ConfigOverrideDeletedAction, "", map[string]interface{}{
+			"key":    key,
+			"secret": override.Secret,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	a.notifyConfigOverridesChanged(ctx)
+
+	return sendJSON(w, http.StatusOK, map[string]interface{}{})
+}
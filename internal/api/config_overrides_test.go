@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+type ConfigOverridesTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	token   string
+	changed int
+}
+
+func TestConfigOverrides(t *testing.T) {
+	api, config, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, conn *storage.Connection) {
+		if config != nil {
+			config.ConfigOverrides.Enabled = true
+		}
+	})
+	require.NoError(t, err)
+
+	ts := &ConfigOverridesTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *ConfigOverridesTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	ts.changed = 0
+	ts.API.configOverridesChanged = func(ctx context.Context) {
+		ts.changed += 1
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role: "supabase_admin",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+	ts.token = token
+}
+
+func (ts *ConfigOverridesTestSuite) request(method, path string, body interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	if body != nil {
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+
+	return w
+}
+
+func (ts *ConfigOverridesTestSuite) TestUpdateAndList() {
+	w := ts.request(http.MethodPut, "/admin/config/overrides/mailer.subjects.invite", map[string]interface{}{
+		"value": "Join us",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	w = ts.request(http.MethodPut, "/admin/config/overrides/external.github.secret", map[string]interface{}{
+		"value": "github-secret",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.Equal(ts.T(), 2, ts.changed)
+
+	// secrets are stored encrypted
+	override, err := models.FindConfigOverrideByKey(ts.API.db, "external.github.secret")
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), override.Secret)
+	require.NotContains(ts.T(), override.Value, "github-secret")
+
+	values, err := models.LoadConfigOverrides(ts.API.db, ts.Config.Security.DBEncryption.DecryptionKeys)
+	require.NoError(ts.T(), err)
+	require.JSONEq(ts.T(), `"github-secret"`, string(values["external.github.secret"]))
+	require.JSONEq(ts.T(), `"Join us"`, string(values["mailer.subjects.invite"]))
+
+	w = ts.request(http.MethodGet, "/admin/config/overrides", nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	var data struct {
+		Overrides []map[string]interface{} `json:"overrides"`
+	}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Len(ts.T(), data.Overrides, 2)
+
+	require.Equal(ts.T(), "external.github.secret", data.Overrides[0]["key"])
+	require.NotContains(ts.T(), data.Overrides[0], "value")
+	require.Equal(ts.T(), "mailer.subjects.invite", data.Overrides[1]["key"])
+	require.Equal(ts.T(), "Join us", data.Overrides[1]["value"])
+}
+
+func (ts *ConfigOverridesTestSuite) TestSmsProviderSecretsAreRedacted() {
+	secrets := map[string]interface{}{
+		"sms.sns.secret_access_key": "sns-secret",
+		"sms.sns.session_token":     "sns-session-token",
+		"sms.sinch.api_token":       "sinch-token",
+		"sms.http.headers":          map[string]string{"Authorization": "Bearer http-token"},
+	}
+
+	for key, value := range secrets {
+		w := ts.request(http.MethodPut, "/admin/config/overrides/"+key, map[string]interface{}{
+			"value": value,
+		})
+		require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+		override, err := models.FindConfigOverrideByKey(ts.API.db, key)
+		require.NoError(ts.T(), err)
+		require.True(ts.T(), override.Secret, key)
+	}
+
+	w := ts.request(http.MethodGet, "/admin/config/overrides", nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	var data struct {
+		Overrides []map[string]interface{} `json:"overrides"`
+	}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Len(ts.T(), data.Overrides, len(secrets))
+
+	for _, override := range data.Overrides {
+		require.NotContains(ts.T(), override, "value", override["key"])
+	}
+}
+
+func (ts *ConfigOverridesTestSuite) TestUpdateInvalid() {
+	cases := []struct {
+		key   string
+		value interface{}
+	}{
+		{key: "jwt.secret", value: "secret"},
+		{key: "external.github", value: map[string]interface{}{"enabled": true}},
+		{key: "external.github.enabled", value: "yes"},
+		{key: "external.github.enabled", value: nil},
+	}
+
+	for _, c := range cases {
+		w := ts.request(http.MethodPut, "/admin/config/overrides/"+c.key, map[string]interface{}{
+			"value": c.value,
+		})
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code, c.key)
+	}
+
+	require.Equal(ts.T(), 0, ts.changed)
+}
+
+func (ts *ConfigOverridesTestSuite) TestDelete() {
+	w := ts.request(http.MethodPut, "/admin/config/overrides/external.github.enabled", map[string]interface{}{
+		"value": true,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	w = ts.request(http.MethodDelete, "/admin/config/overrides/external.github.enabled", nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	require.Equal(ts.T(), 2, ts.changed)
+
+	_, err := models.FindConfigOverrideByKey(ts.API.db, "external.github.enabled")
+	require.True(ts.T(), models.IsNotFoundError(err))
+
+	w = ts.request(http.MethodDelete, "/admin/config/overrides/external.github.enabled", nil)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+}
@@ -31,6 +31,7 @@ const (
 	ssoProviderKey          = contextKey("sso_provider")
 	externalHostKey         = contextKey("external_host")
 	flowStateKey            = contextKey("flow_state_id")
+	serviceClientKey        = contextKey("service_client")
 )
 
 // withToken adds the JWT token to the context.
@@ -230,6 +231,18 @@ func getSSOProvider(ctx context.Context) *models.SSOProvider {
 	return obj.(*models.SSOProvider)
 }
 
+func withServiceClient(ctx context.Context, client *models.ServiceClient) context.Context {
+	return context.WithValue(ctx, serviceClientKey, client)
+}
+
+func getServiceClient(ctx context.Context) *models.ServiceClient {
+	obj := ctx.Value(serviceClientKey)
+	if obj == nil {
+		return nil
+	}
+	return obj.(*models.ServiceClient)
+}
+
 func withExternalHost(ctx context.Context, u *url.URL) context.Context {
 	return context.WithValue(ctx, externalHostKey, u)
 }
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/supabase/auth/internal/metering"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DeviceCodeParams are the parameters the DeviceCode method accepts.
+// ClientID is optional and only shown to the user approving the device.
+type DeviceCodeParams struct {
+	ClientID string `json:"client_id"`
+}
+
+// DeviceCodeResponse is the device authorization response, as defined in
+// RFC 8628 section 3.2.
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceVerifyParams are the parameters the DeviceVerify method accepts.
+type DeviceVerifyParams struct {
+	UserCode string `json:"user_code"`
+	Action   string `json:"action"`
+}
+
+// DeviceCodeGrantParams are the parameters the DeviceCodeGrant method
+// accepts.
+type DeviceCodeGrantParams struct {
+	DeviceCode string `json:"device_code"`
+}
+
+func (a *API) deviceVerificationURI() string {
+	if a.config.DeviceAuthorization.VerificationURI != "" {
+		return a.config.DeviceAuthorization.VerificationURI
+	}
+
+	if a.config.HostedUI.Enabled {
+		return a.hostedUIBaseURL() + "/device"
+	}
+
+	return ""
+}
+
+// DeviceCode starts a device authorization grant (RFC 8628). The device
+// shows the user code and verification URI to the user, and polls the
+// token endpoint with the device code until the user has approved it.
+func (a *API) DeviceCode(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.config
+
+	params := &DeviceCodeParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	verificationURI := a.deviceVerificationURI()
+	if verificationURI == "" {
+		return internalServerError("Device authorization has no verification URI configured")
+	}
+
+	verificationURIComplete, err := url.Parse(verificationURI)
+	if err != nil {
+		return internalServerError("Device authorization verification URI is invalid").WithInternalError(err)
+	}
+
+	deviceCode, code, err := models.NewDeviceCode(params.ClientID, config.DeviceAuthorization.PollingInterval, config.DeviceAuthorization.Expiry)
+	if err != nil {
+		return internalServerError("Error creating device code").WithInternalError(err)
+	}
+
+	if err := db.Create(deviceCode); err != nil {
+		return internalServerError("Error saving device code").WithInternalError(err)
+	}
+
+	query := verificationURIComplete.Query()
+	query.Set("user_code", deviceCode.UserCode)
+	verificationURIComplete.RawQuery = query.Encode()
+
+	return sendJSON(w, http.StatusOK, &DeviceCodeResponse{
+		DeviceCode:              code,
+		UserCode:                deviceCode.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURIComplete.String(),
+		ExpiresIn:               int(config.DeviceAuthorization.Expiry.Seconds()),
+		Interval:                deviceCode.PollingInterval,
+	})
+}
+
+// DeviceVerify approves or denies a pending device code on behalf of the
+// signed in user. The device then receives a session for the user on its
+// next poll.
+func (a *API) DeviceVerify(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+
+	params := &DeviceVerifyParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.UserCode == "" {
+		return badRequestError(ErrorCodeValidationFailed, "user_code is required")
+	}
+
+	if params.Action == "" {
+		params.Action = "approve"
+	}
+
+	if params.Action != "approve" && params.Action != "deny" {
+		return badRequestError(ErrorCodeValidationFailed, "action must be approve or deny")
+	}
+
+	var deviceCode *models.DeviceCode
+	err := db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		deviceCode, terr = models.FindDeviceCodeByUserCode(tx, params.UserCode)
+		if terr != nil {
+			if models.IsNotFoundError(terr) {
+				return notFoundError(ErrorCodeDeviceCodeNotFound, "Invalid or already used code")
+			}
+			return internalServerError("Error finding device code").WithInternalError(terr)
+		}
+
+		// checked with the device code locked, so that it can't be
+		// approved twice or after the device was given a session
+		if deviceCode.Status != models.DeviceCodePending {
+			return notFoundError(ErrorCodeDeviceCodeNotFound, "Invalid or already used code")
+		}
+
+		if deviceCode.IsExpired() {
+			return badRequestError(ErrorCodeDeviceCodeExpired, "Code has expired")
+		}
+
+		action := models.DeviceCodeApprovedAction
+		if params.Action == "deny" {
+			action = models.DeviceCodeDeniedAction
+			terr = deviceCode.Deny(tx)
+		} else {
+			terr = deviceCode.Approve(tx, user.ID)
+		}
+		if terr != nil {
+			return internalServerError("Error updating device code").WithInternalError(terr)
+		}
+
+		var clientID string
+		if deviceCode.ClientID != nil {
+			clientID = *deviceCode.ClientID
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, user, action, "", map[string]interface{}{
+			"device_code_id": deviceCode.ID,
+			"client_id":      clientID,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]string{
+		"status": string(deviceCode.Status),
+	})
+}
+
+// DeviceCodeGrant implements the device code grant (RFC 8628 section 3.4).
+// Until the user approves the device, polls are answered with
+// authorization_pending, or slow_down when they come faster than the
+// polling interval.
+func (a *API) DeviceCodeGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	db := a.db.WithContext(ctx)
+
+	if !a.config.DeviceAuthorization.Enabled {
+		return oauthError("unsupported_grant_type", "")
+	}
+
+	var grantParams models.GrantParams
+	grantParams.FillGrantParams(r)
+
+	params := &DeviceCodeGrantParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.DeviceCode == "" {
+		return oauthError("invalid_request", "device_code required")
+	}
+
+	var token *AccessTokenResponse
+	var user *models.User
+	// grant errors are returned after the transaction commits, so that the
+	// recorded poll isn't rolled back
+	var grantErr error
+
+	err := db.Transaction(func(tx *storage.Connection) error {
+		deviceCode, terr := models.FindDeviceCodeByDeviceCode(tx, params.DeviceCode)
+		if terr != nil {
+			if models.IsNotFoundError(terr) {
+				grantErr = oauthError("invalid_grant", "Invalid device_code")
+				return nil
+			}
+			return internalServerError("Error finding device code").WithInternalError(terr)
+		}
+
+		if deviceCode.IsExpired() {
+			grantErr = oauthError("expired_token", "The device_code has expired")
+			return tx.Destroy(deviceCode)
+		}
+
+		switch deviceCode.Status {
+		case models.DeviceCodePending:
+			slowDown, terr := deviceCode.RecordPoll(tx)
+			if terr != nil {
+				return internalServerError("Error recording device code poll").WithInternalError(terr)
+			}
+
+			if slowDown {
+				grantErr = oauthError("slow_down", "Polling too frequently")
+			} else {
+				grantErr = oauthError("authorization_pending", "The user has not yet approved the device")
+			}
+			return nil
+
+		case models.DeviceCodeDenied:
+			grantErr = oauthError("access_denied", "The user denied the device")
+			return tx.Destroy(deviceCode)
+		}
+
+		user, terr = models.FindUserByID(tx, *deviceCode.UserID)
+		if terr != nil {
+			return internalServerError("Database error finding user").WithInternalError(terr)
+		}
+
+		if terr := tx.Destroy(deviceCode); terr != nil {
+			return internalServerError("Error deleting device code").WithInternalError(terr)
+		}
+
+		if user.IsBanned() {
+			grantErr = oauthError("access_denied", "User is banned")
+			return nil
+		}
+
+		token, terr = a.issueRefreshToken(r, tx, user, models.DeviceAuthorization, grantParams)
+		if terr != nil {
+			return terr
+		}
+
+		return models.NewAuditLogEntry(r, tx, user, models.LoginAction, "", map[string]interface{}{
+			"provider": models.DeviceAuthorization.String(),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if grantErr != nil {
+		return grantErr
+	}
+
+	metering.RecordLogin(models.DeviceAuthorization.String(), user.ID)
+	return sendJSON(w, http.StatusOK, token)
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+type DeviceTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	user  *models.User
+	token string
+}
+
+func TestDevice(t *testing.T) {
+	api, config, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, conn *storage.Connection) {
+		if config != nil {
+			config.DeviceAuthorization.Enabled = true
+			config.DeviceAuthorization.Expiry = 10 * time.Minute
+			config.DeviceAuthorization.PollingInterval = 5 * time.Second
+			config.DeviceAuthorization.VerificationURI = "https://example.com/device"
+		}
+	})
+	require.NoError(t, err)
+
+	ts := &DeviceTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *DeviceTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	ts.user = u
+
+	s, err := models.NewSession(u.ID, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(s))
+
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", nil)
+	ts.token, _, err = ts.API.generateAccessToken(req, ts.API.db, u, &s.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+}
+
+func (ts *DeviceTestSuite) request(method, path, bearer string, body map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *DeviceTestSuite) startDeviceFlow() *DeviceCodeResponse {
+	w := ts.request(http.MethodPost, "/device/code", "", map[string]interface{}{
+		"client_id": "cli",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	res := &DeviceCodeResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(res))
+	return res
+}
+
+func (ts *DeviceTestSuite) poll(deviceCode string) *httptest.ResponseRecorder {
+	return ts.request(http.MethodPost, "/token?grant_type="+url.QueryEscape(deviceCodeGrantType), "", map[string]interface{}{
+		"device_code": deviceCode,
+	})
+}
+
+func (ts *DeviceTestSuite) requireOAuthError(w *httptest.ResponseRecorder, expected string) {
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	data := &OAuthError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), expected, data.Err)
+}
+
+func (ts *DeviceTestSuite) TestDeviceCode() {
+	res := ts.startDeviceFlow()
+
+	require.NotEmpty(ts.T(), res.DeviceCode)
+	require.Regexp(ts.T(), `^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`, res.UserCode)
+	require.Equal(ts.T(), "https://example.com/device", res.VerificationURI)
+	require.Equal(ts.T(), "https://example.com/device?user_code="+url.QueryEscape(res.UserCode), res.VerificationURIComplete)
+	require.Equal(ts.T(), 600, res.ExpiresIn)
+	require.Equal(ts.T(), 5, res.Interval)
+}
+
+func (ts *DeviceTestSuite) TestDeviceCodeGrant() {
+	res := ts.startDeviceFlow()
+
+	ts.requireOAuthError(ts.poll(res.DeviceCode), "authorization_pending")
+	ts.requireOAuthError(ts.poll(res.DeviceCode), "slow_down")
+
+	deviceCode, err := models.FindDeviceCodeByUserCode(ts.API.db, res.UserCode)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), 10, deviceCode.PollingInterval)
+
+	// user codes are accepted in lower case and without the dash
+	w := ts.request(http.MethodPost, "/device/verify", ts.token, map[string]interface{}{
+		"user_code": res.UserCode[:4] + res.UserCode[5:],
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	w = ts.poll(res.DeviceCode)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	require.NotEmpty(ts.T(), token.Token)
+	require.NotEmpty(ts.T(), token.RefreshToken)
+	require.Equal(ts.T(), ts.user.ID, token.User.ID)
+
+	// the device code can only be used once
+	ts.requireOAuthError(ts.poll(res.DeviceCode), "invalid_grant")
+}
+
+func (ts *DeviceTestSuite) TestDeviceVerifyConcurrently() {
+	res := ts.startDeviceFlow()
+
+	codes := make(chan int, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- ts.request(http.MethodPost, "/device/verify", ts.token, map[string]interface{}{
+				"user_code": res.UserCode,
+			}).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	approved := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			approved += 1
+		} else {
+			require.Equal(ts.T(), http.StatusNotFound, code)
+		}
+	}
+	require.Equal(ts.T(), 1, approved)
+
+	w := ts.poll(res.DeviceCode)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	// a consumed device code can't be approved again
+	w = ts.request(http.MethodPost, "/device/verify", ts.token, map[string]interface{}{
+		"user_code": res.UserCode,
+	})
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+	ts.requireOAuthError(ts.poll(res.DeviceCode), "invalid_grant")
+}
+
+func (ts *DeviceTestSuite) TestDeviceCodeDenied() {
+	res := ts.startDeviceFlow()
+
+	w := ts.request(http.MethodPost, "/device/verify", ts.token, map[string]interface{}{
+		"user_code": res.UserCode,
+		"action":    "deny",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	ts.requireOAuthError(ts.poll(res.DeviceCode), "access_denied")
+
+	w = ts.request(http.MethodPost, "/device/verify", ts.token, map[string]interface{}{
+		"user_code": res.UserCode,
+	})
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+}
+
+func (ts *DeviceTestSuite) TestDeviceCodeExpired() {
+	res := ts.startDeviceFlow()
+
+	deviceCode, err := models.FindDeviceCodeByUserCode(ts.API.db, res.UserCode)
+	require.NoError(ts.T(), err)
+	deviceCode.ExpiresAt = time.Now().Add(-time.Minute)
+	require.NoError(ts.T(), ts.API.db.UpdateOnly(deviceCode, "expires_at"))
+
+	w := ts.request(http.MethodPost, "/device/verify", ts.token, map[string]interface{}{
+		"user_code": res.UserCode,
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	ts.requireOAuthError(ts.poll(res.DeviceCode), "expired_token")
+}
+
+func (ts *DeviceTestSuite) TestDeviceVerifyRequiresAuthentication() {
+	res := ts.startDeviceFlow()
+
+	w := ts.request(http.MethodPost, "/device/verify", "", map[string]interface{}{
+		"user_code": res.UserCode,
+	})
+	require.Equal(ts.T(), http.StatusUnauthorized, w.Code)
+}
+
+func (ts *DeviceTestSuite) TestDisabled() {
+	ts.Config.DeviceAuthorization.Enabled = false
+	defer func() {
+		ts.Config.DeviceAuthorization.Enabled = true
+	}()
+
+	w := ts.request(http.MethodPost, "/device/code", "", map[string]interface{}{})
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+
+	ts.requireOAuthError(ts.poll("device-code"), "unsupported_grant_type")
+}
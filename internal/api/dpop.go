@@ -0,0 +1,205 @@
+package api
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	jose "github.com/go-jose/go-jose/v3"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/supabase/auth/internal/hooks"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+const dpopProofType = "dpop+jwt"
+
+// dpopSigningMethods are the algorithms accepted for DPoP proofs. Proofs
+// must be signed with an asymmetric key.
+var dpopSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+var dpopRegexp = regexp.MustCompile(`^(?i:dpop) (\S+$)`)
+
+// dpopProofClaims are the claims of a DPoP proof, as defined in RFC 9449
+// section 4.2.
+type dpopProofClaims struct {
+	jwt.RegisteredClaims
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+}
+
+// dpopAccessTokenHash is the ath claim of proofs sent with the access
+// token.
+func dpopAccessTokenHash(accessToken string) string {
+	hash := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// dpopTargetURI is the htu expected in proofs sent with r. The external URL
+// is used, as the server can be behind a proxy that rewrites the URL.
+func (a *API) dpopTargetURI(r *http.Request) string {
+	return strings.TrimSuffix(a.config.API.ExternalURL, "/") + r.URL.Path
+}
+
+func normalizeDPoPTargetURI(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	return u.String(), nil
+}
+
+// verifyDPoPProof checks the DPoP proof sent with r, if any, and returns
+// the JWK SHA-256 thumbprint of its key. When the proof is sent with an
+// access token, accessToken must be set so that the proof is checked to be
+// bound to it. The proof's jti is recorded with conn, so that the proof can't
+// be used again with any instance.
+func (a *API) verifyDPoPProof(r *http.Request, conn *storage.Connection, accessToken string) (string, error) {
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) == 0 {
+		return "", nil
+	}
+	if len(proofs) > 1 {
+		return "", errors.New("only one DPoP proof may be sent")
+	}
+
+	var jkt string
+	claims := &dpopProofClaims{}
+
+	p := jwt.NewParser(jwt.WithValidMethods(dpopSigningMethods))
+	if _, err := p.ParseWithClaims(proofs[0], claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != dpopProofType {
+			return nil, fmt.Errorf("DPoP proof must have the %s type", dpopProofType)
+		}
+
+		header, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+
+		var jwk jose.JSONWebKey
+		if err := json.Unmarshal(header, &jwk); err != nil {
+			return nil, fmt.Errorf("DPoP proof has an invalid jwk header: %w", err)
+		}
+
+		if !jwk.Valid() || !jwk.IsPublic() {
+			return nil, errors.New("DPoP proof jwk header must be a public key")
+		}
+
+		thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		jkt = base64.RawURLEncoding.EncodeToString(thumbprint)
+
+		return jwk.Key, nil
+	}); err != nil {
+		return "", fmt.Errorf("invalid DPoP proof: %w", err)
+	}
+
+	if claims.ID == "" {
+		return "", errors.New("DPoP proof must have a jti claim")
+	}
+
+	if claims.IssuedAt == nil {
+		return "", errors.New("DPoP proof must have an iat claim")
+	}
+
+	now := a.Now()
+	maxAge := a.config.DPoP.ProofMaxAge
+	issuedAt := claims.IssuedAt.Time
+	if issuedAt.Before(now.Add(-maxAge)) || issuedAt.After(now.Add(maxAge)) {
+		return "", errors.New("DPoP proof is too old or issued in the future")
+	}
+
+	if claims.HTM != r.Method {
+		return "", errors.New("DPoP proof htm claim doesn't match the request method")
+	}
+
+	htu, err := normalizeDPoPTargetURI(claims.HTU)
+	if err != nil {
+		return "", errors.New("DPoP proof htu claim is invalid")
+	}
+	expected, err := normalizeDPoPTargetURI(a.dpopTargetURI(r))
+	if err != nil || htu != expected {
+		return "", errors.New("DPoP proof htu claim doesn't match the request URI")
+	}
+
+	if accessToken != "" && claims.ATH != dpopAccessTokenHash(accessToken) {
+		return "", errors.New("DPoP proof ath claim doesn't match the access token")
+	}
+
+	unused, err := models.UseDPoPProof(conn, jkt, claims.ID, issuedAt.Add(maxAge))
+	if err != nil {
+		return "", internalServerError("Database error recording DPoP proof").WithInternalError(err)
+	}
+	if !unused {
+		return "", errors.New("DPoP proof has already been used")
+	}
+
+	return jkt, nil
+}
+
+// dpopConfirmation returns the cnf claim of access tokens for a session
+// bound to the DPoP key with thumbprint jkt.
+func dpopConfirmation(jkt *string) *hooks.ConfirmationClaim {
+	if jkt == nil {
+		return nil
+	}
+
+	return &hooks.ConfirmationClaim{JKT: *jkt}
+}
+
+// tokenType is the token_type of access tokens for a session bound to the
+// DPoP key with thumbprint jkt.
+func tokenType(jkt *string) string {
+	if jkt == nil {
+		return "bearer"
+	}
+
+	return "DPoP"
+}
+
+// requireDPoPBinding checks that access tokens bound to a DPoP key are sent
+// with a proof signed with that key, as described in RFC 9449 section 7.
+func (a *API) requireDPoPBinding(w http.ResponseWriter, r *http.Request, accessToken string, claims *AccessTokenClaims) error {
+	if claims.Confirmation == nil || claims.Confirmation.JKT == "" {
+		return nil
+	}
+
+	fail := func(description string) error {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("DPoP error=%q, algs=%q", "invalid_dpop_proof", strings.Join(dpopSigningMethods, " ")))
+		return httpError(http.StatusUnauthorized, ErrorCodeInvalidDPoPProof, description)
+	}
+
+	if !dpopRegexp.MatchString(r.Header.Get("Authorization")) {
+		return fail("This access token is bound to a DPoP key and must be sent with the DPoP scheme")
+	}
+
+	jkt, err := a.verifyDPoPProof(r, a.db.WithContext(r.Context()), accessToken)
+	if httpErr, ok := err.(*HTTPError); ok {
+		return httpErr
+	} else if err != nil {
+		return fail(err.Error())
+	}
+
+	if jkt != claims.Confirmation.JKT {
+		return fail("DPoP proof isn't signed with the key the access token is bound to")
+	}
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v3"
+	"github.com/gofrs/uuid"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+type DPoPTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	key *ecdsa.PrivateKey
+	jkt string
+}
+
+func TestDPoP(t *testing.T) {
+	api, config, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, conn *storage.Connection) {
+		if config != nil {
+			config.DPoP.Enabled = true
+			config.DPoP.ProofMaxAge = time.Minute
+		}
+	})
+	require.NoError(t, err)
+
+	ts := &DPoPTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *DPoPTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	now := time.Now()
+	u.EmailConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	ts.key, ts.jkt = ts.generateKey()
+}
+
+func (ts *DPoPTestSuite) generateKey() (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(ts.T(), err)
+
+	thumbprint, err := (&jose.JSONWebKey{Key: &key.PublicKey}).Thumbprint(crypto.SHA256)
+	require.NoError(ts.T(), err)
+
+	return key, base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+func (ts *DPoPTestSuite) proof(key *ecdsa.PrivateKey, method, path, accessToken string) string {
+	claims := &dpopProofClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       uuid.Must(uuid.NewV4()).String(),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+		HTM: method,
+		HTU: ts.Config.API.ExternalURL + path,
+	}
+	if accessToken != "" {
+		claims.ATH = dpopAccessTokenHash(accessToken)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = dpopProofType
+	token.Header["jwk"] = &jose.JSONWebKey{Key: &key.PublicKey}
+
+	signed, err := token.SignedString(key)
+	require.NoError(ts.T(), err)
+	return signed
+}
+
+func (ts *DPoPTestSuite) request(method, path, authorization, proof string, body map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	if proof != "" {
+		req.Header.Set("DPoP", proof)
+	}
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *DPoPTestSuite) signIn() *AccessTokenResponse {
+	w := ts.request(http.MethodPost, "/token?grant_type=password", "", ts.proof(ts.key, http.MethodPost, "/token", ""), map[string]interface{}{
+		"email":    "test@example.com",
+		"password": "password",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	return token
+}
+
+func (ts *DPoPTestSuite) TestBoundToken() {
+	token := ts.signIn()
+	require.Equal(ts.T(), "DPoP", token.TokenType)
+
+	claims := &AccessTokenClaims{}
+	_, err := jwt.ParseWithClaims(token.Token, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(ts.Config.JWT.Secret), nil
+	})
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), claims.Confirmation)
+	require.Equal(ts.T(), ts.jkt, claims.Confirmation.JKT)
+
+	session, err := models.FindSessionByID(ts.API.db, uuid.FromStringOrNil(claims.SessionId), false)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), session.DPoPJKT)
+	require.Equal(ts.T(), ts.jkt, *session.DPoPJKT)
+}
+
+func (ts *DPoPTestSuite) TestRequireAuthentication() {
+	token := ts.signIn()
+
+	// a bound token can't be used as a bearer token
+	w := ts.request(http.MethodGet, "/user", "Bearer "+token.Token, "", nil)
+	require.Equal(ts.T(), http.StatusUnauthorized, w.Code)
+	require.Contains(ts.T(), w.Header().Get("WWW-Authenticate"), "invalid_dpop_proof")
+
+	w = ts.request(http.MethodGet, "/user", "DPoP "+token.Token, "", nil)
+	require.Equal(ts.T(), http.StatusUnauthorized, w.Code)
+
+	proof := ts.proof(ts.key, http.MethodGet, "/user", token.Token)
+	w = ts.request(http.MethodGet, "/user", "DPoP "+token.Token, proof, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// proofs can't be replayed
+	w = ts.request(http.MethodGet, "/user", "DPoP "+token.Token, proof, nil)
+	require.Equal(ts.T(), http.StatusUnauthorized, w.Code)
+
+	otherKey, _ := ts.generateKey()
+	cases := []string{
+		ts.proof(otherKey, http.MethodGet, "/user", token.Token),
+		ts.proof(ts.key, http.MethodPost, "/user", token.Token),
+		ts.proof(ts.key, http.MethodGet, "/factors", token.Token),
+		ts.proof(ts.key, http.MethodGet, "/user", ""),
+	}
+
+	for _, c := range cases {
+		w := ts.request(http.MethodGet, "/user", "DPoP "+token.Token, c, nil)
+		require.Equal(ts.T(), http.StatusUnauthorized, w.Code)
+	}
+}
+
+func (ts *DPoPTestSuite) TestRefreshTokenGrant() {
+	token := ts.signIn()
+
+	w := ts.request(http.MethodPost, "/token?grant_type=refresh_token", "", "", map[string]interface{}{
+		"refresh_token": token.RefreshToken,
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_dpop_proof")
+
+	otherKey, _ := ts.generateKey()
+	w = ts.request(http.MethodPost, "/token?grant_type=refresh_token", "", ts.proof(otherKey, http.MethodPost, "/token", ""), map[string]interface{}{
+		"refresh_token": token.RefreshToken,
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_dpop_proof")
+
+	w = ts.request(http.MethodPost, "/token?grant_type=refresh_token", "", ts.proof(ts.key, http.MethodPost, "/token", ""), map[string]interface{}{
+		"refresh_token": token.RefreshToken,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	refreshed := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(refreshed))
+	require.Equal(ts.T(), "DPoP", refreshed.TokenType)
+}
+
+func (ts *DPoPTestSuite) TestUnboundToken() {
+	w := ts.request(http.MethodPost, "/token?grant_type=password", "", "", map[string]interface{}{
+		"email":    "test@example.com",
+		"password": "password",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	require.Equal(ts.T(), "bearer", token.TokenType)
+
+	w = ts.request(http.MethodGet, "/user", "Bearer "+token.Token, "", nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+}
+
+func (ts *DPoPTestSuite) TestUseDPoPProof() {
+	db := ts.API.db
+
+	used, err := models.UseDPoPProof(db, ts.jkt, "a", time.Now().Add(time.Minute))
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), used)
+
+	// proofs can't be used twice, even by other instances
+	used, err = models.UseDPoPProof(db, ts.jkt, "a", time.Now().Add(time.Minute))
+	require.NoError(ts.T(), err)
+	require.False(ts.T(), used)
+
+	// the same jti can be used with another key
+	_, otherJKT := ts.generateKey()
+	used, err = models.UseDPoPProof(db, otherJKT, "a", time.Now().Add(time.Minute))
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), used)
+
+	// and once the proof expired
+	used, err = models.UseDPoPProof(db, ts.jkt, "b", time.Now().Add(-time.Second))
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), used)
+
+	used, err = models.UseDPoPProof(db, ts.jkt, "b", time.Now().Add(time.Minute))
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), used)
+}
@@ -78,4 +78,24 @@ const (
 	ErrorCodeHookPayloadOverSizeLimit          ErrorCode = "hook_payload_over_size_limit"
 	ErrorCodeHookPayloadUnknownSize            ErrorCode = "hook_payload_unknown_size"
 	ErrorCodeRequestTimeout                    ErrorCode = "request_timeout"
+	ErrorCodeImpersonationDisabled             ErrorCode = "impersonation_disabled"
+	ErrorCodeSessionImpersonated               ErrorCode = "session_impersonated"
+	ErrorCodeConfigOverridesDisabled           ErrorCode = "config_overrides_disabled"
+	ErrorCodeConfigOverrideNotFound            ErrorCode = "config_override_not_found"
+	ErrorCodeHostedUIDisabled                  ErrorCode = "hosted_ui_disabled"
+	ErrorCodeDeviceAuthorizationDisabled       ErrorCode = "device_authorization_disabled"
+	ErrorCodeDeviceCodeNotFound                ErrorCode = "device_code_not_found"
+	ErrorCodeDeviceCodeExpired                 ErrorCode = "device_code_expired"
+	ErrorCodeServiceClientsDisabled            ErrorCode = "service_clients_disabled"
+	ErrorCodeServiceClientNotFound             ErrorCode = "service_client_not_found"
+	ErrorCodeServiceClientRevoked              ErrorCode = "service_client_revoked"
+	ErrorCodeInsufficientScope                 ErrorCode = "insufficient_scope"
+	ErrorCodeAPIKeysDisabled                   ErrorCode = "api_keys_disabled"
+	ErrorCodeAPIKeyNotFound                    ErrorCode = "api_key_not_found"
+	ErrorCodeTooManyAPIKeys                    ErrorCode = "too_many_api_keys"
+	ErrorCodeSessionAPIKey                     ErrorCode = "session_api_key"
+	ErrorCodeInvalidDPoPProof                  ErrorCode = "invalid_dpop_proof"
+	ErrorCodeUserNotAnonymous                  ErrorCode = "user_not_anonymous"
+	ErrorCodeOverAnonymousSessionLimit         ErrorCode = "over_anonymous_session_limit"
+	ErrorCodeAccountDeletionDisabled           ErrorCode = "account_deletion_disabled"
 )
@@ -170,6 +170,7 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 	grantParams.FillGrantParams(r)
 
 	providerType := getExternalProviderType(ctx)
+	models.GetAuditContext(ctx).AuthMethod = models.OAuth.String()
 	data, err := a.handleOAuthCallback(r)
 	if err != nil {
 		return err
@@ -298,6 +299,14 @@ func (a *API) createAccountFromExternalIdentity(tx *storage.Connection, r *http.
 			return nil, terr
 		}
 
+		if terr = models.NewAuditLogEntry(r, tx, user, models.IdentityLinkAction, "", map[string]interface{}{
+			"identity_id": identity.ID,
+			"provider":    identity.Provider,
+			"provider_id": identity.ProviderID,
+		}); terr != nil {
+			return nil, terr
+		}
+
 		if terr = user.UpdateUserMetaData(tx, identityData); terr != nil {
 			return nil, terr
 		}
@@ -64,11 +64,20 @@ func getBodyBytes(req *http.Request) ([]byte, error) {
 
 type RequestParams interface {
 	AdminUserParams |
+		APIKeyGrantParams |
+		APIKeyParams |
+		APIKeyVerifyParams |
+		ClientCredentialsGrantParams |
+		ConfigOverrideParams |
 		CreateSSOProviderParams |
+		DeviceCodeGrantParams |
+		DeviceCodeParams |
+		DeviceVerifyParams |
 		EnrollFactorParams |
 		GenerateLinkParams |
 		IdTokenGrantParams |
 		InviteParams |
+		MergeAnonymousUserParams |
 		OtpParams |
 		PKCEGrantParams |
 		PasswordGrantParams |
@@ -77,7 +86,9 @@ type RequestParams interface {
 		ResendConfirmationParams |
 		SignupParams |
 		SingleSignOnParams |
+		ServiceClientParams |
 		SmsParams |
+		TokenExchangeGrantParams |
 		UserUpdateParams |
 		VerifyFactorParams |
 		VerifyParams |
@@ -341,6 +341,84 @@ func (a *API) invokeHook(conn *storage.Connection, r *http.Request, input, outpu
 			return httpError
 		}
 		return nil
+	case *hooks.RefreshTokenReuseInput:
+		hookOutput, ok := output.(*hooks.RefreshTokenReuseOutput)
+		if !ok {
+			panic("output should be *hooks.RefreshTokenReuseOutput")
+		}
+		if response, err = a.runHook(r, conn, a.config.Hook.RefreshTokenReuse, input, output, u.Scheme); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(response, hookOutput); err != nil {
+			return internalServerError("Error unmarshaling Refresh Token Reuse output.").WithInternalError(err)
+		}
+		if hookOutput.IsError() {
+			httpCode := hookOutput.HookError.HTTPCode
+
+			if httpCode == 0 {
+				httpCode = http.StatusInternalServerError
+			}
+
+			httpError := &HTTPError{
+				HTTPStatus: httpCode,
+				Message:    hookOutput.HookError.Message,
+			}
+
+			return httpError.WithInternalError(&hookOutput.HookError)
+		}
+		return nil
+	case *hooks.UserMergedInput:
+		hookOutput, ok := output.(*hooks.UserMergedOutput)
+		if !ok {
+			panic("output should be *hooks.UserMergedOutput")
+		}
+		if response, err = a.runHook(r, conn, a.config.Hook.UserMerged, input, output, u.Scheme); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(response, hookOutput); err != nil {
+			return internalServerError("Error unmarshaling User Merged output.").WithInternalError(err)
+		}
+		if hookOutput.IsError() {
+			httpCode := hookOutput.HookError.HTTPCode
+
+			if httpCode == 0 {
+				httpCode = http.StatusInternalServerError
+			}
+
+			httpError := &HTTPError{
+				HTTPStatus: httpCode,
+				Message:    hookOutput.HookError.Message,
+			}
+
+			return httpError.WithInternalError(&hookOutput.HookError)
+		}
+		return nil
+	case *hooks.AnonymousUserExpiryInput:
+		hookOutput, ok := output.(*hooks.AnonymousUserExpiryOutput)
+		if !ok {
+			panic("output should be *hooks.AnonymousUserExpiryOutput")
+		}
+		if response, err = a.runHook(r, conn, a.config.Hook.AnonymousUserExpiry, input, output, u.Scheme); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(response, hookOutput); err != nil {
+			return internalServerError("Error unmarshaling Anonymous User Expiry output.").WithInternalError(err)
+		}
+		if hookOutput.IsError() {
+			httpCode := hookOutput.HookError.HTTPCode
+
+			if httpCode == 0 {
+				httpCode = http.StatusInternalServerError
+			}
+
+			httpError := &HTTPError{
+				HTTPStatus: httpCode,
+				Message:    hookOutput.HookError.Message,
+			}
+
+			return httpError.WithInternalError(&hookOutput.HookError)
+		}
+		return nil
 	}
 	return nil
 }
@@ -0,0 +1,767 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+const (
+	hostedUICSRFCookie    = "ui-csrf"
+	hostedUIPendingCookie = "ui-pending"
+
+	// hostedUIPendingDuration is how long a signed in user has to complete
+	// the MFA challenge or the consent page.
+	hostedUIPendingDuration = 10 * time.Minute
+)
+
+// hostedUIPage holds everything a hosted UI template can show.
+type hostedUIPage struct {
+	Title string
+	Theme conf.HostedUIThemeConfiguration
+
+	BaseURL    string
+	CSRFToken  string
+	Nonce      string
+	RedirectTo string
+
+	Error   string
+	Message string
+
+	Link     string
+	LinkText string
+
+	CaptchaProvider string
+	CaptchaSiteKey  string
+
+	Email             string
+	AccessToken       string
+	UserCode          string
+	ClientHost        string
+	SignupEnabled     bool
+	PasswordMinLength int
+}
+
+// hostedUIPending is the session of a signed in user that hasn't been
+// handed over to the client yet, because MFA or consent is still needed.
+type hostedUIPending struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	ExpiresAt    int64  `json:"expires_at"`
+	RefreshToken string `json:"refresh_token"`
+	Email        string `json:"email,omitempty"`
+}
+
+func (p *hostedUIPending) token() *AccessTokenResponse {
+	return &AccessTokenResponse{
+		Token:        p.AccessToken,
+		TokenType:    p.TokenType,
+		ExpiresIn:    p.ExpiresIn,
+		ExpiresAt:    p.ExpiresAt,
+		RefreshToken: p.RefreshToken,
+	}
+}
+
+func (a *API) requireHostedUIEnabled(w http.ResponseWriter, req *http.Request) (context.Context, error) {
+	ctx := req.Context()
+	if !a.config.HostedUI.Enabled {
+		return nil, notFoundError(ErrorCodeHostedUIDisabled, "Hosted UI is disabled")
+	}
+	return ctx, nil
+}
+
+func (a *API) hostedUIBaseURL() string {
+	return strings.TrimSuffix(a.config.API.ExternalURL, "/") + "/ui"
+}
+
+// hostedUIRedirectTo returns where the user is sent once signed in, which
+// must be an allowed redirect URL.
+func (a *API) hostedUIRedirectTo(r *http.Request) string {
+	redirectTo := r.FormValue("redirect_to")
+	if utilities.IsRedirectURLValid(a.config, redirectTo) && (!strings.HasPrefix(redirectTo, a.hostedUIBaseURL()+"/") || a.isHostedUIDeviceURL(redirectTo)) {
+		return redirectTo
+	}
+	return a.config.SiteURL
+}
+
+// isHostedUIDeviceURL reports whether redirectTo is the device page, the
+// only hosted page users are sent to after signing in.
+func (a *API) isHostedUIDeviceURL(redirectTo string) bool {
+	device := a.hostedUIBaseURL() + "/device"
+	return redirectTo == device || strings.HasPrefix(redirectTo, device+"?")
+}
+
+func (a *API) hostedUICookie(name, value string, maxAge int) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     a.config.Cookie.Key + "-" + name,
+		Value:    value,
+		Path:     "/ui",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if u, err := url.Parse(a.hostedUIBaseURL()); err == nil {
+		cookie.Path = u.Path
+		cookie.Secure = u.Scheme == "https"
+	}
+
+	return cookie
+}
+
+// hostedUICSRFToken returns the CSRF token of the browser, setting a new
+// one if it has none. Forms must post it back in the csrf_token field.
+func (a *API) hostedUICSRFToken(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(a.config.Cookie.Key + "-" + hostedUICSRFCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	token := crypto.SecureToken()
+	http.SetCookie(w, a.hostedUICookie(hostedUICSRFCookie, token, 0))
+
+	return token
+}
+
+func (a *API) isValidHostedUICSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(a.config.Cookie.Key + "-" + hostedUICSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue("csrf_token"))) == 1
+}
+
+func (a *API) setHostedUIPending(w http.ResponseWriter, pending *hostedUIPending) error {
+	encoded, err := json.Marshal(pending)
+	if err != nil {
+		return internalServerError("Error encoding hosted UI session").WithInternalError(err)
+	}
+
+	http.SetCookie(w, a.hostedUICookie(hostedUIPendingCookie, base64.RawURLEncoding.EncodeToString(encoded), int(hostedUIPendingDuration.Seconds())))
+
+	return nil
+}
+
+func (a *API) getHostedUIPending(r *http.Request) *hostedUIPending {
+	cookie, err := r.Cookie(a.config.Cookie.Key + "-" + hostedUIPendingCookie)
+	if err != nil {
+		return nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil
+	}
+
+	var pending hostedUIPending
+	if err := json.Unmarshal(decoded, &pending); err != nil || pending.AccessToken == "" {
+		return nil
+	}
+
+	return &pending
+}
+
+func (a *API) clearHostedUIPending(w http.ResponseWriter) {
+	http.SetCookie(w, a.hostedUICookie(hostedUIPendingCookie, "", -1))
+}
+
+func (a *API) newHostedUIPage(w http.ResponseWriter, r *http.Request, title string) *hostedUIPage {
+	config := a.config
+
+	page := &hostedUIPage{
+		Title:             title,
+		Theme:             config.HostedUI.Theme,
+		BaseURL:           a.hostedUIBaseURL(),
+		CSRFToken:         a.hostedUICSRFToken(w, r),
+		Nonce:             crypto.SecureToken(),
+		RedirectTo:        a.hostedUIRedirectTo(r),
+		SignupEnabled:     !config.DisableSignup,
+		PasswordMinLength: config.Password.MinLength,
+	}
+
+	if config.Security.Captcha.Enabled {
+		page.CaptchaProvider = config.Security.Captcha.Provider
+		page.CaptchaSiteKey = config.Security.Captcha.SiteKey
+	}
+
+	return page
+}
+
+func (a *API) renderHostedUI(w http.ResponseWriter, status int, name string, page *hostedUIPage) error {
+	var body bytes.Buffer
+	if err := hostedUITemplates[name].Execute(&body, page); err != nil {
+		return internalServerError("Error rendering page").WithInternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", fmt.Sprintf("default-src 'self'; img-src https: data:; style-src 'self' 'unsafe-inline' https:; script-src 'nonce-%s' 'strict-dynamic'; frame-src https:; connect-src https:; frame-ancestors 'none'", page.Nonce))
+	w.WriteHeader(status)
+
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// renderHostedUIMessage shows a page with a message, and a link to go on.
+func (a *API) renderHostedUIMessage(w http.ResponseWriter, r *http.Request, title, message string) error {
+	page := a.newHostedUIPage(w, r, title)
+	page.Message = message
+	page.Link = page.BaseURL + "/sign-in?redirect_to=" + url.QueryEscape(page.RedirectTo)
+	page.LinkText = "Back to sign in"
+
+	return a.renderHostedUI(w, http.StatusOK, "message", page)
+}
+
+// hostedUIResponse captures the response of an API request made on behalf
+// of a hosted UI page.
+type hostedUIResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *hostedUIResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *hostedUIResponse) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *hostedUIResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *hostedUIResponse) ok() bool {
+	return r.status >= 200 && r.status < 300
+}
+
+func (r *hostedUIResponse) decode(v interface{}) error {
+	return json.Unmarshal(r.body.Bytes(), v)
+}
+
+// errorMessage returns the message of an API error, in any of the error
+// formats of the API.
+func (r *hostedUIResponse) errorMessage() string {
+	var e struct {
+		Msg              string `json:"msg"`
+		Message          string `json:"message"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	if err := r.decode(&e); err == nil {
+		for _, message := range []string{e.Msg, e.Message, e.ErrorDescription} {
+			if message != "" {
+				return message
+			}
+		}
+	}
+
+	return "Something went wrong, please try again."
+}
+
+// callHostedUI makes an API request on behalf of a hosted UI page, so that
+// the pages go through the same rate limits, captcha verification, hooks
+// and audit logging as any other client.
+func (a *API) callHostedUI(r *http.Request, method, path, accessToken string, body interface{}) (*hostedUIResponse, error) {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return nil, internalServerError("Error encoding request").WithInternalError(err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), method, path, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, internalServerError("Error creating request").WithInternalError(err)
+	}
+
+	for name, values := range r.Header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "Content-Length", "Content-Type", "Cookie", "Referer":
+			continue
+		}
+		req.Header[name] = values
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	req.RemoteAddr = r.RemoteAddr
+	req.Host = r.Host
+
+	res := &hostedUIResponse{header: make(http.Header)}
+	a.handler.ServeHTTP(res, req)
+
+	return res, nil
+}
+
+// hostedUICaptcha returns the captcha fields of a request body, taken from
+// the response of the captcha widget.
+func hostedUICaptcha(r *http.Request) map[string]string {
+	token := r.PostFormValue("h-captcha-response")
+	if token == "" {
+		token = r.PostFormValue("cf-turnstile-response")
+	}
+
+	return map[string]string{"captcha_token": token}
+}
+
+// continueHostedUI is called once the user is signed in. It asks for an MFA
+// code if the user has verified factors, and then finishes.
+func (a *API) continueHostedUI(w http.ResponseWriter, r *http.Request, token *AccessTokenResponse, redirectTo string) error {
+	pending := &hostedUIPending{
+		AccessToken:  token.Token,
+		TokenType:    token.TokenType,
+		ExpiresIn:    token.ExpiresIn,
+		ExpiresAt:    token.ExpiresAt,
+		RefreshToken: token.RefreshToken,
+	}
+
+	needsMFA := false
+	if token.User != nil {
+		pending.Email = token.User.GetEmail()
+
+		for _, factor := range token.User.Factors {
+			if factor.IsVerified() && factor.FactorType == models.TOTP {
+				needsMFA = a.config.MFA.Enabled
+			}
+		}
+	}
+
+	if needsMFA {
+		if err := a.setHostedUIPending(w, pending); err != nil {
+			return err
+		}
+
+		http.Redirect(w, r, a.hostedUIBaseURL()+"/mfa?redirect_to="+url.QueryEscape(redirectTo), http.StatusSeeOther)
+		return nil
+	}
+
+	return a.finishHostedUI(w, r, pending, redirectTo)
+}
+
+// finishHostedUI hands the session over to the client, in the fragment of
+// the redirect URL like the implicit flow does. Clients on another host
+// than the site URL need the user's consent first.
+func (a *API) finishHostedUI(w http.ResponseWriter, r *http.Request, pending *hostedUIPending, redirectTo string) error {
+	if a.hostedUINeedsConsent(redirectTo) {
+		if err := a.setHostedUIPending(w, pending); err != nil {
+			return err
+		}
+
+		http.Redirect(w, r, a.hostedUIBaseURL()+"/consent?redirect_to="+url.QueryEscape(redirectTo), http.StatusSeeOther)
+		return nil
+	}
+
+	a.clearHostedUIPending(w)
+	http.Redirect(w, r, pending.token().AsRedirectURL(redirectTo, url.Values{}), http.StatusSeeOther)
+
+	return nil
+}
+
+func (a *API) hostedUINeedsConsent(redirectTo string) bool {
+	if a.isHostedUIDeviceURL(redirectTo) {
+		// approving the device is the consent
+		return false
+	}
+
+	site, err := url.Parse(a.config.SiteURL)
+	if err != nil {
+		return true
+	}
+
+	target, err := url.Parse(redirectTo)
+	if err != nil {
+		return true
+	}
+
+	return site.Hostname() != target.Hostname()
+}
+
+// hostedUIRedirect sends the browser to the index of the hosted UI.
+func (a *API) hostedUIRedirect(w http.ResponseWriter, r *http.Request) error {
+	http.Redirect(w, r, a.hostedUIBaseURL()+"/sign-in?redirect_to="+url.QueryEscape(a.hostedUIRedirectTo(r)), http.StatusSeeOther)
+	return nil
+}
+
+func (a *API) hostedUISignInPage(w http.ResponseWriter, r *http.Request) error {
+	return a.renderHostedUI(w, http.StatusOK, "sign_in", a.newHostedUIPage(w, r, "Sign in"))
+}
+
+func (a *API) hostedUISignIn(w http.ResponseWriter, r *http.Request) error {
+	page := a.newHostedUIPage(w, r, "Sign in")
+	page.Email = r.PostFormValue("email")
+
+	if !a.isValidHostedUICSRFToken(r) {
+		page.Error = "Your session has expired, please try again."
+		return a.renderHostedUI(w, http.StatusForbidden, "sign_in", page)
+	}
+
+	res, err := a.callHostedUI(r, http.MethodPost, "/token?grant_type=password", "", map[string]interface{}{
+		"email":                page.Email,
+		"password":             r.PostFormValue("password"),
+		"gotrue_meta_security": hostedUICaptcha(r),
+	})
+	if err != nil {
+		return err
+	}
+
+	if !res.ok() {
+		page.Error = res.errorMessage()
+		return a.renderHostedUI(w, res.status, "sign_in", page)
+	}
+
+	var token AccessTokenResponse
+	if err := res.decode(&token); err != nil {
+		return internalServerError("Error decoding sign in response").WithInternalError(err)
+	}
+
+	return a.continueHostedUI(w, r, &token, page.RedirectTo)
+}
+
+func (a *API) hostedUISignUpPage(w http.ResponseWriter, r *http.Request) error {
+	if a.config.DisableSignup {
+		return a.hostedUIRedirect(w, r)
+	}
+
+	return a.renderHostedUI(w, http.StatusOK, "sign_up", a.newHostedUIPage(w, r, "Create an account"))
+}
+
+func (a *API) hostedUISignUp(w http.ResponseWriter, r *http.Request) error {
+	page := a.newHostedUIPage(w, r, "Create an account")
+	page.Email = r.PostFormValue("email")
+
+	if !a.isValidHostedUICSRFToken(r) {
+		page.Error = "Your session has expired, please try again."
+		return a.renderHostedUI(w, http.StatusForbidden, "sign_up", page)
+	}
+
+	res, err := a.callHostedUI(r, http.MethodPost, "/signup?redirect_to="+url.QueryEscape(page.RedirectTo), "", map[string]interface{}{
+		"email":                page.Email,
+		"password":             r.PostFormValue("password"),
+		"gotrue_meta_security": hostedUICaptcha(r),
+	})
+	if err != nil {
+		return err
+	}
+
+	if !res.ok() {
+		page.Error = res.errorMessage()
+		return a.renderHostedUI(w, res.status, "sign_up", page)
+	}
+
+	// the user is only signed in right away when emails are autoconfirmed
+	var token AccessTokenResponse
+	if err := res.decode(&token); err == nil && token.Token != "" {
+		return a.continueHostedUI(w, r, &token, page.RedirectTo)
+	}
+
+	return a.renderHostedUIMessage(w, r, "Check your email", "We sent you a link to confirm your email address.")
+}
+
+func (a *API) hostedUIRecoverPage(w http.ResponseWriter, r *http.Request) error {
+	return a.renderHostedUI(w, http.StatusOK, "recover", a.newHostedUIPage(w, r, "Reset your password"))
+}
+
+func (a *API) hostedUIRecover(w http.ResponseWriter, r *http.Request) error {
+	page := a.newHostedUIPage(w, r, "Reset your password")
+	page.Email = r.PostFormValue("email")
+
+	if !a.isValidHostedUICSRFToken(r) {
+		page.Error = "Your session has expired, please try again."
+		return a.renderHostedUI(w, http.StatusForbidden, "recover", page)
+	}
+
+	resetURL := page.BaseURL + "/reset-password?redirect_to=" + url.QueryEscape(page.RedirectTo)
+
+	res, err := a.callHostedUI(r, http.MethodPost, "/recover?redirect_to="+url.QueryEscape(resetURL), "", map[string]interface{}{
+		"email":                page.Email,
+		"gotrue_meta_security": hostedUICaptcha(r),
+	})
+	if err != nil {
+		return err
+	}
+
+	if !res.ok() {
+		page.Error = res.errorMessage()
+		return a.renderHostedUI(w, res.status, "recover", page)
+	}
+
+	return a.renderHostedUIMessage(w, r, "Check your email", "If an account exists for this email address, we sent it a link to reset the password.")
+}
+
+func (a *API) hostedUIResetPasswordPage(w http.ResponseWriter, r *http.Request) error {
+	return a.renderHostedUI(w, http.StatusOK, "reset_password", a.newHostedUIPage(w, r, "Choose a new password"))
+}
+
+func (a *API) hostedUIResetPassword(w http.ResponseWriter, r *http.Request) error {
+	page := a.newHostedUIPage(w, r, "Choose a new password")
+	page.AccessToken = r.PostFormValue("access_token")
+
+	if !a.isValidHostedUICSRFToken(r) {
+		page.Error = "Your session has expired, please try again."
+		return a.renderHostedUI(w, http.StatusForbidden, "reset_password", page)
+	}
+
+	if page.AccessToken == "" {
+		page.Error = "The password reset link is invalid or has expired."
+		return a.renderHostedUI(w, http.StatusUnauthorized, "reset_password", page)
+	}
+
+	password := r.PostFormValue("password")
+	if password != r.PostFormValue("password_confirmation") {
+		page.Error = "The passwords don't match."
+		return a.renderHostedUI(w, http.StatusBadRequest, "reset_password", page)
+	}
+
+	res, err := a.callHostedUI(r, http.MethodPut, "/user", page.AccessToken, map[string]interface{}{
+		"password": password,
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.status == http.StatusUnauthorized || res.status == http.StatusForbidden {
+		page.Error = "The password reset link is invalid or has expired."
+		return a.renderHostedUI(w, res.status, "reset_password", page)
+	}
+
+	if !res.ok() {
+		page.Error = res.errorMessage()
+		return a.renderHostedUI(w, res.status, "reset_password", page)
+	}
+
+	return a.renderHostedUIMessage(w, r, "Password updated", "Your password has been updated. You can now sign in with it.")
+}
+
+func (a *API) hostedUIMFAPage(w http.ResponseWriter, r *http.Request) error {
+	if a.getHostedUIPending(r) == nil {
+		return a.hostedUIRedirect(w, r)
+	}
+
+	return a.renderHostedUI(w, http.StatusOK, "mfa", a.newHostedUIPage(w, r, "Two-factor authentication"))
+}
+
+func (a *API) hostedUIMFA(w http.ResponseWriter, r *http.Request) error {
+	page := a.newHostedUIPage(w, r, "Two-factor authentication")
+
+	pending := a.getHostedUIPending(r)
+	if pending == nil {
+		return a.hostedUIRedirect(w, r)
+	}
+
+	if !a.isValidHostedUICSRFToken(r) {
+		page.Error = "Your session has expired, please try again."
+		return a.renderHostedUI(w, http.StatusForbidden, "mfa", page)
+	}
+
+	res, err := a.callHostedUI(r, http.MethodGet, "/user", pending.AccessToken, nil)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	if !res.ok() || res.decode(&user) != nil {
+		a.clearHostedUIPending(w)
+		return a.hostedUIRedirect(w, r)
+	}
+
+	var factor *models.Factor
+	for i := range user.Factors {
+		if user.Factors[i].IsVerified() && user.Factors[i].FactorType == models.TOTP {
+			factor = &user.Factors[i]
+			break
+		}
+	}
+
+	if factor == nil {
+		return a.finishHostedUI(w, r, pending, page.RedirectTo)
+	}
+
+	factorPath := "/factors/" + factor.ID.String()
+
+	res, err = a.callHostedUI(r, http.MethodPost, factorPath+"/challenge", pending.AccessToken, nil)
+	if err != nil {
+		return err
+	}
+
+	var challenge ChallengeFactorResponse
+	if !res.ok() || res.decode(&challenge) != nil {
+		page.Error = res.errorMessage()
+		return a.renderHostedUI(w, res.status, "mfa", page)
+	}
+
+	res, err = a.callHostedUI(r, http.MethodPost, factorPath+"/verify", pending.AccessToken, &VerifyFactorParams{
+		ChallengeID: challenge.ID,
+		Code:        strings.TrimSpace(r.PostFormValue("code")),
+	})
+	if err != nil {
+		return err
+	}
+
+	if !res.ok() {
+		page.Error = res.errorMessage()
+		return a.renderHostedUI(w, res.status, "mfa", page)
+	}
+
+	var token AccessTokenResponse
+	if err := res.decode(&token); err != nil {
+		return internalServerError("Error decoding MFA verification response").WithInternalError(err)
+	}
+
+	return a.finishHostedUI(w, r, &hostedUIPending{
+		AccessToken:  token.Token,
+		TokenType:    token.TokenType,
+		ExpiresIn:    token.ExpiresIn,
+		ExpiresAt:    token.ExpiresAt,
+		RefreshToken: token.RefreshToken,
+		Email:        pending.Email,
+	}, page.RedirectTo)
+}
+
+func (a *API) hostedUIConsentPage(w http.ResponseWriter, r *http.Request) error {
+	pending := a.getHostedUIPending(r)
+	if pending == nil {
+		return a.hostedUIRedirect(w, r)
+	}
+
+	page := a.newHostedUIPage(w, r, "Allow access")
+	page.Email = pending.Email
+	if u, err := url.Parse(page.RedirectTo); err == nil {
+		page.ClientHost = u.Host
+	}
+
+	return a.renderHostedUI(w, http.StatusOK, "consent", page)
+}
+
+func (a *API) hostedUIConsent(w http.ResponseWriter, r *http.Request) error {
+	page := a.newHostedUIPage(w, r, "Allow access")
+
+	pending := a.getHostedUIPending(r)
+	if pending == nil {
+		return a.hostedUIRedirect(w, r)
+	}
+
+	if !a.isValidHostedUICSRFToken(r) {
+		page.Error = "Your session has expired, please try again."
+		page.Email = pending.Email
+		return a.renderHostedUI(w, http.StatusForbidden, "consent", page)
+	}
+
+	a.clearHostedUIPending(w)
+
+	if r.PostFormValue("action") != "approve" {
+		// the session was created for this client only, so it is ended
+		if _, err := a.callHostedUI(r, http.MethodPost, "/logout?scope=local", pending.AccessToken, nil); err != nil {
+			return err
+		}
+
+		http.Redirect(w, r, page.RedirectTo+"#"+url.Values{
+			"error":             []string{"access_denied"},
+			"error_description": []string{"The user denied access"},
+		}.Encode(), http.StatusSeeOther)
+
+		return nil
+	}
+
+	http.Redirect(w, r, pending.token().AsRedirectURL(page.RedirectTo, url.Values{}), http.StatusSeeOther)
+
+	return nil
+}
+
+func (a *API) newHostedUIDevicePage(w http.ResponseWriter, r *http.Request) *hostedUIPage {
+	page := a.newHostedUIPage(w, r, "Connect a device")
+	page.UserCode = models.NormalizeUserCode(r.FormValue("user_code"))
+
+	device := page.BaseURL + "/device"
+	if page.UserCode != "" {
+		device += "?" + url.Values{"user_code": []string{page.UserCode}}.Encode()
+	}
+
+	page.Link = page.BaseURL + "/sign-in?redirect_to=" + url.QueryEscape(device)
+	page.LinkText = "Sign in to connect your device"
+
+	return page
+}
+
+func (a *API) hostedUIDevicePage(w http.ResponseWriter, r *http.Request) error {
+	if !a.config.DeviceAuthorization.Enabled {
+		return notFoundError(ErrorCodeDeviceAuthorizationDisabled, "Device authorization is disabled")
+	}
+
+	return a.renderHostedUI(w, http.StatusOK, "device", a.newHostedUIDevicePage(w, r))
+}
+
+func (a *API) hostedUIDevice(w http.ResponseWriter, r *http.Request) error {
+	if !a.config.DeviceAuthorization.Enabled {
+		return notFoundError(ErrorCodeDeviceAuthorizationDisabled, "Device authorization is disabled")
+	}
+
+	page := a.newHostedUIDevicePage(w, r)
+	page.AccessToken = r.PostFormValue("access_token")
+
+	if !a.isValidHostedUICSRFToken(r) {
+		page.Error = "Your session has expired, please try again."
+		return a.renderHostedUI(w, http.StatusForbidden, "device", page)
+	}
+
+	if page.AccessToken == "" {
+		page.Error = "Please sign in to connect your device."
+		return a.renderHostedUI(w, http.StatusUnauthorized, "device", page)
+	}
+
+	action := r.PostFormValue("action")
+	res, err := a.callHostedUI(r, http.MethodPost, "/device/verify", page.AccessToken, map[string]interface{}{
+		"user_code": page.UserCode,
+		"action":    action,
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.status == http.StatusUnauthorized || res.status == http.StatusForbidden {
+		page.AccessToken = ""
+		page.Error = "Your session has expired, please sign in again."
+		return a.renderHostedUI(w, res.status, "device", page)
+	}
+
+	if !res.ok() {
+		page.Error = res.errorMessage()
+		return a.renderHostedUI(w, res.status, "device", page)
+	}
+
+	// the session was created only to approve the device, so it is ended
+	if _, err := a.callHostedUI(r, http.MethodPost, "/logout?scope=local", page.AccessToken, nil); err != nil {
+		return err
+	}
+
+	page = a.newHostedUIPage(w, r, "Connect a device")
+	page.Message = "Your device is connected. You can close this page."
+	if action == "deny" {
+		page.Message = "The device was not connected. You can close this page."
+	}
+
+	return a.renderHostedUI(w, http.StatusOK, "message", page)
+}
@@ -0,0 +1,187 @@
+package api
+
+import "html/template"
+
+const hostedUILayout = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="referrer" content="no-referrer">
+<title>{{.Title}}{{with .Theme.BrandName}} · {{.}}{{end}}</title>
+<style>
+:root {
+  --primary: {{.Theme.PrimaryColor}};
+  --background: {{.Theme.BackgroundColor}};
+  --text: {{.Theme.TextColor}};
+}
+* { box-sizing: border-box; }
+body { margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center; background: var(--background); color: var(--text); font-family: system-ui, -apple-system, "Segoe UI", Roboto, sans-serif; }
+main { width: 100%; max-width: 380px; padding: 32px; }
+.logo { display: block; max-height: 48px; margin: 0 auto 24px; }
+h1 { font-size: 1.5rem; margin: 0 0 24px; text-align: center; }
+form { display: flex; flex-direction: column; gap: 16px; }
+label { display: flex; flex-direction: column; gap: 6px; font-size: 0.875rem; }
+input { padding: 10px 12px; border: 1px solid #d0d0d0; border-radius: 6px; font-size: 1rem; }
+button { padding: 10px 12px; border: 0; border-radius: 6px; background: var(--primary); color: #fff; font-size: 1rem; cursor: pointer; }
+button.secondary { background: transparent; color: var(--text); border: 1px solid #d0d0d0; }
+.links { display: flex; justify-content: space-between; margin-top: 16px; font-size: 0.875rem; }
+.links a { color: var(--primary); }
+.error { padding: 10px 12px; border-radius: 6px; background: #fdecea; color: #b3261e; }
+.message { padding: 10px 12px; border-radius: 6px; background: #e8f5e9; color: #1b5e20; }
+</style>
+{{with .Theme.CSSURL}}<link rel="stylesheet" href="{{.}}">{{end}}
+</head>
+<body>
+<main>
+{{with .Theme.LogoURL}}<img class="logo" src="{{.}}" alt="">{{end}}
+<h1>{{.Title}}</h1>
+{{with .Error}}<p class="error" role="alert">{{.}}</p>{{end}}
+{{with .Message}}<p class="message">{{.}}</p>{{end}}
+{{template "content" .}}
+</main>
+</body>
+</html>
+`
+
+const hostedUICaptchaTemplate = `{{define "captcha"}}{{if eq .CaptchaProvider "hcaptcha"}}
+<script nonce="{{.Nonce}}" src="https://js.hcaptcha.com/1/api.js" async defer></script>
+<div class="h-captcha" data-sitekey="{{.CaptchaSiteKey}}"></div>
+{{else if eq .CaptchaProvider "turnstile"}}
+<script nonce="{{.Nonce}}" src="https://challenges.cloudflare.com/turnstile/v0/api.js" async defer></script>
+<div class="cf-turnstile" data-sitekey="{{.CaptchaSiteKey}}"></div>
+{{end}}{{end}}`
+
+const hostedUIHiddenTemplate = `{{define "hidden"}}<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="redirect_to" value="{{.RedirectTo}}">{{end}}`
+
+var hostedUIPages = map[string]string{
+	"sign_in": `{{define "content"}}
+<form method="post" action="{{.BaseURL}}/sign-in">
+{{template "hidden" .}}
+<label>Email<input type="email" name="email" value="{{.Email}}" autocomplete="username" required autofocus></label>
+<label>Password<input type="password" name="password" autocomplete="current-password" required></label>
+{{template "captcha" .}}
+<button type="submit">Sign in</button>
+</form>
+<div class="links">
+<a href="{{.BaseURL}}/recover?redirect_to={{.RedirectTo}}">Forgot your password?</a>
+{{if .SignupEnabled}}<a href="{{.BaseURL}}/sign-up?redirect_to={{.RedirectTo}}">Create an account</a>{{end}}
+</div>
+{{end}}`,
+
+	"sign_up": `{{define "content"}}
+<form method="post" action="{{.BaseURL}}/sign-up">
+{{template "hidden" .}}
+<label>Email<input type="email" name="email" value="{{.Email}}" autocomplete="username" required autofocus></label>
+<label>Password<input type="password" name="password" autocomplete="new-password" minlength="{{.PasswordMinLength}}" required></label>
+{{template "captcha" .}}
+<button type="submit">Create account</button>
+</form>
+<div class="links">
+<a href="{{.BaseURL}}/sign-in?redirect_to={{.RedirectTo}}">Already have an account? Sign in</a>
+</div>
+{{end}}`,
+
+	"recover": `{{define "content"}}
+<form method="post" action="{{.BaseURL}}/recover">
+{{template "hidden" .}}
+<label>Email<input type="email" name="email" value="{{.Email}}" autocomplete="username" required autofocus></label>
+{{template "captcha" .}}
+<button type="submit">Send reset link</button>
+</form>
+<div class="links">
+<a href="{{.BaseURL}}/sign-in?redirect_to={{.RedirectTo}}">Back to sign in</a>
+</div>
+{{end}}`,
+
+	"reset_password": `{{define "content"}}
+<p class="error" id="link-error" role="alert" hidden></p>
+<form method="post" action="{{.BaseURL}}/reset-password">
+{{template "hidden" .}}
+<input type="hidden" name="access_token" id="access_token" value="{{.AccessToken}}">
+<label>New password<input type="password" name="password" autocomplete="new-password" minlength="{{.PasswordMinLength}}" required autofocus></label>
+<label>Confirm new password<input type="password" name="password_confirmation" autocomplete="new-password" minlength="{{.PasswordMinLength}}" required></label>
+<button type="submit">Update password</button>
+</form>
+<script nonce="{{.Nonce}}">
+(function () {
+  var params = new URLSearchParams(window.location.hash.substring(1));
+  var token = params.get("access_token");
+  if (token) {
+    document.getElementById("access_token").value = token;
+  }
+  var error = params.get("error_description");
+  if (error) {
+    var el = document.getElementById("link-error");
+    el.textContent = error;
+    el.hidden = false;
+  }
+  if (window.location.hash) {
+    history.replaceState(null, "", window.location.pathname + window.location.search);
+  }
+})();
+</script>
+{{end}}`,
+
+	"mfa": `{{define "content"}}
+<form method="post" action="{{.BaseURL}}/mfa">
+{{template "hidden" .}}
+<label>Code from your authenticator app<input type="text" name="code" inputmode="numeric" autocomplete="one-time-code" pattern="[0-9]*" required autofocus></label>
+<button type="submit">Verify</button>
+</form>
+{{end}}`,
+
+	"consent": `{{define "content"}}
+<p>{{with .Email}}You are signed in as <strong>{{.}}</strong>. {{end}}<strong>{{.ClientHost}}</strong> would like to access your account.</p>
+<form method="post" action="{{.BaseURL}}/consent">
+{{template "hidden" .}}
+<button type="submit" name="action" value="approve">Allow</button>
+<button type="submit" name="action" value="deny" class="secondary">Deny</button>
+</form>
+{{end}}`,
+
+	"device": `{{define "content"}}
+<form method="post" action="{{.BaseURL}}/device" id="device-form"{{if not .AccessToken}} hidden{{end}}>
+{{template "hidden" .}}
+<input type="hidden" name="access_token" id="access_token" value="{{.AccessToken}}">
+<label>Code shown on your device<input type="text" name="user_code" value="{{.UserCode}}" autocomplete="off" autocapitalize="characters" spellcheck="false" required autofocus></label>
+<button type="submit" name="action" value="approve">Connect device</button>
+<button type="submit" name="action" value="deny" class="secondary">Cancel</button>
+</form>
+<div class="links" id="sign-in"{{if .AccessToken}} hidden{{end}}><a href="{{.Link}}">{{.LinkText}}</a></div>
+<script nonce="{{.Nonce}}">
+(function () {
+  var params = new URLSearchParams(window.location.hash.substring(1));
+  var token = params.get("access_token");
+  if (token) {
+    document.getElementById("access_token").value = token;
+    document.getElementById("device-form").hidden = false;
+    document.getElementById("sign-in").hidden = true;
+  }
+  if (window.location.hash) {
+    history.replaceState(null, "", window.location.pathname + window.location.search);
+  }
+})();
+</script>
+{{end}}`,
+
+	"message": `{{define "content"}}
+{{with .Link}}<div class="links"><a href="{{.}}">{{$.LinkText}}</a></div>{{end}}
+{{end}}`,
+}
+
+// hostedUITemplates are the pages of the hosted UI, each made of the
+// layout and its content.
+var hostedUITemplates = func() map[string]*template.Template {
+	base := template.Must(template.New("layout").Parse(hostedUILayout))
+	template.Must(base.Parse(hostedUICaptchaTemplate))
+	template.Must(base.Parse(hostedUIHiddenTemplate))
+
+	templates := make(map[string]*template.Template, len(hostedUIPages))
+	for name, content := range hostedUIPages {
+		templates[name] = template.Must(template.Must(base.Clone()).Parse(content))
+	}
+
+	return templates
+}()
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+type HostedUITestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	csrf *http.Cookie
+}
+
+func TestHostedUI(t *testing.T) {
+	api, config, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, conn *storage.Connection) {
+		if config != nil {
+			config.HostedUI.Enabled = true
+			config.HostedUI.Theme.PrimaryColor = "#123456"
+		}
+	})
+	require.NoError(t, err)
+
+	ts := &HostedUITestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *HostedUITestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	now := time.Now()
+	u.EmailConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	w := ts.get("/ui/sign-in")
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	ts.csrf = nil
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == ts.Config.Cookie.Key+"-"+hostedUICSRFCookie {
+			ts.csrf = cookie
+		}
+	}
+	require.NotNil(ts.T(), ts.csrf)
+}
+
+func (ts *HostedUITestSuite) get(path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *HostedUITestSuite) post(path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *HostedUITestSuite) TestSignInPage() {
+	w := ts.get("/ui/sign-in")
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	require.Equal(ts.T(), "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Contains(ts.T(), w.Header().Get("Content-Security-Policy"), "frame-ancestors 'none'")
+	require.Contains(ts.T(), w.Body.String(), "--primary: #123456")
+	require.Contains(ts.T(), w.Body.String(), `name="csrf_token"`)
+}
+
+func (ts *HostedUITestSuite) TestSignInRequiresCSRFToken() {
+	w := ts.post("/ui/sign-in", url.Values{
+		"email":    {"test@example.com"},
+		"password": {"password"},
+	}, ts.csrf)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	w = ts.post("/ui/sign-in", url.Values{
+		"email":      {"test@example.com"},
+		"password":   {"password"},
+		"csrf_token": {"wrong"},
+	}, ts.csrf)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+}
+
+func (ts *HostedUITestSuite) TestSignInInvalidCredentials() {
+	w := ts.post("/ui/sign-in", url.Values{
+		"email":      {"test@example.com"},
+		"password":   {"incorrect"},
+		"csrf_token": {ts.csrf.Value},
+	}, ts.csrf)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), InvalidLoginMessage)
+}
+
+func (ts *HostedUITestSuite) TestSignInRedirectsToSite() {
+	w := ts.post("/ui/sign-in", url.Values{
+		"email":      {"test@example.com"},
+		"password":   {"password"},
+		"csrf_token": {ts.csrf.Value},
+	}, ts.csrf)
+	require.Equal(ts.T(), http.StatusSeeOther, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "example.netlify.com", location.Host)
+
+	fragment, err := url.ParseQuery(location.Fragment)
+	require.NoError(ts.T(), err)
+	require.NotEmpty(ts.T(), fragment.Get("access_token"))
+	require.NotEmpty(ts.T(), fragment.Get("refresh_token"))
+}
+
+func (ts *HostedUITestSuite) TestSignInAsksForConsent() {
+	w := ts.post("/ui/sign-in", url.Values{
+		"email":       {"test@example.com"},
+		"password":    {"password"},
+		"csrf_token":  {ts.csrf.Value},
+		"redirect_to": {"http://localhost:3000/welcome"},
+	}, ts.csrf)
+	require.Equal(ts.T(), http.StatusSeeOther, w.Code)
+	require.Equal(ts.T(), "http://localhost:9999/ui/consent?redirect_to=http%3A%2F%2Flocalhost%3A3000%2Fwelcome", w.Header().Get("Location"))
+
+	var pending *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == ts.Config.Cookie.Key+"-"+hostedUIPendingCookie {
+			pending = cookie
+		}
+	}
+	require.NotNil(ts.T(), pending)
+
+	w = ts.post("/ui/consent", url.Values{
+		"action":      {"approve"},
+		"csrf_token":  {ts.csrf.Value},
+		"redirect_to": {"http://localhost:3000/welcome"},
+	}, ts.csrf, pending)
+	require.Equal(ts.T(), http.StatusSeeOther, w.Code)
+	require.True(ts.T(), strings.HasPrefix(w.Header().Get("Location"), "http://localhost:3000/welcome#"))
+	require.Contains(ts.T(), w.Header().Get("Location"), "access_token=")
+
+	w = ts.post("/ui/consent", url.Values{
+		"action":      {"deny"},
+		"csrf_token":  {ts.csrf.Value},
+		"redirect_to": {"http://localhost:3000/welcome"},
+	}, ts.csrf, pending)
+	require.Equal(ts.T(), http.StatusSeeOther, w.Code)
+	require.Equal(ts.T(), "http://localhost:3000/welcome#error=access_denied&error_description=The+user+denied+access", w.Header().Get("Location"))
+}
+
+func (ts *HostedUITestSuite) TestRedirectToIsValidated() {
+	w := ts.post("/ui/sign-in", url.Values{
+		"email":       {"test@example.com"},
+		"password":    {"password"},
+		"csrf_token":  {ts.csrf.Value},
+		"redirect_to": {"https://evil.example.org"},
+	}, ts.csrf)
+	require.Equal(ts.T(), http.StatusSeeOther, w.Code)
+	require.True(ts.T(), strings.HasPrefix(w.Header().Get("Location"), ts.Config.SiteURL+"#"))
+}
+
+func (ts *HostedUITestSuite) TestSignInRedirectsToDevicePage() {
+	ts.Config.DeviceAuthorization.Enabled = true
+	defer func() {
+		ts.Config.DeviceAuthorization.Enabled = false
+	}()
+
+	w := ts.get("/ui/device?user_code=bcdfghjk")
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	require.Contains(ts.T(), w.Body.String(), `value="BCDF-GHJK"`)
+	require.Contains(ts.T(), w.Body.String(), "/ui/sign-in?redirect_to=http%3A%2F%2Flocalhost%3A9999%2Fui%2Fdevice%3Fuser_code%3DBCDF-GHJK")
+
+	// approving the device is the consent, so none is asked
+	w = ts.post("/ui/sign-in", url.Values{
+		"email":       {"test@example.com"},
+		"password":    {"password"},
+		"csrf_token":  {ts.csrf.Value},
+		"redirect_to": {"http://localhost:9999/ui/device?user_code=BCDF-GHJK"},
+	}, ts.csrf)
+	require.Equal(ts.T(), http.StatusSeeOther, w.Code)
+	require.True(ts.T(), strings.HasPrefix(w.Header().Get("Location"), "http://localhost:9999/ui/device?user_code=BCDF-GHJK#"))
+}
+
+func (ts *HostedUITestSuite) TestDisabled() {
+	ts.Config.HostedUI.Enabled = false
+	defer func() {
+		ts.Config.HostedUI.Enabled = true
+	}()
+
+	w := ts.get("/ui/sign-in")
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+}
@@ -120,7 +120,15 @@ func (a *API) linkIdentityToUser(r *http.Request, ctx context.Context, tx *stora
 		}
 		return nil, unprocessableEntityError(ErrorCodeIdentityAlreadyExists, "Identity is already linked to another user")
 	}
-	if _, terr := a.createNewIdentity(tx, targetUser, providerType, structs.Map(userData.Metadata)); terr != nil {
+	identity, terr = a.createNewIdentity(tx, targetUser, providerType, structs.Map(userData.Metadata))
+	if terr != nil {
+		return nil, terr
+	}
+	if terr := models.NewAuditLogEntry(r, tx, targetUser, models.IdentityLinkAction, "", map[string]interface{}{
+		"identity_id": identity.ID,
+		"provider":    identity.Provider,
+		"provider_id": identity.ProviderID,
+	}); terr != nil {
 		return nil, terr
 	}
 
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// adminUserImpersonate creates a short-lived session for the user on behalf
+// of the calling admin. Access tokens of the session carry an act claim
+// naming the admin. No refresh token is issued, and the session can't be
+// used to change the user's credentials or MFA factors. It can be revoked
+// like any other session, for example by calling /logout with its token.
+func (a *API) adminUserImpersonate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.config
+	user := getUser(ctx)
+	adminUser := getAdminUser(ctx)
+	claims := getClaims(ctx)
+
+	if !config.Impersonation.Enabled {
+		return notFoundError(ErrorCodeImpersonationDisabled, "Impersonation is disabled")
+	}
+
+	// tokens signed with the service role key don't have a subject
+	impersonator := claims.Subject
+	if impersonator == "" {
+		impersonator = claims.Role
+	}
+
+	var token *AccessTokenResponse
+	err := db.Transaction(func(tx *storage.Connection) error {
+		session, terr := models.NewSession(user.ID, nil)
+		if terr != nil {
+			return internalServerError("Failed to create session").WithInternalError(terr)
+		}
+
+		notAfter := time.Now().Add(config.Impersonation.Duration)
+		session.NotAfter = &notAfter
+		session.Impersonator = &impersonator
+
+		if terr := tx.Create(session); terr != nil {
+			return internalServerError("Database error creating session").WithInternalError(terr)
+		}
+
+		if terr := models.AddClaimToSession(tx, session.ID, models.Impersonation); terr != nil {
+			return internalServerError("Database error updating session").WithInternalError(terr)
+		}
+
+		ac := models.GetAuditContext(ctx)
+		ac.SessionID = &session.ID
+		ac.AuthMethod = models.Impersonation.String()
+
+		if terr := models.NewAuditLogEntry(r, tx, adminUser, models.UserImpersonatedAction, "", map[string]interface{}{
+			"user_id":      user.ID,
+			"user_email":   user.Email,
+			"user_phone":   user.Phone,
+			"impersonator": impersonator,
+			"not_after":    notAfter,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		tokenString, expiresAt, terr := a.generateAccessToken(r, tx, user, &session.ID, models.Impersonation)
+		if terr != nil {
+			if httpErr, ok := terr.(*HTTPError); ok {
+				return httpErr
+			}
+			return internalServerError("error generating jwt token").WithInternalError(terr)
+		}
+
+		token = &AccessTokenResponse{
+			Token:     tokenString,
+			TokenType: "bearer",
+			ExpiresIn: int(time.Until(time.Unix(expiresAt, 0)).Seconds()),
+			ExpiresAt: expiresAt,
+			User:      user,
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, token)
+}
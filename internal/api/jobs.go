@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/auth/internal/models"
+)
+
+// RunBackgroundJobs expires anonymous users and deletes the users whose
+// deletion is due, until ctx is done. The jobs run with the configuration
+// in use when they start, outside of any request, so that a batch neither
+// holds up a client nor is interrupted when one disconnects. Several
+// instances can run them at once, as each user is locked while handled.
+func (r *ReloadableAPI) RunBackgroundJobs(ctx context.Context) {
+	anonymousUserSweep := time.NewTicker(anonymousUserSweepInterval)
+	defer anonymousUserSweep.Stop()
+
+	accountDeletionSweep := time.NewTicker(accountDeletionSweepInterval)
+	defer accountDeletionSweep.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-anonymousUserSweep.C:
+			r.api.Load().sweepAnonymousUsers(ctx)
+
+		case <-accountDeletionSweep.C:
+			r.api.Load().sweepScheduledDeletions(ctx)
+		}
+	}
+}
+
+// backgroundRequest returns the request that jobs running outside of
+// requests build emails, hook calls and audit log entries with. It is
+// bound to ctx instead of a client connection, and links in emails point to
+// the configured external URL.
+func (a *API) backgroundRequest(ctx context.Context) (*http.Request, error) {
+	externalURL, err := url.ParseRequestURI(a.config.API.ExternalURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = withExternalHost(models.WithAuditContext(ctx), externalURL)
+	return http.NewRequestWithContext(ctx, http.MethodGet, externalURL.String(), nil)
+}
+
+func (a *API) sweepAnonymousUsers(ctx context.Context) {
+	config := a.config
+	if !config.DB.CleanupEnabled || !config.External.AnonymousUsers.Enabled || !config.Hook.AnonymousUserExpiry.Enabled {
+		return
+	}
+
+	log := logrus.WithField("component", "api")
+
+	r, err := a.backgroundRequest(ctx)
+	if err != nil {
+		log.WithError(err).Warn("anonymous user expiry failed")
+		return
+	}
+
+	deleted, err := a.expireAnonymousUsers(r)
+	if err != nil {
+		log.WithError(err).WithField("deleted_users", deleted).Warn("anonymous user expiry failed")
+	} else if deleted > 0 {
+		log.WithField("deleted_users", deleted).Debug("deleted expired anonymous users")
+	}
+}
+
+func (a *API) sweepScheduledDeletions(ctx context.Context) {
+	if !a.config.AccountDeletion.Enabled {
+		return
+	}
+
+	log := logrus.WithField("component", "api")
+
+	r, err := a.backgroundRequest(ctx)
+	if err != nil {
+		log.WithError(err).Warn("scheduled account deletion failed")
+		return
+	}
+
+	deleted, err := a.deleteScheduledUsers(r)
+	if err != nil {
+		log.WithError(err).WithField("deleted_users", deleted).Warn("scheduled account deletion failed")
+	} else if deleted > 0 {
+		log.WithField("deleted_users", deleted).Debug("deleted users whose deletion was scheduled")
+	}
+}
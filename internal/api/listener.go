@@ -12,13 +12,17 @@ import (
 
 // ListenAndServe starts the REST API
 func (a *API) ListenAndServe(ctx context.Context, hostAndPort string) {
+	listenAndServe(ctx, hostAndPort, a.handler)
+}
+
+func listenAndServe(ctx context.Context, hostAndPort string, handler http.Handler) {
 	baseCtx, cancel := context.WithCancel(context.Background())
 
 	log := logrus.WithField("component", "api")
 
 	server := &http.Server{
 		Addr:              hostAndPort,
-		Handler:           a.handler,
+		Handler:           handler,
 		ReadHeaderTimeout: 2 * time.Second, // to mitigate a Slowloris attack
 		BaseContext: func(net.Listener) context.Context {
 			return baseCtx
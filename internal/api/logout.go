@@ -43,6 +43,12 @@ func (a *API) Logout(w http.ResponseWriter, r *http.Request) error {
 	s := getSession(ctx)
 	u := getUser(ctx)
 
+	if s != nil && s.IsImpersonated() {
+		// an impersonated session only ever ends itself, never the
+		// user's own sessions
+		scope = LogoutLocal
+	}
+
 	err := db.Transaction(func(tx *storage.Connection) error {
 		if terr := models.NewAuditLogEntry(r, tx, u, models.LogoutAction, "", nil); terr != nil {
 			return terr
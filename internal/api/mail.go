@@ -599,6 +599,14 @@ func (a *API) sendEmail(r *http.Request, tx *storage.Connection, u *models.User,
 		return mailer.MagicLinkMail(r, u, otp, referrerURL, externalURL)
 	case mail.ReauthenticationVerification:
 		return mailer.ReauthenticateMail(r, u, otp)
+	case mail.RefreshTokenReuseNotification:
+		return mailer.RefreshTokenReuseMail(r, u)
+	case mail.AccountDeletionScheduled:
+		return mailer.AccountDeletionScheduledMail(r, u)
+	case mail.AccountDeletionCancelled:
+		return mailer.AccountDeletionCancelledMail(r, u)
+	case mail.AccountDeleted:
+		return mailer.AccountDeletedMail(r, u)
 	case mail.RecoveryVerification:
 		return mailer.RecoveryMail(r, u, otp, referrerURL, externalURL)
 	case mail.InviteVerification:
@@ -275,6 +275,16 @@ func (a *API) VerifyFactor(w http.ResponseWriter, r *http.Request) error {
 				output.Message = hooks.DefaultMFAHookRejectionMessage
 			}
 
+			a.auditFailure(r, &models.AuditEvent{
+				Action:        models.VerifyFactorAction,
+				Actor:         user,
+				AuthMethod:    models.TOTPSignIn.String(),
+				FailureReason: "rejected_by_hook",
+				Traits: map[string]interface{}{
+					"factor_id":    factor.ID,
+					"challenge_id": challenge.ID,
+				},
+			})
 			return forbiddenError(ErrorCodeMFAVerificationRejected, output.Message)
 		}
 	}
@@ -289,6 +299,16 @@ func (a *API) VerifyFactor(w http.ResponseWriter, r *http.Request) error {
 				return err
 			}
 		}
+		a.auditFailure(r, &models.AuditEvent{
+			Action:        models.VerifyFactorAction,
+			Actor:         user,
+			AuthMethod:    models.TOTPSignIn.String(),
+			FailureReason: "invalid_totp",
+			Traits: map[string]interface{}{
+				"factor_id":    factor.ID,
+				"challenge_id": challenge.ID,
+			},
+		})
 		return unprocessableEntityError(ErrorCodeMFAVerificationFailed, "Invalid TOTP code entered").WithInternalError(verr)
 	}
 
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -56,6 +57,50 @@ func (f *FunctionHooks) UnmarshalJSON(b []byte) error {
 
 var emailRateLimitCounter = observability.ObtainMetricCounter("gotrue_email_rate_limit_counter", "Number of times an email rate limit has been triggered")
 
+// limiterCache keeps rate limiters across configuration reloads, so that
+// reloading doesn't reset the rate limits. A limiter is only created again
+// when its settings changed. Cached limiters are shared by the APIs built
+// before and after a reload, so they're never changed once created.
+type limiterCache struct {
+	mu       sync.Mutex
+	limiters map[string]*cachedLimiter
+}
+
+type cachedLimiter struct {
+	max     float64
+	ttl     time.Duration
+	burst   int
+	methods []string
+	limiter *limiter.Limiter
+}
+
+func newLimiterCache() *limiterCache {
+	return &limiterCache{limiters: make(map[string]*cachedLimiter)}
+}
+
+// limiter returns the rate limiter called name, allowing max requests per
+// second with bursts of up to burst requests. If methods are given, only
+// requests with those methods are limited.
+func (a *API) limiter(name string, max float64, ttl time.Duration, burst int, methods ...string) *limiter.Limiter {
+	c := a.limiters
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.limiters[name]; ok && cached.max == max && cached.ttl == ttl && cached.burst == burst && reflect.DeepEqual(cached.methods, methods) {
+		return cached.limiter
+	}
+
+	lmt := tollbooth.NewLimiter(max, &limiter.ExpirableOptions{
+		DefaultExpirationTTL: ttl,
+	}).SetBurst(burst)
+	if len(methods) > 0 {
+		lmt.SetMethods(methods)
+	}
+
+	c.limiters[name] = &cachedLimiter{max: max, ttl: ttl, burst: burst, methods: methods, limiter: lmt}
+	return lmt
+}
+
 func (a *API) limitHandler(lmt *limiter.Limiter) middlewareHandler {
 	return func(w http.ResponseWriter, req *http.Request) (context.Context, error) {
 		c := req.Context()
@@ -83,13 +128,9 @@ func (a *API) limitEmailOrPhoneSentHandler() middlewareHandler {
 	emailFreq := a.config.RateLimitEmailSent / (60 * 60)
 	smsFreq := a.config.RateLimitSmsSent / (60 * 60)
 
-	emailLimiter := tollbooth.NewLimiter(emailFreq, &limiter.ExpirableOptions{
-		DefaultExpirationTTL: time.Hour,
-	}).SetBurst(int(a.config.RateLimitEmailSent)).SetMethods([]string{"PUT", "POST"})
+	emailLimiter := a.limiter("email_sent", emailFreq, time.Hour, int(a.config.RateLimitEmailSent), "PUT", "POST")
 
-	phoneLimiter := tollbooth.NewLimiter(smsFreq, &limiter.ExpirableOptions{
-		DefaultExpirationTTL: time.Hour,
-	}).SetBurst(int(a.config.RateLimitSmsSent)).SetMethods([]string{"PUT", "POST"})
+	phoneLimiter := a.limiter("sms_sent", smsFreq, time.Hour, int(a.config.RateLimitSmsSent), "PUT", "POST")
 
 	return func(w http.ResponseWriter, req *http.Request) (context.Context, error) {
 		c := req.Context()
@@ -231,6 +272,46 @@ func (a *API) requireSAMLEnabled(w http.ResponseWriter, req *http.Request) (cont
 	return ctx, nil
 }
 
+func (a *API) requireConfigOverridesEnabled(w http.ResponseWriter, req *http.Request) (context.Context, error) {
+	ctx := req.Context()
+	if !a.config.ConfigOverrides.Enabled {
+		return nil, notFoundError(ErrorCodeConfigOverridesDisabled, "Configuration overrides are disabled")
+	}
+	return ctx, nil
+}
+
+func (a *API) requireDeviceAuthorizationEnabled(w http.ResponseWriter, req *http.Request) (context.Context, error) {
+	ctx := req.Context()
+	if !a.config.DeviceAuthorization.Enabled {
+		return nil, notFoundError(ErrorCodeDeviceAuthorizationDisabled, "Device authorization is disabled")
+	}
+	return ctx, nil
+}
+
+func (a *API) requireServiceClientsEnabled(w http.ResponseWriter, req *http.Request) (context.Context, error) {
+	ctx := req.Context()
+	if !a.config.ServiceClients.Enabled {
+		return nil, notFoundError(ErrorCodeServiceClientsDisabled, "Service clients are disabled")
+	}
+	return ctx, nil
+}
+
+func (a *API) requireAPIKeysEnabled(w http.ResponseWriter, req *http.Request) (context.Context, error) {
+	ctx := req.Context()
+	if !a.config.APIKeys.Enabled {
+		return nil, notFoundError(ErrorCodeAPIKeysDisabled, "API keys are disabled")
+	}
+	return ctx, nil
+}
+
+func (a *API) requireAccountDeletionEnabled(w http.ResponseWriter, req *http.Request) (context.Context, error) {
+	ctx := req.Context()
+	if !a.config.AccountDeletion.Enabled {
+		return nil, notFoundError(ErrorCodeAccountDeletionDisabled, "Account deletion is disabled")
+	}
+	return ctx, nil
+}
+
 func (a *API) requireManualLinkingEnabled(w http.ResponseWriter, req *http.Request) (context.Context, error) {
 	ctx := req.Context()
 	if !a.config.Security.ManualLinkingEnabled {
@@ -239,6 +320,15 @@ func (a *API) requireManualLinkingEnabled(w http.ResponseWriter, req *http.Reque
 	return ctx, nil
 }
 
+// auditContext attaches a models.AuditContext to every request, which the
+// authentication middlewares and handlers fill in so that audit log entries
+// record who performed an action and how they authenticated.
+func auditContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(models.WithAuditContext(r.Context())))
+	})
+}
+
 func (a *API) databaseCleanup(cleanup *models.Cleanup) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -20,6 +20,11 @@ func calculateTotalPages(perPage, total uint64) uint64 {
 }
 
 func addPaginationHeaders(w http.ResponseWriter, r *http.Request, p *models.Pagination) {
+	if p.Keyset {
+		addKeysetPaginationHeaders(w, r, p)
+		return
+	}
+
 	totalPages := calculateTotalPages(p.PerPage, p.Count)
 	url, _ := url.ParseRequestURI(r.URL.String())
 	query := url.Query()
@@ -37,6 +42,22 @@ func addPaginationHeaders(w http.ResponseWriter, r *http.Request, p *models.Pagi
 	w.Header().Add("X-Total-Count", fmt.Sprintf("%v", p.Count))
 }
 
+// addKeysetPaginationHeaders only links to the next page, as counting the
+// total number of rows is exactly what keyset pagination avoids.
+func addKeysetPaginationHeaders(w http.ResponseWriter, r *http.Request, p *models.Pagination) {
+	if p.NextCursor == nil {
+		return
+	}
+
+	url, _ := url.ParseRequestURI(r.URL.String())
+	query := url.Query()
+	query.Del("page")
+	query.Set("cursor", p.NextCursor.String())
+	url.RawQuery = query.Encode()
+
+	w.Header().Add("Link", "<"+url.String()+">; rel=\"next\"")
+}
+
 func paginate(r *http.Request) (*models.Pagination, error) {
 	params := r.URL.Query()
 	queryPage := params.Get("page")
@@ -57,8 +78,24 @@ func paginate(r *http.Request) (*models.Pagination, error) {
 		}
 	}
 
-	return &models.Pagination{
+	p := &models.Pagination{
 		Page:    page,
 		PerPage: perPage,
-	}, nil
+	}
+
+	// the presence of a cursor parameter, even an empty one, selects
+	// keyset pagination
+	if cursor, ok := params["cursor"]; ok {
+		if queryPage != "" {
+			return nil, fmt.Errorf("page and cursor cannot be used together")
+		}
+		p.Keyset = true
+		if len(cursor) > 0 && cursor[0] != "" {
+			if p.After, err = models.ParseCursor(cursor[0]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return p, nil
 }
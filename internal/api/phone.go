@@ -11,9 +11,11 @@ import (
 	"github.com/supabase/auth/internal/hooks"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/supabase/auth/internal/api/sms_provider"
 	"github.com/supabase/auth/internal/crypto"
 	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
 )
 
@@ -109,10 +111,32 @@ func (a *API) sendPhoneConfirmation(r *http.Request, tx *storage.Connection, use
 				return "", err
 			}
 		} else {
-			messageID, err = smsProvider.SendMessage(phone, message, channel, otp)
+			delivery, err := sms_provider.Send(smsProvider, config.Sms.Provider, phone, message, channel, otp)
+			messageID = delivery.MessageID
 			if err != nil {
 				return messageID, err
 			}
+
+			for _, failure := range delivery.Failures {
+				observability.GetLogEntry(r).Entry.WithError(failure.Err).WithField("sms_provider", failure.Provider).Warn("SMS provider failed to send message, trying the next provider")
+			}
+			observability.LogEntrySetFields(r, logrus.Fields{
+				"sms_provider":   delivery.Provider,
+				"sms_message_id": delivery.MessageID,
+			})
+
+			// with failover, recorded so that delivery receipts can be
+			// reconciled with the provider that sent the message
+			if config.Sms.HasFailover() {
+				if err := models.NewAuditLogEntry(r, tx, user, models.SmsSentAction, "", map[string]interface{}{
+					"sms_provider": delivery.Provider,
+					"message_id":   delivery.MessageID,
+					"channel":      channel,
+					"otp_type":     otpType,
+				}); err != nil {
+					return messageID, err
+				}
+			}
 		}
 	}
 
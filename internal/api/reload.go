@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// ReloadableAPI serves requests with an API built from the most recently
+// loaded configuration. Reloading builds a new API and swaps it in
+// atomically. The new API keeps the rate limiters whose configuration
+// didn't change, and the used DPoP proofs. Requests already in flight
+// finish with the configuration they started with.
+type ReloadableAPI struct {
+	db      *storage.Connection
+	version string
+
+	mu sync.Mutex
+
+	// base is the configuration loaded from the environment, and
+	// overrides are applied over it when enabled.
+	base      *conf.GlobalConfiguration
+	overrides map[string]json.RawMessage
+
+	api atomic.Pointer[API]
+}
+
+// NewReloadableAPI creates a new ReloadableAPI using the specified version
+func NewReloadableAPI(globalConfig *conf.GlobalConfiguration, db *storage.Connection, version string) *ReloadableAPI {
+	r := &ReloadableAPI{db: db, version: version, base: globalConfig}
+	r.api.Store(r.newAPI(globalConfig, nil))
+
+	if globalConfig.ConfigOverrides.Enabled {
+		if err := r.ReloadOverrides(context.Background()); err != nil {
+			logrus.WithField("component", "api").WithError(err).Error("unable to apply configuration overrides")
+		}
+	}
+
+	return r
+}
+
+func (r *ReloadableAPI) newAPI(config *conf.GlobalConfiguration, previous *API) *API {
+	api := newAPIFrom(config, r.db, r.version, previous)
+	api.configOverridesChanged = func(ctx context.Context) {
+		if err := r.ReloadOverrides(ctx); err != nil {
+			logrus.WithField("component", "api").WithError(err).Error("unable to apply configuration overrides")
+		}
+	}
+
+	return api
+}
+
+// Config returns the configuration currently in use.
+func (r *ReloadableAPI) Config() *conf.GlobalConfiguration {
+	return r.api.Load().config
+}
+
+// Reload applies the reloadable parts of the configuration. Changes that
+// need a restart are logged and ignored. If the resulting configuration is
+// invalid, nothing is applied and an error is returned.
+func (r *ReloadableAPI) Reload(next *conf.GlobalConfiguration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log := logrus.WithField("component", "api")
+
+	base, rejected, err := r.base.MergeReloadable(next)
+	for _, reason := range rejected {
+		log.Warnf("configuration change rejected: %s", reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	changed, err := r.apply(base, r.overrides)
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		log.Info("configuration reloaded")
+	} else {
+		log.Info("configuration reloaded without changes")
+	}
+
+	return nil
+}
+
+// ReloadOverrides loads the configuration overrides from the database and
+// applies them over the environment configuration. If the overrides result
+// in an invalid configuration, nothing is applied and an error is returned.
+func (r *ReloadableAPI) ReloadOverrides(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.base.ConfigOverrides.Enabled {
+		return nil
+	}
+
+	overrides, err := models.LoadConfigOverrides(r.db.WithContext(ctx), r.base.Security.DBEncryption.DecryptionKeys)
+	if err != nil {
+		return err
+	}
+
+	changed, err := r.apply(r.base, overrides)
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		logrus.WithField("component", "api").Infof("applied %d configuration overrides", len(overrides))
+	}
+
+	return nil
+}
+
+// apply swaps in a new API if the configuration changed. It must be called
+// with the lock held.
+func (r *ReloadableAPI) apply(base *conf.GlobalConfiguration, overrides map[string]json.RawMessage) (bool, error) {
+	config, err := base.ApplyOverrides(overrides)
+	if err != nil {
+		return false, err
+	}
+
+	r.base = base
+	r.overrides = overrides
+
+	current := r.api.Load()
+	if reflect.DeepEqual(config, current.config) {
+		return false, nil
+	}
+
+	r.api.Store(r.newAPI(config, current))
+
+	return true, nil
+}
+
+func (r *ReloadableAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.api.Load().handler.ServeHTTP(w, req)
+}
+
+// ListenAndServe starts the REST API
+func (r *ReloadableAPI) ListenAndServe(ctx context.Context, hostAndPort string) {
+	listenAndServe(ctx, hostAndPort, r)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+)
+
+func TestReloadKeepsState(t *testing.T) {
+	config, err := conf.LoadGlobal(apiTestConfig)
+	require.NoError(t, err)
+	config.ConfigOverrides.Enabled = false
+	config.DB.CleanupEnabled = true
+
+	r := NewReloadableAPI(config, nil, "test")
+	first := r.api.Load()
+	tokenLimiter := first.limiter("token", config.RateLimitTokenRefresh/(60*5), time.Hour, 30)
+
+	next := *config
+	next.Mailer.Subjects.Invite = "Join us"
+	require.NoError(t, r.Reload(&next))
+
+	second := r.api.Load()
+	require.NotSame(t, first, second)
+	require.Equal(t, "Join us", second.config.Mailer.Subjects.Invite)
+
+	// rate limits survive the reload
+	require.Same(t, tokenLimiter, second.limiter("token", config.RateLimitTokenRefresh/(60*5), time.Hour, 30))
+
+	// so does the database cleanup, as its configuration didn't change
+	require.Same(t, first.cleanup, second.cleanup)
+
+	// a limiter whose rate changed starts over
+	next.RateLimitTokenRefresh = config.RateLimitTokenRefresh + 10
+	require.NoError(t, r.Reload(&next))
+
+	third := r.api.Load()
+	require.NotSame(t, tokenLimiter, third.limiter("token", next.RateLimitTokenRefresh/(60*5), time.Hour, 30))
+
+	// and so does a cleanup whose statements changed
+	next.External.AnonymousUsers.Enabled = !config.External.AnonymousUsers.Enabled
+	require.NoError(t, r.Reload(&next))
+
+	require.NotSame(t, first.cleanup, r.api.Load().cleanup)
+}
@@ -51,6 +51,8 @@ func (a *API) SAMLACS(w http.ResponseWriter, r *http.Request) error {
 	config := a.config
 	log := observability.GetLogEntry(r).Entry
 
+	models.GetAuditContext(ctx).AuthMethod = models.SSOSAML.String()
+
 	relayStateValue := r.FormValue("RelayState")
 	relayStateUUID := uuid.FromStringOrNil(relayStateValue)
 	relayStateURL, _ := url.ParseRequestURI(relayStateValue)
@@ -0,0 +1,599 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
+	"github.com/supabase/auth/internal/storage"
+)
+
+const (
+	clientCredentialsGrantType = "client_credentials"
+
+	clientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// maxClientAssertionLifetime limits how long a client assertion can be
+	// replayed.
+	maxClientAssertionLifetime = 5 * time.Minute
+)
+
+var errClientAssertionReused = errors.New("client assertion was already used")
+
+// ClientCredentialsGrantParams are the parameters the
+// ClientCredentialsGrant method accepts. Clients authenticate with
+// client_id and client_secret, which may also be sent with HTTP Basic
+// authentication, or with a signed client_assertion (RFC 7523).
+type ClientCredentialsGrantParams struct {
+	ClientID            string `json:"client_id"`
+	ClientSecret        string `json:"client_secret"`
+	ClientAssertionType string `json:"client_assertion_type"`
+	ClientAssertion     string `json:"client_assertion"`
+	Scope               string `json:"scope"`
+}
+
+// ClientCredentialsResponse is the successful response of the
+// client_credentials grant. No refresh token is issued.
+type ClientCredentialsResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	ExpiresAt   int64  `json:"expires_at"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// serviceClientAccessTokenClaims are the claims of an access token issued
+// to a service client. The subject is the client ID.
+type serviceClientAccessTokenClaims struct {
+	jwt.RegisteredClaims
+	Role     string `json:"role"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id"`
+}
+
+// ServiceClientParams are the parameters the service client admin
+// endpoints accept. Clients get a generated secret, unless a PEM encoded
+// public key is given to verify their private_key_jwt assertions.
+type ServiceClientParams struct {
+	Name      string  `json:"name"`
+	Role      string  `json:"role"`
+	Scope     *string `json:"scope"`
+	PublicKey *string `json:"public_key"`
+}
+
+// ServiceClientResponse is a service client as returned by the admin API.
+// The secret is only returned when it is generated.
+type ServiceClientResponse struct {
+	*models.ServiceClient
+	AuthMethod   string `json:"auth_method"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// requireGrantableScope checks that an admin token restricted to some admin
+// scopes only gives service clients scopes it has itself, and only manages
+// clients with such scopes, so that it can't create or take over a client
+// more powerful than itself.
+func requireGrantableScope(ctx context.Context, scope string) error {
+	claims := getClaims(ctx)
+	if claims == nil || claims.Scope == "" {
+		return nil
+	}
+
+	if scope == "" {
+		return forbiddenError(ErrorCodeInsufficientScope, "This token can only manage service clients with some of its own scopes")
+	}
+
+	for _, s := range strings.Fields(scope) {
+		if !hasAdminScope(ctx, s) {
+			return forbiddenError(ErrorCodeInsufficientScope, "This token doesn't have the %s scope", s)
+		}
+	}
+
+	return nil
+}
+
+func newServiceClientResponse(client *models.ServiceClient, secret string) *ServiceClientResponse {
+	return &ServiceClientResponse{
+		ServiceClient: client,
+		AuthMethod:    client.AuthMethod(),
+		ClientSecret:  secret,
+	}
+}
+
+// isValidScopeToken reports whether scope is a valid scope-token of RFC
+// 6749 section 3.3.
+func isValidScopeToken(scope string) bool {
+	if scope == "" {
+		return false
+	}
+
+	for _, c := range scope {
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseServiceClientPublicKey parses a PEM encoded RSA, ECDSA or Ed25519
+// public key.
+func parseServiceClientPublicKey(publicKey string) (crypto.PublicKey, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(publicKey)); err == nil {
+		return key, nil
+	}
+
+	if key, err := jwt.ParseECPublicKeyFromPEM([]byte(publicKey)); err == nil {
+		return key, nil
+	}
+
+	if key, err := jwt.ParseEdPublicKeyFromPEM([]byte(publicKey)); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("public key must be a PEM encoded RSA, ECDSA or Ed25519 public key")
+}
+
+// verifyClientAssertion checks a private_key_jwt assertion (RFC 7523
+// section 3), which the client signs with its private key. Its jti is
+// recorded until it expires, so that it can only be used once.
+func (a *API) verifyClientAssertion(tx *storage.Connection, client *models.ServiceClient, assertion string) error {
+	key, err := parseServiceClientPublicKey(*client.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	clientID := client.ID.String()
+
+	p := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(clientID),
+		jwt.WithSubject(clientID),
+	)
+
+	claims := &jwt.RegisteredClaims{}
+	if _, err := p.ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	}); err != nil {
+		return err
+	}
+
+	if claims.ExpiresAt.Time.After(time.Now().Add(maxClientAssertionLifetime)) {
+		return errors.New("client assertion expires too far in the future")
+	}
+
+	if claims.ID == "" {
+		return errors.New("client assertion has no jti")
+	}
+
+	// the assertion is meant for the token endpoint or this issuer
+	audiences := []string{strings.TrimSuffix(a.config.API.ExternalURL, "/") + "/token"}
+	if a.config.JWT.Issuer != "" {
+		audiences = append(audiences, a.config.JWT.Issuer)
+	}
+
+	validAudience := false
+	for _, aud := range claims.Audience {
+		if isStringInSlice(aud, audiences) {
+			validAudience = true
+			break
+		}
+	}
+
+	if !validAudience {
+		return errors.New("client assertion has an invalid audience")
+	}
+
+	unused, err := models.UseServiceClientAssertion(tx, client.ID, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return internalServerError("Database error recording client assertion").WithInternalError(err)
+	}
+
+	if !unused {
+		return errClientAssertionReused
+	}
+
+	return nil
+}
+
+// ClientCredentialsGrant implements the client_credentials grant (RFC 6749
+// section 4.4) for registered service clients. The issued access token
+// carries the client's role and scope, narrowed to the requested scope.
+func (a *API) ClientCredentialsGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	db := a.db.WithContext(ctx)
+	config := a.config
+
+	if !config.ServiceClients.Enabled {
+		return oauthError("unsupported_grant_type", "")
+	}
+
+	params := &ClientCredentialsGrantParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		if (params.ClientID != "" && params.ClientID != clientID) || params.ClientSecret != "" {
+			return oauthError("invalid_request", "Client credentials must be sent only once")
+		}
+
+		params.ClientID = clientID
+		params.ClientSecret = clientSecret
+	}
+
+	if params.ClientAssertion != "" && params.ClientAssertionType != clientAssertionTypeJWTBearer {
+		return oauthError("invalid_request", "Unsupported client_assertion_type")
+	}
+
+	clientID, err := uuid.FromString(params.ClientID)
+	if err != nil {
+		return oauthError("invalid_client", "Invalid client credentials")
+	}
+
+	client, err := models.FindServiceClientByID(db, clientID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return oauthError("invalid_client", "Invalid client credentials")
+		}
+		return internalServerError("Database error finding service client").WithInternalError(err)
+	}
+
+	if client.IsRevoked() {
+		return oauthError("invalid_client", "Invalid client credentials")
+	}
+
+	switch client.AuthMethod() {
+	case models.ServiceClientPrivateKeyAuth:
+		if params.ClientAssertion == "" {
+			return oauthError("invalid_client", "Invalid client credentials")
+		}
+
+		if err := a.verifyClientAssertion(db, client, params.ClientAssertion); err != nil {
+			if httpErr, ok := err.(*HTTPError); ok {
+				return httpErr
+			}
+			return oauthError("invalid_client", "Invalid client credentials").WithInternalError(err)
+		}
+
+	default:
+		if !client.VerifySecret(params.ClientSecret) {
+			return oauthError("invalid_client", "Invalid client credentials")
+		}
+	}
+
+	scope := client.Scope
+	if params.Scope != "" {
+		granted := strings.Fields(client.Scope)
+		for _, s := range strings.Fields(params.Scope) {
+			if !isStringInSlice(s, granted) {
+				return oauthError("invalid_scope", "Scope "+s+" is not granted to the client")
+			}
+		}
+		scope = strings.Join(strings.Fields(params.Scope), " ")
+	}
+
+	issuedAt := time.Now().UTC()
+	expiresAt := issuedAt.Add(time.Second * time.Duration(config.ServiceClients.Exp))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &serviceClientAccessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   client.ID.String(),
+			Audience:  []string{config.JWT.Aud},
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			Issuer:    config.JWT.Issuer,
+		},
+		Role:     client.Role,
+		Scope:    scope,
+		ClientID: client.ID.String(),
+	})
+
+	if config.JWT.KeyID != "" {
+		token.Header["kid"] = config.JWT.KeyID
+	}
+
+	signed, err := token.SignedString([]byte(config.JWT.Secret))
+	if err != nil {
+		return internalServerError("error generating jwt token").WithInternalError(err)
+	}
+
+	ac := models.GetAuditContext(ctx)
+	ac.ActorType = models.AuditActorServiceClient
+	ac.ServiceClientID = &client.ID
+
+	if err := models.NewAuditLogEvent(r, db, &models.AuditEvent{
+		Action:        models.ServiceClientTokenIssuedAction,
+		ActorUsername: client.Name,
+		AuthMethod:    client.AuthMethod(),
+		Traits: map[string]interface{}{
+			"scope": scope,
+			"role":  client.Role,
+		},
+	}); err != nil {
+		return internalServerError("Error recording audit log entry").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, &ClientCredentialsResponse{
+		AccessToken: signed,
+		TokenType:   "bearer",
+		ExpiresIn:   config.ServiceClients.Exp,
+		ExpiresAt:   expiresAt.Unix(),
+		Scope:       scope,
+	})
+}
+
+// loadServiceClient looks for a client_id parameter in the URL route and
+// loads the service client with that ID into the context.
+func (a *API) loadServiceClient(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	clientID, err := uuid.FromString(chi.URLParam(r, "client_id"))
+	if err != nil {
+		return nil, notFoundError(ErrorCodeServiceClientNotFound, "Service client not found")
+	}
+
+	client, err := models.FindServiceClientByID(db, clientID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, notFoundError(ErrorCodeServiceClientNotFound, "Service client not found")
+		}
+		return nil, internalServerError("Database error finding service client").WithInternalError(err)
+	}
+
+	observability.LogEntrySetField(r, "service_client_id", client.ID.String())
+
+	return withServiceClient(ctx, client), nil
+}
+
+// validate checks the params and applies them to client. It returns the
+// new secret when one was generated.
+func (p *ServiceClientParams) validate(client *models.ServiceClient) (string, error) {
+	if p.Name != "" {
+		client.Name = p.Name
+	}
+	if client.Name == "" {
+		return "", badRequestError(ErrorCodeValidationFailed, "name is required")
+	}
+
+	if p.Role != "" {
+		client.Role = p.Role
+	}
+	if client.Role == "" || strings.ContainsAny(client.Role, " \t\n") {
+		return "", badRequestError(ErrorCodeValidationFailed, "role is required and must not contain whitespace")
+	}
+
+	if p.Scope != nil {
+		scopes := strings.Fields(*p.Scope)
+		for _, scope := range scopes {
+			if !isValidScopeToken(scope) {
+				return "", badRequestError(ErrorCodeValidationFailed, "Invalid scope %q", scope)
+			}
+		}
+		client.Scope = strings.Join(scopes, " ")
+	}
+
+	if p.PublicKey != nil {
+		if *p.PublicKey == "" {
+			if client.PublicKey != nil || client.SecretHash == nil {
+				return client.GenerateSecret(), nil
+			}
+			return "", nil
+		}
+
+		if _, err := parseServiceClientPublicKey(*p.PublicKey); err != nil {
+			return "", badRequestError(ErrorCodeValidationFailed, err.Error())
+		}
+		client.SetPublicKey(*p.PublicKey)
+	}
+
+	if client.PublicKey == nil && client.SecretHash == nil {
+		return client.GenerateSecret(), nil
+	}
+
+	return "", nil
+}
+
+// adminServiceClientsList lists all service clients, including revoked
+// ones.
+func (a *API) adminServiceClientsList(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	clients, err := models.FindServiceClients(db)
+	if err != nil {
+		return internalServerError("Database error finding service clients").WithInternalError(err)
+	}
+
+	responses := make([]*ServiceClientResponse, 0, len(clients))
+	for _, client := range clients {
+		responses = append(responses, newServiceClientResponse(client, ""))
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]interface{}{
+		"service_clients": responses,
+	})
+}
+
+// adminServiceClientsCreate registers a service client. Its secret, if
+// any, is only returned in this response.
+func (a *API) adminServiceClientsCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	params := &ServiceClientParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	client := models.NewServiceClient("", "", "")
+	secret, err := params.validate(client)
+	if err != nil {
+		return err
+	}
+
+	if err := requireGrantableScope(ctx, client.Scope); err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := tx.Create(client); terr != nil {
+			return internalServerError("Database error creating service client").WithInternalError(terr)
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.ServiceClientCreatedAction, "", map[string]interface{}{
+			"client_id":   client.ID,
+			"name":        client.Name,
+			"role":        client.Role,
+			"scope":       client.Scope,
+			"auth_method": client.AuthMethod(),
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusCreated, newServiceClientResponse(client, secret))
+}
+
+// adminServiceClientsGet returns a service client.
+func (a *API) adminServiceClientsGet(w http.ResponseWriter, r *http.Request) error {
+	return sendJSON(w, http.StatusOK, newServiceClientResponse(getServiceClient(r.Context()), ""))
+}
+
+// adminServiceClientsUpdate changes the name, role, scope or credentials of
+// a service client. Tokens already issued keep their role and scope until
+// they expire.
+func (a *API) adminServiceClientsUpdate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	client := getServiceClient(ctx)
+
+	if client.IsRevoked() {
+		return unprocessableEntityError(ErrorCodeServiceClientRevoked, "Service client has been revoked")
+	}
+
+	if err := requireGrantableScope(ctx, client.Scope); err != nil {
+		return err
+	}
+
+	params := &ServiceClientParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	secret, err := params.validate(client)
+	if err != nil {
+		return err
+	}
+
+	if err := requireGrantableScope(ctx, client.Scope); err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := tx.UpdateOnly(client, "name", "role", "scope", "secret_hash", "public_key", "updated_at"); terr != nil {
+			return internalServerError("Database error updating service client").WithInternalError(terr)
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.ServiceClientUpdatedAction, "", map[string]interface{}{
+			"client_id":   client.ID,
+			"name":        client.Name,
+			"role":        client.Role,
+			"scope":       client.Scope,
+			"auth_method": client.AuthMethod(),
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, newServiceClientResponse(client, secret))
+}
+
+// adminServiceClientsRotateSecret replaces the credentials of a service
+// client with a new secret. The previous secret stops working immediately.
+func (a *API) adminServiceClientsRotateSecret(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	client := getServiceClient(ctx)
+
+	if client.IsRevoked() {
+		return unprocessableEntityError(ErrorCodeServiceClientRevoked, "Service client has been revoked")
+	}
+
+	if err := requireGrantableScope(ctx, client.Scope); err != nil {
+		return err
+	}
+
+	secret := client.GenerateSecret()
+
+	err := db.Transaction(func(tx *storage.Connection) error {
+		if terr := tx.UpdateOnly(client, "secret_hash", "public_key", "updated_at"); terr != nil {
+			return internalServerError("Database error updating service client").WithInternalError(terr)
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.ServiceClientSecretRotatedAction, "", map[string]interface{}{
+			"client_id": client.ID,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, newServiceClientResponse(client, secret))
+}
+
+// adminServiceClientsRevoke revokes a service client. It can't obtain new
+// tokens, and the tokens it already has are rejected by the admin API.
+func (a *API) adminServiceClientsRevoke(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	client := getServiceClient(ctx)
+
+	if err := requireGrantableScope(ctx, client.Scope); err != nil {
+		return err
+	}
+
+	if !client.IsRevoked() {
+		err := db.Transaction(func(tx *storage.Connection) error {
+			if terr := client.Revoke(tx); terr != nil {
+				return internalServerError("Database error revoking service client").WithInternalError(terr)
+			}
+
+			if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.ServiceClientRevokedAction, "", map[string]interface{}{
+				"client_id": client.ID,
+			}); terr != nil {
+				return internalServerError("Error recording audit log entry").WithInternalError(terr)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return sendJSON(w, http.StatusOK, newServiceClientResponse(client, ""))
+}
@@ -0,0 +1,323 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+type ServiceClientsTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	adminToken string
+}
+
+func TestServiceClients(t *testing.T) {
+	api, config, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, conn *storage.Connection) {
+		if config != nil {
+			config.ServiceClients.Enabled = true
+			config.ServiceClients.Exp = 600
+		}
+	})
+	require.NoError(t, err)
+
+	ts := &ServiceClientsTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *ServiceClientsTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{Role: "supabase_admin"}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+	ts.adminToken = token
+}
+
+func (ts *ServiceClientsTestSuite) request(method, path, bearer string, body map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *ServiceClientsTestSuite) createClient(body map[string]interface{}) *ServiceClientResponse {
+	w := ts.request(http.MethodPost, "/admin/service_clients", ts.adminToken, body)
+	require.Equal(ts.T(), http.StatusCreated, w.Code, w.Body.String())
+
+	client := &ServiceClientResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(client))
+	return client
+}
+
+func (ts *ServiceClientsTestSuite) grant(body map[string]interface{}) *httptest.ResponseRecorder {
+	return ts.request(http.MethodPost, "/token?grant_type=client_credentials", "", body)
+}
+
+func (ts *ServiceClientsTestSuite) TestClientCredentialsGrant() {
+	client := ts.createClient(map[string]interface{}{
+		"name":  "billing",
+		"role":  "service_role",
+		"scope": "users:read users:write",
+	})
+	require.NotEmpty(ts.T(), client.ClientSecret)
+	require.Equal(ts.T(), models.ServiceClientSecretAuth, client.AuthMethod)
+
+	w := ts.grant(map[string]interface{}{
+		"client_id":     client.ID.String(),
+		"client_secret": client.ClientSecret,
+		"scope":         "users:read",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	res := &ClientCredentialsResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(res))
+	require.Equal(ts.T(), "users:read", res.Scope)
+	require.Equal(ts.T(), 600, res.ExpiresIn)
+
+	claims := &AccessTokenClaims{}
+	_, err := jwt.ParseWithClaims(res.AccessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(ts.Config.JWT.Secret), nil
+	})
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), client.ID.String(), claims.ClientID)
+	require.Equal(ts.T(), "service_role", claims.Role)
+
+	// the token can be used with the admin API
+	w = ts.request(http.MethodGet, "/admin/users", res.AccessToken, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	// scopes that aren't granted to the client can't be requested
+	w = ts.grant(map[string]interface{}{
+		"client_id":     client.ID.String(),
+		"client_secret": client.ClientSecret,
+		"scope":         "sso:manage",
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_scope")
+}
+
+func (ts *ServiceClientsTestSuite) TestClientCredentialsGrantBasicAuth() {
+	client := ts.createClient(map[string]interface{}{
+		"name": "billing",
+		"role": "service_role",
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=client_credentials", bytes.NewBufferString("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(client.ID.String(), client.ClientSecret)
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/token?grant_type=client_credentials", bytes.NewBufferString("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(client.ID.String(), "incorrect")
+
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_client")
+}
+
+func (ts *ServiceClientsTestSuite) TestClientCredentialsGrantPrivateKeyJWT() {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(ts.T(), err)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(ts.T(), err)
+
+	client := ts.createClient(map[string]interface{}{
+		"name":       "reports",
+		"role":       "reporting",
+		"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	})
+	require.Empty(ts.T(), client.ClientSecret)
+	require.Equal(ts.T(), models.ServiceClientPrivateKeyAuth, client.AuthMethod)
+
+	assertionWithID := func(aud string, exp time.Duration, jti string) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodES256, &jwt.RegisteredClaims{
+			Issuer:    client.ID.String(),
+			Subject:   client.ID.String(),
+			Audience:  []string{aud},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(exp)),
+			ID:        jti,
+		}).SignedString(key)
+		require.NoError(ts.T(), err)
+		return signed
+	}
+
+	assertion := func(aud string, exp time.Duration) string {
+		return assertionWithID(aud, exp, uuid.Must(uuid.NewV4()).String())
+	}
+
+	jti := uuid.Must(uuid.NewV4()).String()
+	valid := assertionWithID(ts.Config.API.ExternalURL+"/token", time.Minute, jti)
+
+	w := ts.grant(map[string]interface{}{
+		"client_id":             client.ID.String(),
+		"client_assertion_type": clientAssertionTypeJWTBearer,
+		"client_assertion":      valid,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	cases := []string{
+		// replayed
+		valid,
+		// jti reused in another assertion
+		assertionWithID(ts.Config.API.ExternalURL+"/token", 2*time.Minute, jti),
+		// no jti
+		assertionWithID(ts.Config.API.ExternalURL+"/token", time.Minute, ""),
+		assertion("https://example.com/token", time.Minute),
+		assertion(ts.Config.API.ExternalURL+"/token", time.Hour),
+		assertion(ts.Config.API.ExternalURL+"/token", -time.Minute),
+	}
+
+	for _, c := range cases {
+		w := ts.grant(map[string]interface{}{
+			"client_id":             client.ID.String(),
+			"client_assertion_type": clientAssertionTypeJWTBearer,
+			"client_assertion":      c,
+		})
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+		require.Contains(ts.T(), w.Body.String(), "invalid_client")
+	}
+}
+
+func (ts *ServiceClientsTestSuite) TestRevoke() {
+	client := ts.createClient(map[string]interface{}{
+		"name": "billing",
+		"role": "service_role",
+	})
+
+	w := ts.grant(map[string]interface{}{
+		"client_id":     client.ID.String(),
+		"client_secret": client.ClientSecret,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	res := &ClientCredentialsResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(res))
+
+	w = ts.request(http.MethodDelete, "/admin/service_clients/"+client.ID.String(), ts.adminToken, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	// tokens already issued are rejected
+	w = ts.request(http.MethodGet, "/admin/users", res.AccessToken, nil)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	w = ts.grant(map[string]interface{}{
+		"client_id":     client.ID.String(),
+		"client_secret": client.ClientSecret,
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_client")
+}
+
+func (ts *ServiceClientsTestSuite) TestRotateSecret() {
+	client := ts.createClient(map[string]interface{}{
+		"name": "billing",
+		"role": "service_role",
+	})
+
+	w := ts.request(http.MethodPost, "/admin/service_clients/"+client.ID.String()+"/secret", ts.adminToken, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	rotated := &ServiceClientResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(rotated))
+	require.NotEmpty(ts.T(), rotated.ClientSecret)
+	require.NotEqual(ts.T(), client.ClientSecret, rotated.ClientSecret)
+
+	w = ts.grant(map[string]interface{}{
+		"client_id":     client.ID.String(),
+		"client_secret": client.ClientSecret,
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	w = ts.grant(map[string]interface{}{
+		"client_id":     client.ID.String(),
+		"client_secret": rotated.ClientSecret,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+}
+
+func (ts *ServiceClientsTestSuite) TestScopedAdminCantManageMorePowerfulClients() {
+	client := ts.createClient(map[string]interface{}{
+		"name": "billing",
+		"role": "service_role",
+	})
+
+	scoped, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role:  "supabase_admin",
+		Scope: "service_clients:manage users:read",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+
+	cases := []struct {
+		method string
+		path   string
+		body   map[string]interface{}
+	}{
+		{http.MethodPost, "/admin/service_clients/" + client.ID.String() + "/secret", nil},
+		{http.MethodDelete, "/admin/service_clients/" + client.ID.String(), nil},
+		{http.MethodPut, "/admin/service_clients/" + client.ID.String(), map[string]interface{}{"scope": "users:read"}},
+	}
+
+	for _, c := range cases {
+		w := ts.request(c.method, c.path, scoped, c.body)
+		require.Equal(ts.T(), http.StatusForbidden, w.Code, w.Body.String())
+		require.NotContains(ts.T(), w.Body.String(), "client_secret")
+	}
+
+	// the original secret still works
+	w := ts.grant(map[string]interface{}{
+		"client_id":     client.ID.String(),
+		"client_secret": client.ClientSecret,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+}
+
+func (ts *ServiceClientsTestSuite) TestCreateValidation() {
+	cases := []map[string]interface{}{
+		{"role": "service_role"},
+		{"name": "billing"},
+		{"name": "billing", "role": "service_role", "scope": `users:"read"`},
+		{"name": "billing", "role": "service_role", "public_key": "not a key"},
+	}
+
+	for _, c := range cases {
+		w := ts.request(http.MethodPost, "/admin/service_clients", ts.adminToken, c)
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	}
+}
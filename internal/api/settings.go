@@ -1,6 +1,10 @@
 package api
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/supabase/auth/internal/models"
+)
 
 type ProviderSettings struct {
 	AnonymousUsers bool `json:"anonymous_users"`
@@ -40,10 +44,125 @@ type Settings struct {
 	SAMLEnabled       bool             `json:"saml_enabled"`
 }
 
+type PasswordSettings struct {
+	MinLength          int      `json:"min_length"`
+	RequiredCharacters []string `json:"required_characters"`
+	CheckPwned         bool     `json:"check_pwned"`
+}
+
+type OTPSettings struct {
+	EmailLength int `json:"email_length"`
+	SmsLength   int `json:"sms_length"`
+}
+
+type MFASettings struct {
+	Enabled            bool     `json:"enabled"`
+	FactorTypes        []string `json:"factor_types"`
+	MaxEnrolledFactors int      `json:"max_enrolled_factors"`
+}
+
+type CaptchaSettings struct {
+	Enabled  bool   `json:"enabled"`
+	Provider string `json:"provider,omitempty"`
+	SiteKey  string `json:"site_key,omitempty"`
+}
+
+type SSOSettings struct {
+	Enabled bool     `json:"enabled"`
+	Domains []string `json:"domains,omitempty"`
+}
+
+// CapabilitySettings describes what the server supports, so that client
+// SDKs and login pages can adapt to it. It is returned instead of Settings
+// from API version 2024-09-01 on.
+type CapabilitySettings struct {
+	Settings
+
+	APIVersion string           `json:"api_version"`
+	FlowTypes  []string         `json:"flow_types"`
+	Password   PasswordSettings `json:"password"`
+	OTP        OTPSettings      `json:"otp"`
+	MFA        MFASettings      `json:"mfa"`
+	Captcha    CaptchaSettings  `json:"captcha"`
+	SSO        SSOSettings      `json:"sso"`
+}
+
 func (a *API) Settings(w http.ResponseWriter, r *http.Request) error {
+	settings := a.settings()
+
+	// an invalid version falls back to the initial one, like errors do
+	apiVersion, _ := DetermineClosestAPIVersion(r.Header.Get(APIVersionHeaderName))
+	if apiVersion.Compare(APIVersion20240901) < 0 {
+		return sendJSON(w, http.StatusOK, settings)
+	}
+
+	capabilities, err := a.capabilitySettings(r, settings)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set(APIVersionHeaderName, FormatAPIVersion(apiVersion))
+
+	return sendJSON(w, http.StatusOK, capabilities)
+}
+
+func (a *API) capabilitySettings(r *http.Request, settings *Settings) (*CapabilitySettings, error) {
+	config := a.config
+
+	capabilities := &CapabilitySettings{
+		Settings:   *settings,
+		APIVersion: FormatAPIVersion(APIVersion20240901),
+		FlowTypes:  []string{models.ImplicitFlow.String(), models.PKCEFlow.String()},
+		Password: PasswordSettings{
+			MinLength:          config.Password.MinLength,
+			RequiredCharacters: []string(config.Password.RequiredCharacters),
+			CheckPwned:         config.Password.HIBP.Enabled,
+		},
+		OTP: OTPSettings{
+			EmailLength: config.Mailer.OtpLength,
+			SmsLength:   config.Sms.OtpLength,
+		},
+		MFA: MFASettings{
+			Enabled:            config.MFA.Enabled,
+			FactorTypes:        []string{},
+			MaxEnrolledFactors: int(config.MFA.MaxEnrolledFactors),
+		},
+		Captcha: CaptchaSettings{
+			Enabled: config.Security.Captcha.Enabled,
+		},
+		SSO: SSOSettings{
+			Enabled: config.SAML.Enabled,
+		},
+	}
+
+	if capabilities.Password.RequiredCharacters == nil {
+		capabilities.Password.RequiredCharacters = []string{}
+	}
+
+	if config.MFA.Enabled {
+		capabilities.MFA.FactorTypes = append(capabilities.MFA.FactorTypes, models.TOTP)
+	}
+
+	if config.Security.Captcha.Enabled {
+		capabilities.Captcha.Provider = config.Security.Captcha.Provider
+		capabilities.Captcha.SiteKey = config.Security.Captcha.SiteKey
+	}
+
+	if config.SAML.Enabled && config.SAML.ExposeDomains {
+		domains, err := models.FindAllSSODomains(a.db.WithContext(r.Context()))
+		if err != nil {
+			return nil, internalServerError("Database error loading SSO domains").WithInternalError(err)
+		}
+		capabilities.SSO.Domains = domains
+	}
+
+	return capabilities, nil
+}
+
+func (a *API) settings() *Settings {
 	config := a.config
 
-	return sendJSON(w, http.StatusOK, &Settings{
+	return &Settings{
 		ExternalProviders: ProviderSettings{
 			AnonymousUsers: config.External.AnonymousUsers.Enabled,
 			Apple:          config.External.Apple.Enabled,
@@ -77,5 +196,5 @@ func (a *API) Settings(w http.ResponseWriter, r *http.Request) error {
 		SmsProvider:       config.Sms.Provider,
 		MFAEnabled:        config.MFA.Enabled,
 		SAMLEnabled:       config.SAML.Enabled,
-	})
+	}
 }
@@ -71,3 +71,48 @@ func TestSettings_EmailDisabled(t *testing.T) {
 	p := resp.ExternalProviders
 	require.False(t, p.Email)
 }
+
+func TestSettings_Capabilities(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	config.MFA.Enabled = true
+	config.Password.MinLength = 8
+	config.Password.RequiredCharacters = []string{"abcdefghijklmnopqrstuvwxyz", "0123456789"}
+	config.Security.Captcha.Enabled = true
+	config.Security.Captcha.Provider = "turnstile"
+	config.Security.Captcha.SiteKey = "site-key"
+
+	// without the version header the settings are unchanged
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/settings", nil)
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get(APIVersionHeaderName))
+
+	var legacy map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&legacy))
+	require.NotContains(t, legacy, "api_version")
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost/settings", nil)
+	req.Header.Set(APIVersionHeaderName, "2024-09-01")
+	w = httptest.NewRecorder()
+	api.handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "2024-09-01", w.Header().Get(APIVersionHeaderName))
+
+	resp := CapabilitySettings{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+
+	require.Equal(t, "2024-09-01", resp.APIVersion)
+	require.True(t, resp.ExternalProviders.Email)
+	require.ElementsMatch(t, []string{"implicit", "pkce"}, resp.FlowTypes)
+	require.Equal(t, 8, resp.Password.MinLength)
+	require.Equal(t, []string{"abcdefghijklmnopqrstuvwxyz", "0123456789"}, resp.Password.RequiredCharacters)
+	require.Equal(t, config.Mailer.OtpLength, resp.OTP.EmailLength)
+	require.Equal(t, config.Sms.OtpLength, resp.OTP.SmsLength)
+	require.Equal(t, []string{"totp"}, resp.MFA.FactorTypes)
+	require.Equal(t, CaptchaSettings{Enabled: true, Provider: "turnstile", SiteKey: "site-key"}, resp.Captcha)
+	require.Equal(t, config.SAML.Enabled, resp.SSO.Enabled)
+	require.Empty(t, resp.SSO.Domains)
+}
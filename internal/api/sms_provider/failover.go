@@ -0,0 +1,98 @@
+package sms_provider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/supabase/auth/internal/conf"
+)
+
+// FailoverProvider sends messages with the first provider that accepts them.
+// Providers are tried in the order of conf.SmsProviderConfiguration.ProvidersFor,
+// so that messages to some countries can be routed to other providers. Each
+// attempt is bounded by the HTTP timeout of the provider.
+type FailoverProvider struct {
+	Config    *conf.SmsProviderConfiguration
+	Providers map[string]SmsProvider
+}
+
+// Delivery records which provider sent a message, so that delivery receipts
+// can be reconciled with it.
+type Delivery struct {
+	Provider  string
+	MessageID string
+
+	// Failures are the providers that were tried before, and their errors
+	Failures []DeliveryFailure
+}
+
+// DeliveryFailure is a provider that failed to send a message.
+type DeliveryFailure struct {
+	Provider string
+	Err      error
+}
+
+// Creates a FailoverProvider with every provider messages can be sent with
+func NewFailoverProvider(config conf.GlobalConfiguration) (*FailoverProvider, error) {
+	if err := config.Sms.ValidateFailover(); err != nil {
+		return nil, err
+	}
+
+	providers := map[string]SmsProvider{}
+	for _, name := range config.Sms.ProviderNames() {
+		factory, ok := factories[name]
+		if !ok {
+			return nil, fmt.Errorf("sms Provider %s could not be found", name)
+		}
+		provider, err := factory(config)
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = provider
+	}
+
+	return &FailoverProvider{
+		Config:    &config.Sms,
+		Providers: providers,
+	}, nil
+}
+
+func (t *FailoverProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	delivery, err := t.Send(phone, message, channel, otp)
+	return delivery.MessageID, err
+}
+
+// Send tries the providers for phone in order until one of them sends the
+// message. The returned delivery records the failed attempts even if no
+// provider could send the message.
+func (t *FailoverProvider) Send(phone, message, channel, otp string) (*Delivery, error) {
+	delivery := &Delivery{}
+	for _, name := range t.Config.ProvidersFor(phone) {
+		messageID, err := t.Providers[name].SendMessage(phone, message, channel, otp)
+		if err != nil {
+			delivery.Failures = append(delivery.Failures, DeliveryFailure{Provider: name, Err: err})
+			continue
+		}
+		delivery.Provider = name
+		delivery.MessageID = messageID
+		return delivery, nil
+	}
+
+	errs := make([]string, 0, len(delivery.Failures))
+	for _, failure := range delivery.Failures {
+		errs = append(errs, failure.Provider+": "+failure.Err.Error())
+	}
+	return delivery, fmt.Errorf("no sms provider could send the message: %s", strings.Join(errs, "; "))
+}
+
+// Send sends a message with provider, which is configured as name, and
+// records which provider sent it. A FailoverProvider chooses the provider
+// itself.
+func Send(provider SmsProvider, name, phone, message, channel, otp string) (*Delivery, error) {
+	if failover, ok := provider.(*FailoverProvider); ok {
+		return failover.Send(phone, message, channel, otp)
+	}
+
+	messageID, err := provider.SendMessage(phone, message, channel, otp)
+	return &Delivery{Provider: name, MessageID: messageID}, err
+}
@@ -0,0 +1,118 @@
+package sms_provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+// HTTPProvider sends messages with a request to any HTTP API, so that SMS
+// gateways without a built-in provider can be used.
+type HTTPProvider struct {
+	Config       *conf.HTTPSmsProviderConfiguration
+	BodyTemplate *template.Template
+}
+
+// HTTPMessage is the data the body template of the HTTP provider is
+// rendered with. Phone is in E.164 format, with a leading +.
+type HTTPMessage struct {
+	Phone   string
+	Message string
+	OTP     string
+	Channel string
+}
+
+func init() {
+	Register("http", func(config conf.GlobalConfiguration) (SmsProvider, error) {
+		return NewHTTPProvider(config.Sms.HTTP)
+	})
+}
+
+// Creates a SmsProvider with the HTTP Config
+func NewHTTPProvider(config conf.HTTPSmsProviderConfiguration) (SmsProvider, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	bodyTemplate, err := config.ParseBodyTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &HTTPProvider{
+		Config:       &config,
+		BodyTemplate: bodyTemplate,
+	}, nil
+}
+
+func (t *HTTPProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	switch channel {
+	case SMSProvider:
+		return t.SendSms(phone, message, otp)
+	default:
+		return "", fmt.Errorf("channel type %q is not supported for the HTTP SMS provider", channel)
+	}
+}
+
+// Send an SMS containing the OTP with a request rendered from the body
+// template
+func (t *HTTPProvider) SendSms(phone, message, otp string) (string, error) {
+	var body bytes.Buffer
+	if err := t.BodyTemplate.Execute(&body, HTTPMessage{
+		Phone:   "+" + phone,
+		Message: message,
+		OTP:     otp,
+		Channel: SMSProvider,
+	}); err != nil {
+		return "", fmt.Errorf("http sms error: unable to render body: %w", err)
+	}
+
+	client := &http.Client{Timeout: defaultTimeout}
+	r, err := http.NewRequest(t.Config.Method, t.Config.URL, &body)
+	if err != nil {
+		return "", err
+	}
+	r.Header.Set("Content-Type", t.Config.ContentType)
+	for name, value := range t.Config.Headers {
+		r.Header.Set(name, value)
+	}
+	res, err := client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer utilities.SafeClose(res.Body)
+
+	if res.StatusCode/100 != 2 {
+		// the error body is usually short, keep enough of it to debug
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return "", fmt.Errorf("http sms error: status %v: %s", res.StatusCode, b)
+	}
+
+	if t.Config.MessageIDField == "" {
+		return "", nil
+	}
+
+	resp := map[string]interface{}{}
+	dec := json.NewDecoder(res.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&resp); err != nil {
+		return "", fmt.Errorf("http sms error: unable to decode response: %w", err)
+	}
+
+	switch id := resp[t.Config.MessageIDField].(type) {
+	case string:
+		return id, nil
+	case json.Number:
+		return id.String(), nil
+	case nil:
+		return "", fmt.Errorf("http sms error: response has no %q field", t.Config.MessageIDField)
+	default:
+		return fmt.Sprint(id), nil
+	}
+}
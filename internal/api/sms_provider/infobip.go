@@ -0,0 +1,129 @@
+package sms_provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+type InfobipProvider struct {
+	Config  *conf.InfobipProviderConfiguration
+	APIPath string
+}
+
+type InfobipDestination struct {
+	To string `json:"to"`
+}
+
+type InfobipMessage struct {
+	Destinations []InfobipDestination `json:"destinations"`
+	From         string               `json:"from"`
+	Text         string               `json:"text"`
+}
+
+type InfobipRequest struct {
+	Messages []InfobipMessage `json:"messages"`
+}
+
+type InfobipStatus struct {
+	GroupName   string `json:"groupName"`
+	Description string `json:"description"`
+}
+
+type InfobipResponseMessage struct {
+	MessageID string        `json:"messageId"`
+	Status    InfobipStatus `json:"status"`
+}
+
+type InfobipResponse struct {
+	Messages     []InfobipResponseMessage `json:"messages"`
+	RequestError struct {
+		ServiceException struct {
+			MessageID string `json:"messageId"`
+			Text      string `json:"text"`
+		} `json:"serviceException"`
+	} `json:"requestError"`
+}
+
+func init() {
+	Register("infobip", func(config conf.GlobalConfiguration) (SmsProvider, error) {
+		return NewInfobipProvider(config.Sms.Infobip)
+	})
+}
+
+// Creates a SmsProvider with the Infobip Config
+func NewInfobipProvider(config conf.InfobipProviderConfiguration) (SmsProvider, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	apiBase := strings.TrimSuffix(config.BaseURL, "/")
+	if !strings.Contains(apiBase, "://") {
+		apiBase = "https://" + apiBase
+	}
+
+	apiPath := apiBase + "/sms/2/text/advanced"
+	return &InfobipProvider{
+		Config:  &config,
+		APIPath: apiPath,
+	}, nil
+}
+
+func (t *InfobipProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	switch channel {
+	case SMSProvider:
+		return t.SendSms(phone, message)
+	default:
+		return "", fmt.Errorf("channel type %q is not supported for Infobip", channel)
+	}
+}
+
+// Send an SMS containing the OTP with Infobip's API
+func (t *InfobipProvider) SendSms(phone string, message string) (string, error) {
+	body, err := json.Marshal(InfobipRequest{
+		Messages: []InfobipMessage{{
+			Destinations: []InfobipDestination{{To: phone}},
+			From:         t.Config.Sender,
+			Text:         message,
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: defaultTimeout}
+	r, err := http.NewRequest("POST", t.APIPath, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	r.Header.Add("Content-Type", "application/json")
+	r.Header.Add("Accept", "application/json")
+	r.Header.Add("Authorization", "App "+t.Config.ApiKey)
+	res, err := client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer utilities.SafeClose(res.Body)
+
+	resp := &InfobipResponse{}
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return "", fmt.Errorf("infobip error: unable to decode response with status %v: %w", res.StatusCode, err)
+	}
+
+	if res.StatusCode/100 != 2 || len(resp.Messages) == 0 {
+		exception := resp.RequestError.ServiceException
+		return "", fmt.Errorf("infobip error: %v (code: %v)", exception.Text, exception.MessageID)
+	}
+
+	// messages are accepted as pending, and rejected right away otherwise
+	if status := resp.Messages[0].Status; status.GroupName == "REJECTED" {
+		return resp.Messages[0].MessageID, fmt.Errorf("infobip error: %v for message %v", status.Description, resp.Messages[0].MessageID)
+	}
+
+	return resp.Messages[0].MessageID, nil
+}
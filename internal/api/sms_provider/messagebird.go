@@ -43,6 +43,12 @@ func (t MessagebirdErrResponse) Error() string {
 	return t.Errors[0].Description
 }
 
+func init() {
+	Register("messagebird", func(config conf.GlobalConfiguration) (SmsProvider, error) {
+		return NewMessagebirdProvider(config.Sms.Messagebird)
+	})
+}
+
 // Creates a SmsProvider with the Messagebird Config
 func NewMessagebirdProvider(config conf.MessagebirdProviderConfiguration) (SmsProvider, error) {
 	if err := config.Validate(); err != nil {
@@ -0,0 +1,101 @@
+package sms_provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+const (
+	defaultPlivoApiBase = "https://api.plivo.com"
+)
+
+type PlivoProvider struct {
+	Config  *conf.PlivoProviderConfiguration
+	APIPath string
+}
+
+type PlivoMessage struct {
+	Src  string `json:"src"`
+	Dst  string `json:"dst"`
+	Text string `json:"text"`
+}
+
+type PlivoResponse struct {
+	MessageUUID []string `json:"message_uuid"`
+	Error       string   `json:"error"`
+}
+
+func init() {
+	Register("plivo", func(config conf.GlobalConfiguration) (SmsProvider, error) {
+		return NewPlivoProvider(config.Sms.Plivo)
+	})
+}
+
+// Creates a SmsProvider with the Plivo Config
+func NewPlivoProvider(config conf.PlivoProviderConfiguration) (SmsProvider, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	apiBase := defaultPlivoApiBase
+	if config.BaseURL != "" {
+		apiBase = strings.TrimSuffix(config.BaseURL, "/")
+	}
+
+	apiPath := apiBase + "/v1/Account/" + config.AuthID + "/Message/"
+	return &PlivoProvider{
+		Config:  &config,
+		APIPath: apiPath,
+	}, nil
+}
+
+func (t *PlivoProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	switch channel {
+	case SMSProvider:
+		return t.SendSms(phone, message)
+	default:
+		return "", fmt.Errorf("channel type %q is not supported for Plivo", channel)
+	}
+}
+
+// Send an SMS containing the OTP with Plivo's API
+func (t *PlivoProvider) SendSms(phone string, message string) (string, error) {
+	body, err := json.Marshal(PlivoMessage{
+		Src:  t.Config.From,
+		Dst:  phone,
+		Text: message,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: defaultTimeout}
+	r, err := http.NewRequest("POST", t.APIPath, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	r.Header.Add("Content-Type", "application/json")
+	r.SetBasicAuth(t.Config.AuthID, t.Config.AuthToken)
+	res, err := client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer utilities.SafeClose(res.Body)
+
+	resp := &PlivoResponse{}
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return "", fmt.Errorf("plivo error: unable to decode response with status %v: %w", res.StatusCode, err)
+	}
+
+	if res.StatusCode/100 != 2 || len(resp.MessageUUID) == 0 {
+		return "", fmt.Errorf("plivo error: %v (code: %v)", resp.Error, res.StatusCode)
+	}
+
+	return resp.MessageUUID[0], nil
+}
@@ -0,0 +1,98 @@
+package sms_provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+type SinchProvider struct {
+	Config  *conf.SinchProviderConfiguration
+	APIPath string
+}
+
+type SinchBatch struct {
+	From string   `json:"from"`
+	To   []string `json:"to"`
+	Body string   `json:"body"`
+}
+
+type SinchResponse struct {
+	ID   string `json:"id"`
+	Code string `json:"code"`
+	Text string `json:"text"`
+}
+
+func init() {
+	Register("sinch", func(config conf.GlobalConfiguration) (SmsProvider, error) {
+		return NewSinchProvider(config.Sms.Sinch)
+	})
+}
+
+// Creates a SmsProvider with the Sinch Config
+func NewSinchProvider(config conf.SinchProviderConfiguration) (SmsProvider, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	apiBase := "https://" + config.Region + ".sms.api.sinch.com"
+	if config.BaseURL != "" {
+		apiBase = strings.TrimSuffix(config.BaseURL, "/")
+	}
+
+	apiPath := apiBase + "/xms/v1/" + config.ServicePlanID + "/batches"
+	return &SinchProvider{
+		Config:  &config,
+		APIPath: apiPath,
+	}, nil
+}
+
+func (t *SinchProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	switch channel {
+	case SMSProvider:
+		return t.SendSms(phone, message)
+	default:
+		return "", fmt.Errorf("channel type %q is not supported for Sinch", channel)
+	}
+}
+
+// Send an SMS containing the OTP with Sinch's API
+func (t *SinchProvider) SendSms(phone string, message string) (string, error) {
+	body, err := json.Marshal(SinchBatch{
+		From: t.Config.From,
+		To:   []string{"+" + phone},
+		Body: message,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: defaultTimeout}
+	r, err := http.NewRequest("POST", t.APIPath, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	r.Header.Add("Content-Type", "application/json")
+	r.Header.Add("Authorization", "Bearer "+t.Config.ApiToken)
+	res, err := client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer utilities.SafeClose(res.Body)
+
+	resp := &SinchResponse{}
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return "", fmt.Errorf("sinch error: unable to decode response with status %v: %w", res.StatusCode, err)
+	}
+
+	if res.StatusCode/100 != 2 || resp.ID == "" {
+		return "", fmt.Errorf("sinch error: %v (code: %v)", resp.Text, resp.Code)
+	}
+
+	return resp.ID, nil
+}
@@ -29,21 +29,34 @@ type SmsProvider interface {
 	SendMessage(phone, message, channel, otp string) (string, error)
 }
 
+// Factory creates an SmsProvider from the configuration.
+type Factory func(config conf.GlobalConfiguration) (SmsProvider, error)
+
+// factories holds the registered SMS providers by name.
+var factories = map[string]Factory{}
+
+// Register makes an SMS provider available under name, which is selected
+// with GOTRUE_SMS_PROVIDER. It panics if a provider is already registered
+// under name.
+func Register(name string, factory Factory) {
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("sms provider %q is already registered", name))
+	}
+	factories[name] = factory
+}
+
+// GetSmsProvider returns the configured provider, or a FailoverProvider if
+// messages can also be sent with failover providers or routes.
 func GetSmsProvider(config conf.GlobalConfiguration) (SmsProvider, error) {
-	switch name := config.Sms.Provider; name {
-	case "twilio":
-		return NewTwilioProvider(config.Sms.Twilio)
-	case "messagebird":
-		return NewMessagebirdProvider(config.Sms.Messagebird)
-	case "textlocal":
-		return NewTextlocalProvider(config.Sms.Textlocal)
-	case "vonage":
-		return NewVonageProvider(config.Sms.Vonage)
-	case "twilio_verify":
-		return NewTwilioVerifyProvider(config.Sms.TwilioVerify)
-	default:
-		return nil, fmt.Errorf("sms Provider %s could not be found", name)
+	if config.Sms.HasFailover() {
+		return NewFailoverProvider(config)
+	}
+
+	factory, ok := factories[config.Sms.Provider]
+	if !ok {
+		return nil, fmt.Errorf("sms Provider %s could not be found", config.Sms.Provider)
 	}
+	return factory(config)
 }
 
 func IsValidMessageChannel(channel string, smsProvider string) bool {
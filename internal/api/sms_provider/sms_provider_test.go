@@ -2,10 +2,15 @@ package sms_provider
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -285,3 +290,245 @@ func (ts *SmsProviderTestSuite) TestTwilioVerifySendSms() {
 		})
 	}
 }
+
+// fakeSmsServer starts a local server standing in for an SMS provider's API.
+// check inspects each request, and the server replies with status and
+// response.
+func (ts *SmsProviderTestSuite) fakeSmsServer(check func(r *http.Request, body []byte), status int, response string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(ts.T(), err)
+		check(r, body)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	ts.T().Cleanup(server.Close)
+	return server
+}
+
+func (ts *SmsProviderTestSuite) TestGetSmsProvider() {
+	for _, name := range []string{"twilio", "twilio_verify", "messagebird", "textlocal", "vonage", "sns", "plivo", "sinch", "infobip", "http"} {
+		_, ok := factories[name]
+		require.True(ts.T(), ok, "expected %s to be registered", name)
+	}
+
+	_, err := GetSmsProvider(conf.GlobalConfiguration{Sms: conf.SmsProviderConfiguration{Provider: "unknown"}})
+	require.Error(ts.T(), err)
+
+	require.Panics(ts.T(), func() {
+		Register("twilio", nil)
+	})
+}
+
+func (ts *SmsProviderTestSuite) TestSignAWSRequest() {
+	// the example from the AWS Signature Version 4 documentation
+	r, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	require.NoError(ts.T(), err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	signAWSRequest(r, nil, "iam", "us-east-1", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	require.Equal(ts.T(), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7", r.Header.Get("Authorization"))
+}
+
+func (ts *SmsProviderTestSuite) TestSNSSendSms() {
+	server := ts.fakeSmsServer(func(r *http.Request, body []byte) {
+		require.True(ts.T(), strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test_access_key_id/"))
+		require.NotEmpty(ts.T(), r.Header.Get("X-Amz-Date"))
+
+		values, err := url.ParseQuery(string(body))
+		require.NoError(ts.T(), err)
+		require.Equal(ts.T(), "Publish", values.Get("Action"))
+		require.Equal(ts.T(), "+123456789", values.Get("PhoneNumber"))
+		require.Equal(ts.T(), "This is the sms code: 123456", values.Get("Message"))
+		require.Equal(ts.T(), "Transactional", values.Get("MessageAttributes.entry.1.Value.StringValue"))
+		require.Equal(ts.T(), "test_sender", values.Get("MessageAttributes.entry.2.Value.StringValue"))
+	}, http.StatusOK, `<PublishResponse><PublishResult><MessageId>abcdef</MessageId></PublishResult></PublishResponse>`)
+
+	provider, err := NewSNSProvider(conf.SNSProviderConfiguration{
+		AccessKeyID:     "test_access_key_id",
+		SecretAccessKey: "test_secret_access_key",
+		SenderID:        "test_sender",
+		SMSType:         "Transactional",
+		BaseURL:         server.URL,
+	})
+	require.NoError(ts.T(), err)
+
+	messageID, err := provider.SendMessage("123456789", "This is the sms code: 123456", SMSProvider, "123456")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "abcdef", messageID)
+
+	server = ts.fakeSmsServer(func(r *http.Request, body []byte) {}, http.StatusBadRequest, `<ErrorResponse><Error><Code>InvalidParameter</Code><Message>Invalid parameter: PhoneNumber</Message></Error></ErrorResponse>`)
+	provider.(*SNSProvider).APIPath = server.URL + "/"
+
+	_, err = provider.SendMessage("123456789", "This is the sms code: 123456", SMSProvider, "123456")
+	require.EqualError(ts.T(), err, "sns error: Invalid parameter: PhoneNumber (code: InvalidParameter)")
+}
+
+func (ts *SmsProviderTestSuite) TestPlivoSendSms() {
+	server := ts.fakeSmsServer(func(r *http.Request, body []byte) {
+		require.Equal(ts.T(), "/v1/Account/test_auth_id/Message/", r.URL.Path)
+		require.Equal(ts.T(), "Basic "+base64.StdEncoding.EncodeToString([]byte("test_auth_id:test_auth_token")), r.Header.Get("Authorization"))
+		require.JSONEq(ts.T(), `{"src": "test_from", "dst": "123456789", "text": "This is the sms code: 123456"}`, string(body))
+	}, http.StatusAccepted, `{"api_id": "1", "message": "message(s) queued", "message_uuid": ["abcdef"]}`)
+
+	provider, err := NewPlivoProvider(conf.PlivoProviderConfiguration{
+		AuthID:    "test_auth_id",
+		AuthToken: "test_auth_token",
+		From:      "test_from",
+		BaseURL:   server.URL,
+	})
+	require.NoError(ts.T(), err)
+
+	messageID, err := provider.SendMessage("123456789", "This is the sms code: 123456", SMSProvider, "123456")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "abcdef", messageID)
+
+	_, err = provider.SendMessage("123456789", "This is the sms code: 123456", WhatsappProvider, "123456")
+	require.Error(ts.T(), err)
+}
+
+func (ts *SmsProviderTestSuite) TestSinchSendSms() {
+	server := ts.fakeSmsServer(func(r *http.Request, body []byte) {
+		require.Equal(ts.T(), "/xms/v1/test_service_plan_id/batches", r.URL.Path)
+		require.Equal(ts.T(), "Bearer test_api_token", r.Header.Get("Authorization"))
+		require.JSONEq(ts.T(), `{"from": "test_from", "to": ["+123456789"], "body": "This is the sms code: 123456"}`, string(body))
+	}, http.StatusBadRequest, `{"code": "syntax_invalid_parameter_format", "text": "Invalid number"}`)
+
+	provider, err := NewSinchProvider(conf.SinchProviderConfiguration{
+		ServicePlanID: "test_service_plan_id",
+		ApiToken:      "test_api_token",
+		From:          "test_from",
+		BaseURL:       server.URL,
+	})
+	require.NoError(ts.T(), err)
+
+	_, err = provider.SendMessage("123456789", "This is the sms code: 123456", SMSProvider, "123456")
+	require.EqualError(ts.T(), err, "sinch error: Invalid number (code: syntax_invalid_parameter_format)")
+}
+
+func (ts *SmsProviderTestSuite) TestInfobipSendSms() {
+	server := ts.fakeSmsServer(func(r *http.Request, body []byte) {
+		require.Equal(ts.T(), "/sms/2/text/advanced", r.URL.Path)
+		require.Equal(ts.T(), "App test_api_key", r.Header.Get("Authorization"))
+		require.JSONEq(ts.T(), `{"messages": [{"destinations": [{"to": "123456789"}], "from": "test_sender", "text": "This is the sms code: 123456"}]}`, string(body))
+	}, http.StatusOK, `{"messages": [{"messageId": "abcdef", "status": {"groupName": "PENDING", "description": "Message sent to next instance"}}]}`)
+
+	provider, err := NewInfobipProvider(conf.InfobipProviderConfiguration{
+		ApiKey:  "test_api_key",
+		Sender:  "test_sender",
+		BaseURL: server.URL,
+	})
+	require.NoError(ts.T(), err)
+
+	messageID, err := provider.SendMessage("123456789", "This is the sms code: 123456", SMSProvider, "123456")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "abcdef", messageID)
+}
+
+func (ts *SmsProviderTestSuite) TestHTTPSendSms() {
+	server := ts.fakeSmsServer(func(r *http.Request, body []byte) {
+		require.Equal(ts.T(), http.MethodPut, r.Method)
+		require.Equal(ts.T(), "/send", r.URL.Path)
+		require.Equal(ts.T(), "Bearer test_token", r.Header.Get("Authorization"))
+		require.Equal(ts.T(), "application/json", r.Header.Get("Content-Type"))
+		require.JSONEq(ts.T(), `{"to": "+123456789", "text": "Your code is \"123456\"", "code": "123456"}`, string(body))
+	}, http.StatusOK, `{"id": 42}`)
+
+	provider, err := NewHTTPProvider(conf.HTTPSmsProviderConfiguration{
+		URL:            server.URL + "/send",
+		Method:         http.MethodPut,
+		Headers:        map[string]string{"Authorization": "Bearer test_token"},
+		ContentType:    "application/json",
+		BodyTemplate:   `{"to": {{ json .Phone }}, "text": {{ json .Message }}, "code": {{ json .OTP }}}`,
+		MessageIDField: "id",
+	})
+	require.NoError(ts.T(), err)
+
+	messageID, err := provider.SendMessage("123456789", `Your code is "123456"`, SMSProvider, "123456")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "42", messageID)
+
+	server = ts.fakeSmsServer(func(r *http.Request, body []byte) {}, http.StatusServiceUnavailable, "gateway down")
+	provider.(*HTTPProvider).Config.URL = server.URL
+
+	_, err = provider.SendMessage("123456789", "Your code is 123456", SMSProvider, "123456")
+	require.EqualError(ts.T(), err, "http sms error: status 503: gateway down")
+}
+
+func (ts *SmsProviderTestSuite) TestFailoverSendSms() {
+	plivo := ts.fakeSmsServer(func(r *http.Request, body []byte) {}, http.StatusInternalServerError, `{"error": "service unavailable"}`)
+	sinch := ts.fakeSmsServer(func(r *http.Request, body []byte) {
+		batch := SinchBatch{}
+		require.NoError(ts.T(), json.Unmarshal(body, &batch))
+		require.Equal(ts.T(), []string{"+447700900123"}, batch.To)
+	}, http.StatusCreated, `{"id": "sinch_id"}`)
+	infobip := ts.fakeSmsServer(func(r *http.Request, body []byte) {}, http.StatusOK, `{"messages": [{"messageId": "infobip_id", "status": {"groupName": "PENDING"}}]}`)
+
+	config := conf.GlobalConfiguration{
+		Sms: conf.SmsProviderConfiguration{
+			Provider:          "plivo",
+			FailoverProviders: []string{"infobip"},
+			Routes:            map[string]string{"44": "sinch"},
+			Plivo:             conf.PlivoProviderConfiguration{AuthID: "test_auth_id", AuthToken: "test_auth_token", From: "test_from", BaseURL: plivo.URL},
+			Sinch:             conf.SinchProviderConfiguration{ServicePlanID: "test_service_plan_id", ApiToken: "test_api_token", From: "test_from", Region: "eu", BaseURL: sinch.URL},
+			Infobip:           conf.InfobipProviderConfiguration{ApiKey: "test_api_key", Sender: "test_sender", BaseURL: infobip.URL},
+		},
+	}
+
+	provider, err := GetSmsProvider(config)
+	require.NoError(ts.T(), err)
+	require.IsType(ts.T(), &FailoverProvider{}, provider)
+
+	// plivo fails, so the message is sent with the failover provider
+	delivery, err := Send(provider, config.Sms.Provider, "15555555555", "This is the sms code: 123456", SMSProvider, "123456")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "infobip", delivery.Provider)
+	require.Equal(ts.T(), "infobip_id", delivery.MessageID)
+	require.Len(ts.T(), delivery.Failures, 1)
+	require.Equal(ts.T(), "plivo", delivery.Failures[0].Provider)
+
+	// messages to the UK are routed to sinch first
+	delivery, err = Send(provider, config.Sms.Provider, "447700900123", "This is the sms code: 123456", SMSProvider, "123456")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "sinch", delivery.Provider)
+	require.Equal(ts.T(), "sinch_id", delivery.MessageID)
+	require.Empty(ts.T(), delivery.Failures)
+
+	// every provider fails
+	delivery, err = Send(provider, config.Sms.Provider, "15555555555", "This is the sms code: 123456", WhatsappProvider, "123456")
+	require.Error(ts.T(), err)
+	require.Empty(ts.T(), delivery.Provider)
+	require.Len(ts.T(), delivery.Failures, 2)
+
+	config.Sms.FailoverProviders = []string{"twilio_verify"}
+	_, err = GetSmsProvider(config)
+	require.Error(ts.T(), err)
+}
+
+func (ts *SmsProviderTestSuite) TestFailoverOnTimeout() {
+	timeout := defaultTimeout
+	defaultTimeout = 50 * time.Millisecond
+	defer func() { defaultTimeout = timeout }()
+
+	slow := ts.fakeSmsServer(func(r *http.Request, body []byte) {
+		time.Sleep(200 * time.Millisecond)
+	}, http.StatusOK, `{"id": "slow_id"}`)
+	fast := ts.fakeSmsServer(func(r *http.Request, body []byte) {}, http.StatusOK, `{"message_uuid": ["fast_id"]}`)
+
+	provider, err := NewFailoverProvider(conf.GlobalConfiguration{
+		Sms: conf.SmsProviderConfiguration{
+			Provider:          "http",
+			FailoverProviders: []string{"plivo"},
+			HTTP:              conf.HTTPSmsProviderConfiguration{URL: slow.URL, Method: http.MethodPost, ContentType: "application/json", MessageIDField: "id"},
+			Plivo:             conf.PlivoProviderConfiguration{AuthID: "test_auth_id", AuthToken: "test_auth_token", From: "test_from", BaseURL: fast.URL},
+		},
+	})
+	require.NoError(ts.T(), err)
+
+	delivery, err := provider.Send("15555555555", "This is the sms code: 123456", SMSProvider, "123456")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "plivo", delivery.Provider)
+	require.Equal(ts.T(), "fast_id", delivery.MessageID)
+	require.Len(ts.T(), delivery.Failures, 1)
+	require.Equal(ts.T(), "http", delivery.Failures[0].Provider)
+}
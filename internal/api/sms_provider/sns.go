@@ -0,0 +1,199 @@
+package sms_provider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+type SNSProvider struct {
+	Config  *conf.SNSProviderConfiguration
+	APIPath string
+
+	// now returns the time requests are signed at
+	now func() time.Time
+}
+
+type SNSPublishResponse struct {
+	MessageID string `xml:"PublishResult>MessageId"`
+}
+
+type SNSErrorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func init() {
+	Register("sns", func(config conf.GlobalConfiguration) (SmsProvider, error) {
+		return NewSNSProvider(config.Sms.SNS)
+	})
+}
+
+// Creates a SmsProvider with the SNS Config
+func NewSNSProvider(config conf.SNSProviderConfiguration) (SmsProvider, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	apiPath := "https://sns." + config.Region + ".amazonaws.com/"
+	if config.BaseURL != "" {
+		apiPath = strings.TrimSuffix(config.BaseURL, "/") + "/"
+	}
+
+	return &SNSProvider{
+		Config:  &config,
+		APIPath: apiPath,
+		now:     time.Now,
+	}, nil
+}
+
+func (t *SNSProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	switch channel {
+	case SMSProvider:
+		return t.SendSms(phone, message)
+	default:
+		return "", fmt.Errorf("channel type %q is not supported for SNS", channel)
+	}
+}
+
+// Send an SMS containing the OTP with the SNS Publish action
+func (t *SNSProvider) SendSms(phone string, message string) (string, error) {
+	body := url.Values{
+		"Action":      {"Publish"},
+		"Version":     {"2010-03-31"},
+		"PhoneNumber": {"+" + phone},
+		"Message":     {message},
+
+		"MessageAttributes.entry.1.Name":              {"AWS.SNS.SMS.SMSType"},
+		"MessageAttributes.entry.1.Value.DataType":    {"String"},
+		"MessageAttributes.entry.1.Value.StringValue": {t.Config.SMSType},
+	}
+	if t.Config.SenderID != "" {
+		body.Set("MessageAttributes.entry.2.Name", "AWS.SNS.SMS.SenderID")
+		body.Set("MessageAttributes.entry.2.Value.DataType", "String")
+		body.Set("MessageAttributes.entry.2.Value.StringValue", t.Config.SenderID)
+	}
+	payload := body.Encode()
+
+	client := &http.Client{Timeout: defaultTimeout}
+	r, err := http.NewRequest("POST", t.APIPath, strings.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSRequest(r, []byte(payload), "sns", t.region(), t.Config.AccessKeyID, t.Config.SecretAccessKey, t.Config.SessionToken, t.now())
+
+	res, err := client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer utilities.SafeClose(res.Body)
+
+	if res.StatusCode/100 != 2 {
+		resp := &SNSErrorResponse{}
+		if err := xml.NewDecoder(res.Body).Decode(resp); err != nil {
+			return "", fmt.Errorf("sns error: unable to decode response with status %v: %w", res.StatusCode, err)
+		}
+		return "", fmt.Errorf("sns error: %v (code: %v)", resp.Message, resp.Code)
+	}
+
+	resp := &SNSPublishResponse{}
+	if err := xml.NewDecoder(res.Body).Decode(resp); err != nil {
+		return "", err
+	}
+
+	return resp.MessageID, nil
+}
+
+// region returns the region requests are signed for. It can only be left
+// out when a base URL is configured, as with a local fake server.
+func (t *SNSProvider) region() string {
+	if t.Config.Region == "" {
+		return "us-east-1"
+	}
+	return t.Config.Region
+}
+
+// signAWSRequest signs r with AWS Signature Version 4. The Content-Type
+// header must already be set, and body is the request body.
+func signAWSRequest(r *http.Request, body []byte, service, region, accessKeyID, secretAccessKey, sessionToken string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	r.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{
+		"content-type": r.Header.Get("Content-Type"),
+		"host":         r.URL.Host,
+		"x-amz-date":   amzDate,
+	}
+	if sessionToken != "" {
+		headers["x-amz-security-token"] = sessionToken
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		path,
+		strings.ReplaceAll(r.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
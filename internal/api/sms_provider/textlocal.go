@@ -36,6 +36,12 @@ type TextlocalMessage struct {
 	MessageID string `json:"id"`
 }
 
+func init() {
+	Register("textlocal", func(config conf.GlobalConfiguration) (SmsProvider, error) {
+		return NewTextlocalProvider(config.Sms.Textlocal)
+	})
+}
+
 // Creates a SmsProvider with the Textlocal Config
 func NewTextlocalProvider(config conf.TextlocalProviderConfiguration) (SmsProvider, error) {
 	if err := config.Validate(); err != nil {
@@ -50,6 +50,12 @@ func (t twilioErrResponse) Error() string {
 	return fmt.Sprintf("%s More information: %s", t.Message, t.MoreInfo)
 }
 
+func init() {
+	Register("twilio", func(config conf.GlobalConfiguration) (SmsProvider, error) {
+		return NewTwilioProvider(config.Sms.Twilio)
+	})
+}
+
 // Creates a SmsProvider with the Twilio Config
 func NewTwilioProvider(config conf.TwilioProviderConfiguration) (SmsProvider, error) {
 	if err := config.Validate(); err != nil {
@@ -40,6 +40,12 @@ type VerificationCheckResponse struct {
 	ErrorMessage string `json:"error_message"`
 }
 
+func init() {
+	Register("twilio_verify", func(config conf.GlobalConfiguration) (SmsProvider, error) {
+		return NewTwilioVerifyProvider(config.Sms.TwilioVerify)
+	})
+}
+
 // Creates a SmsProvider with the Twilio Config
 func NewTwilioVerifyProvider(config conf.TwilioVerifyProviderConfiguration) (SmsProvider, error) {
 	if err := config.Validate(); err != nil {
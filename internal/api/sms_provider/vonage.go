@@ -32,6 +32,12 @@ type VonageResponse struct {
 	Messages []VonageResponseMessage `json:"messages"`
 }
 
+func init() {
+	Register("vonage", func(config conf.GlobalConfiguration) (SmsProvider, error) {
+		return NewVonageProvider(config.Sms.Vonage)
+	})
+}
+
 // Creates a SmsProvider with the Vonage Config
 func NewVonageProvider(config conf.VonageProviderConfiguration) (SmsProvider, error) {
 	if err := config.Validate(); err != nil {
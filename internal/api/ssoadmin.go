@@ -258,7 +258,11 @@ func (a *API) adminSSOProvidersCreate(w http.ResponseWriter, r *http.Request) er
 			return terr
 		}
 
-		return tx.Eager().Load(provider)
+		if terr := tx.Eager().Load(provider); terr != nil {
+			return terr
+		}
+
+		return auditSSOProviderAction(r, tx, provider, models.SSOProviderCreatedAction)
 	}); err != nil {
 		return err
 	}
@@ -392,7 +396,11 @@ func (a *API) adminSSOProvidersUpdate(w http.ResponseWriter, r *http.Request) er
 				}
 			}
 
-			return tx.Eager().Load(provider)
+			if terr := tx.Eager().Load(provider); terr != nil {
+				return terr
+			}
+
+			return auditSSOProviderAction(r, tx, provider, models.SSOProviderUpdatedAction)
 		}); err != nil {
 			return unprocessableEntityError(ErrorCodeConflict, "Updating SSO provider failed, likely due to a conflict. Try again?").WithInternalError(err)
 		}
@@ -409,6 +417,10 @@ func (a *API) adminSSOProvidersDelete(w http.ResponseWriter, r *http.Request) er
 	provider := getSSOProvider(ctx)
 
 	if err := db.Transaction(func(tx *storage.Connection) error {
+		if terr := auditSSOProviderAction(r, tx, provider, models.SSOProviderDeletedAction); terr != nil {
+			return terr
+		}
+
 		return tx.Eager().Destroy(provider)
 	}); err != nil {
 		return err
@@ -416,3 +428,21 @@ func (a *API) adminSSOProvidersDelete(w http.ResponseWriter, r *http.Request) er
 
 	return sendJSON(w, http.StatusOK, provider)
 }
+
+// auditSSOProviderAction records an administrative change to an SSO provider.
+func auditSSOProviderAction(r *http.Request, tx *storage.Connection, provider *models.SSOProvider, action models.AuditAction) error {
+	domains := make([]string, 0, len(provider.SSODomains))
+	for _, domain := range provider.SSODomains {
+		domains = append(domains, domain.Domain)
+	}
+
+	if err := models.NewAuditLogEntry(r, tx, getAdminUser(r.Context()), action, "", map[string]interface{}{
+		"sso_provider_id": provider.ID,
+		"entity_id":       provider.SAMLProvider.EntityID,
+		"domains":         domains,
+	}); err != nil {
+		return internalServerError("Error recording audit log entry").WithInternalError(err)
+	}
+
+	return nil
+}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/hooks"
+	mail "github.com/supabase/auth/internal/mailer"
 	"github.com/supabase/auth/internal/metering"
 	"github.com/supabase/auth/internal/models"
 	"github.com/supabase/auth/internal/observability"
@@ -25,15 +26,19 @@ import (
 // AccessTokenClaims is a struct thats used for JWT claims
 type AccessTokenClaims struct {
 	jwt.RegisteredClaims
-	Email                         string                 `json:"email"`
-	Phone                         string                 `json:"phone"`
-	AppMetaData                   map[string]interface{} `json:"app_metadata"`
-	UserMetaData                  map[string]interface{} `json:"user_metadata"`
-	Role                          string                 `json:"role"`
-	AuthenticatorAssuranceLevel   string                 `json:"aal,omitempty"`
-	AuthenticationMethodReference []models.AMREntry      `json:"amr,omitempty"`
-	SessionId                     string                 `json:"session_id,omitempty"`
-	IsAnonymous                   bool                   `json:"is_anonymous"`
+	Email                         string                   `json:"email"`
+	Phone                         string                   `json:"phone"`
+	AppMetaData                   map[string]interface{}   `json:"app_metadata"`
+	UserMetaData                  map[string]interface{}   `json:"user_metadata"`
+	Role                          string                   `json:"role"`
+	AuthenticatorAssuranceLevel   string                   `json:"aal,omitempty"`
+	AuthenticationMethodReference []models.AMREntry        `json:"amr,omitempty"`
+	SessionId                     string                   `json:"session_id,omitempty"`
+	IsAnonymous                   bool                     `json:"is_anonymous"`
+	Actor                         *hooks.ActorClaim        `json:"act,omitempty"`
+	Scope                         string                   `json:"scope,omitempty"`
+	ClientID                      string                   `json:"client_id,omitempty"`
+	Confirmation                  *hooks.ConfirmationClaim `json:"cnf,omitempty"`
 }
 
 // AccessTokenResponse represents an OAuth2 success response
@@ -81,15 +86,31 @@ const InvalidLoginMessage = "Invalid login credentials"
 func (a *API) Token(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	grantType := r.FormValue("grant_type")
+	ac := models.GetAuditContext(ctx)
 	switch grantType {
 	case "password":
+		ac.AuthMethod = models.PasswordGrant.String()
 		return a.ResourceOwnerPasswordGrant(ctx, w, r)
 	case "refresh_token":
+		ac.AuthMethod = models.TokenRefresh.String()
 		return a.RefreshTokenGrant(ctx, w, r)
 	case "id_token":
+		ac.AuthMethod = models.OAuth.String()
 		return a.IdTokenGrant(ctx, w, r)
 	case "pkce":
 		return a.PKCE(ctx, w, r)
+	case tokenExchangeGrantType:
+		ac.AuthMethod = models.TokenExchange.String()
+		return a.TokenExchangeGrant(ctx, w, r)
+	case clientCredentialsGrantType:
+		ac.AuthMethod = models.ClientCredentials.String()
+		return a.ClientCredentialsGrant(ctx, w, r)
+	case deviceCodeGrantType:
+		ac.AuthMethod = models.DeviceAuthorization.String()
+		return a.DeviceCodeGrant(ctx, w, r)
+	case apiKeyGrantType:
+		ac.AuthMethod = models.APIKeyAuth.String()
+		return a.APIKeyGrant(ctx, w, r)
 	default:
 		return oauthError("unsupported_grant_type", "")
 	}
@@ -136,12 +157,24 @@ func (a *API) ResourceOwnerPasswordGrant(ctx context.Context, w http.ResponseWri
 
 	if err != nil {
 		if models.IsNotFoundError(err) {
+			a.auditFailure(r, &models.AuditEvent{
+				Action:        models.LoginAction,
+				ActorUsername: params.Email + params.Phone,
+				FailureReason: "user_not_found",
+				Traits:        map[string]interface{}{"provider": provider},
+			})
 			return oauthError("invalid_grant", InvalidLoginMessage)
 		}
 		return internalServerError("Database error querying schema").WithInternalError(err)
 	}
 
 	if user.IsBanned() {
+		a.auditFailure(r, &models.AuditEvent{
+			Action:        models.LoginAction,
+			Actor:         user,
+			FailureReason: "user_banned",
+			Traits:        map[string]interface{}{"provider": provider},
+		})
 		return oauthError("invalid_grant", InvalidLoginMessage)
 	}
 
@@ -195,10 +228,22 @@ func (a *API) ResourceOwnerPasswordGrant(ctx context.Context, w http.ResponseWri
 					return err
 				}
 			}
+			a.auditFailure(r, &models.AuditEvent{
+				Action:        models.LoginAction,
+				Actor:         user,
+				FailureReason: "rejected_by_hook",
+				Traits:        map[string]interface{}{"provider": provider},
+			})
 			return oauthError("invalid_grant", InvalidLoginMessage)
 		}
 	}
 	if !isValidPassword {
+		a.auditFailure(r, &models.AuditEvent{
+			Action:        models.LoginAction,
+			Actor:         user,
+			FailureReason: "invalid_credentials",
+			Traits:        map[string]interface{}{"provider": provider},
+		})
 		return oauthError("invalid_grant", InvalidLoginMessage)
 	}
 
@@ -211,15 +256,15 @@ func (a *API) ResourceOwnerPasswordGrant(ctx context.Context, w http.ResponseWri
 	var token *AccessTokenResponse
 	err = db.Transaction(func(tx *storage.Connection) error {
 		var terr error
+		token, terr = a.issueRefreshToken(r, tx, user, models.PasswordGrant, grantParams)
+		if terr != nil {
+			return terr
+		}
 		if terr = models.NewAuditLogEntry(r, tx, user, models.LoginAction, "", map[string]interface{}{
 			"provider": provider,
 		}); terr != nil {
 			return terr
 		}
-		token, terr = a.issueRefreshToken(r, tx, user, models.PasswordGrant, grantParams)
-		if terr != nil {
-			return terr
-		}
 
 		if terr = a.setCookieTokens(config, token, false, w); terr != nil {
 			return internalServerError("Failed to set JWT cookie. %s", terr)
@@ -281,15 +326,15 @@ func (a *API) PKCE(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			return err
 		}
+		token, terr = a.issueRefreshToken(r, tx, user, authMethod, grantParams)
+		if terr != nil {
+			return oauthError("server_error", terr.Error())
+		}
 		if terr := models.NewAuditLogEntry(r, tx, user, models.LoginAction, "", map[string]interface{}{
 			"provider_type": flowState.ProviderType,
 		}); terr != nil {
 			return terr
 		}
-		token, terr = a.issueRefreshToken(r, tx, user, authMethod, grantParams)
-		if terr != nil {
-			return oauthError("server_error", terr.Error())
-		}
 		token.ProviderAccessToken = flowState.ProviderAccessToken
 		// Because not all providers give out a refresh token
 		// See corresponding OAuth2 spec: <https://www.rfc-editor.org/rfc/rfc6749.html#section-5.1>
@@ -309,6 +354,38 @@ func (a *API) PKCE(ctx context.Context, w http.ResponseWriter, r *http.Request)
 }
 
 func (a *API) generateAccessToken(r *http.Request, tx *storage.Connection, user *models.User, sessionId *uuid.UUID, authenticationMethod models.AuthenticationMethod) (string, int64, error) {
+	return a.generateRestrictedAccessToken(r, tx, user, sessionId, authenticationMethod, nil)
+}
+
+// accessTokenRestrictions narrow down an access token issued with
+// generateRestrictedAccessToken.
+type accessTokenRestrictions struct {
+	Audience []string
+	Scope    string
+	Actor    *hooks.ActorClaim
+
+	// ExpiresAt caps the expiry of the token.
+	ExpiresAt time.Time
+}
+
+// restrictedAccessTokenClaims are the claims of an access token issued with
+// restrictions. The user's email, phone and metadata are left out.
+type restrictedAccessTokenClaims struct {
+	jwt.RegisteredClaims
+	Role                          string                   `json:"role"`
+	AuthenticatorAssuranceLevel   string                   `json:"aal,omitempty"`
+	AuthenticationMethodReference []models.AMREntry        `json:"amr,omitempty"`
+	SessionId                     string                   `json:"session_id,omitempty"`
+	IsAnonymous                   bool                     `json:"is_anonymous"`
+	Scope                         string                   `json:"scope,omitempty"`
+	Actor                         *hooks.ActorClaim        `json:"act,omitempty"`
+	Confirmation                  *hooks.ConfirmationClaim `json:"cnf,omitempty"`
+}
+
+// generateRestrictedAccessToken issues an access token for the session. When
+// restrictions are given, the token only carries restricted claims and the
+// custom access token hook is not invoked, as it could widen them.
+func (a *API) generateRestrictedAccessToken(r *http.Request, tx *storage.Connection, user *models.User, sessionId *uuid.UUID, authenticationMethod models.AuthenticationMethod, restrictions *accessTokenRestrictions) (string, int64, error) {
 	config := a.config
 	if sessionId == nil {
 		return "", 0, internalServerError("Session is required to issue access token")
@@ -326,6 +403,27 @@ func (a *API) generateAccessToken(r *http.Request, tx *storage.Connection, user
 	issuedAt := time.Now().UTC()
 	expiresAt := issuedAt.Add(time.Second * time.Duration(config.JWT.Exp))
 
+	var actor *hooks.ActorClaim
+	if session.IsImpersonated() {
+		actor = &hooks.ActorClaim{Subject: *session.Impersonator}
+	}
+
+	// sessions can't be refreshed past not_after, such as impersonated or
+	// timeboxed sessions, so their access tokens must not outlive them
+	if session.NotAfter != nil && expiresAt.After(*session.NotAfter) {
+		expiresAt = session.NotAfter.UTC()
+	}
+
+	if restrictions != nil && expiresAt.After(restrictions.ExpiresAt) {
+		expiresAt = restrictions.ExpiresAt.UTC()
+	}
+
+	// exchanged tokens are told apart from the session's own tokens, so
+	// that they can be kept from managing the account
+	if authenticationMethod == models.TokenExchange {
+		amr = append(amr, models.AMREntry{Method: models.TokenExchange.String(), Timestamp: issuedAt.Unix()})
+	}
+
 	claims := &hooks.AccessTokenClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   user.ID.String(),
@@ -343,10 +441,33 @@ func (a *API) generateAccessToken(r *http.Request, tx *storage.Connection, user
 		AuthenticatorAssuranceLevel:   aal.String(),
 		AuthenticationMethodReference: amr,
 		IsAnonymous:                   user.IsAnonymous,
+		Actor:                         actor,
+		Confirmation:                  dpopConfirmation(session.DPoPJKT),
 	}
 
 	var token *jwt.Token
-	if config.Hook.CustomAccessToken.Enabled {
+	if restrictions != nil {
+		restricted := &restrictedAccessTokenClaims{
+			RegisteredClaims:              claims.RegisteredClaims,
+			Role:                          claims.Role,
+			AuthenticatorAssuranceLevel:   claims.AuthenticatorAssuranceLevel,
+			AuthenticationMethodReference: claims.AuthenticationMethodReference,
+			SessionId:                     claims.SessionId,
+			IsAnonymous:                   claims.IsAnonymous,
+			Scope:                         restrictions.Scope,
+			Actor:                         restrictions.Actor,
+			Confirmation:                  claims.Confirmation,
+		}
+		if len(restrictions.Audience) > 0 {
+			restricted.Audience = restrictions.Audience
+		}
+		if actor != nil {
+			// an impersonation can't be exchanged away
+			restricted.Actor = actor
+		}
+
+		token = jwt.NewWithClaims(jwt.SigningMethodHS256, restricted)
+	} else if config.Hook.CustomAccessToken.Enabled {
 		input := hooks.CustomAccessTokenInput{
 			UserID:               user.ID,
 			Claims:               claims,
@@ -360,6 +481,15 @@ func (a *API) generateAccessToken(r *http.Request, tx *storage.Connection, user
 			return "", 0, err
 		}
 		goTrueClaims := jwt.MapClaims(output.Claims)
+		if actor != nil {
+			// the hook can't hide or extend an impersonation
+			goTrueClaims["act"] = actor
+			goTrueClaims["exp"] = expiresAt.Unix()
+		}
+		if claims.Confirmation != nil {
+			// the hook can't unbind the token from the DPoP key
+			goTrueClaims["cnf"] = claims.Confirmation
+		}
 
 		token = jwt.NewWithClaims(jwt.SigningMethodHS256, goTrueClaims)
 
@@ -389,9 +519,32 @@ func (a *API) issueRefreshToken(r *http.Request, conn *storage.Connection, user
 	now := time.Now()
 	user.LastSignInAt = &now
 
+	// the timebox of a matching session policy is fixed when the session is
+	// issued, so that it still applies if the user stops matching it
+	if policy := models.DetermineSessionPolicy(&config.Sessions, user, grantParams.SessionTag); policy.Matched && policy.Timebox != nil {
+		notAfter := now.Add(*policy.Timebox)
+		if grantParams.SessionNotAfter == nil || notAfter.Before(*grantParams.SessionNotAfter) {
+			grantParams.SessionNotAfter = &notAfter
+		}
+	}
+
+	// clients that send a DPoP proof get a session bound to the proof's key
+	if config.DPoP.Enabled {
+		jkt, err := a.verifyDPoPProof(r, conn, "")
+		if httpErr, ok := err.(*HTTPError); ok {
+			return nil, httpErr
+		} else if err != nil {
+			return nil, oauthError("invalid_dpop_proof", err.Error())
+		}
+		if jkt != "" {
+			grantParams.SessionDPoPJKT = &jkt
+		}
+	}
+
 	var tokenString string
 	var expiresAt int64
 	var refreshToken *models.RefreshToken
+	var deletionCancelled bool
 
 	err := conn.Transaction(func(tx *storage.Connection) error {
 		var terr error
@@ -415,15 +568,32 @@ func (a *API) issueRefreshToken(r *http.Request, conn *storage.Connection, user
 			}
 			return internalServerError("error generating jwt token").WithInternalError(terr)
 		}
+
+		// signing in during the grace period keeps the account
+		if user.DeletionScheduledAt != nil {
+			deletionCancelled = true
+			return a.cancelAccountDeletion(r, tx, user)
+		}
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	if deletionCancelled {
+		a.notifyAccountDeletion(r, user, mail.AccountDeletionCancelled)
+	}
+
+	// audit log entries written after this point belong to the new session
+	ac := models.GetAuditContext(r.Context())
+	ac.SessionID = refreshToken.SessionId
+	if ac.AuthMethod == "" {
+		ac.AuthMethod = authenticationMethod.String()
+	}
+
 	return &AccessTokenResponse{
 		Token:        tokenString,
-		TokenType:    "bearer",
+		TokenType:    tokenType(grantParams.SessionDPoPJKT),
 		ExpiresIn:    config.JWT.Exp,
 		ExpiresAt:    expiresAt,
 		RefreshToken: refreshToken.Token,
@@ -437,6 +607,7 @@ func (a *API) updateMFASessionAndClaims(r *http.Request, tx *storage.Connection,
 	var tokenString string
 	var expiresAt int64
 	var refreshToken *models.RefreshToken
+	var dpopJKT *string
 	currentClaims := getClaims(ctx)
 	sessionId, err := uuid.FromString(currentClaims.SessionId)
 	if err != nil {
@@ -451,6 +622,7 @@ func (a *API) updateMFASessionAndClaims(r *http.Request, tx *storage.Connection,
 		if terr != nil {
 			return terr
 		}
+		dpopJKT = session.DPoPJKT
 		currentToken, terr := models.FindTokenBySessionID(tx, &session.ID)
 		if terr != nil {
 			return terr
@@ -487,7 +659,7 @@ func (a *API) updateMFASessionAndClaims(r *http.Request, tx *storage.Connection,
 	}
 	return &AccessTokenResponse{
 		Token:        tokenString,
-		TokenType:    "bearer",
+		TokenType:    tokenType(dpopJKT),
 		ExpiresIn:    config.JWT.Exp,
 		ExpiresAt:    expiresAt,
 		RefreshToken: refreshToken.Token,
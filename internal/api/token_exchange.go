@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/supabase/auth/internal/api/provider"
+	"github.com/supabase/auth/internal/hooks"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeIDToken     = "urn:ietf:params:oauth:token-type:id_token"
+)
+
+// TokenExchangeGrantParams are the parameters the TokenExchangeGrant method
+// accepts. Provider is an extension to RFC 8693 naming the identity provider
+// that issued an external ID token.
+type TokenExchangeGrantParams struct {
+	SubjectToken       string `json:"subject_token"`
+	SubjectTokenType   string `json:"subject_token_type"`
+	RequestedTokenType string `json:"requested_token_type"`
+	Audience           string `json:"audience"`
+	Scope              string `json:"scope"`
+	Provider           string `json:"provider"`
+}
+
+// TokenExchangeResponse is the successful response of the token exchange
+// grant, as defined in RFC 8693 section 2.2.1.
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	ExpiresAt       int64  `json:"expires_at"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+// TokenExchangeGrant implements the OAuth 2.0 Token Exchange grant (RFC
+// 8693). It can only be used by trusted backends, which authenticate with an
+// admin token in the Authorization header. The subject token is either an
+// access token issued by this server or an ID token of a trusted external
+// provider, and is exchanged for a short-lived access token with restricted
+// claims whose act claim names the backend.
+func (a *API) TokenExchangeGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	config := a.config
+
+	if !config.TokenExchange.Enabled {
+		return oauthError("unsupported_grant_type", "")
+	}
+
+	bearer, err := a.extractBearerToken(r)
+	if err != nil {
+		return err
+	}
+	callerCtx, err := a.parseJWTClaims(bearer, r)
+	if err != nil {
+		return err
+	}
+	if callerCtx, err = a.requireAdmin(callerCtx); err != nil {
+		return err
+	}
+	if !hasAdminScope(callerCtx, adminScopeTokensExchange) {
+		return forbiddenError(ErrorCodeInsufficientScope, "This token doesn't have the %s scope", adminScopeTokensExchange)
+	}
+	callerClaims := getClaims(callerCtx)
+	adminUser := getAdminUser(callerCtx)
+
+	// tokens signed with the service role key don't have a subject
+	actor := &hooks.ActorClaim{Subject: callerClaims.Subject}
+	if actor.Subject == "" {
+		actor.Subject = callerClaims.Role
+	}
+
+	params := &TokenExchangeGrantParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.SubjectToken == "" || params.SubjectTokenType == "" {
+		return oauthError("invalid_request", "subject_token and subject_token_type required")
+	}
+
+	if params.RequestedTokenType != "" && params.RequestedTokenType != tokenTypeAccessToken {
+		return oauthError("invalid_request", fmt.Sprintf("Unsupported requested_token_type %q", params.RequestedTokenType))
+	}
+
+	var audience []string
+	if params.Audience != "" {
+		if params.Audience != config.JWT.Aud && !isStringInSlice(params.Audience, config.TokenExchange.AllowedAudiences) {
+			return oauthError("invalid_target", fmt.Sprintf("Audience %q is not allowed", params.Audience))
+		}
+		audience = []string{params.Audience}
+	}
+
+	scopes := strings.Fields(params.Scope)
+	for _, scope := range scopes {
+		if !isStringInSlice(scope, config.TokenExchange.AllowedScopes) {
+			return oauthError("invalid_scope", fmt.Sprintf("Scope %q is not allowed", scope))
+		}
+	}
+
+	restrictions := &accessTokenRestrictions{
+		Audience:  audience,
+		Scope:     strings.Join(scopes, " "),
+		Actor:     actor,
+		ExpiresAt: time.Now().Add(time.Duration(config.TokenExchange.Exp) * time.Second),
+	}
+
+	var user *models.User
+	var session *models.Session
+	var providerType string
+
+	switch params.SubjectTokenType {
+	case tokenTypeAccessToken:
+		user, session, err = a.loadTokenExchangeAccessToken(r, params, restrictions)
+	case tokenTypeIDToken:
+		user, providerType, err = a.loadTokenExchangeIDToken(ctx, r, params, restrictions)
+	default:
+		return oauthError("invalid_request", fmt.Sprintf("Unsupported subject_token_type %q", params.SubjectTokenType))
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.IsBanned() {
+		return oauthError("invalid_grant", "User is banned")
+	}
+
+	var response *TokenExchangeResponse
+	err = a.db.WithContext(ctx).Transaction(func(tx *storage.Connection) error {
+		if session == nil {
+			// external ID tokens are exchanged into a session of their
+			// own, which can't be refreshed
+			var terr error
+			session, terr = models.NewSession(user.ID, nil)
+			if terr != nil {
+				return internalServerError("Failed to create session").WithInternalError(terr)
+			}
+			session.NotAfter = &restrictions.ExpiresAt
+			if terr := tx.Create(session); terr != nil {
+				return internalServerError("Database error creating session").WithInternalError(terr)
+			}
+			if terr := models.AddClaimToSession(tx, session.ID, models.OAuth); terr != nil {
+				return internalServerError("Database error updating session").WithInternalError(terr)
+			}
+		}
+
+		ac := models.GetAuditContext(ctx)
+		ac.SessionID = &session.ID
+		ac.AuthMethod = models.TokenExchange.String()
+
+		if terr := models.NewAuditLogEntry(r, tx, adminUser, models.TokenExchangedAction, "", map[string]interface{}{
+			"user_id":            user.ID,
+			"subject_token_type": params.SubjectTokenType,
+			"provider":           providerType,
+			"audience":           restrictions.Audience,
+			"scope":              restrictions.Scope,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		tokenString, expiresAt, terr := a.generateRestrictedAccessToken(r, tx, user, &session.ID, models.TokenExchange, restrictions)
+		if terr != nil {
+			if httpErr, ok := terr.(*HTTPError); ok {
+				return httpErr
+			}
+			return internalServerError("error generating jwt token").WithInternalError(terr)
+		}
+
+		response = &TokenExchangeResponse{
+			AccessToken:     tokenString,
+			IssuedTokenType: tokenTypeAccessToken,
+			TokenType:       "bearer",
+			ExpiresIn:       int(time.Until(time.Unix(expiresAt, 0)).Seconds()),
+			ExpiresAt:       expiresAt,
+			Scope:           restrictions.Scope,
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, response)
+}
+
+// loadTokenExchangeAccessToken verifies an access token issued by this
+// server and loads its user and session. The exchanged token never outlives
+// the subject token.
+func (a *API) loadTokenExchangeAccessToken(r *http.Request, params *TokenExchangeGrantParams, restrictions *accessTokenRestrictions) (*models.User, *models.Session, error) {
+	ctx, err := a.parseJWTClaims(params.SubjectToken, r)
+	if err != nil {
+		return nil, nil, oauthError("invalid_grant", "Invalid subject_token").WithInternalError(err)
+	}
+
+	claims := getClaims(ctx)
+	if claims.Actor != nil {
+		return nil, nil, oauthError("invalid_grant", "subject_token is already acting on behalf of the user")
+	}
+	if claims.SessionId == "" {
+		return nil, nil, oauthError("invalid_grant", "subject_token has no session")
+	}
+
+	ctx, err = a.maybeLoadUserOrSession(ctx)
+	if err != nil {
+		return nil, nil, oauthError("invalid_grant", "Invalid subject_token").WithInternalError(err)
+	}
+
+	if claims.ExpiresAt != nil && restrictions.ExpiresAt.After(claims.ExpiresAt.Time) {
+		restrictions.ExpiresAt = claims.ExpiresAt.Time
+	}
+
+	return getUser(ctx), getSession(ctx), nil
+}
+
+// loadTokenExchangeIDToken verifies an ID token of a trusted external
+// provider and loads the user the identity belongs to. Users are not created
+// by a token exchange.
+func (a *API) loadTokenExchangeIDToken(ctx context.Context, r *http.Request, params *TokenExchangeGrantParams, restrictions *accessTokenRestrictions) (*models.User, string, error) {
+	config := a.config
+	db := a.db.WithContext(ctx)
+
+	if params.Provider == "" {
+		return nil, "", oauthError("invalid_request", "provider required for ID tokens")
+	}
+
+	if !isStringInSlice(params.Provider, config.TokenExchange.TrustedProviders) {
+		return nil, "", oauthError("invalid_grant", fmt.Sprintf("Provider %q is not trusted for token exchange", params.Provider))
+	}
+
+	idTokenParams := &IdTokenGrantParams{
+		IdToken:  params.SubjectToken,
+		Provider: params.Provider,
+	}
+	oidcProvider, _, providerType, acceptableClientIDs, err := idTokenParams.getProvider(ctx, config, r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	idToken, _, err := provider.ParseIDToken(ctx, oidcProvider, nil, params.SubjectToken, provider.ParseIDTokenOptions{
+		SkipAccessTokenCheck: true,
+	})
+	if err != nil {
+		return nil, "", oauthError("invalid_grant", "Invalid subject_token").WithInternalError(err)
+	}
+
+	correctAudience := false
+	for _, aud := range idToken.Audience {
+		if aud != "" && isStringInSlice(aud, acceptableClientIDs) {
+			correctAudience = true
+			break
+		}
+	}
+	if !correctAudience {
+		return nil, "", oauthError("invalid_grant", fmt.Sprintf("Unacceptable audience in subject_token: %v", idToken.Audience))
+	}
+
+	identity, err := models.FindIdentityByIdAndProvider(db, idToken.Subject, providerType)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, "", oauthError("invalid_grant", "No user is linked to the subject_token")
+		}
+		return nil, "", internalServerError("Database error finding identity").WithInternalError(err)
+	}
+
+	user, err := models.FindUserByID(db, identity.UserID)
+	if err != nil {
+		return nil, "", internalServerError("Database error finding user").WithInternalError(err)
+	}
+
+	if restrictions.ExpiresAt.After(idToken.Expiry) {
+		restrictions.ExpiresAt = idToken.Expiry
+	}
+
+	return user, providerType, nil
+}
@@ -6,14 +6,21 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/supabase/auth/internal/hooks"
+	mail "github.com/supabase/auth/internal/mailer"
 	"github.com/supabase/auth/internal/metering"
 	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
 	"github.com/supabase/auth/internal/utilities"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 const retryLoopDuration = 5.0
 
+var refreshTokenReuseCounter = observability.ObtainMetricCounter("gotrue_refresh_token_reuse_detected", "Number of times a revoked refresh token was reused outside the reuse interval")
+
 // RefreshTokenGrantParams are the parameters the RefreshTokenGrant method accepts
 type RefreshTokenGrantParams struct {
 	RefreshToken string `json:"refresh_token"`
@@ -33,6 +40,13 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 		return oauthError("invalid_request", "refresh_token required")
 	}
 
+	// the proof is checked once, as its jti can't be used again when
+	// retrying, and only matters if the session is bound to a DPoP key
+	dpopJKT, dpopErr := a.verifyDPoPProof(r, db, "")
+	if httpErr, ok := dpopErr.(*HTTPError); ok {
+		return httpErr
+	}
+
 	// A 5 second retry loop is used to make sure that refresh token
 	// requests do not waste database connections waiting for each other.
 	// Instead of waiting at the database level, they're waiting at the API
@@ -57,7 +71,7 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 		}
 
 		if session != nil {
-			result := session.CheckValidity(retryStart, &token.UpdatedAt, config.Sessions.Timebox, config.Sessions.InactivityTimeout)
+			result := session.CheckValidity(retryStart, &token.UpdatedAt, models.DetermineSessionPolicy(&config.Sessions, user, session.Tag))
 
 			switch result {
 			case models.SessionValid:
@@ -69,6 +83,15 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 			default:
 				return oauthError("invalid_grant", "Invalid Refresh Token: Session Expired")
 			}
+
+			if session.DPoPJKT != nil {
+				if dpopErr != nil {
+					return oauthError("invalid_dpop_proof", dpopErr.Error())
+				}
+				if dpopJKT != *session.DPoPJKT {
+					return oauthError("invalid_dpop_proof", "Refresh token is bound to a DPoP key and must be sent with a proof signed with it")
+				}
+			}
 		}
 
 		// Basic checks above passed, now we need to serialize access
@@ -81,6 +104,7 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 		var tokenString string
 		var expiresAt int64
 		var newTokenResponse *AccessTokenResponse
+		var reuse *hooks.RefreshTokenReuseInput
 
 		err = db.Transaction(func(tx *storage.Connection) error {
 			user, token, session, terr := models.FindUserWithRefreshToken(tx, params.RefreshToken, true /* forUpdate */)
@@ -100,7 +124,7 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 				return internalServerError(terr.Error())
 			}
 
-			if a.config.Sessions.SinglePerUser {
+			if session != nil && models.DetermineSessionPolicy(&config.Sessions, user, session.Tag).SinglePerUser {
 				sessions, terr := models.FindAllSessionsForUser(tx, user.ID, true /* forUpdate */)
 				if models.IsNotFoundError(terr) {
 					// because forUpdate was set, and the
@@ -127,7 +151,14 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 						continue
 					}
 
-					if s.CheckValidity(retryStart, nil, config.Sessions.Timebox, config.Sessions.InactivityTimeout) != models.SessionValid {
+					if s.IsImpersonated() {
+						// sessions created by an admin
+						// don't count as logins by the
+						// user
+						continue
+					}
+
+					if s.CheckValidity(retryStart, nil, models.DetermineSessionPolicy(&config.Sessions, user, s.Tag)) != models.SessionValid {
 						// session is not valid so it
 						// can't be regarded as active
 						// on the user
@@ -165,7 +196,7 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 					return internalServerError(terr.Error())
 				}
 
-				if activeRefreshToken != nil && activeRefreshToken.Parent.String() == token.Token {
+				if activeRefreshToken != nil && activeRefreshToken.Parent.String() == token.TokenHash {
 					// Token was revoked, but it's the
 					// parent of the currently active one.
 					// This indicates that the client was
@@ -174,7 +205,15 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 					// allowed, provided we return back the
 					// active refresh token instead of
 					// creating a new one.
-					issuedToken = activeRefreshToken
+					if activeRefreshToken.RecoverToken(token.Token) {
+						issuedToken = activeRefreshToken
+					} else {
+						// the active token wasn't derived
+						// from this one and only its hash
+						// is known, so it's swapped for a
+						// new one instead
+						token = activeRefreshToken
+					}
 				} else {
 					// For a revoked refresh token to be reused, it
 					// has to fall within the reuse interval.
@@ -185,6 +224,11 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 						a.clearCookieTokens(config, w)
 						// not OK to reuse this token
 
+						// the family can only be revoked if
+						// the session still had an active
+						// token, later reuses only get audited
+						familyRevoked := config.Security.RefreshTokenRotationEnabled && activeRefreshToken != nil
+
 						if config.Security.RefreshTokenRotationEnabled {
 							// Revoke all tokens in token family
 							if err := models.RevokeTokenFamily(tx, token); err != nil {
@@ -192,12 +236,50 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 							}
 						}
 
+						userAgent := r.Header.Get("User-Agent")
+
+						if terr := models.NewAuditLogEvent(r, tx, &models.AuditEvent{
+							Action:        models.TokenReusedAction,
+							Actor:         user,
+							SessionID:     &session.ID,
+							Outcome:       models.AuditOutcomeFailure,
+							FailureReason: "refresh_token_reused",
+							Traits: map[string]interface{}{
+								"refresh_token_id": token.ID,
+								"user_agent":       userAgent,
+								"family_revoked":   familyRevoked,
+							},
+						}); terr != nil {
+							return internalServerError("Error recording audit log entry").WithInternalError(terr)
+						}
+
+						refreshTokenReuseCounter.Add(
+							r.Context(),
+							1,
+							metric.WithAttributeSet(attribute.NewSet(attribute.Bool("family_revoked", familyRevoked))),
+						)
+
+						if activeRefreshToken != nil {
+							reuse = &hooks.RefreshTokenReuseInput{
+								UserID:         user.ID,
+								SessionID:      &session.ID,
+								RefreshTokenID: token.ID,
+								IPAddress:      utilities.GetIPAddress(r),
+								UserAgent:      userAgent,
+								FamilyRevoked:  familyRevoked,
+							}
+						}
+
 						return storage.NewCommitWithError(oauthError("invalid_grant", "Invalid Refresh Token: Already Used").WithInternalMessage("Possible abuse attempt: %v", token.ID))
 					}
 				}
 			}
 
-			if terr = models.NewAuditLogEntry(r, tx, user, models.TokenRefreshedAction, "", nil); terr != nil {
+			if terr = models.NewAuditLogEvent(r, tx, &models.AuditEvent{
+				Action:    models.TokenRefreshedAction,
+				Actor:     user,
+				SessionID: &session.ID,
+			}); terr != nil {
 				return terr
 			}
 
@@ -242,7 +324,7 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 
 			newTokenResponse = &AccessTokenResponse{
 				Token:        tokenString,
-				TokenType:    "bearer",
+				TokenType:    tokenType(session.DPoPJKT),
 				ExpiresIn:    config.JWT.Exp,
 				ExpiresAt:    expiresAt,
 				RefreshToken: issuedToken.Token,
@@ -262,6 +344,9 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 				time.Sleep(time.Duration(10+mathRand.Intn(20)) * time.Millisecond) // #nosec
 				continue
 			} else {
+				if reuse != nil {
+					a.notifyRefreshTokenReuse(r, user, reuse)
+				}
 				return err
 			}
 		}
@@ -271,3 +356,24 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 
 	return conflictError("Too many concurrent token refresh requests on the same session or refresh token")
 }
+
+// notifyRefreshTokenReuse runs the refresh token reuse hook and, if the
+// token family was revoked, emails the user. Failures are only logged, as
+// the reuse has already been recorded in the audit log.
+func (a *API) notifyRefreshTokenReuse(r *http.Request, user *models.User, input *hooks.RefreshTokenReuseInput) {
+	config := a.config
+	log := observability.GetLogEntry(r).Entry
+
+	if config.Hook.RefreshTokenReuse.Enabled {
+		output := hooks.RefreshTokenReuseOutput{}
+		if err := a.invokeHook(nil, r, input, &output, config.Hook.RefreshTokenReuse.URI); err != nil {
+			log.WithError(err).Warn("Refresh token reuse hook failed")
+		}
+	}
+
+	if config.Security.RefreshTokenReuseNotificationEnabled && input.FamilyRevoked && user.GetEmail() != "" {
+		if err := a.sendEmail(r, nil, user, mail.RefreshTokenReuseNotification, "", "", ""); err != nil {
+			log.WithError(err).Warn("Error sending refresh token reuse notification")
+		}
+	}
+}
@@ -14,11 +14,14 @@ import (
 	"testing"
 	"time"
 
+	jwt "github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/hooks"
 	"github.com/supabase/auth/internal/models"
+	"gopkg.in/h2non/gock.v1"
 )
 
 type TokenTestSuite struct {
@@ -133,6 +136,45 @@ func (ts *TokenTestSuite) TestSessionInactivityTimeout() {
 	assert.Equal(ts.T(), "Invalid Refresh Token: Session Expired (Inactivity)", firstResult.ErrorDescription)
 }
 
+func (ts *TokenTestSuite) TestSessionPolicy() {
+	timebox := 10 * time.Second
+	ts.API.config.Sessions.Policies = conf.SessionPolicies{
+		{AppMetadata: map[string]interface{}{"plan": "enterprise"}, Timebox: &timebox},
+	}
+	ts.API.overrideTime = func() time.Time {
+		return time.Now().Add(timebox).Add(time.Second)
+	}
+
+	defer func() {
+		ts.API.overrideTime = nil
+		ts.API.config.Sessions.Policies = nil
+	}()
+
+	refresh := func() *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"refresh_token": ts.RefreshToken.Token,
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/token?grant_type=refresh_token", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// the policy doesn't apply to other users
+	w := refresh()
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	require.NoError(ts.T(), ts.User.UpdateAppMetaData(ts.API.db, map[string]interface{}{"plan": "enterprise"}))
+
+	w = refresh()
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "Session Expired")
+}
+
 func (ts *TokenTestSuite) TestFailedToSaveRefreshTokenResultCase() {
 	var buffer bytes.Buffer
 
@@ -471,6 +513,84 @@ func (ts *TokenTestSuite) TestRefreshTokenReuseRevocation() {
 	}
 }
 
+func (ts *TokenTestSuite) TestRefreshTokenReuseDetection() {
+	originalSecurity := ts.API.config.Security
+	originalHook := ts.API.config.Hook.RefreshTokenReuse
+
+	ts.API.config.Security.RefreshTokenRotationEnabled = true
+	ts.API.config.Security.RefreshTokenReuseInterval = 0
+	ts.API.config.Hook.RefreshTokenReuse = conf.ExtensibilityPointConfiguration{
+		Enabled:         true,
+		URI:             "http://localhost:54321/functions/v1/refresh-token-reuse",
+		HTTPHookSecrets: []string{"v1,whsec_aWxpa2VzdXBhYmFzZXZlcnltdWNoYW5kaWhvcGV5b3Vkb3Rvbw=="},
+	}
+
+	defer func() {
+		ts.API.config.Security = originalSecurity
+		ts.API.config.Hook.RefreshTokenReuse = originalHook
+		gock.OffAll()
+	}()
+
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"refresh_token": refreshToken,
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/token?grant_type=refresh_token", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "stolen-client/1.0")
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := refresh(ts.RefreshToken.Token)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	var input hooks.RefreshTokenReuseInput
+	gock.New(ts.API.config.Hook.RefreshTokenReuse.URI).
+		Post("/").
+		MatchType("json").
+		AddMatcher(func(req *http.Request, _ *gock.Request) (bool, error) {
+			return true, json.NewDecoder(req.Body).Decode(&input)
+		}).
+		Reply(http.StatusOK).
+		JSON(map[string]interface{}{})
+
+	w = refresh(ts.RefreshToken.Token)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.True(ts.T(), gock.IsDone())
+
+	require.Equal(ts.T(), ts.User.ID, input.UserID)
+	require.Equal(ts.T(), ts.RefreshToken.SessionId, input.SessionID)
+	require.Equal(ts.T(), ts.RefreshToken.ID, input.RefreshTokenID)
+	require.Equal(ts.T(), "stolen-client/1.0", input.UserAgent)
+	require.True(ts.T(), input.FamilyRevoked)
+
+	logs, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{
+		Actions: []string{string(models.TokenReusedAction)},
+	}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), logs, 1)
+
+	traits := logs[0].Payload["traits"].(map[string]interface{})
+	require.Equal(ts.T(), "stolen-client/1.0", traits["user_agent"])
+	require.Equal(ts.T(), true, traits["family_revoked"])
+
+	// the family is revoked already, so reusing a token again is only
+	// audited and doesn't invoke the hook
+	w = refresh(ts.RefreshToken.Token)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	logs, err = models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{
+		Actions: []string{string(models.TokenReusedAction)},
+	}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), logs, 2)
+}
+
 func (ts *TokenTestSuite) createBannedUser() *models.User {
 	u, err := models.NewUser("", "banned@example.com", "password", ts.Config.JWT.Aud, nil)
 	require.NoError(ts.T(), err, "Error creating test user model")
@@ -855,3 +975,100 @@ $$;`
 		})
 	}
 }
+
+func (ts *TokenTestSuite) TestTokenExchangeGrant() {
+	ts.Config.TokenExchange.Enabled = true
+	ts.Config.TokenExchange.Exp = 60
+	ts.Config.TokenExchange.AllowedAudiences = []string{"billing"}
+	ts.Config.TokenExchange.AllowedScopes = []string{"invoices:read", "invoices:write"}
+	defer func() {
+		ts.Config.TokenExchange.Enabled = false
+	}()
+
+	subjectToken, _, err := ts.API.generateAccessToken(httptest.NewRequest(http.MethodPost, "/token", nil), ts.API.db, ts.User, ts.RefreshToken.SessionId, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+
+	adminToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{Role: "supabase_admin"}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+
+	exchange := func(bearer string, params map[string]interface{}) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(params))
+
+		req := httptest.NewRequest(http.MethodPost, "/token?grant_type="+url.QueryEscape(tokenExchangeGrantType), &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+bearer)
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	params := map[string]interface{}{
+		"subject_token":      subjectToken,
+		"subject_token_type": tokenTypeAccessToken,
+		"audience":           "billing",
+		"scope":              "invoices:read",
+	}
+
+	// only trusted backends can exchange tokens
+	w := exchange(subjectToken, params)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	w = exchange(adminToken, params)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	var response TokenExchangeResponse
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&response))
+	require.Equal(ts.T(), tokenTypeAccessToken, response.IssuedTokenType)
+	require.Equal(ts.T(), "invoices:read", response.Scope)
+	require.LessOrEqual(ts.T(), response.ExpiresAt, time.Now().Add(time.Minute).Unix())
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.NewParser().ParseWithClaims(response.AccessToken, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(ts.Config.JWT.Secret), nil
+	})
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), ts.User.ID.String(), claims["sub"])
+	require.Equal(ts.T(), "billing", claims["aud"])
+	require.Equal(ts.T(), "invoices:read", claims["scope"])
+	require.Equal(ts.T(), map[string]interface{}{"sub": "supabase_admin"}, claims["act"])
+	require.NotContains(ts.T(), claims, "email")
+	require.NotContains(ts.T(), claims, "user_metadata")
+
+	// exchanged tokens can't manage the account
+	for _, c := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPut, "/user"},
+		{http.MethodPost, "/factors"},
+		{http.MethodGet, "/user/export"},
+	} {
+		req := httptest.NewRequest(c.method, c.path, bytes.NewBufferString("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+response.AccessToken)
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusForbidden, w.Code, c.path)
+		require.Contains(ts.T(), w.Body.String(), string(ErrorCodeInsufficientScope), c.path)
+	}
+
+	// exchanged tokens can't be exchanged again
+	w = exchange(adminToken, map[string]interface{}{
+		"subject_token":      response.AccessToken,
+		"subject_token_type": tokenTypeAccessToken,
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	for _, invalid := range []map[string]interface{}{
+		{"subject_token": subjectToken, "subject_token_type": tokenTypeAccessToken, "audience": "elsewhere"},
+		{"subject_token": subjectToken, "subject_token_type": tokenTypeAccessToken, "scope": "admin"},
+		{"subject_token": subjectToken, "subject_token_type": "urn:ietf:params:oauth:token-type:saml2"},
+		{"subject_token": subjectToken},
+	} {
+		w = exchange(adminToken, invalid)
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code, invalid)
+	}
+}
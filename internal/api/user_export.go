@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// UserExport returns everything stored about the user, to answer a subject
+// access request.
+func (a *API) UserExport(w http.ResponseWriter, r *http.Request) error {
+	user := getUser(r.Context())
+
+	return a.sendUserExport(w, r, user, user, nil)
+}
+
+// adminUserExport returns everything stored about a user on behalf of an
+// admin.
+func (a *API) adminUserExport(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+	adminUser := getAdminUser(ctx)
+
+	return a.sendUserExport(w, r, adminUser, user, map[string]interface{}{
+		"user_id":    user.ID,
+		"user_email": user.Email,
+		"user_phone": user.Phone,
+	})
+}
+
+func (a *API) sendUserExport(w http.ResponseWriter, r *http.Request, actor, user *models.User, traits map[string]interface{}) error {
+	db := a.db.WithContext(r.Context())
+
+	var export *models.UserExport
+	err := db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		if export, terr = models.NewUserExport(tx, user, a.Now()); terr != nil {
+			return internalServerError("Database error exporting user").WithInternalError(terr)
+		}
+
+		// recorded after collecting the export, so it isn't part of it
+		if terr := models.NewAuditLogEntry(r, tx, actor, models.UserDataExportedAction, "", traits); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("user-%s.json", user.ID)))
+	return sendJSON(w, http.StatusOK, export)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+)
+
+type UserExportTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	user    *models.User
+	session *models.Session
+
+	authCode string
+	userCode string
+}
+
+func TestUserExport(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &UserExportTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *UserExportTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	identity, err := models.NewIdentity(u, "email", map[string]interface{}{
+		"sub":   u.ID.String(),
+		"email": "test@example.com",
+	})
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(identity))
+
+	require.NoError(ts.T(), models.CreateOneTimeToken(ts.API.db, u.ID, "test@example.com", "secret-token-hash", models.ConfirmationToken))
+
+	session, err := models.NewSession(u.ID, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(session))
+
+	factor := models.NewFactor(u, "authenticator", models.TOTP, models.FactorStateVerified)
+	require.NoError(ts.T(), factor.SetSecret("secretkey", ts.Config.Security.DBEncryption.Encrypt, ts.Config.Security.DBEncryption.EncryptionKeyID, ts.Config.Security.DBEncryption.EncryptionKey))
+	require.NoError(ts.T(), ts.API.db.Create(factor))
+	require.NoError(ts.T(), ts.API.db.Create(models.NewChallenge(factor, "203.0.113.7")))
+
+	flowState := models.NewFlowState("email", "secret-code-challenge", models.SHA256, models.EmailSignup, &u.ID)
+	require.NoError(ts.T(), ts.API.db.Create(flowState))
+	ts.authCode = flowState.AuthCode
+
+	deviceCode, _, err := models.NewDeviceCode("cli", 5*time.Second, 10*time.Minute)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(deviceCode))
+	require.NoError(ts.T(), deviceCode.Approve(ts.API.db, u.ID))
+	ts.userCode = deviceCode.UserCode
+
+	require.NoError(ts.T(), models.NewAuditLogEntry(httptest.NewRequest(http.MethodPost, "/", nil), ts.API.db, u, models.LoginAction, "", nil))
+
+	ts.user = u
+	ts.session = session
+}
+
+func (ts *UserExportTestSuite) export(path, token string) map[string]interface{} {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.Contains(ts.T(), w.Header().Get("Content-Disposition"), "attachment")
+
+	require.NotContains(ts.T(), w.Body.String(), "secret-token-hash")
+	require.NotContains(ts.T(), w.Body.String(), "encrypted_password")
+	require.NotContains(ts.T(), w.Body.String(), "secret-code-challenge")
+	require.NotContains(ts.T(), w.Body.String(), ts.authCode)
+	require.NotContains(ts.T(), w.Body.String(), ts.userCode)
+
+	var data map[string]interface{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	return data
+}
+
+func (ts *UserExportTestSuite) requireExport(data map[string]interface{}) {
+	user := data["user"].(map[string]interface{})
+	require.Equal(ts.T(), ts.user.ID.String(), user["id"])
+	require.Len(ts.T(), user["identities"], 1)
+
+	sessions := data["sessions"].([]interface{})
+	require.Len(ts.T(), sessions, 1)
+	require.Equal(ts.T(), ts.session.ID.String(), sessions[0].(map[string]interface{})["id"])
+
+	tokens := data["one_time_tokens"].([]interface{})
+	require.Len(ts.T(), tokens, 1)
+	require.Equal(ts.T(), "confirmation_token", tokens[0].(map[string]interface{})["token_type"])
+
+	challenges := data["mfa_challenges"].([]interface{})
+	require.Len(ts.T(), challenges, 1)
+	require.Equal(ts.T(), "203.0.113.7", challenges[0].(map[string]interface{})["ip_address"])
+
+	flowStates := data["flow_states"].([]interface{})
+	require.Len(ts.T(), flowStates, 1)
+	require.Equal(ts.T(), "email", flowStates[0].(map[string]interface{})["provider_type"])
+
+	deviceCodes := data["device_codes"].([]interface{})
+	require.Len(ts.T(), deviceCodes, 1)
+	require.Equal(ts.T(), string(models.DeviceCodeApproved), deviceCodes[0].(map[string]interface{})["status"])
+
+	entries := data["audit_log_entries"].([]interface{})
+	require.Len(ts.T(), entries, 1)
+	require.Equal(ts.T(), string(models.LoginAction), entries[0].(map[string]interface{})["payload"].(map[string]interface{})["action"])
+}
+
+func (ts *UserExportTestSuite) requireExportRecorded(actor *models.User) {
+	entries, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{
+		Actions:      []string{string(models.UserDataExportedAction)},
+		TargetUserID: &ts.user.ID,
+	}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), entries, 1)
+	if actor != nil {
+		require.Equal(ts.T(), actor.ID.String(), entries[0].Payload["actor_id"])
+	}
+}
+
+func (ts *UserExportTestSuite) TestUserExport() {
+	token, _, err := ts.API.generateAccessToken(httptest.NewRequest(http.MethodPost, "/", nil), ts.API.db, ts.user, &ts.session.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+
+	ts.requireExport(ts.export("http://localhost/user/export", token))
+	ts.requireExportRecorded(ts.user)
+}
+
+func (ts *UserExportTestSuite) TestAdminUserExport() {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role: "supabase_admin",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+
+	ts.requireExport(ts.export(fmt.Sprintf("http://localhost/admin/users/%s/export", ts.user.ID), token))
+	ts.requireExportRecorded(nil)
+}
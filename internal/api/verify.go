@@ -126,6 +126,7 @@ func (a *API) verifyGet(w http.ResponseWriter, r *http.Request, params *VerifyPa
 		token       *AccessTokenResponse
 		authCode    string
 		rurl        string
+		failure     *models.AuditEvent
 	)
 
 	grantParams.FillGrantParams(r)
@@ -142,7 +143,7 @@ func (a *API) verifyGet(w http.ResponseWriter, r *http.Request, params *VerifyPa
 
 	err = db.Transaction(func(tx *storage.Connection) error {
 		var terr error
-		user, terr = a.verifyTokenHash(tx, params)
+		user, failure, terr = a.verifyTokenHash(tx, params)
 		if terr != nil {
 			return terr
 		}
@@ -196,6 +197,10 @@ func (a *API) verifyGet(w http.ResponseWriter, r *http.Request, params *VerifyPa
 		return nil
 	})
 
+	if failure != nil {
+		a.auditFailure(r, failure)
+	}
+
 	if err != nil {
 		var herr *HTTPError
 		if errors.As(err, &herr) {
@@ -233,6 +238,7 @@ func (a *API) verifyPost(w http.ResponseWriter, r *http.Request, params *VerifyP
 		user        *models.User
 		grantParams models.GrantParams
 		token       *AccessTokenResponse
+		failure     *models.AuditEvent
 	)
 	var isSingleConfirmationResponse = false
 
@@ -243,9 +249,9 @@ func (a *API) verifyPost(w http.ResponseWriter, r *http.Request, params *VerifyP
 		aud := a.requestAud(ctx, r)
 
 		if isUsingTokenHash(params) {
-			user, terr = a.verifyTokenHash(tx, params)
+			user, failure, terr = a.verifyTokenHash(tx, params)
 		} else {
-			user, terr = a.verifyUserAndToken(tx, params, aud)
+			user, failure, terr = a.verifyUserAndToken(tx, params, aud)
 		}
 		if terr != nil {
 			return terr
@@ -291,6 +297,9 @@ func (a *API) verifyPost(w http.ResponseWriter, r *http.Request, params *VerifyP
 		}
 		return nil
 	})
+	if failure != nil {
+		a.auditFailure(r, failure)
+	}
 	if err != nil {
 		return err
 	}
@@ -573,7 +582,9 @@ func (a *API) emailChangeVerify(r *http.Request, conn *storage.Connection, param
 	return user, nil
 }
 
-func (a *API) verifyTokenHash(conn *storage.Connection, params *VerifyParams) (*models.User, error) {
+// verifyTokenHash finds the user of an email link. If it fails, it also
+// returns the audit event to record once the transaction is rolled back.
+func (a *API) verifyTokenHash(conn *storage.Connection, params *VerifyParams) (*models.User, *models.AuditEvent, error) {
 	config := a.config
 
 	var user *models.User
@@ -589,18 +600,18 @@ func (a *API) verifyTokenHash(conn *storage.Connection, params *VerifyParams) (*
 	case mail.EmailChangeVerification:
 		user, err = models.FindUserByEmailChangeToken(conn, params.TokenHash)
 	default:
-		return nil, badRequestError(ErrorCodeValidationFailed, "Invalid email verification type")
+		return nil, nil, badRequestError(ErrorCodeValidationFailed, "Invalid email verification type")
 	}
 
 	if err != nil {
 		if models.IsNotFoundError(err) {
-			return nil, forbiddenError(ErrorCodeOTPExpired, "Email link is invalid or has expired").WithInternalError(err)
+			return nil, verifyFailure(nil, params, "invalid_token"), forbiddenError(ErrorCodeOTPExpired, "Email link is invalid or has expired").WithInternalError(err)
 		}
-		return nil, internalServerError("Database error finding user from email link").WithInternalError(err)
+		return nil, nil, internalServerError("Database error finding user from email link").WithInternalError(err)
 	}
 
 	if user.IsBanned() {
-		return nil, forbiddenError(ErrorCodeUserBanned, "User is banned")
+		return nil, verifyFailure(user, params, "user_banned"), forbiddenError(ErrorCodeUserBanned, "User is banned")
 	}
 
 	var isExpired bool
@@ -622,14 +633,16 @@ func (a *API) verifyTokenHash(conn *storage.Connection, params *VerifyParams) (*
 	}
 
 	if isExpired {
-		return nil, forbiddenError(ErrorCodeOTPExpired, "Email link is invalid or has expired").WithInternalMessage("email link has expired")
+		return nil, verifyFailure(user, params, "expired_token"), forbiddenError(ErrorCodeOTPExpired, "Email link is invalid or has expired").WithInternalMessage("email link has expired")
 	}
 
-	return user, nil
+	return user, nil, nil
 }
 
-// verifyUserAndToken verifies the token associated to the user based on the verify type
-func (a *API) verifyUserAndToken(conn *storage.Connection, params *VerifyParams, aud string) (*models.User, error) {
+// verifyUserAndToken verifies the token associated to the user based on the verify type.
+// If it fails, it also returns the audit event to record once the transaction
+// is rolled back.
+func (a *API) verifyUserAndToken(conn *storage.Connection, params *VerifyParams, aud string) (*models.User, *models.AuditEvent, error) {
 	config := a.config
 
 	var user *models.User
@@ -651,13 +664,13 @@ func (a *API) verifyUserAndToken(conn *storage.Connection, params *VerifyParams,
 
 	if err != nil {
 		if models.IsNotFoundError(err) {
-			return nil, forbiddenError(ErrorCodeOTPExpired, "Token has expired or is invalid").WithInternalError(err)
+			return nil, verifyFailure(nil, params, "user_not_found"), forbiddenError(ErrorCodeOTPExpired, "Token has expired or is invalid").WithInternalError(err)
 		}
-		return nil, internalServerError("Database error finding user").WithInternalError(err)
+		return nil, nil, internalServerError("Database error finding user").WithInternalError(err)
 	}
 
 	if user.IsBanned() {
-		return nil, forbiddenError(ErrorCodeUserBanned, "User is banned")
+		return nil, verifyFailure(user, params, "user_banned"), forbiddenError(ErrorCodeUserBanned, "User is banned")
 	}
 
 	var isValid bool
@@ -694,21 +707,36 @@ func (a *API) verifyUserAndToken(conn *storage.Connection, params *VerifyParams,
 		if config.Sms.IsTwilioVerifyProvider() {
 			if testOTP, ok := config.Sms.GetTestOTP(params.Phone, time.Now()); ok {
 				if params.Token == testOTP {
-					return user, nil
+					return user, nil, nil
 				}
 			}
 			if err := smsProvider.(*sms_provider.TwilioVerifyProvider).VerifyOTP(phone, params.Token); err != nil {
-				return nil, forbiddenError(ErrorCodeOTPExpired, "Token has expired or is invalid").WithInternalError(err)
+				return nil, verifyFailure(user, params, "invalid_otp"), forbiddenError(ErrorCodeOTPExpired, "Token has expired or is invalid").WithInternalError(err)
 			}
-			return user, nil
+			return user, nil, nil
 		}
 		isValid = isOtpValid(tokenHash, expectedToken, sentAt, config.Sms.OtpExp)
 	}
 
 	if !isValid {
-		return nil, forbiddenError(ErrorCodeOTPExpired, "Token has expired or is invalid").WithInternalMessage("token has expired or is invalid")
+		return nil, verifyFailure(user, params, "invalid_otp"), forbiddenError(ErrorCodeOTPExpired, "Token has expired or is invalid").WithInternalMessage("token has expired or is invalid")
+	}
+	return user, nil, nil
+}
+
+// verifyFailure returns the audit event of a failed verification attempt.
+// user is nil when the token or identifier didn't match any user.
+func verifyFailure(user *models.User, params *VerifyParams, reason string) *models.AuditEvent {
+	return &models.AuditEvent{
+		Action:        models.LoginAction,
+		Actor:         user,
+		ActorUsername: params.Email + params.Phone,
+		AuthMethod:    params.Type,
+		FailureReason: reason,
+		Traits: map[string]interface{}{
+			"type": params.Type,
+		},
 	}
-	return user, nil
 }
 
 // isOtpValid checks the actual otp sent against the expected otp and ensures that it's within the valid window
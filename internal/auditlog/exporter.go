@@ -0,0 +1,152 @@
+// Package auditlog streams new audit log entries to external sinks, such as
+// a SIEM, with at-least-once delivery.
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// Exporter periodically reads the entries that follow its cursor, hands
+// them to the sink and advances the cursor once the sink accepted them. If
+// the sink fails the cursor stays where it was, so the batch is sent again.
+type Exporter struct {
+	db     *storage.Connection
+	config *conf.AuditLogExportConfiguration
+	sink   Sink
+
+	// recent holds the entries exported within the settle window before
+	// the cursor, by ID, so that they aren't sent again when the window
+	// is read again.
+	recent map[uuid.UUID]time.Time
+}
+
+func NewExporter(config *conf.AuditLogExportConfiguration, db *storage.Connection, sink Sink) *Exporter {
+	return &Exporter{
+		db:     db,
+		config: config,
+		sink:   sink,
+		recent: make(map[uuid.UUID]time.Time),
+	}
+}
+
+// Run exports entries until ctx is done. Full batches are followed by
+// another round right away so that a backlog is drained quickly.
+func (e *Exporter) Run(ctx context.Context) {
+	log := logrus.WithField("component", "audit_log_export").WithField("sink", e.config.Sink)
+	defer func() {
+		if err := e.sink.Close(); err != nil {
+			log.WithError(err).Warn("unable to close audit log export sink")
+		}
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-timer.C:
+			count, err := e.ExportOnce(ctx)
+			if err != nil {
+				log.WithError(err).Warn("audit log export failed, will retry")
+			} else if count > 0 {
+				log.WithField("entries", count).Debug("exported audit log entries")
+			}
+
+			next := e.config.Interval
+			if err == nil && count >= e.config.BatchSize {
+				next = 0
+			}
+			timer.Reset(next)
+		}
+	}
+}
+
+// exportLeaseMargin is how much longer than the sink timeout the cursor is
+// leased for, to read the entries and advance the cursor.
+const exportLeaseMargin = 30 * time.Second
+
+// ExportOnce exports at most one batch and returns the number of exported
+// entries. It returns zero without an error if another exporter holds the
+// cursor. The cursor is leased rather than locked, so that no transaction
+// is held open while the sink is called.
+func (e *Exporter) ExportOnce(ctx context.Context) (int, error) {
+	db := e.db.WithContext(ctx)
+
+	cursor, err := models.LeaseAuditLogExportCursor(db, e.config.Name, e.config.Timeout+exportLeaseMargin)
+	if err == models.ErrAuditLogExportCursorLocked {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	position := cursor.Cursor()
+
+	entries, err := models.FindAuditLogEntriesAfter(db, position, e.config.Settle, e.config.BatchSize+len(e.recent))
+	if err != nil {
+		return 0, e.release(db, cursor, nil, err)
+	}
+
+	var batch []*models.AuditLogEntry
+	for _, entry := range entries {
+		if _, ok := e.recent[entry.ID]; ok {
+			continue
+		}
+
+		batch = append(batch, entry)
+		if len(batch) == e.config.BatchSize {
+			break
+		}
+	}
+
+	if len(batch) == 0 {
+		return 0, e.release(db, cursor, nil, nil)
+	}
+
+	sinkCtx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	if err := e.sink.Export(sinkCtx, batch); err != nil {
+		return 0, e.release(db, cursor, nil, err)
+	}
+
+	for _, entry := range batch {
+		e.recent[entry.ID] = entry.CreatedAt
+
+		if entry.CreatedAt.After(position.Time) || (entry.CreatedAt.Equal(position.Time) && bytes.Compare(entry.ID.Bytes(), position.ID.Bytes()) > 0) {
+			position = models.Cursor{Time: entry.CreatedAt, ID: entry.ID}
+		}
+	}
+
+	for id, createdAt := range e.recent {
+		if createdAt.Before(position.Time.Add(-e.config.Settle)) {
+			delete(e.recent, id)
+		}
+	}
+
+	if err := e.release(db, cursor, &position, nil); err != nil {
+		return 0, err
+	}
+
+	return len(batch), nil
+}
+
+// release ends the lease on the cursor, moving it to position if set, and
+// returns err, or the error releasing the cursor.
+func (e *Exporter) release(db *storage.Connection, cursor *models.AuditLogExportCursor, position *models.Cursor, err error) error {
+	if rerr := cursor.Release(db, position); rerr != nil && err == nil {
+		return rerr
+	}
+
+	return err
+}
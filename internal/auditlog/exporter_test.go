@@ -0,0 +1,97 @@
+package auditlog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+	"github.com/supabase/auth/internal/storage/test"
+)
+
+const exporterTestConfig = "../../hack/test.env"
+
+// recordingSink remembers the entries it exported.
+type recordingSink struct {
+	exported []uuid.UUID
+}
+
+func (s *recordingSink) Export(ctx context.Context, entries []*models.AuditLogEntry) error {
+	for _, entry := range entries {
+		s.exported = append(s.exported, entry.ID)
+	}
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func insertEntry(t *testing.T, db *storage.Connection, createdAt time.Time) uuid.UUID {
+	id := uuid.Must(uuid.NewV4())
+	require.NoError(t, db.RawQuery("insert into audit_log_entries (instance_id, id, payload, created_at, ip_address) values (?, ?, '{}', ?, '')", uuid.Nil, id, createdAt).Exec())
+	return id
+}
+
+func TestExporter(t *testing.T) {
+	globalConfig, err := conf.LoadGlobal(exporterTestConfig)
+	require.NoError(t, err)
+	db, err := test.SetupDBConnection(globalConfig)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, models.TruncateAll(db))
+
+	config := &conf.AuditLogExportConfiguration{
+		Name:      "test",
+		BatchSize: 10,
+		Timeout:   time.Second,
+		Settle:    time.Minute,
+	}
+	sink := &recordingSink{}
+	exporter := NewExporter(config, db, sink)
+
+	now := time.Now()
+	first := insertEntry(t, db, now.Add(-2*time.Second))
+	second := insertEntry(t, db, now.Add(-time.Second))
+
+	count, err := exporter.ExportOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	require.Equal(t, []uuid.UUID{first, second}, sink.exported)
+
+	// the settle window is read again, but exported entries aren't sent
+	// twice
+	count, err = exporter.ExportOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	// an entry committed late, before the cursor, is still exported
+	late := insertEntry(t, db, now.Add(-3*time.Second))
+
+	count, err = exporter.ExportOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, late, sink.exported[2])
+
+	// other exporters skip the cursor while it's leased
+	cursor, err := models.LeaseAuditLogExportCursor(db, config.Name, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, second, cursor.Cursor().ID)
+
+	insertEntry(t, db, now)
+
+	count, err = exporter.ExportOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	require.NoError(t, cursor.Release(db, nil))
+
+	count, err = exporter.ExportOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
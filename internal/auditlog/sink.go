@@ -0,0 +1,151 @@
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+)
+
+// Sink delivers a batch of audit log entries to an external system. A batch
+// is only considered exported once Export returns without an error.
+type Sink interface {
+	Export(ctx context.Context, entries []*models.AuditLogEntry) error
+	Close() error
+}
+
+// NewSink returns the sink configured for the export.
+func NewSink(config *conf.AuditLogExportConfiguration) (Sink, error) {
+	switch config.Sink {
+	case "stdout":
+		return &WriterSink{Writer: os.Stdout}, nil
+
+	case "syslog":
+		return newSyslogSink(config.URL)
+
+	case "http":
+		return &HTTPSink{
+			URL:     config.URL,
+			Headers: config.HTTPHeaders,
+			Client:  &http.Client{Timeout: config.Timeout},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("auditlog: unsupported sink %q", config.Sink)
+}
+
+// WriterSink writes entries as newline delimited JSON.
+type WriterSink struct {
+	Writer io.Writer
+}
+
+func (s *WriterSink) Export(ctx context.Context, entries []*models.AuditLogEntry) error {
+	encoder := json.NewEncoder(s.Writer)
+
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(address string) (*syslogSink, error) {
+	var network, raddr string
+
+	if address != "" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+
+		network, raddr = u.Scheme, u.Host
+	}
+
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_AUTH, "gotrue")
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Export(ctx context.Context, entries []*models.AuditLogEntry) error {
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		if err := s.writer.Info(string(line)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
+
+// HTTPSink POSTs each batch as a JSON array. Any response other than 2xx
+// fails the batch, which is then retried.
+type HTTPSink struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+func (s *HTTPSink) Export(ctx context.Context, entries []*models.AuditLogEntry) error {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+
+	rsp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, rsp.Body)
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("auditlog: sink responded with status %d", rsp.StatusCode)
+	}
+
+	return nil
+}
+
+func (s *HTTPSink) Close() error {
+	return nil
+}
@@ -0,0 +1,78 @@
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/models"
+)
+
+func testEntries() []*models.AuditLogEntry {
+	return []*models.AuditLogEntry{
+		{
+			ID:        uuid.Must(uuid.NewV4()),
+			Payload:   models.JSONMap{"action": "login"},
+			CreatedAt: time.Now(),
+		},
+		{
+			ID:        uuid.Must(uuid.NewV4()),
+			Payload:   models.JSONMap{"action": "logout"},
+			CreatedAt: time.Now(),
+		},
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := &WriterSink{Writer: &buf}
+
+	entries := testEntries()
+	require.NoError(t, sink.Export(context.Background(), entries))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var decoded models.AuditLogEntry
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &decoded))
+	require.Equal(t, entries[1].ID, decoded.ID)
+	require.Equal(t, "logout", decoded.Payload["action"])
+}
+
+func TestHTTPSink(t *testing.T) {
+	var received []*models.AuditLogEntry
+	status := http.StatusNoContent
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		received = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := &HTTPSink{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+		Client:  server.Client(),
+	}
+
+	entries := testEntries()
+	require.NoError(t, sink.Export(context.Background(), entries))
+	require.Len(t, received, 2)
+	require.Equal(t, entries[0].ID, received[0].ID)
+
+	status = http.StatusServiceUnavailable
+	require.Error(t, sink.Export(context.Background(), entries))
+}
@@ -0,0 +1,360 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gobwas/glob"
+)
+
+// DeprecationNotices returns a notice for every deprecated setting in use.
+func (c *GlobalConfiguration) DeprecationNotices() []string {
+	var notices []string
+
+	if c.JWT.AdminGroupName != "" {
+		notices = append(notices, "GOTRUE_JWT_ADMIN_GROUP_NAME not supported by Supabase's GoTrue, will be removed soon")
+	}
+
+	if c.JWT.DefaultGroupName != "" {
+		notices = append(notices, "GOTRUE_JWT_DEFAULT_GROUP_NAME not supported by Supabase's GoTrue, will be removed soon")
+	}
+
+	return notices
+}
+
+// CheckSeverity is the severity of a problem found by Check.
+type CheckSeverity string
+
+const (
+	CheckError   CheckSeverity = "error"
+	CheckWarning CheckSeverity = "warning"
+)
+
+// CheckFinding is a problem found in the configuration.
+type CheckFinding struct {
+	Severity CheckSeverity `json:"severity"`
+	Section  string        `json:"section"`
+	Message  string        `json:"message"`
+}
+
+// CheckReport lists all problems found in the configuration.
+type CheckReport struct {
+	Findings []CheckFinding `json:"findings"`
+}
+
+func (r *CheckReport) errorf(section, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, CheckFinding{Severity: CheckError, Section: section, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *CheckReport) warnf(section, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, CheckFinding{Severity: CheckWarning, Section: section, Message: fmt.Sprintf(format, args...)})
+}
+
+// Count returns the number of findings with the severity.
+func (r *CheckReport) Count(severity CheckSeverity) int {
+	count := 0
+	for _, finding := range r.Findings {
+		if finding.Severity == severity {
+			count += 1
+		}
+	}
+	return count
+}
+
+// HasErrors reports whether the configuration can't be used.
+func (r *CheckReport) HasErrors() bool {
+	return r.Count(CheckError) > 0
+}
+
+// CheckOptions controls the checks that need network access or the email
+// templates built into the mailer.
+type CheckOptions struct {
+	// FetchTemplates downloads the email templates and compiles them.
+	FetchTemplates bool
+
+	// DefaultTemplates are the templates emails are sent with when no
+	// template URL is configured, by the JSON name of the email. They're
+	// compiled in place of the missing templates.
+	DefaultTemplates map[string]string
+}
+
+// Check loads the configuration like LoadGlobal does, but reports every
+// problem found instead of stopping at the first one. Beyond validation, it
+// checks the required settings of enabled providers and compiles the email
+// and SMS templates.
+func Check(ctx context.Context, filename string, options CheckOptions) *CheckReport {
+	report := &CheckReport{}
+
+	if err := loadEnvironment(filename); err != nil {
+		report.errorf("env", "unable to load %q: %v", filename, err)
+		return report
+	}
+
+	config, err := processEnvironment()
+	if err != nil {
+		report.errorf("env", "%v", err)
+		return report
+	}
+
+	// invalid patterns would make ApplyDefaults panic
+	allowList := make([]string, 0, len(config.URIAllowList))
+	for _, uri := range config.URIAllowList {
+		if _, err := glob.Compile(uri, '.', '/'); err != nil {
+			report.errorf("uri_allow_list", "invalid pattern %q: %v", uri, err)
+			continue
+		}
+		allowList = append(allowList, uri)
+	}
+	config.URIAllowList = allowList
+
+	if err := config.ApplyDefaults(); err != nil {
+		report.errorf("defaults", "%v", err)
+	}
+
+	for _, section := range config.validatables() {
+		if section.name == "hook" {
+			// hooks are checked one by one below
+			continue
+		}
+
+		if err := section.Validate(); err != nil {
+			report.errorf(section.name, "%v", err)
+		}
+	}
+
+	config.checkHooks(report)
+	config.checkURLs(report)
+	config.checkProviders(report)
+	config.checkSms(report)
+	config.checkMailer(ctx, report, options)
+
+	if err := config.populateSAML(); err != nil {
+		report.errorf("saml", "%v", err)
+	}
+
+	if config.DeviceAuthorization.Enabled && config.DeviceAuthorization.VerificationURI == "" && !config.HostedUI.Enabled {
+		report.errorf("device_authorization", "a verification URI is required when the hosted UI is disabled")
+	}
+
+	if len(config.JWT.Secret) < 32 {
+		report.warnf("jwt", "secret is shorter than 32 characters")
+	}
+
+	for _, notice := range config.DeprecationNotices() {
+		report.warnf("deprecation", "%s", notice)
+	}
+
+	return report
+}
+
+func (c *GlobalConfiguration) checkHooks(report *CheckReport) {
+	for _, h := range c.hooks() {
+		if err := h.hook.ValidateExtensibilityPoint(); err != nil {
+			report.errorf("hook", "%s: %v", h.name, err)
+			continue
+		}
+
+		if !h.hook.Enabled {
+			continue
+		}
+
+		if h.hook.URI == "" {
+			report.errorf("hook", "%s: enabled without a URI", h.name)
+			continue
+		}
+
+		if err := h.hook.PopulateExtensibilityPoint(); err != nil {
+			report.errorf("hook", "%s: %v", h.name, err)
+		}
+	}
+}
+
+func (c *GlobalConfiguration) checkURLs(report *CheckReport) {
+	if u, err := url.ParseRequestURI(c.SiteURL); err != nil || u.Host == "" {
+		report.errorf("site_url", "%q is not an absolute URL", c.SiteURL)
+	}
+
+	if c.External.RedirectURL != "" {
+		if u, err := url.ParseRequestURI(c.External.RedirectURL); err != nil || u.Host == "" {
+			report.errorf("external", "redirect URL %q is not an absolute URL", c.External.RedirectURL)
+		}
+	}
+}
+
+func (c *GlobalConfiguration) checkProviders(report *CheckReport) {
+	external := reflect.ValueOf(&c.External).Elem()
+	providerType := reflect.TypeOf(OAuthProviderConfiguration{})
+
+	for i := 0; i < external.NumField(); i++ {
+		if external.Field(i).Type() != providerType {
+			continue
+		}
+
+		name := jsonFieldName(external.Type().Field(i))
+		provider := external.Field(i).Addr().Interface().(*OAuthProviderConfiguration)
+
+		if !provider.Enabled {
+			continue
+		}
+
+		if err := provider.ValidateOAuth(); err != nil {
+			report.errorf("external", "%s: %v", name, err)
+		}
+
+		if provider.RedirectURI != "" {
+			if u, err := url.ParseRequestURI(provider.RedirectURI); err != nil || u.Host == "" {
+				report.errorf("external", "%s: redirect URI %q is not an absolute URL", name, provider.RedirectURI)
+			}
+		}
+	}
+}
+
+func (c *GlobalConfiguration) checkSms(report *CheckReport) {
+	if err := c.Sms.PopulateTemplate(); err != nil {
+		report.errorf("sms", "invalid template: %v", err)
+	}
+
+	if c.Sms.Provider == "" {
+		if c.External.Phone.Enabled && !c.Hook.SendSMS.Enabled {
+			report.errorf("sms", "phone provider is enabled without an SMS provider or send SMS hook")
+		}
+		if c.Sms.Template != "" {
+			report.warnf("sms", "template is set without an SMS provider and is not used")
+		}
+		return
+	}
+
+	provider, ok := c.Sms.providerConfiguration(c.Sms.Provider)
+	if !ok {
+		report.errorf("sms", "unknown SMS provider %q", c.Sms.Provider)
+		return
+	}
+
+	if err := provider.Validate(); err != nil {
+		report.errorf("sms", "%s: %v", c.Sms.Provider, err)
+	}
+
+	if c.Sms.HasFailover() {
+		if err := c.Sms.ValidateFailover(); err != nil {
+			report.errorf("sms", "failover: %v", err)
+		}
+	}
+
+}
+
+func (c *GlobalConfiguration) checkMailer(ctx context.Context, report *CheckReport, options CheckOptions) {
+	if c.External.Email.Enabled && c.SMTP.Host == "" && !c.Hook.SendEmail.Enabled && !c.Mailer.Autoconfirm {
+		report.warnf("smtp", "no SMTP host or send email hook is configured, emails will not be sent")
+	}
+
+	contents := []struct {
+		kind    string
+		content *EmailContentConfiguration
+	}{
+		{"subjects", &c.Mailer.Subjects},
+		{"templates", &c.Mailer.Templates},
+		{"url_paths", &c.Mailer.URLPaths},
+	}
+
+	for _, content := range contents {
+		kind := content.kind
+		values := reflect.ValueOf(content.content).Elem()
+
+		for i := 0; i < values.NumField(); i++ {
+			field := jsonFieldName(values.Type().Field(i))
+			name := fmt.Sprintf("%s.%s", kind, field)
+			value := values.Field(i).String()
+			if value == "" && kind != "templates" {
+				continue
+			}
+
+			switch kind {
+			case "subjects":
+				if err := compileTemplate(name, value); err != nil {
+					report.errorf("mailer", "%s: %v", name, err)
+				}
+
+			case "templates":
+				// the default template is also used when the configured
+				// one can't be fetched
+				if defaultTemplate, ok := options.DefaultTemplates[field]; ok {
+					if err := compileTemplate(name, defaultTemplate); err != nil {
+						report.errorf("mailer", "%s: default %v", name, err)
+					}
+				}
+
+				if value == "" {
+					continue
+				}
+
+				// relative URLs are fetched from the site URL
+				templateURL := value
+				if !strings.HasPrefix(templateURL, "http") {
+					templateURL = c.SiteURL + templateURL
+				}
+
+				u, err := url.ParseRequestURI(templateURL)
+				if err != nil || u.Host == "" {
+					report.errorf("mailer", "%s: %q is not a valid URL", name, value)
+					continue
+				}
+
+				if options.FetchTemplates {
+					if err := fetchTemplate(ctx, templateURL); err != nil {
+						report.errorf("mailer", "%s: %v", name, err)
+					}
+				}
+
+			case "url_paths":
+				if _, err := url.Parse(value); err != nil {
+					report.errorf("mailer", "%s: invalid URL path %q: %v", name, value, err)
+				}
+			}
+		}
+	}
+}
+
+// fetchTemplate downloads an email template and compiles it.
+func fetchTemplate(ctx context.Context, templateURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, templateURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to fetch template: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to fetch template: status %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("unable to fetch template: %w", err)
+	}
+
+	return compileTemplate(templateURL, string(body))
+}
+
+// compileTemplate compiles an email subject or template like the mailer
+// does when sending an email.
+func compileTemplate(name, value string) error {
+	if _, err := htmltemplate.New(name).Parse(value); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package conf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	t.Setenv("GOTRUE_SITE_URL", "http://localhost:3000")
+	t.Setenv("API_EXTERNAL_URL", "http://localhost:9999")
+	t.Setenv("GOTRUE_DB_DRIVER", "postgres")
+	t.Setenv("GOTRUE_DB_DATABASE_URL", "fake")
+	t.Setenv("GOTRUE_JWT_SECRET", "secret")
+
+	report := Check(context.Background(), "", CheckOptions{})
+	require.False(t, report.HasErrors(), "%+v", report.Findings)
+	require.Contains(t, report.Findings, CheckFinding{Severity: CheckWarning, Section: "jwt", Message: "secret is shorter than 32 characters"})
+
+	t.Setenv("GOTRUE_URI_ALLOW_LIST", "http://localhost:3000/[")
+	t.Setenv("GOTRUE_HOOK_SEND_SMS_ENABLED", "true")
+	t.Setenv("GOTRUE_HOOK_SEND_SMS_URI", "ftp://example.com/hook")
+	t.Setenv("GOTRUE_EXTERNAL_GITHUB_ENABLED", "true")
+	t.Setenv("GOTRUE_EXTERNAL_GITHUB_CLIENT_ID", "client")
+	t.Setenv("GOTRUE_EXTERNAL_GITHUB_REDIRECT_URI", "http://localhost:9999/callback")
+	t.Setenv("GOTRUE_SMS_PROVIDER", "twilio")
+	t.Setenv("GOTRUE_SMS_TEMPLATE", "{{ .Code")
+	t.Setenv("GOTRUE_MAILER_SUBJECTS_INVITE", "{{ .Invalid")
+	t.Setenv("GOTRUE_MAILER_TEMPLATES_INVITE", "/invite.html")
+	t.Setenv("GOTRUE_MAILER_TEMPLATES_RECOVERY", "http://[::1")
+
+	report = Check(context.Background(), "", CheckOptions{
+		DefaultTemplates: map[string]string{
+			"invite":       "<p>{{ .Token }}</p>",
+			"confirmation": "<p>{{ .Token </p>",
+		},
+	})
+	require.True(t, report.HasErrors())
+
+	sections := map[string]int{}
+	for _, finding := range report.Findings {
+		if finding.Severity == CheckError {
+			sections[finding.Section] += 1
+		}
+	}
+
+	require.Equal(t, map[string]int{
+		"uri_allow_list": 1,
+		"hook":           1,
+		"external":       1,
+		"sms":            2,
+		"mailer":         3,
+	}, sections)
+}
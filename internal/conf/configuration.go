@@ -3,6 +3,7 @@ package conf
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
@@ -13,7 +14,6 @@ import (
 	"time"
 
 	"github.com/gobwas/glob"
-	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 )
 
@@ -32,6 +32,10 @@ var postgresNamesRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,62}$`)
 var symmetricSecretFormat = regexp.MustCompile(`^v1,whsec_[A-Za-z0-9+/=]{32,88}`)
 var asymmetricSecretFormat = regexp.MustCompile(`^v1a,whpk_[A-Za-z0-9+/=]{44,}:whsk_[A-Za-z0-9+/=]{44,}$`)
 
+// e164Prefix matches the leading digits of an E.164 phone number, such as a
+// country calling code
+var e164Prefix = regexp.MustCompile(`^[1-9][0-9]{0,14}$`)
+
 // Time is used to represent timestamps in the configuration, as envconfig has
 // trouble parsing empty strings, due to time.Time.UnmarshalText().
 type Time struct {
@@ -55,7 +59,7 @@ func (t *Time) UnmarshalText(text []byte) error {
 // OAuthProviderConfiguration holds all config related to external account providers.
 type OAuthProviderConfiguration struct {
 	ClientID       []string `json:"client_id" split_words:"true"`
-	Secret         string   `json:"secret"`
+	Secret         string   `json:"secret" secret:"true"`
 	RedirectURI    string   `json:"redirect_uri" split_words:"true"`
 	URL            string   `json:"url"`
 	ApiURL         string   `json:"api_url" split_words:"true"`
@@ -63,8 +67,45 @@ type OAuthProviderConfiguration struct {
 	SkipNonceCheck bool     `json:"skip_nonce_check" split_words:"true"`
 }
 
+// AnonymousProviderConfiguration controls anonymous sign-ins and how long
+// anonymous users are kept. Anonymous users are deleted by the database
+// cleanup once they haven't signed in or refreshed a session for Retention,
+// CleanupBatchSize at a time. With the anonymous user expiry hook enabled,
+// the hook is called ReminderBefore the deletion and again before it.
 type AnonymousProviderConfiguration struct {
 	Enabled bool `json:"enabled" default:"false"`
+
+	Retention        time.Duration `json:"retention" default:"720h"`
+	CleanupBatchSize int           `json:"cleanup_batch_size" split_words:"true" default:"100"`
+	ReminderBefore   time.Duration `json:"reminder_before" split_words:"true"`
+
+	// MaxSessionsPerIP limits the active sessions of anonymous users
+	// created from one IP address. Zero means no limit.
+	MaxSessionsPerIP int `json:"max_sessions_per_ip" split_words:"true"`
+}
+
+func (c *AnonymousProviderConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Retention < time.Hour {
+		return fmt.Errorf("conf: anonymous users retention must be at least 1h, was %v", c.Retention)
+	}
+
+	if c.CleanupBatchSize < 1 || c.CleanupBatchSize > 1000 {
+		return fmt.Errorf("conf: anonymous users cleanup batch size must be between 1 and 1000, was %d", c.CleanupBatchSize)
+	}
+
+	if c.ReminderBefore < 0 || c.ReminderBefore >= c.Retention {
+		return fmt.Errorf("conf: anonymous users reminder must be sent before the retention of %v ends, was %v", c.Retention, c.ReminderBefore)
+	}
+
+	if c.MaxSessionsPerIP < 0 {
+		return fmt.Errorf("conf: anonymous users max sessions per IP can't be negative, was %d", c.MaxSessionsPerIP)
+	}
+
+	return nil
 }
 
 type EmailProviderConfiguration struct {
@@ -104,12 +145,12 @@ type JWTConfiguration struct {
 
 // MFAConfiguration holds all the MFA related Configuration
 type MFAConfiguration struct {
-	Enabled                     bool          `default:"false"`
+	Enabled                     bool          `json:"enabled" default:"false"`
 	ChallengeExpiryDuration     float64       `json:"challenge_expiry_duration" default:"300" split_words:"true"`
 	FactorExpiryDuration        time.Duration `json:"factor_expiry_duration" default:"300s" split_words:"true"`
-	RateLimitChallengeAndVerify float64       `split_words:"true" default:"15"`
-	MaxEnrolledFactors          float64       `split_words:"true" default:"10"`
-	MaxVerifiedFactors          int           `split_words:"true" default:"10"`
+	RateLimitChallengeAndVerify float64       `json:"rate_limit_challenge_and_verify" split_words:"true" default:"15"`
+	MaxEnrolledFactors          float64       `json:"max_enrolled_factors" split_words:"true" default:"10"`
+	MaxVerifiedFactors          int           `json:"max_verified_factors" split_words:"true" default:"10"`
 }
 
 type APIConfiguration struct {
@@ -136,20 +177,144 @@ type SessionsConfiguration struct {
 
 	SinglePerUser bool     `json:"single_per_user" split_words:"true"`
 	Tags          []string `json:"tags,omitempty"`
+
+	Policies SessionPolicies `json:"policies,omitempty"`
 }
 
 func (c *SessionsConfiguration) Validate() error {
-	if c.Timebox == nil {
-		return nil
+	if c.Timebox != nil && *c.Timebox <= time.Duration(0) {
+		return fmt.Errorf("conf: session timebox duration must be positive when set, was %v", (*c.Timebox).String())
 	}
 
-	if *c.Timebox <= time.Duration(0) {
-		return fmt.Errorf("conf: session timebox duration must be positive when set, was %v", (*c.Timebox).String())
+	for i, policy := range c.Policies {
+		if policy.Timebox != nil && *policy.Timebox <= time.Duration(0) {
+			return fmt.Errorf("conf: session policy %d timebox must be positive when set, was %v", i, (*policy.Timebox).String())
+		}
+
+		if policy.InactivityTimeout != nil && *policy.InactivityTimeout <= time.Duration(0) {
+			return fmt.Errorf("conf: session policy %d inactivity timeout must be positive when set, was %v", i, (*policy.InactivityTimeout).String())
+		}
+
+		// the database cleanup deletes sessions past the global limits,
+		// so policies can only shorten them
+		if policy.Timebox != nil && c.Timebox != nil && *policy.Timebox > *c.Timebox {
+			return fmt.Errorf("conf: session policy %d timebox must not be longer than the global timebox %v, was %v", i, (*c.Timebox).String(), (*policy.Timebox).String())
+		}
+
+		if policy.InactivityTimeout != nil && c.InactivityTimeout != nil && *c.InactivityTimeout > time.Duration(0) && *policy.InactivityTimeout > *c.InactivityTimeout {
+			return fmt.Errorf("conf: session policy %d inactivity timeout must not be longer than the global inactivity timeout %v, was %v", i, (*c.InactivityTimeout).String(), (*policy.InactivityTimeout).String())
+		}
 	}
 
 	return nil
 }
 
+// SessionPolicy replaces the session limits for the sessions of users
+// matching all of its conditions. Conditions left empty match everyone,
+// and limits left unset fall back to the global ones. Policies can only
+// shorten the global limits. AppMetadata matches
+// users whose app_metadata has the given values, or lists containing them.
+// SSOProvider is the ID of an SSO provider.
+type SessionPolicy struct {
+	Role        string                 `json:"role,omitempty"`
+	AppMetadata map[string]interface{} `json:"app_metadata,omitempty"`
+	Tag         string                 `json:"tag,omitempty"`
+	SSOProvider string                 `json:"sso_provider,omitempty"`
+
+	Timebox           *time.Duration `json:"timebox,omitempty"`
+	InactivityTimeout *time.Duration `json:"inactivity_timeout,omitempty"`
+	SinglePerUser     *bool          `json:"single_per_user,omitempty"`
+}
+
+// sessionPolicyJSON is how a SessionPolicy is written in JSON, with
+// durations such as "8h" instead of nanoseconds.
+type sessionPolicyJSON struct {
+	Role        string                 `json:"role,omitempty"`
+	AppMetadata map[string]interface{} `json:"app_metadata,omitempty"`
+	Tag         string                 `json:"tag,omitempty"`
+	SSOProvider string                 `json:"sso_provider,omitempty"`
+
+	Timebox           string `json:"timebox,omitempty"`
+	InactivityTimeout string `json:"inactivity_timeout,omitempty"`
+	SinglePerUser     *bool  `json:"single_per_user,omitempty"`
+}
+
+func parseOptionalDuration(value string) (*time.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+func formatOptionalDuration(d *time.Duration) string {
+	if d == nil {
+		return ""
+	}
+
+	return d.String()
+}
+
+func (p SessionPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sessionPolicyJSON{
+		Role:              p.Role,
+		AppMetadata:       p.AppMetadata,
+		Tag:               p.Tag,
+		SSOProvider:       p.SSOProvider,
+		Timebox:           formatOptionalDuration(p.Timebox),
+		InactivityTimeout: formatOptionalDuration(p.InactivityTimeout),
+		SinglePerUser:     p.SinglePerUser,
+	})
+}
+
+func (p *SessionPolicy) UnmarshalJSON(data []byte) error {
+	var raw sessionPolicyJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	timebox, err := parseOptionalDuration(raw.Timebox)
+	if err != nil {
+		return fmt.Errorf("conf: invalid session policy timebox: %w", err)
+	}
+
+	inactivityTimeout, err := parseOptionalDuration(raw.InactivityTimeout)
+	if err != nil {
+		return fmt.Errorf("conf: invalid session policy inactivity timeout: %w", err)
+	}
+
+	*p = SessionPolicy{
+		Role:              raw.Role,
+		AppMetadata:       raw.AppMetadata,
+		Tag:               raw.Tag,
+		SSOProvider:       raw.SSOProvider,
+		Timebox:           timebox,
+		InactivityTimeout: inactivityTimeout,
+		SinglePerUser:     raw.SinglePerUser,
+	}
+
+	return nil
+}
+
+// SessionPolicies are matched in order, and the first policy matching a
+// session applies to it. They're configured as a JSON array.
+type SessionPolicies []SessionPolicy
+
+func (p *SessionPolicies) Decode(value string) error {
+	var policies []SessionPolicy
+	if err := json.Unmarshal([]byte(value), &policies); err != nil {
+		return fmt.Errorf("conf: session policies must be a JSON array: %w", err)
+	}
+
+	*p = policies
+	return nil
+}
+
 type PasswordRequiredCharacters []string
 
 func (v *PasswordRequiredCharacters) Decode(value string) error {
@@ -209,10 +374,10 @@ type PasswordConfiguration struct {
 type GlobalConfiguration struct {
 	API                     APIConfiguration
 	DB                      DBConfiguration
-	External                ProviderConfiguration
-	Logging                 LoggingConfig  `envconfig:"LOG"`
-	Profiler                ProfilerConfig `envconfig:"PROFILER"`
-	OperatorToken           string         `split_words:"true" required:"false"`
+	External                ProviderConfiguration `json:"external"`
+	Logging                 LoggingConfig         `envconfig:"LOG"`
+	Profiler                ProfilerConfig        `envconfig:"PROFILER"`
+	OperatorToken           string                `split_words:"true" required:"false"`
 	Tracing                 TracingConfig
 	Metrics                 MetricsConfig
 	SMTP                    SMTPConfiguration
@@ -236,14 +401,364 @@ type GlobalConfiguration struct {
 	Hook            HookConfiguration        `json:"hook" split_words:"true"`
 	Security        SecurityConfiguration    `json:"security"`
 	Sessions        SessionsConfiguration    `json:"sessions"`
-	MFA             MFAConfiguration         `json:"MFA"`
+	MFA             MFAConfiguration         `json:"mfa"`
 	Cookie          struct {
 		Key      string `json:"key"`
 		Domain   string `json:"domain"`
 		Duration int    `json:"duration"`
 	} `json:"cookies"`
-	SAML SAMLConfiguration `json:"saml"`
-	CORS CORSConfiguration `json:"cors"`
+	SAML     SAMLConfiguration     `json:"saml"`
+	CORS     CORSConfiguration     `json:"cors"`
+	AuditLog AuditLogConfiguration `json:"audit_log" split_words:"true"`
+
+	Impersonation   ImpersonationConfiguration   `json:"impersonation"`
+	TokenExchange   TokenExchangeConfiguration   `json:"token_exchange" split_words:"true"`
+	ConfigOverrides ConfigOverridesConfiguration `json:"config_overrides" split_words:"true"`
+	HostedUI        HostedUIConfiguration        `json:"hosted_ui" split_words:"true"`
+
+	DeviceAuthorization DeviceAuthorizationConfiguration `json:"device_authorization" split_words:"true"`
+	ServiceClients      ServiceClientsConfiguration      `json:"service_clients" split_words:"true"`
+	APIKeys             APIKeysConfiguration             `json:"api_keys" split_words:"true"`
+	DPoP                DPoPConfiguration                `json:"dpop" envconfig:"DPOP"`
+	AccountDeletion     AccountDeletionConfiguration     `json:"account_deletion" split_words:"true"`
+}
+
+// DPoPConfiguration controls sender-constrained tokens (RFC 9449). Clients
+// that send a DPoP proof to /token get sessions and access tokens bound to
+// the proof's key. Proofs are accepted for ProofMaxAge after they're issued.
+type DPoPConfiguration struct {
+	Enabled bool `json:"enabled"`
+
+	ProofMaxAge time.Duration `json:"proof_max_age" split_words:"true" default:"1m"`
+}
+
+func (c *DPoPConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.ProofMaxAge < time.Second || c.ProofMaxAge > 10*time.Minute {
+		return fmt.Errorf("conf: DPoP proof max age must be between 1s and 10m, was %v", c.ProofMaxAge)
+	}
+
+	return nil
+}
+
+// APIKeysConfiguration controls personal API keys, which users manage with
+// the /user/api_keys endpoints and exchange for access tokens valid for Exp
+// seconds.
+type APIKeysConfiguration struct {
+	Enabled bool `json:"enabled"`
+
+	Exp        int `json:"exp" default:"300"`
+	MaxPerUser int `json:"max_per_user" split_words:"true" default:"25"`
+}
+
+func (c *APIKeysConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Exp <= 0 {
+		return fmt.Errorf("conf: API keys exp must be positive, was %d", c.Exp)
+	}
+
+	if c.MaxPerUser <= 0 {
+		return fmt.Errorf("conf: API keys max per user must be positive, was %d", c.MaxPerUser)
+	}
+
+	return nil
+}
+
+// AccountDeletionConfiguration controls the deletion of accounts requested
+// by their users. The account is deleted after GracePeriod, unless the user
+// signs in before then. Accounts are soft deleted unless SoftDelete is
+// false, in batches of at most BatchSize users.
+type AccountDeletionConfiguration struct {
+	Enabled bool `json:"enabled"`
+
+	GracePeriod time.Duration `json:"grace_period" split_words:"true" default:"336h"`
+	SoftDelete  bool          `json:"soft_delete" split_words:"true" default:"true"`
+	BatchSize   int           `json:"batch_size" split_words:"true" default:"100"`
+}
+
+func (c *AccountDeletionConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.GracePeriod < 0 {
+		return fmt.Errorf("conf: account deletion grace period can't be negative, was %v", c.GracePeriod)
+	}
+
+	if c.BatchSize < 1 || c.BatchSize > 1000 {
+		return fmt.Errorf("conf: account deletion batch size must be between 1 and 1000, was %d", c.BatchSize)
+	}
+
+	return nil
+}
+
+// ServiceClientsConfiguration controls the client_credentials grant, with
+// which registered service clients obtain access tokens valid for Exp
+// seconds. Clients are managed with the /admin/service_clients endpoints.
+type ServiceClientsConfiguration struct {
+	Enabled bool `json:"enabled"`
+
+	Exp int `json:"exp" default:"3600"`
+}
+
+func (c *ServiceClientsConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Exp <= 0 {
+		return fmt.Errorf("conf: service clients exp must be positive, was %d", c.Exp)
+	}
+
+	return nil
+}
+
+// DeviceAuthorizationConfiguration controls the OAuth 2.0 Device
+// Authorization Grant (RFC 8628), used by CLIs and TVs that can't receive a
+// redirect. Users approve devices at VerificationURI, which defaults to the
+// hosted UI device page when the hosted UI is enabled.
+type DeviceAuthorizationConfiguration struct {
+	Enabled bool `json:"enabled"`
+
+	Expiry          time.Duration `json:"expiry" default:"10m"`
+	PollingInterval time.Duration `json:"polling_interval" split_words:"true" default:"5s"`
+	VerificationURI string        `json:"verification_uri" envconfig:"VERIFICATION_URI"`
+}
+
+func (c *DeviceAuthorizationConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Expiry < time.Minute || c.Expiry > time.Hour {
+		return fmt.Errorf("conf: device authorization expiry must be between 1m and 1h, was %v", c.Expiry.String())
+	}
+
+	if c.PollingInterval < time.Second || c.PollingInterval >= c.Expiry {
+		return fmt.Errorf("conf: device authorization polling interval must be at least 1s and shorter than the expiry, was %v", c.PollingInterval.String())
+	}
+
+	if c.VerificationURI != "" {
+		if parsed, err := url.ParseRequestURI(c.VerificationURI); err != nil || parsed.Host == "" {
+			return fmt.Errorf("conf: device authorization verification URI %q must be absolute", c.VerificationURI)
+		}
+	}
+
+	return nil
+}
+
+// HostedUIConfiguration controls the built-in sign-in, sign-up, password
+// reset, MFA challenge and consent pages served under /ui.
+type HostedUIConfiguration struct {
+	Enabled bool `json:"enabled"`
+
+	Theme HostedUIThemeConfiguration `json:"theme"`
+}
+
+// HostedUIThemeConfiguration sets the look of the hosted pages. CSSURL is
+// loaded after the built-in styles, so it can override any of them.
+type HostedUIThemeConfiguration struct {
+	BrandName       string `json:"brand_name" split_words:"true"`
+	LogoURL         string `json:"logo_url" split_words:"true"`
+	CSSURL          string `json:"css_url" envconfig:"CSS_URL"`
+	PrimaryColor    string `json:"primary_color" split_words:"true" default:"#3ecf8e"`
+	BackgroundColor string `json:"background_color" split_words:"true" default:"#f8f9fa"`
+	TextColor       string `json:"text_color" split_words:"true" default:"#1c1c1c"`
+}
+
+var hostedUIColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func (c *HostedUIConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	colors := []struct {
+		name  string
+		value string
+	}{
+		{"primary color", c.Theme.PrimaryColor},
+		{"background color", c.Theme.BackgroundColor},
+		{"text color", c.Theme.TextColor},
+	}
+
+	for _, color := range colors {
+		if !hostedUIColorPattern.MatchString(color.value) {
+			return fmt.Errorf("conf: hosted UI %s must be a hex color such as #3ecf8e, was %q", color.name, color.value)
+		}
+	}
+
+	for _, u := range []string{c.Theme.LogoURL, c.Theme.CSSURL} {
+		if u == "" {
+			continue
+		}
+
+		if parsed, err := url.ParseRequestURI(u); err != nil || parsed.Host == "" {
+			return fmt.Errorf("conf: hosted UI theme URL %q must be absolute", u)
+		}
+	}
+
+	return nil
+}
+
+// ConfigOverridesConfiguration controls whether the configuration can be
+// overridden by admins at runtime. Overrides are stored in the database and
+// applied over the environment configuration.
+type ConfigOverridesConfiguration struct {
+	Enabled bool `json:"enabled"`
+}
+
+// TokenExchangeConfiguration controls the OAuth 2.0 Token Exchange grant
+// (RFC 8693). Exchanged tokens carry restricted claims, are valid for at
+// most Exp seconds and may only be issued for the listed audiences and
+// scopes. External ID tokens are accepted only from TrustedProviders,
+// which must also be configured as OAuth providers.
+type TokenExchangeConfiguration struct {
+	Enabled bool `json:"enabled"`
+
+	Exp              int      `json:"exp" default:"300"`
+	AllowedAudiences []string `json:"allowed_audiences" split_words:"true"`
+	AllowedScopes    []string `json:"allowed_scopes" split_words:"true"`
+	TrustedProviders []string `json:"trusted_providers" split_words:"true"`
+}
+
+func (c *TokenExchangeConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Exp <= 0 {
+		return fmt.Errorf("conf: token exchange exp must be positive, was %d", c.Exp)
+	}
+
+	for _, scope := range c.AllowedScopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return fmt.Errorf("conf: token exchange scope %q must be a non-empty string without whitespace", scope)
+		}
+	}
+
+	return nil
+}
+
+// ImpersonationConfiguration controls whether admins can mint sessions on
+// behalf of users, and for how long those sessions live.
+type ImpersonationConfiguration struct {
+	Enabled  bool          `json:"enabled"`
+	Duration time.Duration `json:"duration" default:"15m"`
+}
+
+func (c *ImpersonationConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Duration <= 0 || c.Duration > time.Hour {
+		return fmt.Errorf("conf: impersonation duration must be positive and at most 1h, was %v", c.Duration.String())
+	}
+
+	return nil
+}
+
+// AuditLogConfiguration holds the retention and export settings of the
+// audit log.
+type AuditLogConfiguration struct {
+	// Retention is how long entries are kept before being cleaned up.
+	// Zero keeps them forever.
+	Retention time.Duration `json:"retention"`
+
+	Export AuditLogExportConfiguration `json:"export"`
+}
+
+func (c *AuditLogConfiguration) Validate() error {
+	if c.Retention < 0 {
+		return fmt.Errorf("conf: audit log retention must not be negative, was %v", c.Retention.String())
+	}
+
+	if c.Retention > 0 && c.Retention < 24*time.Hour {
+		return fmt.Errorf("conf: audit log retention must be at least 24h, was %v", c.Retention.String())
+	}
+
+	return c.Export.Validate()
+}
+
+// AuditLogExportConfiguration configures streaming of new audit log entries
+// to an external sink. The Sink is one of stdout, syslog or http. For
+// syslog, URL is the address of the daemon (udp://host:514, tcp://host:514
+// or empty for the local daemon); for http it is the endpoint that receives
+// POSTed JSON arrays of entries.
+type AuditLogExportConfiguration struct {
+	Enabled bool   `json:"enabled"`
+	Sink    string `json:"sink" default:"stdout"`
+	URL     string `json:"url"`
+
+	// Name identifies the export cursor in the database, so that
+	// several independent exports can run off the same audit log.
+	Name string `json:"name" default:"default"`
+
+	// HTTPHeaders are sent with every request to an http sink, in the
+	// form header:value separated by commas.
+	HTTPHeaders map[string]string `json:"-" split_words:"true"`
+
+	Interval  time.Duration `json:"interval" default:"10s"`
+	BatchSize int           `json:"batch_size" split_words:"true" default:"100"`
+	Timeout   time.Duration `json:"timeout" default:"10s"`
+
+	// Settle is how far before the cursor entries are read again, to
+	// avoid skipping over entries from transactions that were still in
+	// flight when the cursor was advanced.
+	Settle time.Duration `json:"settle" default:"5s"`
+}
+
+func (c *AuditLogExportConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	switch c.Sink {
+	case "stdout":
+		// nothing to validate
+
+	case "syslog":
+		if c.URL != "" {
+			u, err := url.Parse(c.URL)
+			if err != nil {
+				return fmt.Errorf("conf: invalid audit log export syslog URL: %w", err)
+			}
+
+			if u.Scheme != "udp" && u.Scheme != "tcp" {
+				return fmt.Errorf("conf: audit log export syslog URL must use udp or tcp, was %q", u.Scheme)
+			}
+		}
+
+	case "http":
+		u, err := url.ParseRequestURI(c.URL)
+		if err != nil {
+			return fmt.Errorf("conf: invalid audit log export HTTP URL: %w", err)
+		}
+
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return fmt.Errorf("conf: audit log export HTTP URL must use http or https, was %q", u.Scheme)
+		}
+
+	default:
+		return fmt.Errorf("conf: unsupported audit log export sink %q", c.Sink)
+	}
+
+	if c.Name == "" {
+		return errors.New("conf: audit log export name must not be empty")
+	}
+
+	if c.Interval <= 0 || c.BatchSize <= 0 || c.Timeout <= 0 {
+		return errors.New("conf: audit log export interval, batch size and timeout must be positive")
+	}
+
+	return nil
 }
 
 type CORSConfiguration struct {
@@ -278,6 +793,17 @@ type EmailContentConfiguration struct {
 	EmailChange      string `json:"email_change" split_words:"true"`
 	MagicLink        string `json:"magic_link" split_words:"true"`
 	Reauthentication string `json:"reauthentication"`
+
+	// RefreshTokenReuse is the notification sent when a revoked refresh
+	// token is reused. It has no URL path.
+	RefreshTokenReuse string `json:"refresh_token_reuse" split_words:"true"`
+
+	// AccountDeletionScheduled, AccountDeletionCancelled and AccountDeleted
+	// are the notifications sent about the deletion of an account requested
+	// by its user. They have no URL path.
+	AccountDeletionScheduled string `json:"account_deletion_scheduled" split_words:"true"`
+	AccountDeletionCancelled string `json:"account_deletion_cancelled" split_words:"true"`
+	AccountDeleted           string `json:"account_deleted" split_words:"true"`
 }
 
 type ProviderConfiguration struct {
@@ -350,6 +876,8 @@ type SmsProviderConfiguration struct {
 	OtpExp            uint               `json:"otp_exp" split_words:"true"`
 	OtpLength         int                `json:"otp_length" split_words:"true"`
 	Provider          string             `json:"provider"`
+	FailoverProviders []string           `json:"failover_providers" split_words:"true"`
+	Routes            map[string]string  `json:"routes"`
 	Template          string             `json:"template"`
 	TestOTP           map[string]string  `json:"test_otp" split_words:"true"`
 	TestOTPValidUntil Time               `json:"test_otp_valid_until" split_words:"true"`
@@ -360,6 +888,136 @@ type SmsProviderConfiguration struct {
 	Messagebird  MessagebirdProviderConfiguration  `json:"messagebird"`
 	Textlocal    TextlocalProviderConfiguration    `json:"textlocal"`
 	Vonage       VonageProviderConfiguration       `json:"vonage"`
+	SNS          SNSProviderConfiguration          `json:"sns"`
+	Plivo        PlivoProviderConfiguration        `json:"plivo"`
+	Sinch        SinchProviderConfiguration        `json:"sinch"`
+	Infobip      InfobipProviderConfiguration      `json:"infobip"`
+	HTTP         HTTPSmsProviderConfiguration      `json:"http"`
+}
+
+// HasFailover reports whether messages can be sent with other providers
+// than Provider.
+func (c *SmsProviderConfiguration) HasFailover() bool {
+	return len(c.FailoverProviders) > 0 || len(c.Routes) > 0
+}
+
+// ProviderNames returns every provider that messages can be sent with:
+// Provider, the failover providers and the providers of every route.
+func (c *SmsProviderConfiguration) ProviderNames() []string {
+	names := []string{c.Provider}
+	names = append(names, c.FailoverProviders...)
+	for _, providers := range c.Routes {
+		names = append(names, splitRouteProviders(providers)...)
+	}
+	return uniqueStrings(names)
+}
+
+// ProvidersFor returns the providers to try, in order, to send a message to
+// phone. The providers of the route with the longest prefix of phone come
+// first, followed by Provider and the failover providers.
+func (c *SmsProviderConfiguration) ProvidersFor(phone string) []string {
+	var names []string
+
+	longest := -1
+	for prefix, providers := range c.Routes {
+		if strings.HasPrefix(phone, prefix) && len(prefix) > longest {
+			longest = len(prefix)
+			names = splitRouteProviders(providers)
+		}
+	}
+
+	names = append(names, c.Provider)
+	names = append(names, c.FailoverProviders...)
+	return uniqueStrings(names)
+}
+
+// ValidateFailover checks the routes, and that every provider messages can be
+// sent with is known and configured.
+func (c *SmsProviderConfiguration) ValidateFailover() error {
+	for prefix, providers := range c.Routes {
+		if !e164Prefix.MatchString(prefix) {
+			return fmt.Errorf("route prefix %q is not the start of an E.164 phone number", prefix)
+		}
+		if len(splitRouteProviders(providers)) == 0 {
+			return fmt.Errorf("route %q has no providers", prefix)
+		}
+	}
+
+	for _, name := range c.ProviderNames() {
+		if name == "twilio_verify" {
+			return errors.New("twilio_verify checks OTPs itself and cannot be used with failover providers or routes")
+		}
+		provider, ok := c.providerConfiguration(name)
+		if !ok {
+			return fmt.Errorf("unknown SMS provider %q", name)
+		}
+		if err := provider.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// splitRouteProviders splits the providers of a route, which are separated
+// with |.
+func splitRouteProviders(providers string) []string {
+	var names []string
+	for _, name := range strings.Split(providers, "|") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// providerConfiguration returns the configuration of the SMS provider
+// called name, if there is such a provider.
+func (c *SmsProviderConfiguration) providerConfiguration(name string) (validatable, bool) {
+	providers := map[string]validatable{
+		"twilio":        &c.Twilio,
+		"twilio_verify": &c.TwilioVerify,
+		"messagebird":   &c.Messagebird,
+		"textlocal":     &c.Textlocal,
+		"vonage":        &c.Vonage,
+		"sns":           &c.SNS,
+		"plivo":         &c.Plivo,
+		"sinch":         &c.Sinch,
+		"infobip":       &c.Infobip,
+		"http":          &c.HTTP,
+	}
+	provider, ok := providers[name]
+	return provider, ok
+}
+
+// PopulateTemplate compiles the SMS template when an SMS provider is set.
+func (c *SmsProviderConfiguration) PopulateTemplate() error {
+	if c.Provider == "" {
+		return nil
+	}
+
+	SMSTemplate := c.Template
+	if SMSTemplate == "" {
+		SMSTemplate = "Your code is {{ .Code }}"
+	}
+	template, err := template.New("").Parse(SMSTemplate)
+	if err != nil {
+		return err
+	}
+	c.SMSTemplate = template
+	return nil
 }
 
 func (c *SmsProviderConfiguration) GetTestOTP(phone string, now time.Time) (string, bool) {
@@ -373,37 +1031,112 @@ func (c *SmsProviderConfiguration) GetTestOTP(phone string, now time.Time) (stri
 
 type TwilioProviderConfiguration struct {
 	AccountSid        string `json:"account_sid" split_words:"true"`
-	AuthToken         string `json:"auth_token" split_words:"true"`
+	AuthToken         string `json:"auth_token" split_words:"true" secret:"true"`
 	MessageServiceSid string `json:"message_service_sid" split_words:"true"`
 	ContentSid        string `json:"content_sid" split_words:"true"`
 }
 
 type TwilioVerifyProviderConfiguration struct {
 	AccountSid        string `json:"account_sid" split_words:"true"`
-	AuthToken         string `json:"auth_token" split_words:"true"`
+	AuthToken         string `json:"auth_token" split_words:"true" secret:"true"`
 	MessageServiceSid string `json:"message_service_sid" split_words:"true"`
 }
 
 type MessagebirdProviderConfiguration struct {
-	AccessKey  string `json:"access_key" split_words:"true"`
+	AccessKey  string `json:"access_key" split_words:"true" secret:"true"`
 	Originator string `json:"originator" split_words:"true"`
 }
 
 type TextlocalProviderConfiguration struct {
-	ApiKey string `json:"api_key" split_words:"true"`
+	ApiKey string `json:"api_key" split_words:"true" secret:"true"`
 	Sender string `json:"sender" split_words:"true"`
 }
 
 type VonageProviderConfiguration struct {
-	ApiKey    string `json:"api_key" split_words:"true"`
-	ApiSecret string `json:"api_secret" split_words:"true"`
+	ApiKey    string `json:"api_key" split_words:"true" secret:"true"`
+	ApiSecret string `json:"api_secret" split_words:"true" secret:"true"`
 	From      string `json:"from" split_words:"true"`
 }
 
+// SNSProviderConfiguration sends SMS with Amazon SNS. SessionToken is only
+// needed with temporary credentials.
+type SNSProviderConfiguration struct {
+	AccessKeyID     string `json:"access_key_id" split_words:"true"`
+	SecretAccessKey string `json:"secret_access_key" split_words:"true" secret:"true"`
+	SessionToken    string `json:"session_token" split_words:"true" secret:"true"`
+	Region          string `json:"region"`
+	SenderID        string `json:"sender_id" split_words:"true"`
+	SMSType         string `json:"sms_type" split_words:"true" default:"Transactional"`
+
+	// BaseURL overrides the regional SNS endpoint.
+	BaseURL string `json:"base_url" split_words:"true"`
+}
+
+type PlivoProviderConfiguration struct {
+	AuthID    string `json:"auth_id" split_words:"true"`
+	AuthToken string `json:"auth_token" split_words:"true" secret:"true"`
+	From      string `json:"from"`
+
+	// BaseURL overrides the Plivo API URL.
+	BaseURL string `json:"base_url" split_words:"true"`
+}
+
+type SinchProviderConfiguration struct {
+	ServicePlanID string `json:"service_plan_id" split_words:"true"`
+	ApiToken      string `json:"api_token" split_words:"true" secret:"true"`
+	From          string `json:"from"`
+	Region        string `json:"region" default:"us"`
+
+	// BaseURL overrides the regional Sinch API URL.
+	BaseURL string `json:"base_url" split_words:"true"`
+}
+
+// InfobipProviderConfiguration sends SMS with Infobip. BaseURL is the API
+// base URL of the Infobip account.
+type InfobipProviderConfiguration struct {
+	ApiKey  string `json:"api_key" split_words:"true" secret:"true"`
+	Sender  string `json:"sender"`
+	BaseURL string `json:"base_url" split_words:"true"`
+}
+
+// defaultHTTPSmsBodyTemplate is the body of requests sent by the HTTP SMS
+// provider when no template is set.
+const defaultHTTPSmsBodyTemplate = `{"phone": {{ json .Phone }}, "message": {{ json .Message }}}`
+
+// HTTPSmsProviderConfiguration sends SMS with a request to any HTTP API. The
+// body is rendered from BodyTemplate with the Phone, Message, OTP and
+// Channel of the message. If MessageIDField is set, the message ID is read
+// from that field of a JSON response.
+type HTTPSmsProviderConfiguration struct {
+	URL            string            `json:"url"`
+	Method         string            `json:"method" default:"POST"`
+	Headers        map[string]string `json:"headers" secret:"true"`
+	ContentType    string            `json:"content_type" split_words:"true" default:"application/json"`
+	BodyTemplate   string            `json:"body_template" split_words:"true"`
+	MessageIDField string            `json:"message_id_field" split_words:"true"`
+}
+
+// ParseBodyTemplate parses the body template. In it, the json function
+// encodes a value as JSON.
+func (c *HTTPSmsProviderConfiguration) ParseBodyTemplate() (*template.Template, error) {
+	body := c.BodyTemplate
+	if body == "" {
+		body = defaultHTTPSmsBodyTemplate
+	}
+
+	return template.New("body").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(body)
+}
+
 type CaptchaConfiguration struct {
 	Enabled  bool   `json:"enabled" default:"false"`
 	Provider string `json:"provider" default:"hcaptcha"`
 	Secret   string `json:"provider_secret"`
+	SiteKey  string `json:"site_key" split_words:"true"`
 }
 
 func (c *CaptchaConfiguration) Validate() error {
@@ -479,6 +1212,10 @@ type SecurityConfiguration struct {
 	UpdatePasswordRequireReauthentication bool                 `json:"update_password_require_reauthentication" split_words:"true"`
 	ManualLinkingEnabled                  bool                 `json:"manual_linking_enabled" split_words:"true" default:"false"`
 
+	// RefreshTokenReuseNotificationEnabled emails users when one of their
+	// revoked refresh tokens is reused outside the reuse interval.
+	RefreshTokenReuseNotificationEnabled bool `json:"refresh_token_reuse_notification_enabled" split_words:"true"`
+
 	DBEncryption DatabaseEncryptionConfiguration `json:"database_encryption" split_words:"true"`
 }
 
@@ -495,15 +1232,14 @@ func (c *SecurityConfiguration) Validate() error {
 }
 
 func loadEnvironment(filename string) error {
-	var err error
 	if filename != "" {
-		err = godotenv.Overload(filename)
-	} else {
-		err = godotenv.Load()
-		// handle if .env file does not exist, this is OK
-		if os.IsNotExist(err) {
-			return nil
-		}
+		return loadEnvFile(filename, true)
+	}
+
+	err := loadEnvFile(".env", false)
+	// handle if .env file does not exist, this is OK
+	if os.IsNotExist(err) {
+		return nil
 	}
 	return err
 }
@@ -515,6 +1251,9 @@ type HookConfiguration struct {
 	CustomAccessToken           ExtensibilityPointConfiguration `json:"custom_access_token" split_words:"true"`
 	SendEmail                   ExtensibilityPointConfiguration `json:"send_email" split_words:"true"`
 	SendSMS                     ExtensibilityPointConfiguration `json:"send_sms" split_words:"true"`
+	RefreshTokenReuse           ExtensibilityPointConfiguration `json:"refresh_token_reuse" split_words:"true"`
+	UserMerged                  ExtensibilityPointConfiguration `json:"user_merged" split_words:"true"`
+	AnonymousUserExpiry         ExtensibilityPointConfiguration `json:"anonymous_user_expiry" split_words:"true"`
 }
 
 type HTTPHookSecrets []string
@@ -545,6 +1284,9 @@ func (h *HookConfiguration) Validate() error {
 		h.CustomAccessToken,
 		h.SendSMS,
 		h.SendEmail,
+		h.RefreshTokenReuse,
+		h.UserMerged,
+		h.AnonymousUserExpiry,
 	}
 	for _, point := range points {
 		if err := point.ValidateExtensibilityPoint(); err != nil {
@@ -626,11 +1368,12 @@ func LoadGlobal(filename string) (*GlobalConfiguration, error) {
 		return nil, err
 	}
 
-	config := new(GlobalConfiguration)
+	return loadGlobalFromEnvironment()
+}
 
-	// although the package is called "auth" it used to be called "gotrue"
-	// so environment configs will remain to be called "GOTRUE"
-	if err := envconfig.Process("gotrue", config); err != nil {
+func loadGlobalFromEnvironment() (*GlobalConfiguration, error) {
+	config, err := processEnvironment()
+	if err != nil {
 		return nil, err
 	}
 
@@ -642,55 +1385,67 @@ func LoadGlobal(filename string) (*GlobalConfiguration, error) {
 		return nil, err
 	}
 
-	if config.Hook.PasswordVerificationAttempt.Enabled {
-		if err := config.Hook.PasswordVerificationAttempt.PopulateExtensibilityPoint(); err != nil {
-			return nil, err
+	for _, h := range config.hooks() {
+		if h.hook.Enabled {
+			if err := h.hook.PopulateExtensibilityPoint(); err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	if config.Hook.SendSMS.Enabled {
-		if err := config.Hook.SendSMS.PopulateExtensibilityPoint(); err != nil {
-			return nil, err
-		}
-	}
-	if config.Hook.SendEmail.Enabled {
-		if err := config.Hook.SendEmail.PopulateExtensibilityPoint(); err != nil {
-			return nil, err
-		}
+	if err := config.populateSAML(); err != nil {
+		return nil, err
 	}
 
-	if config.Hook.MFAVerificationAttempt.Enabled {
-		if err := config.Hook.MFAVerificationAttempt.PopulateExtensibilityPoint(); err != nil {
-			return nil, err
-		}
+	if err := config.Sms.PopulateTemplate(); err != nil {
+		return nil, err
 	}
+	return config, nil
+}
 
-	if config.Hook.CustomAccessToken.Enabled {
-		if err := config.Hook.CustomAccessToken.PopulateExtensibilityPoint(); err != nil {
-			return nil, err
-		}
+// processEnvironment reads the configuration from the environment, without
+// applying defaults or validating it.
+func processEnvironment() (*GlobalConfiguration, error) {
+	config := new(GlobalConfiguration)
+
+	// although the package is called "auth" it used to be called "gotrue"
+	// so environment configs will remain to be called "GOTRUE"
+	if err := envconfig.Process("gotrue", config); err != nil {
+		return nil, err
 	}
 
-	if config.SAML.Enabled {
-		if err := config.SAML.PopulateFields(config.API.ExternalURL); err != nil {
-			return nil, err
-		}
-	} else {
-		config.SAML.PrivateKey = ""
+	return config, nil
+}
+
+// hooks returns every extensibility point with its name.
+func (c *GlobalConfiguration) hooks() []struct {
+	name string
+	hook *ExtensibilityPointConfiguration
+} {
+	return []struct {
+		name string
+		hook *ExtensibilityPointConfiguration
+	}{
+		{"mfa_verification_attempt", &c.Hook.MFAVerificationAttempt},
+		{"password_verification_attempt", &c.Hook.PasswordVerificationAttempt},
+		{"custom_access_token", &c.Hook.CustomAccessToken},
+		{"send_email", &c.Hook.SendEmail},
+		{"send_sms", &c.Hook.SendSMS},
+		{"refresh_token_reuse", &c.Hook.RefreshTokenReuse},
+		{"user_merged", &c.Hook.UserMerged},
+		{"anonymous_user_expiry", &c.Hook.AnonymousUserExpiry},
 	}
+}
 
-	if config.Sms.Provider != "" {
-		SMSTemplate := config.Sms.Template
-		if SMSTemplate == "" {
-			SMSTemplate = "Your code is {{ .Code }}"
-		}
-		template, err := template.New("").Parse(SMSTemplate)
-		if err != nil {
-			return nil, err
-		}
-		config.Sms.SMSTemplate = template
+// populateSAML loads the SAML keys, or clears the private key when SAML is
+// disabled.
+func (c *GlobalConfiguration) populateSAML() error {
+	if !c.SAML.Enabled {
+		c.SAML.PrivateKey = ""
+		return nil
 	}
-	return config, nil
+
+	return c.SAML.PopulateFields(c.API.ExternalURL)
 }
 
 // ApplyDefaults sets defaults for a GlobalConfiguration
@@ -762,6 +1517,15 @@ func (config *GlobalConfiguration) ApplyDefaults() error {
 		config.Sms.TestOTP = formatTestOtps
 	}
 
+	if config.Sms.Routes != nil {
+		formatRoutes := make(map[string]string)
+		for prefix, providers := range config.Sms.Routes {
+			prefix = strings.ReplaceAll(strings.TrimPrefix(prefix, "+"), " ", "")
+			formatRoutes[prefix] = providers
+		}
+		config.Sms.Routes = formatRoutes
+	}
+
 	if len(config.Sms.Template) == 0 {
 		config.Sms.Template = ""
 	}
@@ -810,24 +1574,46 @@ func (config *GlobalConfiguration) ApplyDefaults() error {
 	return nil
 }
 
+type validatable interface {
+	Validate() error
+}
+
+// validatables returns the sections of the configuration that can be
+// validated, by name.
+func (c *GlobalConfiguration) validatables() []struct {
+	name string
+	validatable
+} {
+	return []struct {
+		name string
+		validatable
+	}{
+		{"api", &c.API},
+		{"db", &c.DB},
+		{"tracing", &c.Tracing},
+		{"metrics", &c.Metrics},
+		{"smtp", &c.SMTP},
+		{"saml", &c.SAML},
+		{"security", &c.Security},
+		{"sessions", &c.Sessions},
+		{"hook", &c.Hook},
+		{"audit_log", &c.AuditLog},
+		{"impersonation", &c.Impersonation},
+		{"token_exchange", &c.TokenExchange},
+		{"hosted_ui", &c.HostedUI},
+		{"device_authorization", &c.DeviceAuthorization},
+		{"service_clients", &c.ServiceClients},
+		{"api_keys", &c.APIKeys},
+		{"dpop", &c.DPoP},
+		{"anonymous_users", &c.External.AnonymousUsers},
+		{"account_deletion", &c.AccountDeletion},
+	}
+}
+
 // Validate validates all of configuration.
 func (c *GlobalConfiguration) Validate() error {
-	validatables := []interface {
-		Validate() error
-	}{
-		&c.API,
-		&c.DB,
-		&c.Tracing,
-		&c.Metrics,
-		&c.SMTP,
-		&c.SAML,
-		&c.Security,
-		&c.Sessions,
-		&c.Hook,
-	}
-
-	for _, validatable := range validatables {
-		if err := validatable.Validate(); err != nil {
+	for _, section := range c.validatables() {
+		if err := section.Validate(); err != nil {
 			return err
 		}
 	}
@@ -910,6 +1696,77 @@ func (t *VonageProviderConfiguration) Validate() error {
 	return nil
 }
 
+func (t *SNSProviderConfiguration) Validate() error {
+	if t.AccessKeyID == "" {
+		return errors.New("missing SNS access key ID")
+	}
+	if t.SecretAccessKey == "" {
+		return errors.New("missing SNS secret access key")
+	}
+	if t.Region == "" && t.BaseURL == "" {
+		return errors.New("missing SNS region")
+	}
+	if t.SMSType != "Transactional" && t.SMSType != "Promotional" {
+		return fmt.Errorf("SNS SMS type must be Transactional or Promotional, was %q", t.SMSType)
+	}
+	return nil
+}
+
+func (t *PlivoProviderConfiguration) Validate() error {
+	if t.AuthID == "" {
+		return errors.New("missing Plivo auth ID")
+	}
+	if t.AuthToken == "" {
+		return errors.New("missing Plivo auth token")
+	}
+	if t.From == "" {
+		return errors.New("missing Plivo 'from' parameter")
+	}
+	return nil
+}
+
+func (t *SinchProviderConfiguration) Validate() error {
+	if t.ServicePlanID == "" {
+		return errors.New("missing Sinch service plan ID")
+	}
+	if t.ApiToken == "" {
+		return errors.New("missing Sinch API token")
+	}
+	if t.From == "" {
+		return errors.New("missing Sinch 'from' parameter")
+	}
+	if t.Region == "" && t.BaseURL == "" {
+		return errors.New("missing Sinch region")
+	}
+	return nil
+}
+
+func (t *InfobipProviderConfiguration) Validate() error {
+	if t.ApiKey == "" {
+		return errors.New("missing Infobip API key")
+	}
+	if t.Sender == "" {
+		return errors.New("missing Infobip sender")
+	}
+	if t.BaseURL == "" {
+		return errors.New("missing Infobip base URL")
+	}
+	return nil
+}
+
+func (t *HTTPSmsProviderConfiguration) Validate() error {
+	if u, err := url.ParseRequestURI(t.URL); err != nil || u.Host == "" {
+		return fmt.Errorf("HTTP SMS provider URL must be an absolute URL, was %q", t.URL)
+	}
+	if t.Method == "" {
+		return errors.New("missing HTTP SMS provider method")
+	}
+	if _, err := t.ParseBodyTemplate(); err != nil {
+		return fmt.Errorf("invalid HTTP SMS provider body template: %w", err)
+	}
+	return nil
+}
+
 func (t *SmsProviderConfiguration) IsTwilioVerifyProvider() bool {
 	return t.Provider == "twilio_verify"
 }
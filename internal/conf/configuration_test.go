@@ -1,8 +1,10 @@
 package conf
 
 import (
+	"encoding/json"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -211,3 +213,313 @@ func TestValidateExtensibilityPointSecrets(t *testing.T) {
 	}
 
 }
+
+func TestValidateAuditLogConfiguration(t *testing.T) {
+	export := AuditLogExportConfiguration{
+		Enabled:   true,
+		Name:      "default",
+		Interval:  10 * time.Second,
+		BatchSize: 100,
+		Timeout:   10 * time.Second,
+	}
+
+	cases := []struct {
+		desc        string
+		retention   time.Duration
+		sink        string
+		url         string
+		expectError bool
+	}{
+		// Positive test cases
+		{desc: "Stdout sink", sink: "stdout", expectError: false},
+		{desc: "Local syslog sink", sink: "syslog", expectError: false},
+		{desc: "Remote syslog sink", sink: "syslog", url: "udp://localhost:514", expectError: false},
+		{desc: "HTTP sink", sink: "http", url: "https://siem.example.com/ingest", expectError: false},
+		{desc: "Retention", sink: "stdout", retention: 90 * 24 * time.Hour, expectError: false},
+
+		// Negative test cases
+		{desc: "Unknown sink", sink: "kafka", expectError: true},
+		{desc: "HTTP sink without URL", sink: "http", expectError: true},
+		{desc: "Syslog over HTTP", sink: "syslog", url: "https://localhost:514", expectError: true},
+		{desc: "Short retention", sink: "stdout", retention: time.Hour, expectError: true},
+	}
+
+	for _, tc := range cases {
+		config := AuditLogConfiguration{Retention: tc.retention, Export: export}
+		config.Export.Sink = tc.sink
+		config.Export.URL = tc.url
+
+		err := config.Validate()
+		if tc.expectError {
+			require.Error(t, err, tc.desc)
+		} else {
+			require.NoError(t, err, tc.desc)
+		}
+	}
+}
+
+func TestValidateImpersonationConfiguration(t *testing.T) {
+	cases := []struct {
+		desc        string
+		enabled     bool
+		duration    time.Duration
+		expectError bool
+	}{
+		{desc: "Disabled", enabled: false, duration: 0, expectError: false},
+		{desc: "Default duration", enabled: true, duration: 15 * time.Minute, expectError: false},
+		{desc: "Zero duration", enabled: true, duration: 0, expectError: true},
+		{desc: "Long duration", enabled: true, duration: 2 * time.Hour, expectError: true},
+	}
+
+	for _, tc := range cases {
+		config := ImpersonationConfiguration{Enabled: tc.enabled, Duration: tc.duration}
+
+		err := config.Validate()
+		if tc.expectError {
+			require.Error(t, err, tc.desc)
+		} else {
+			require.NoError(t, err, tc.desc)
+		}
+	}
+}
+
+func TestValidateTokenExchangeConfiguration(t *testing.T) {
+	cases := []struct {
+		desc        string
+		config      TokenExchangeConfiguration
+		expectError bool
+	}{
+		{desc: "Disabled", config: TokenExchangeConfiguration{}, expectError: false},
+		{desc: "Valid", config: TokenExchangeConfiguration{Enabled: true, Exp: 300, AllowedScopes: []string{"invoices:read"}}, expectError: false},
+		{desc: "Zero expiry", config: TokenExchangeConfiguration{Enabled: true, Exp: 0}, expectError: true},
+		{desc: "Empty scope", config: TokenExchangeConfiguration{Enabled: true, Exp: 300, AllowedScopes: []string{""}}, expectError: true},
+		{desc: "Scope with whitespace", config: TokenExchangeConfiguration{Enabled: true, Exp: 300, AllowedScopes: []string{"a b"}}, expectError: true},
+	}
+
+	for _, tc := range cases {
+		err := tc.config.Validate()
+		if tc.expectError {
+			require.Error(t, err, tc.desc)
+		} else {
+			require.NoError(t, err, tc.desc)
+		}
+	}
+}
+
+func TestValidateHostedUIConfiguration(t *testing.T) {
+	theme := HostedUIThemeConfiguration{
+		PrimaryColor:    "#3ecf8e",
+		BackgroundColor: "#fff",
+		TextColor:       "#1c1c1c",
+	}
+
+	withTheme := func(change func(*HostedUIThemeConfiguration)) HostedUIConfiguration {
+		c := HostedUIConfiguration{Enabled: true, Theme: theme}
+		change(&c.Theme)
+		return c
+	}
+
+	cases := []struct {
+		desc        string
+		config      HostedUIConfiguration
+		expectError bool
+	}{
+		{desc: "Disabled", config: HostedUIConfiguration{}, expectError: false},
+		{desc: "Valid", config: withTheme(func(t *HostedUIThemeConfiguration) { t.LogoURL = "https://example.com/logo.png" }), expectError: false},
+		{desc: "Invalid color", config: withTheme(func(t *HostedUIThemeConfiguration) { t.PrimaryColor = "red; background: url(x)" }), expectError: true},
+		{desc: "Relative logo URL", config: withTheme(func(t *HostedUIThemeConfiguration) { t.LogoURL = "/logo.png" }), expectError: true},
+		{desc: "Relative CSS URL", config: withTheme(func(t *HostedUIThemeConfiguration) { t.CSSURL = "auth.css" }), expectError: true},
+	}
+
+	for _, tc := range cases {
+		err := tc.config.Validate()
+		if tc.expectError {
+			require.Error(t, err, tc.desc)
+		} else {
+			require.NoError(t, err, tc.desc)
+		}
+	}
+}
+
+func TestValidateServiceClientsConfiguration(t *testing.T) {
+	require.NoError(t, (&ServiceClientsConfiguration{}).Validate())
+	require.NoError(t, (&ServiceClientsConfiguration{Enabled: true, Exp: 3600}).Validate())
+	require.Error(t, (&ServiceClientsConfiguration{Enabled: true, Exp: 0}).Validate())
+}
+
+func TestSessionPoliciesDecode(t *testing.T) {
+	var policies SessionPolicies
+	require.NoError(t, policies.Decode(`[{"role":"admin","timebox":"8h","inactivity_timeout":"15m"},{"app_metadata":{"plan":"free"},"single_per_user":true}]`))
+	require.Len(t, policies, 2)
+
+	require.Equal(t, "admin", policies[0].Role)
+	require.Equal(t, 8*time.Hour, *policies[0].Timebox)
+	require.Equal(t, 15*time.Minute, *policies[0].InactivityTimeout)
+	require.Nil(t, policies[0].SinglePerUser)
+
+	require.Equal(t, map[string]interface{}{"plan": "free"}, policies[1].AppMetadata)
+	require.Nil(t, policies[1].Timebox)
+	require.True(t, *policies[1].SinglePerUser)
+
+	encoded, err := json.Marshal(policies[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{"role":"admin","timebox":"8h0m0s","inactivity_timeout":"15m0s"}`, string(encoded))
+
+	require.Error(t, policies.Decode(`[{"timebox":"eight hours"}]`))
+	require.Error(t, policies.Decode(`{"role":"admin"}`))
+
+	negative := -time.Hour
+	require.Error(t, (&SessionsConfiguration{Policies: SessionPolicies{{Timebox: &negative}}}).Validate())
+
+	// policies can only shorten the global limits
+	global, short, long := 24*time.Hour, time.Hour, 48*time.Hour
+	require.NoError(t, (&SessionsConfiguration{Timebox: &global, InactivityTimeout: &global, Policies: SessionPolicies{{Timebox: &short, InactivityTimeout: &short}}}).Validate())
+	require.NoError(t, (&SessionsConfiguration{Policies: SessionPolicies{{Timebox: &long, InactivityTimeout: &long}}}).Validate())
+	require.Error(t, (&SessionsConfiguration{Timebox: &global, Policies: SessionPolicies{{Timebox: &long}}}).Validate())
+	require.Error(t, (&SessionsConfiguration{InactivityTimeout: &global, Policies: SessionPolicies{{InactivityTimeout: &long}}}).Validate())
+}
+
+func TestValidateAnonymousProviderConfiguration(t *testing.T) {
+	valid := AnonymousProviderConfiguration{
+		Enabled:          true,
+		Retention:        30 * 24 * time.Hour,
+		CleanupBatchSize: 100,
+		ReminderBefore:   7 * 24 * time.Hour,
+		MaxSessionsPerIP: 10,
+	}
+	require.NoError(t, valid.Validate())
+	require.NoError(t, (&AnonymousProviderConfiguration{}).Validate())
+
+	cases := []func(c *AnonymousProviderConfiguration){
+		func(c *AnonymousProviderConfiguration) { c.Retention = time.Minute },
+		func(c *AnonymousProviderConfiguration) { c.CleanupBatchSize = 0 },
+		func(c *AnonymousProviderConfiguration) { c.CleanupBatchSize = 5000 },
+		func(c *AnonymousProviderConfiguration) { c.ReminderBefore = c.Retention },
+		func(c *AnonymousProviderConfiguration) { c.ReminderBefore = -time.Hour },
+		func(c *AnonymousProviderConfiguration) { c.MaxSessionsPerIP = -1 },
+	}
+
+	for i, mutate := range cases {
+		c := valid
+		mutate(&c)
+		require.Error(t, c.Validate(), "case %d", i)
+	}
+}
+
+func TestValidateAccountDeletionConfiguration(t *testing.T) {
+	require.NoError(t, (&AccountDeletionConfiguration{}).Validate())
+	require.NoError(t, (&AccountDeletionConfiguration{Enabled: true, GracePeriod: 14 * 24 * time.Hour, BatchSize: 100}).Validate())
+	require.NoError(t, (&AccountDeletionConfiguration{Enabled: true, GracePeriod: 0, BatchSize: 1}).Validate())
+	require.Error(t, (&AccountDeletionConfiguration{Enabled: true, GracePeriod: -time.Hour, BatchSize: 100}).Validate())
+	require.Error(t, (&AccountDeletionConfiguration{Enabled: true, GracePeriod: time.Hour, BatchSize: 0}).Validate())
+	require.Error(t, (&AccountDeletionConfiguration{Enabled: true, GracePeriod: time.Hour, BatchSize: 5000}).Validate())
+}
+
+func TestValidateSmsProviderConfigurations(t *testing.T) {
+	sms := SmsProviderConfiguration{
+		SNS: SNSProviderConfiguration{
+			AccessKeyID:     "test_access_key_id",
+			SecretAccessKey: "test_secret_access_key",
+			Region:          "eu-west-1",
+			SMSType:         "Transactional",
+		},
+		Plivo:   PlivoProviderConfiguration{AuthID: "test_auth_id", AuthToken: "test_auth_token", From: "test_from"},
+		Sinch:   SinchProviderConfiguration{ServicePlanID: "test_service_plan_id", ApiToken: "test_api_token", From: "test_from", Region: "eu"},
+		Infobip: InfobipProviderConfiguration{ApiKey: "test_api_key", Sender: "test_sender", BaseURL: "example.api.infobip.com"},
+		HTTP:    HTTPSmsProviderConfiguration{URL: "https://sms.example.com/send", Method: "POST"},
+	}
+
+	for _, name := range []string{"sns", "plivo", "sinch", "infobip", "http"} {
+		provider, ok := sms.providerConfiguration(name)
+		require.True(t, ok, name)
+		require.NoError(t, provider.Validate(), name)
+	}
+
+	_, ok := sms.providerConfiguration("unknown")
+	require.False(t, ok)
+
+	require.Error(t, (&SNSProviderConfiguration{AccessKeyID: "id", SecretAccessKey: "secret", Region: "eu-west-1", SMSType: "Urgent"}).Validate())
+	require.Error(t, (&SinchProviderConfiguration{ServicePlanID: "id", ApiToken: "token", From: "from"}).Validate())
+	require.Error(t, (&InfobipProviderConfiguration{ApiKey: "key", Sender: "sender"}).Validate())
+	require.Error(t, (&HTTPSmsProviderConfiguration{URL: "/send", Method: "POST"}).Validate())
+	require.Error(t, (&HTTPSmsProviderConfiguration{URL: "https://sms.example.com", Method: "POST", BodyTemplate: "{{ .Phone"}).Validate())
+}
+
+func TestSmsProviderFailover(t *testing.T) {
+	sms := SmsProviderConfiguration{
+		Provider:          "twilio",
+		FailoverProviders: []string{"sns", "twilio"},
+		Routes: map[string]string{
+			"44":  "sinch | plivo",
+			"447": "infobip",
+		},
+		Twilio: TwilioProviderConfiguration{AccountSid: "test_account_sid", AuthToken: "test_auth_token", MessageServiceSid: "test_message_service_sid"},
+		SNS: SNSProviderConfiguration{
+			AccessKeyID:     "test_access_key_id",
+			SecretAccessKey: "test_secret_access_key",
+			Region:          "eu-west-1",
+			SMSType:         "Transactional",
+		},
+		Plivo:   PlivoProviderConfiguration{AuthID: "test_auth_id", AuthToken: "test_auth_token", From: "test_from"},
+		Sinch:   SinchProviderConfiguration{ServicePlanID: "test_service_plan_id", ApiToken: "test_api_token", From: "test_from", Region: "eu"},
+		Infobip: InfobipProviderConfiguration{ApiKey: "test_api_key", Sender: "test_sender", BaseURL: "example.api.infobip.com"},
+	}
+
+	require.True(t, sms.HasFailover())
+	require.NoError(t, sms.ValidateFailover())
+	require.ElementsMatch(t, []string{"twilio", "sns", "sinch", "plivo", "infobip"}, sms.ProviderNames())
+
+	require.Equal(t, []string{"twilio", "sns"}, sms.ProvidersFor("15555555555"))
+	require.Equal(t, []string{"sinch", "plivo", "twilio", "sns"}, sms.ProvidersFor("442071234567"))
+	require.Equal(t, []string{"infobip", "twilio", "sns"}, sms.ProvidersFor("447700900123"))
+
+	sms.Routes["91"] = "unknown"
+	require.Error(t, sms.ValidateFailover())
+	delete(sms.Routes, "91")
+
+	sms.Routes["+91"] = "plivo"
+	require.Error(t, sms.ValidateFailover())
+	delete(sms.Routes, "+91")
+
+	sms.FailoverProviders = []string{"twilio_verify"}
+	require.Error(t, sms.ValidateFailover())
+
+	require.False(t, (&SmsProviderConfiguration{Provider: "twilio"}).HasFailover())
+}
+
+func TestValidateDPoPConfiguration(t *testing.T) {
+	require.NoError(t, (&DPoPConfiguration{}).Validate())
+	require.NoError(t, (&DPoPConfiguration{Enabled: true, ProofMaxAge: time.Minute}).Validate())
+	require.Error(t, (&DPoPConfiguration{Enabled: true, ProofMaxAge: 0}).Validate())
+	require.Error(t, (&DPoPConfiguration{Enabled: true, ProofMaxAge: time.Hour}).Validate())
+}
+
+func TestValidateAPIKeysConfiguration(t *testing.T) {
+	require.NoError(t, (&APIKeysConfiguration{}).Validate())
+	require.NoError(t, (&APIKeysConfiguration{Enabled: true, Exp: 300, MaxPerUser: 25}).Validate())
+	require.Error(t, (&APIKeysConfiguration{Enabled: true, Exp: 0, MaxPerUser: 25}).Validate())
+	require.Error(t, (&APIKeysConfiguration{Enabled: true, Exp: 300, MaxPerUser: 0}).Validate())
+}
+
+func TestValidateDeviceAuthorizationConfiguration(t *testing.T) {
+	cases := []struct {
+		desc        string
+		config      DeviceAuthorizationConfiguration
+		expectError bool
+	}{
+		{desc: "Disabled", config: DeviceAuthorizationConfiguration{}, expectError: false},
+		{desc: "Valid", config: DeviceAuthorizationConfiguration{Enabled: true, Expiry: 10 * time.Minute, PollingInterval: 5 * time.Second, VerificationURI: "https://example.com/device"}, expectError: false},
+		{desc: "Expiry too long", config: DeviceAuthorizationConfiguration{Enabled: true, Expiry: 2 * time.Hour, PollingInterval: 5 * time.Second}, expectError: true},
+		{desc: "Polling interval too short", config: DeviceAuthorizationConfiguration{Enabled: true, Expiry: 10 * time.Minute, PollingInterval: 0}, expectError: true},
+		{desc: "Relative verification URI", config: DeviceAuthorizationConfiguration{Enabled: true, Expiry: 10 * time.Minute, PollingInterval: 5 * time.Second, VerificationURI: "/device"}, expectError: true},
+	}
+
+	for _, tc := range cases {
+		err := tc.config.Validate()
+		if tc.expectError {
+			require.Error(t, err, tc.desc)
+		} else {
+			require.NoError(t, err, tc.desc)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// overridableSections are the fields of GlobalConfiguration, by JSON name,
+// that can be overridden from the database.
+var overridableSections = map[string]bool{
+	"external": true,
+	"mailer":   true,
+	"sms":      true,
+	"mfa":      true,
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func findJSONField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.IsExported() && name != "-" && jsonFieldName(f) == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// decodeJSONWithNumbers keeps numbers as json.Number, so that large
+// integers such as durations in nanoseconds don't lose precision.
+func decodeJSONWithNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// ValidateOverrideKey checks that the key is the path of an overridable
+// field, made of the JSON names of the fields separated by dots, such as
+// external.github.secret or mailer.subjects.invite.
+func ValidateOverrideKey(key string) error {
+	path := strings.Split(key, ".")
+	if !overridableSections[path[0]] {
+		return fmt.Errorf("conf: %q can't be overridden", key)
+	}
+
+	t := reflect.TypeOf(GlobalConfiguration{})
+	for _, name := range path {
+		if t.Kind() != reflect.Struct {
+			return fmt.Errorf("conf: %q is not a configuration field", key)
+		}
+
+		f, ok := findJSONField(t, name)
+		if !ok {
+			return fmt.Errorf("conf: %q is not a configuration field", key)
+		}
+		t = f.Type
+	}
+
+	if t.Kind() == reflect.Struct && t != reflect.TypeOf(Time{}) {
+		return fmt.Errorf("conf: %q is a configuration section, override its fields instead", key)
+	}
+
+	return nil
+}
+
+// IsSecretOverride reports whether the overridden field holds a secret,
+// which must be stored encrypted and never be returned. Secret fields, or
+// the sections holding them, are tagged with secret:"true".
+func IsSecretOverride(key string) bool {
+	t := reflect.TypeOf(GlobalConfiguration{})
+	for _, name := range strings.Split(key, ".") {
+		if t.Kind() != reflect.Struct {
+			return false
+		}
+
+		f, ok := findJSONField(t, name)
+		if !ok {
+			return false
+		}
+		if f.Tag.Get("secret") == "true" {
+			return true
+		}
+		t = f.Type
+	}
+	return false
+}
+
+// copyUnencodedFields copies the fields tagged json:"-" from src to dst, so
+// that they survive overriding a section through JSON.
+func copyUnencodedFields(dst, src reflect.Value) {
+	for i := 0; i < src.NumField(); i++ {
+		f := src.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		if f.Tag.Get("json") == "-" {
+			dst.Field(i).Set(src.Field(i))
+		} else if f.Type.Kind() == reflect.Struct {
+			copyUnencodedFields(dst.Field(i), src.Field(i))
+		}
+	}
+}
+
+// ApplyOverrides returns a copy of the configuration with the overrides
+// applied. Overrides map keys as accepted by ValidateOverrideKey to JSON
+// encoded values. The resulting configuration is validated.
+func (c *GlobalConfiguration) ApplyOverrides(overrides map[string]json.RawMessage) (*GlobalConfiguration, error) {
+	merged := *c
+
+	if len(overrides) == 0 {
+		return &merged, nil
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	target := reflect.ValueOf(&merged).Elem()
+
+	// sections are overridden by encoding them to JSON, replacing the
+	// overridden values and decoding them again, so that values are
+	// decoded exactly like their types expect
+	sections := make(map[string]map[string]interface{})
+
+	for _, key := range keys {
+		if err := ValidateOverrideKey(key); err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if err := decodeJSONWithNumbers(overrides[key], &value); err != nil {
+			return nil, fmt.Errorf("conf: invalid value for %q: %w", key, err)
+		}
+
+		path := strings.Split(key, ".")
+
+		section, ok := sections[path[0]]
+		if !ok {
+			f, _ := findJSONField(target.Type(), path[0])
+
+			encoded, err := json.Marshal(target.FieldByIndex(f.Index).Interface())
+			if err != nil {
+				return nil, err
+			}
+
+			if err := decodeJSONWithNumbers(encoded, &section); err != nil {
+				return nil, err
+			}
+
+			sections[path[0]] = section
+		}
+
+		node := section
+		for _, name := range path[1 : len(path)-1] {
+			child, ok := node[name].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[name] = child
+			}
+			node = child
+		}
+		node[path[len(path)-1]] = value
+	}
+
+	for name, section := range sections {
+		f, _ := findJSONField(target.Type(), name)
+
+		encoded, err := json.Marshal(section)
+		if err != nil {
+			return nil, err
+		}
+
+		value := reflect.New(f.Type)
+		if err := json.Unmarshal(encoded, value.Interface()); err != nil {
+			return nil, fmt.Errorf("conf: invalid override of %q: %w", name, err)
+		}
+		copyUnencodedFields(value.Elem(), target.FieldByIndex(f.Index))
+
+		target.FieldByIndex(f.Index).Set(value.Elem())
+	}
+
+	if _, ok := sections["sms"]; ok {
+		if err := merged.Sms.PopulateTemplate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := merged.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &merged, nil
+}
@@ -0,0 +1,133 @@
+package conf
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOverrideKey(t *testing.T) {
+	cases := []struct {
+		key         string
+		expectError bool
+	}{
+		{key: "external.github.enabled", expectError: false},
+		{key: "external.github.client_id", expectError: false},
+		{key: "mailer.subjects.invite", expectError: false},
+		{key: "sms.template", expectError: false},
+		{key: "mfa.max_enrolled_factors", expectError: false},
+		{key: "external.github", expectError: true},
+		{key: "external.github.unknown", expectError: true},
+		{key: "external.github.secret.value", expectError: true},
+		{key: "sms.SMSTemplate", expectError: true},
+		{key: "jwt.secret", expectError: true},
+		{key: "", expectError: true},
+	}
+
+	for _, tc := range cases {
+		err := ValidateOverrideKey(tc.key)
+		if tc.expectError {
+			require.Error(t, err, tc.key)
+		} else {
+			require.NoError(t, err, tc.key)
+		}
+	}
+}
+
+func TestIsSecretOverride(t *testing.T) {
+	require.True(t, IsSecretOverride("external.github.secret"))
+	require.True(t, IsSecretOverride("sms.twilio.auth_token"))
+	require.False(t, IsSecretOverride("external.github.client_id"))
+
+	for _, key := range []string{
+		"sms.sns.secret_access_key",
+		"sms.sns.session_token",
+		"sms.sinch.api_token",
+		"sms.plivo.auth_token",
+		"sms.infobip.api_key",
+		"sms.http.headers",
+	} {
+		require.True(t, IsSecretOverride(key), key)
+	}
+	require.False(t, IsSecretOverride("sms.sns.access_key_id"))
+	require.False(t, IsSecretOverride("sms.http.url"))
+}
+
+func TestApplyOverridesKeepsUnencodedFields(t *testing.T) {
+	current := &GlobalConfiguration{
+		API: APIConfiguration{ExternalURL: "http://localhost:9999"},
+		Sms: SmsProviderConfiguration{
+			Provider: "http",
+			HTTP: HTTPSmsProviderConfiguration{
+				URL:     "https://sms.example.com/send",
+				Method:  "POST",
+				Headers: map[string]string{"Authorization": "Bearer token"},
+			},
+		},
+	}
+	require.NoError(t, current.Sms.PopulateTemplate())
+
+	merged, err := current.ApplyOverrides(map[string]json.RawMessage{
+		"sms.template": json.RawMessage(`"Code: {{ .Code }}"`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"Authorization": "Bearer token"}, merged.Sms.HTTP.Headers)
+
+	merged, err = current.ApplyOverrides(map[string]json.RawMessage{
+		"sms.http.headers": json.RawMessage(`{"Authorization": "Bearer rotated"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"Authorization": "Bearer rotated"}, merged.Sms.HTTP.Headers)
+}
+
+func TestApplyOverrides(t *testing.T) {
+	current := &GlobalConfiguration{
+		API: APIConfiguration{ExternalURL: "http://localhost:9999"},
+		External: ProviderConfiguration{
+			Github: OAuthProviderConfiguration{
+				ClientID: []string{"client"},
+			},
+			AllowedIdTokenIssuers:   []string{"https://accounts.google.com"},
+			FlowStateExpiryDuration: 300 * time.Second,
+		},
+		Sms: SmsProviderConfiguration{
+			Provider: "twilio",
+		},
+	}
+	require.NoError(t, current.Sms.PopulateTemplate())
+
+	merged, err := current.ApplyOverrides(map[string]json.RawMessage{
+		"external.github.enabled":  json.RawMessage(`true`),
+		"external.github.secret":   json.RawMessage(`"secret"`),
+		"mailer.subjects.invite":   json.RawMessage(`"Join us"`),
+		"sms.template":             json.RawMessage(`"Code: {{ .Code }}"`),
+		"mfa.max_enrolled_factors": json.RawMessage(`5`),
+	})
+	require.NoError(t, err)
+
+	require.True(t, merged.External.Github.Enabled)
+	require.Equal(t, "secret", merged.External.Github.Secret)
+	require.Equal(t, []string{"client"}, merged.External.Github.ClientID)
+	require.Equal(t, []string{"https://accounts.google.com"}, merged.External.AllowedIdTokenIssuers)
+	require.Equal(t, 300*time.Second, merged.External.FlowStateExpiryDuration)
+	require.Equal(t, "Join us", merged.Mailer.Subjects.Invite)
+	require.Equal(t, "Code: {{ .Code }}", merged.Sms.Template)
+	require.NotNil(t, merged.Sms.SMSTemplate)
+	require.Equal(t, float64(5), merged.MFA.MaxEnrolledFactors)
+
+	// the current configuration is left untouched
+	require.False(t, current.External.Github.Enabled)
+	require.Equal(t, "", current.Mailer.Subjects.Invite)
+
+	_, err = current.ApplyOverrides(map[string]json.RawMessage{
+		"external.github.enabled": json.RawMessage(`"yes"`),
+	})
+	require.Error(t, err)
+
+	_, err = current.ApplyOverrides(map[string]json.RawMessage{
+		"jwt.secret": json.RawMessage(`"secret"`),
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,145 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// reloadableFields are the fields of GlobalConfiguration that can be
+// changed while the server is running: the mailer, hooks, the redirect
+// allow list, rate limits and the external and SMS providers. Everything
+// else is used to set up long lived state, such as database connections,
+// listeners or signing keys, and needs a restart.
+var reloadableFields = map[string]bool{
+	"SMTP":                    true,
+	"Mailer":                  true,
+	"Hook":                    true,
+	"SiteURL":                 true,
+	"URIAllowList":            true,
+	"URIAllowListMap":         true,
+	"RateLimitHeader":         true,
+	"RateLimitEmailSent":      true,
+	"RateLimitSmsSent":        true,
+	"RateLimitVerify":         true,
+	"RateLimitTokenRefresh":   true,
+	"RateLimitSso":            true,
+	"RateLimitAnonymousUsers": true,
+	"RateLimitOtp":            true,
+	"External":                true,
+	"Sms":                     true,
+}
+
+var (
+	envFileMu sync.Mutex
+
+	// envFilePrevious holds, for every variable set from the env file,
+	// the value it had in the environment before, or nil if it wasn't
+	// set, so that removing it from the file restores that value.
+	envFilePrevious = map[string]*string{}
+)
+
+// loadEnvFile sets the variables of the env file in the environment, and
+// restores the variables removed from the file since it was last loaded.
+// Unless override is set, variables already set in the environment are
+// kept.
+func loadEnvFile(filename string, override bool) error {
+	values, err := godotenv.Read(filename)
+	if err != nil {
+		return err
+	}
+
+	envFileMu.Lock()
+	defer envFileMu.Unlock()
+
+	for key, previous := range envFilePrevious {
+		if _, ok := values[key]; ok {
+			continue
+		}
+
+		if previous == nil {
+			err = os.Unsetenv(key)
+		} else {
+			err = os.Setenv(key, *previous)
+		}
+		if err != nil {
+			return err
+		}
+		delete(envFilePrevious, key)
+	}
+
+	for key, value := range values {
+		if _, ok := envFilePrevious[key]; !ok {
+			previous, set := os.LookupEnv(key)
+			if set && !override {
+				continue
+			}
+
+			if set {
+				envFilePrevious[key] = &previous
+			} else {
+				envFilePrevious[key] = nil
+			}
+		}
+
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReloadGlobal loads the configuration again after the server has started.
+// Unlike LoadGlobal, values in the env file always override the ones
+// already present in the environment, so that edits to the file take
+// effect. Variables removed from the file get back the value they had
+// before it was loaded, or are unset. When no file is given, .env is used
+// if it exists.
+func ReloadGlobal(filename string) (*GlobalConfiguration, error) {
+	if filename == "" {
+		filename = ".env"
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			return loadGlobalFromEnvironment()
+		}
+	}
+
+	if err := loadEnvFile(filename, true); err != nil {
+		return nil, err
+	}
+
+	return loadGlobalFromEnvironment()
+}
+
+// MergeReloadable returns a copy of the configuration with the reloadable
+// fields taken from next. Changes to any other field are not applied, and
+// are returned as rejected with the reason why. The merged configuration
+// is validated before being returned.
+func (c *GlobalConfiguration) MergeReloadable(next *GlobalConfiguration) (*GlobalConfiguration, []string, error) {
+	merged := *c
+
+	var rejected []string
+
+	current := reflect.ValueOf(c).Elem()
+	updated := reflect.ValueOf(next).Elem()
+	target := reflect.ValueOf(&merged).Elem()
+
+	for i := 0; i < current.NumField(); i++ {
+		name := current.Type().Field(i).Name
+
+		if reloadableFields[name] {
+			target.Field(i).Set(updated.Field(i))
+		} else if !reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			rejected = append(rejected, fmt.Sprintf("%s was changed but can only be applied by restarting the server", name))
+		}
+	}
+
+	if err := merged.Validate(); err != nil {
+		return nil, rejected, err
+	}
+
+	return &merged, rejected, nil
+}
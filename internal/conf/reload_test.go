@@ -0,0 +1,87 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// restoreEnv restores the environment variables set from env files by the
+// test once it finishes.
+func restoreEnv(t *testing.T, keys ...string) {
+	for _, key := range keys {
+		if value, ok := os.LookupEnv(key); ok {
+			t.Setenv(key, value)
+		} else {
+			t.Setenv(key, "")
+			require.NoError(t, os.Unsetenv(key))
+		}
+	}
+
+	t.Cleanup(func() {
+		envFileMu.Lock()
+		defer envFileMu.Unlock()
+		envFilePrevious = map[string]*string{}
+	})
+}
+
+func TestReloadGlobal(t *testing.T) {
+	restoreEnv(t, "GOTRUE_SITE_URL", "API_EXTERNAL_URL", "GOTRUE_DB_DRIVER", "GOTRUE_DB_DATABASE_URL", "GOTRUE_URI_ALLOW_LIST", "GOTRUE_JWT_SECRET", "GOTRUE_MAILER_SUBJECTS_INVITE")
+
+	envFile := filepath.Join(t.TempDir(), "test.env")
+
+	writeEnv := func(allowList, jwtSecret string, extra string) {
+		require.NoError(t, os.WriteFile(envFile, []byte(
+			"GOTRUE_SITE_URL=http://localhost:3000\n"+
+				"API_EXTERNAL_URL=http://localhost:9999\n"+
+				"GOTRUE_DB_DRIVER=postgres\n"+
+				"GOTRUE_DB_DATABASE_URL=fake\n"+
+				"GOTRUE_URI_ALLOW_LIST="+allowList+"\n"+
+				"GOTRUE_JWT_SECRET="+jwtSecret+"\n"+
+				extra,
+		), 0600))
+	}
+
+	writeEnv("http://localhost:3000/**", "secret", "GOTRUE_MAILER_SUBJECTS_INVITE=Join us\n")
+	current, err := LoadGlobal(envFile)
+	require.NoError(t, err)
+
+	writeEnv("http://localhost:3000/**,https://example.com/**", "rotated", "")
+	next, err := ReloadGlobal(envFile)
+	require.NoError(t, err)
+
+	merged, rejected, err := current.MergeReloadable(next)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"http://localhost:3000/**", "https://example.com/**"}, merged.URIAllowList)
+	require.Contains(t, merged.URIAllowListMap, "https://example.com/**")
+
+	require.Equal(t, "secret", merged.JWT.Secret)
+	require.Equal(t, []string{"JWT was changed but can only be applied by restarting the server"}, rejected)
+
+	// the current configuration is left untouched
+	require.Equal(t, []string{"http://localhost:3000/**"}, current.URIAllowList)
+
+	// variables removed from the file are unset
+	require.Equal(t, "Join us", current.Mailer.Subjects.Invite)
+	require.Equal(t, "", next.Mailer.Subjects.Invite)
+	_, ok := os.LookupEnv("GOTRUE_MAILER_SUBJECTS_INVITE")
+	require.False(t, ok)
+}
+
+func TestMergeReloadableInvalid(t *testing.T) {
+	current := &GlobalConfiguration{
+		API: APIConfiguration{ExternalURL: "http://localhost:9999"},
+	}
+
+	_, _, err := current.MergeReloadable(current)
+	require.NoError(t, err)
+
+	next := *current
+	next.Hook.SendSMS.URI = "ftp://example.com/hook"
+
+	_, _, err = current.MergeReloadable(&next)
+	require.Error(t, err)
+}
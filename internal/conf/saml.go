@@ -26,6 +26,10 @@ type SAMLConfiguration struct {
 	ExternalURL string `json:"external_url,omitempty" split_words:"true"`
 
 	RateLimitAssertion float64 `default:"15" split_words:"true"`
+
+	// ExposeDomains lists the SSO domains in the settings, so that login
+	// pages can offer SSO as soon as an email address is typed.
+	ExposeDomains bool `json:"expose_domains" split_words:"true"`
 }
 
 func (c *SAMLConfiguration) Validate() error {
@@ -1,6 +1,8 @@
 package hooks
 
 import (
+	"time"
+
 	"github.com/gofrs/uuid"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/supabase/auth/internal/mailer"
@@ -108,6 +110,20 @@ type AccessTokenClaims struct {
 	AuthenticationMethodReference []models.AMREntry      `json:"amr,omitempty"`
 	SessionId                     string                 `json:"session_id,omitempty"`
 	IsAnonymous                   bool                   `json:"is_anonymous"`
+	Actor                         *ActorClaim            `json:"act,omitempty"`
+	Confirmation                  *ConfirmationClaim     `json:"cnf,omitempty"`
+}
+
+// ActorClaim identifies the party acting on behalf of the subject of a
+// token, as described in RFC 8693 section 4.1.
+type ActorClaim struct {
+	Subject string `json:"sub"`
+}
+
+// ConfirmationClaim binds a token to the DPoP key with the JWK SHA-256
+// thumbprint JKT, as described in RFC 9449 section 6.1.
+type ConfirmationClaim struct {
+	JKT string `json:"jkt"`
 }
 
 type MFAVerificationAttemptInput struct {
@@ -165,6 +181,57 @@ type SendEmailOutput struct {
 	HookError AuthHookError `json:"error,omitempty"`
 }
 
+// RefreshTokenReuseInput describes a revoked refresh token that was reused
+// outside the reuse interval, a strong sign that it was stolen.
+type RefreshTokenReuseInput struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	SessionID      *uuid.UUID `json:"session_id,omitempty"`
+	RefreshTokenID int64      `json:"refresh_token_id"`
+	IPAddress      string     `json:"ip_address"`
+	UserAgent      string     `json:"user_agent"`
+	FamilyRevoked  bool       `json:"family_revoked"`
+}
+
+type RefreshTokenReuseOutput struct {
+	HookError AuthHookError `json:"error,omitempty"`
+}
+
+// UserMergedInput describes an anonymous user that is about to be merged
+// into the permanent user with UserID and then deleted.
+type UserMergedInput struct {
+	AnonymousUserID uuid.UUID `json:"anonymous_user_id"`
+	UserID          uuid.UUID `json:"user_id"`
+}
+
+type UserMergedOutput struct {
+	HookError AuthHookError `json:"error,omitempty"`
+}
+
+// Anonymous user expiry events
+const (
+	AnonymousUserExpiryReminder = "reminder"
+	AnonymousUserExpiryDeletion = "deletion"
+)
+
+// AnonymousUserExpiryInput describes an anonymous user that is about to be
+// deleted for inactivity. The reminder event is sent once the user enters
+// the reminder period, the deletion event right before the user is deleted.
+type AnonymousUserExpiryInput struct {
+	UserID       uuid.UUID `json:"user_id"`
+	Event        string    `json:"event"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	DeleteAt     time.Time `json:"delete_at"`
+}
+
+// AnonymousUserExpiryOutput can reject the deletion event, in which case
+// the user is kept for another retention period.
+type AnonymousUserExpiryOutput struct {
+	Decision  string        `json:"decision"`
+	Message   string        `json:"message"`
+	HookError AuthHookError `json:"error,omitempty"`
+}
+
 func (mf *MFAVerificationAttemptOutput) IsError() bool {
 	return mf.HookError.Message != ""
 }
@@ -205,6 +272,30 @@ func (cs *SendEmailOutput) Error() string {
 	return cs.HookError.Message
 }
 
+func (rt *RefreshTokenReuseOutput) IsError() bool {
+	return rt.HookError.Message != ""
+}
+
+func (rt *RefreshTokenReuseOutput) Error() string {
+	return rt.HookError.Message
+}
+
+func (um *UserMergedOutput) IsError() bool {
+	return um.HookError.Message != ""
+}
+
+func (um *UserMergedOutput) Error() string {
+	return um.HookError.Message
+}
+
+func (ae *AnonymousUserExpiryOutput) IsError() bool {
+	return ae.HookError.Message != ""
+}
+
+func (ae *AnonymousUserExpiryOutput) Error() string {
+	return ae.HookError.Message
+}
+
 type AuthHookError struct {
 	HTTPCode int    `json:"http_code,omitempty"`
 	Message  string `json:"message,omitempty"`
@@ -22,6 +22,10 @@ type Mailer interface {
 	MagicLinkMail(r *http.Request, user *models.User, otp, referrerURL string, externalURL *url.URL) error
 	EmailChangeMail(r *http.Request, user *models.User, otpNew, otpCurrent, referrerURL string, externalURL *url.URL) error
 	ReauthenticateMail(r *http.Request, user *models.User, otp string) error
+	RefreshTokenReuseMail(r *http.Request, user *models.User) error
+	AccountDeletionScheduledMail(r *http.Request, user *models.User) error
+	AccountDeletionCancelledMail(r *http.Request, user *models.User) error
+	AccountDeletedMail(r *http.Request, user *models.User) error
 	ValidateEmail(email string) error
 	GetEmailActionLink(user *models.User, actionType, referrerURL string, externalURL *url.URL) (string, error)
 }
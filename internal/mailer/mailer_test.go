@@ -1,11 +1,15 @@
 package mailer
 
 import (
+	"html/template"
 	"net/url"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/supabase/auth/internal/conf"
 )
 
 var urlRegexp = regexp.MustCompile(`^https?://[^/]+`)
@@ -85,3 +89,16 @@ func TestRelativeURL(t *testing.T) {
 		assert.Equal(t, c.Expected, res, c.URL)
 	}
 }
+
+func TestDefaultTemplates(t *testing.T) {
+	values := reflect.TypeOf(conf.EmailContentConfiguration{})
+	templates := DefaultTemplates()
+	assert.Len(t, templates, values.NumField())
+
+	for i := 0; i < values.NumField(); i++ {
+		name := strings.Split(values.Field(i).Tag.Get("json"), ",")[0]
+		_, err := template.New(name).Parse(templates[name])
+		assert.NoError(t, err, name)
+		assert.NotEmpty(t, templates[name], name)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/badoux/checkmail"
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/utilities"
 )
 
 type MailClient interface {
@@ -44,6 +45,10 @@ const (
 	EmailChangeCurrentVerification = "email_change_current"
 	EmailChangeNewVerification     = "email_change_new"
 	ReauthenticationVerification   = "reauthentication"
+	RefreshTokenReuseNotification  = "refresh_token_reuse"
+	AccountDeletionScheduled       = "account_deletion_scheduled"
+	AccountDeletionCancelled       = "account_deletion_cancelled"
+	AccountDeleted                 = "account_deleted"
 )
 
 const defaultInviteMail = `<h2>You have been invited</h2>
@@ -81,6 +86,44 @@ const defaultReauthenticateMail = `<h2>Confirm reauthentication</h2>
 
 <p>Enter the code: {{ .Token }}</p>`
 
+const defaultRefreshTokenReuseMail = `<h2>Suspicious sign-in activity</h2>
+
+<p>A session token for your account on {{ .SiteURL }} was used again after it had been replaced, which can mean that someone copied your session. To protect you, that session was signed out.</p>
+<p>IP address: {{ .IPAddress }}<br>Device: {{ .UserAgent }}</p>
+<p>If you don't recognize this activity, sign in again and change your password.</p>`
+
+const defaultAccountDeletionScheduledMail = `<h2>Your account will be deleted</h2>
+
+<p>We received a request to delete your account on {{ .SiteURL }}. Your account will be deleted on {{ .DeleteAt }}.</p>
+<p>If you change your mind, sign in before then to keep your account.</p>`
+
+const defaultAccountDeletionCancelledMail = `<h2>Your account will not be deleted</h2>
+
+<p>You signed in to {{ .SiteURL }}, so the deletion of your account was cancelled.</p>
+<p>If you still want your account deleted, request the deletion again.</p>`
+
+const defaultAccountDeletedMail = `<h2>Your account was deleted</h2>
+
+<p>As you requested, your account on {{ .SiteURL }} was deleted.</p>`
+
+// DefaultTemplates returns the templates emails are sent with when no
+// template URL is configured, by the name of the email in
+// conf.EmailContentConfiguration.
+func DefaultTemplates() map[string]string {
+	return map[string]string{
+		"invite":                     defaultInviteMail,
+		"confirmation":               defaultConfirmationMail,
+		"recovery":                   defaultRecoveryMail,
+		"email_change":               defaultEmailChangeMail,
+		"magic_link":                 defaultMagicLinkMail,
+		"reauthentication":           defaultReauthenticateMail,
+		"refresh_token_reuse":        defaultRefreshTokenReuseMail,
+		"account_deletion_scheduled": defaultAccountDeletionScheduledMail,
+		"account_deletion_cancelled": defaultAccountDeletionCancelledMail,
+		"account_deleted":            defaultAccountDeletedMail,
+	}
+}
+
 // ValidateEmail returns nil if the email is valid,
 // otherwise an error indicating the reason it is invalid
 func (m TemplateMailer) ValidateEmail(email string) error {
@@ -166,6 +209,80 @@ func (m *TemplateMailer) ReauthenticateMail(r *http.Request, user *models.User,
 	)
 }
 
+// RefreshTokenReuseMail tells a user that a revoked refresh token of theirs
+// was reused by the client making the request r.
+func (m *TemplateMailer) RefreshTokenReuseMail(r *http.Request, user *models.User) error {
+	data := map[string]interface{}{
+		"SiteURL":   m.Config.SiteURL,
+		"Email":     user.Email,
+		"IPAddress": utilities.GetIPAddress(r),
+		"UserAgent": r.Header.Get("User-Agent"),
+		"Data":      user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.GetEmail(),
+		withDefault(m.Config.Mailer.Subjects.RefreshTokenReuse, "Suspicious sign-in activity"),
+		m.Config.Mailer.Templates.RefreshTokenReuse,
+		defaultRefreshTokenReuseMail,
+		data,
+	)
+}
+
+// AccountDeletionScheduledMail tells a user when the deletion of their
+// account they requested will happen.
+func (m *TemplateMailer) AccountDeletionScheduledMail(r *http.Request, user *models.User) error {
+	data := map[string]interface{}{
+		"SiteURL":  m.Config.SiteURL,
+		"Email":    user.Email,
+		"DeleteAt": user.DeletionScheduledAt,
+		"Data":     user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.GetEmail(),
+		withDefault(m.Config.Mailer.Subjects.AccountDeletionScheduled, "Your account will be deleted"),
+		m.Config.Mailer.Templates.AccountDeletionScheduled,
+		defaultAccountDeletionScheduledMail,
+		data,
+	)
+}
+
+// AccountDeletionCancelledMail tells a user that signing in cancelled the
+// deletion of their account.
+func (m *TemplateMailer) AccountDeletionCancelledMail(r *http.Request, user *models.User) error {
+	data := map[string]interface{}{
+		"SiteURL": m.Config.SiteURL,
+		"Email":   user.Email,
+		"Data":    user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.GetEmail(),
+		withDefault(m.Config.Mailer.Subjects.AccountDeletionCancelled, "Your account will not be deleted"),
+		m.Config.Mailer.Templates.AccountDeletionCancelled,
+		defaultAccountDeletionCancelledMail,
+		data,
+	)
+}
+
+// AccountDeletedMail tells a user that their account was deleted.
+func (m *TemplateMailer) AccountDeletedMail(r *http.Request, user *models.User) error {
+	data := map[string]interface{}{
+		"SiteURL": m.Config.SiteURL,
+		"Email":   user.Email,
+		"Data":    user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.GetEmail(),
+		withDefault(m.Config.Mailer.Subjects.AccountDeleted, "Your account was deleted"),
+		m.Config.Mailer.Templates.AccountDeleted,
+		defaultAccountDeletedMail,
+		data,
+	)
+}
+
 // EmailChangeMail sends an email change confirmation mail to a user
 func (m *TemplateMailer) EmailChangeMail(r *http.Request, user *models.User, otpNew, otpCurrent, referrerURL string, externalURL *url.URL) error {
 	type Email struct {
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// anonymousUserLastActiveAt is the time an anonymous user was last active:
+// when they last signed in, or last refreshed one of their sessions.
+// Refreshing a session doesn't update the last sign in time.
+var anonymousUserLastActiveAt = fmt.Sprintf("greatest(coalesce(users.last_sign_in_at, users.created_at), (select max(coalesce(s.refreshed_at, s.created_at)) from %q s where s.user_id = users.id))", Session{}.TableName())
+
+// anonymousUserNotRetained excludes anonymous users whose deletion was
+// rejected by the anonymous user expiry hook.
+const anonymousUserNotRetained = "(anonymous_retained_until is null or anonymous_retained_until < now())"
+
+// LastActiveAt returns the time the user last signed in or refreshed a
+// session.
+func (u *User) LastActiveAt(tx *storage.Connection) (time.Time, error) {
+	var lastActiveAt time.Time
+	if err := tx.RawQuery(fmt.Sprintf("select %s from %q where id = ?", anonymousUserLastActiveAt, u.TableName()), u.ID).First(&lastActiveAt); err != nil {
+		return time.Time{}, errors.Wrap(err, "error finding when the user was last active")
+	}
+	return lastActiveAt, nil
+}
+
+// FindExpiredAnonymousUsers finds at most limit anonymous users that were
+// last active before lastActiveBefore.
+func FindExpiredAnonymousUsers(tx *storage.Connection, lastActiveBefore time.Time, limit int) ([]*User, error) {
+	users := []*User{}
+	if err := tx.Q().Where("instance_id = ? and is_anonymous is true and "+anonymousUserLastActiveAt+" < ? and "+anonymousUserNotRetained, uuid.Nil, lastActiveBefore).Limit(limit).All(&users); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return users, nil
+		}
+		return nil, errors.Wrap(err, "error finding expired anonymous users")
+	}
+	return users, nil
+}
+
+// anonymousUserNotReminded excludes anonymous users that were reminded
+// since they were last active.
+var anonymousUserNotReminded = "(anonymous_reminded_at is null or anonymous_reminded_at < " + anonymousUserLastActiveAt + ")"
+
+// FindAnonymousUsersToRemind finds at most limit anonymous users that were
+// last active before lastActiveBefore and haven't been reminded since.
+func FindAnonymousUsersToRemind(tx *storage.Connection, lastActiveBefore time.Time, limit int) ([]*User, error) {
+	users := []*User{}
+	if err := tx.Q().Where("instance_id = ? and is_anonymous is true and "+anonymousUserLastActiveAt+" < ? and "+anonymousUserNotRetained+" and "+anonymousUserNotReminded, uuid.Nil, lastActiveBefore).Limit(limit).All(&users); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return users, nil
+		}
+		return nil, errors.Wrap(err, "error finding anonymous users to remind")
+	}
+	return users, nil
+}
+
+// LockExpiredAnonymousUser locks the anonymous user with id if it was last
+// active before lastActiveBefore. If the user is locked by another
+// transaction, or was active since, a UserNotFoundError is returned.
+func LockExpiredAnonymousUser(tx *storage.Connection, id uuid.UUID, lastActiveBefore time.Time) (*User, error) {
+	user := &User{}
+	if err := tx.RawQuery(fmt.Sprintf("select * from %q where id = ? and is_anonymous is true and %s < ? and %s for update skip locked", user.TableName(), anonymousUserLastActiveAt, anonymousUserNotRetained), id, lastActiveBefore).First(user); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, UserNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error locking anonymous user")
+	}
+	return user, nil
+}
+
+// LockAnonymousUserToRemind locks the anonymous user with id if it was last
+// active before lastActiveBefore and wasn't reminded since. If the user is
+// locked by another transaction, or was active or reminded since, a
+// UserNotFoundError is returned.
+func LockAnonymousUserToRemind(tx *storage.Connection, id uuid.UUID, lastActiveBefore time.Time) (*User, error) {
+	user := &User{}
+	if err := tx.RawQuery(fmt.Sprintf("select * from %q where id = ? and is_anonymous is true and %s < ? and %s and %s for update skip locked", user.TableName(), anonymousUserLastActiveAt, anonymousUserNotRetained, anonymousUserNotReminded), id, lastActiveBefore).First(user); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, UserNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error locking anonymous user")
+	}
+	return user, nil
+}
+
+// RetainAnonymousUser keeps the anonymous user from being deleted until the
+// provided time.
+func (u *User) RetainAnonymousUser(tx *storage.Connection, until time.Time) error {
+	u.AnonymousRetainedUntil = &until
+	return tx.UpdateOnly(u, "anonymous_retained_until")
+}
+
+// MarkAnonymousUserReminded records that the anonymous user was reminded to
+// convert to a permanent user.
+func (u *User) MarkAnonymousUserReminded(tx *storage.Connection, at time.Time) error {
+	u.AnonymousRemindedAt = &at
+	return tx.UpdateOnly(u, "anonymous_reminded_at")
+}
+
+// CountActiveAnonymousSessionsByIP counts the sessions of anonymous users
+// created from ip that haven't expired. The ip is locked for the rest of the
+// transaction, so that concurrent sign-ins from it are counted one after the
+// other and can't all slip under the limit.
+func CountActiveAnonymousSessionsByIP(tx *storage.Connection, ip string) (int, error) {
+	if err := tx.RawQuery("select pg_advisory_xact_lock(hashtext(?))", "anonymous_sessions:"+ip).Exec(); err != nil {
+		return 0, errors.Wrap(err, "error locking anonymous sessions")
+	}
+
+	count := 0
+	if err := tx.RawQuery(fmt.Sprintf("select count(*) from %q s join %q u on u.id = s.user_id where u.is_anonymous is true and s.ip = ? and (s.not_after is null or s.not_after > now())", Session{}.TableName(), User{}.TableName()), ip).First(&count); err != nil {
+		return 0, errors.Wrap(err, "error counting anonymous sessions")
+	}
+	return count, nil
+}
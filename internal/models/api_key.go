@@ -0,0 +1,119 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// apiKeyPrefix marks personal API keys, so that leaked keys are easy to
+// recognize.
+const apiKeyPrefix = "ak_"
+
+// APIKey is a personal API key of a user, used by scripts that can't sign
+// in interactively. Only a hash of the key is stored; KeyPrefix lets the
+// user tell keys apart. Keys are exchanged for short-lived access tokens
+// that carry Scope, all in the session with SessionID.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	KeyPrefix  string     `json:"key_prefix" db:"key_prefix"`
+	KeyHash    string     `json:"-" db:"key_hash"`
+	Scope      string     `json:"scope" db:"scope"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	SessionID  *uuid.UUID `json:"-" db:"session_id"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+func (APIKey) TableName() string {
+	tableName := "api_keys"
+	return tableName
+}
+
+// NewAPIKey creates an API key for the user and returns it along with the
+// key in the clear, which can't be retrieved afterwards.
+func NewAPIKey(userID uuid.UUID, name, scope string, expiresAt *time.Time) (*APIKey, string) {
+	key := apiKeyPrefix + crypto.SecureToken(32)
+
+	return &APIKey{
+		ID:        uuid.Must(uuid.NewV4()),
+		UserID:    userID,
+		Name:      name,
+		KeyPrefix: key[:len(apiKeyPrefix)+8],
+		KeyHash:   hashAPIKey(key),
+		Scope:     scope,
+		ExpiresAt: expiresAt,
+	}, key
+}
+
+func hashAPIKey(key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+}
+
+// IsAPIKey reports whether key looks like a personal API key.
+func IsAPIKey(key string) bool {
+	return strings.HasPrefix(key, apiKeyPrefix)
+}
+
+func (k *APIKey) IsExpired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+// RecordUse records when the key was last used, so that users can find
+// keys they no longer need.
+func (k *APIKey) RecordUse(tx *storage.Connection) error {
+	now := time.Now()
+	k.LastUsedAt = &now
+	return tx.UpdateOnly(k, "last_used_at", "updated_at")
+}
+
+func FindAPIKeyByKey(tx *storage.Connection, key string) (*APIKey, error) {
+	obj := &APIKey{}
+	if err := tx.Q().Where("key_hash = ?", hashAPIKey(key)).First(obj); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, APIKeyNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding API key")
+	}
+
+	return obj, nil
+}
+
+func FindAPIKeyByUserIDAndID(tx *storage.Connection, userID, id uuid.UUID) (*APIKey, error) {
+	obj := &APIKey{}
+	if err := tx.Q().Where("user_id = ? and id = ?", userID, id).First(obj); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, APIKeyNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding API key")
+	}
+
+	return obj, nil
+}
+
+func FindAPIKeysByUserID(tx *storage.Connection, userID uuid.UUID) ([]*APIKey, error) {
+	keys := []*APIKey{}
+	if err := tx.Q().Where("user_id = ?", userID).Order("created_at asc").All(&keys); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return keys, nil
+		}
+		return nil, errors.Wrap(err, "error finding API keys")
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKeysForUser deletes all API keys of the user.
+func RevokeAPIKeysForUser(tx *storage.Connection, userID uuid.UUID) error {
+	return tx.RawQuery("delete from "+(&APIKey{}).TableName()+" where user_id = ?", userID).Exec()
+}
@@ -2,45 +2,76 @@ package models
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/gobuffalo/pop/v6"
 	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
+	"github.com/supabase/auth/internal/utilities"
 )
 
+// AuditLogSchemaVersion is recorded in the payload of every audit log entry
+// so that consumers can tell which fields to expect. Version 1 entries only
+// carried the actor, action, log type and traits.
+const AuditLogSchemaVersion = 2
+
 type AuditAction string
 type auditLogType string
 
 const (
-	LoginAction                     AuditAction = "login"
-	LogoutAction                    AuditAction = "logout"
-	InviteAcceptedAction            AuditAction = "invite_accepted"
-	UserSignedUpAction              AuditAction = "user_signedup"
-	UserInvitedAction               AuditAction = "user_invited"
-	UserDeletedAction               AuditAction = "user_deleted"
-	UserModifiedAction              AuditAction = "user_modified"
-	UserRecoveryRequestedAction     AuditAction = "user_recovery_requested"
-	UserReauthenticateAction        AuditAction = "user_reauthenticate_requested"
-	UserConfirmationRequestedAction AuditAction = "user_confirmation_requested"
-	UserRepeatedSignUpAction        AuditAction = "user_repeated_signup"
-	UserUpdatePasswordAction        AuditAction = "user_updated_password"
-	TokenRevokedAction              AuditAction = "token_revoked"
-	TokenRefreshedAction            AuditAction = "token_refreshed"
-	GenerateRecoveryCodesAction     AuditAction = "generate_recovery_codes"
-	EnrollFactorAction              AuditAction = "factor_in_progress"
-	UnenrollFactorAction            AuditAction = "factor_unenrolled"
-	CreateChallengeAction           AuditAction = "challenge_created"
-	VerifyFactorAction              AuditAction = "verification_attempted"
-	DeleteFactorAction              AuditAction = "factor_deleted"
-	DeleteRecoveryCodesAction       AuditAction = "recovery_codes_deleted"
-	UpdateFactorAction              AuditAction = "factor_updated"
-	MFACodeLoginAction              AuditAction = "mfa_code_login"
-	IdentityUnlinkAction            AuditAction = "identity_unlinked"
+	LoginAction                      AuditAction = "login"
+	LogoutAction                     AuditAction = "logout"
+	InviteAcceptedAction             AuditAction = "invite_accepted"
+	UserSignedUpAction               AuditAction = "user_signedup"
+	UserInvitedAction                AuditAction = "user_invited"
+	UserDeletedAction                AuditAction = "user_deleted"
+	UserModifiedAction               AuditAction = "user_modified"
+	UserRecoveryRequestedAction      AuditAction = "user_recovery_requested"
+	UserReauthenticateAction         AuditAction = "user_reauthenticate_requested"
+	UserConfirmationRequestedAction  AuditAction = "user_confirmation_requested"
+	UserRepeatedSignUpAction         AuditAction = "user_repeated_signup"
+	UserUpdatePasswordAction         AuditAction = "user_updated_password"
+	TokenRevokedAction               AuditAction = "token_revoked"
+	TokenRefreshedAction             AuditAction = "token_refreshed"
+	TokenExchangedAction             AuditAction = "token_exchanged"
+	TokenReusedAction                AuditAction = "token_reused"
+	GenerateRecoveryCodesAction      AuditAction = "generate_recovery_codes"
+	EnrollFactorAction               AuditAction = "factor_in_progress"
+	UnenrollFactorAction             AuditAction = "factor_unenrolled"
+	CreateChallengeAction            AuditAction = "challenge_created"
+	VerifyFactorAction               AuditAction = "verification_attempted"
+	DeleteFactorAction               AuditAction = "factor_deleted"
+	DeleteRecoveryCodesAction        AuditAction = "recovery_codes_deleted"
+	UpdateFactorAction               AuditAction = "factor_updated"
+	MFACodeLoginAction               AuditAction = "mfa_code_login"
+	UserImpersonatedAction           AuditAction = "user_impersonated"
+	IdentityUnlinkAction             AuditAction = "identity_unlinked"
+	IdentityLinkAction               AuditAction = "identity_linked"
+	SSOProviderCreatedAction         AuditAction = "sso_provider_created"
+	SSOProviderUpdatedAction         AuditAction = "sso_provider_updated"
+	SSOProviderDeletedAction         AuditAction = "sso_provider_deleted"
+	ConfigOverrideUpdatedAction      AuditAction = "config_override_updated"
+	ConfigOverrideDeletedAction      AuditAction = "config_override_deleted"
+	DeviceCodeApprovedAction         AuditAction = "device_code_approved"
+	DeviceCodeDeniedAction           AuditAction = "device_code_denied"
+	ServiceClientCreatedAction       AuditAction = "service_client_created"
+	ServiceClientUpdatedAction       AuditAction = "service_client_updated"
+	ServiceClientSecretRotatedAction AuditAction = "service_client_secret_rotated"
+	ServiceClientRevokedAction       AuditAction = "service_client_revoked"
+	ServiceClientTokenIssuedAction   AuditAction = "service_client_token_issued"
+	APIKeyCreatedAction              AuditAction = "api_key_created"
+	APIKeyRevokedAction              AuditAction = "api_key_revoked"
+	UserMergedAction                 AuditAction = "user_merged"
+	UserDeletionRequestedAction      AuditAction = "user_deletion_requested"
+	UserDeletionCancelledAction      AuditAction = "user_deletion_cancelled"
+	UserDataExportedAction           AuditAction = "user_data_exported"
+	SmsSentAction                    AuditAction = "sms_sent"
 
 	account       auditLogType = "account"
 	team          auditLogType = "team"
@@ -48,31 +79,137 @@ const (
 	user          auditLogType = "user"
 	factor        auditLogType = "factor"
 	recoveryCodes auditLogType = "recovery_codes"
+	identity      auditLogType = "identity"
+	ssoProvider   auditLogType = "sso_provider"
+	config        auditLogType = "config"
+	serviceClient auditLogType = "service_client"
+)
+
+// AuditActorType describes who performed an audited action.
+type AuditActorType string
+
+const (
+	AuditActorUser   AuditActorType = "user"
+	AuditActorAdmin  AuditActorType = "admin"
+	AuditActorSystem AuditActorType = "system"
+
+	AuditActorServiceClient AuditActorType = "service_client"
+)
+
+// AuditOutcome describes whether an audited action succeeded.
+type AuditOutcome string
+
+const (
+	AuditOutcomeSuccess AuditOutcome = "success"
+	AuditOutcomeFailure AuditOutcome = "failure"
 )
 
 var ActionLogTypeMap = map[AuditAction]auditLogType{
-	LoginAction:                     account,
-	LogoutAction:                    account,
-	InviteAcceptedAction:            account,
-	UserSignedUpAction:              team,
-	UserInvitedAction:               team,
-	UserDeletedAction:               team,
-	TokenRevokedAction:              token,
-	TokenRefreshedAction:            token,
-	UserModifiedAction:              user,
-	UserRecoveryRequestedAction:     user,
-	UserConfirmationRequestedAction: user,
-	UserRepeatedSignUpAction:        user,
-	UserUpdatePasswordAction:        user,
-	GenerateRecoveryCodesAction:     user,
-	EnrollFactorAction:              factor,
-	UnenrollFactorAction:            factor,
-	CreateChallengeAction:           factor,
-	VerifyFactorAction:              factor,
-	DeleteFactorAction:              factor,
-	UpdateFactorAction:              factor,
-	MFACodeLoginAction:              factor,
-	DeleteRecoveryCodesAction:       recoveryCodes,
+	LoginAction:                      account,
+	LogoutAction:                     account,
+	InviteAcceptedAction:             account,
+	UserSignedUpAction:               team,
+	UserInvitedAction:                team,
+	UserDeletedAction:                team,
+	UserMergedAction:                 team,
+	UserDeletionRequestedAction:      user,
+	UserDeletionCancelledAction:      user,
+	UserDataExportedAction:           user,
+	SmsSentAction:                    user,
+	UserImpersonatedAction:           team,
+	TokenRevokedAction:               token,
+	TokenRefreshedAction:             token,
+	TokenExchangedAction:             token,
+	TokenReusedAction:                token,
+	UserModifiedAction:               user,
+	UserRecoveryRequestedAction:      user,
+	UserConfirmationRequestedAction:  user,
+	UserRepeatedSignUpAction:         user,
+	UserUpdatePasswordAction:         user,
+	GenerateRecoveryCodesAction:      user,
+	EnrollFactorAction:               factor,
+	UnenrollFactorAction:             factor,
+	CreateChallengeAction:            factor,
+	VerifyFactorAction:               factor,
+	DeleteFactorAction:               factor,
+	UpdateFactorAction:               factor,
+	MFACodeLoginAction:               factor,
+	DeleteRecoveryCodesAction:        recoveryCodes,
+	IdentityLinkAction:               identity,
+	IdentityUnlinkAction:             identity,
+	SSOProviderCreatedAction:         ssoProvider,
+	SSOProviderUpdatedAction:         ssoProvider,
+	SSOProviderDeletedAction:         ssoProvider,
+	ConfigOverrideUpdatedAction:      config,
+	ConfigOverrideDeletedAction:      config,
+	DeviceCodeApprovedAction:         account,
+	DeviceCodeDeniedAction:           account,
+	ServiceClientCreatedAction:       serviceClient,
+	ServiceClientUpdatedAction:       serviceClient,
+	ServiceClientSecretRotatedAction: serviceClient,
+	ServiceClientRevokedAction:       serviceClient,
+	ServiceClientTokenIssuedAction:   serviceClient,
+	APIKeyCreatedAction:              user,
+	APIKeyRevokedAction:              user,
+}
+
+type auditContextKey struct{}
+
+// AuditContext holds request-scoped details about the actor of the audit
+// log entries written while serving a request. It is attached to the request
+// context once and filled in by the middlewares and handlers as the request
+// is authenticated.
+type AuditContext struct {
+	ActorType  AuditActorType
+	SessionID  *uuid.UUID
+	AuthMethod string
+
+	// Impersonator is set when the request was made with a session an
+	// admin created on behalf of the user.
+	Impersonator string
+
+	// ServiceClientID is set when the request was made by a service
+	// client, with a token from the client_credentials grant.
+	ServiceClientID *uuid.UUID
+}
+
+// WithAuditContext attaches an empty AuditContext to ctx.
+func WithAuditContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, &AuditContext{})
+}
+
+// GetAuditContext returns the AuditContext attached to ctx. A detached
+// context is returned when there is none, so callers can always write to it.
+func GetAuditContext(ctx context.Context) *AuditContext {
+	if ctx != nil {
+		if ac, ok := ctx.Value(auditContextKey{}).(*AuditContext); ok {
+			return ac
+		}
+	}
+	return &AuditContext{}
+}
+
+// AuditEvent describes a single audited action. Fields left empty are
+// filled in from the request's AuditContext where possible.
+type AuditEvent struct {
+	Action AuditAction
+
+	// Actor is the user performing the action. It may be nil for failed
+	// attempts where the user could not be identified, in which case
+	// ActorUsername should be set to the identifier that was presented.
+	Actor         *User
+	ActorUsername string
+	ActorType     AuditActorType
+
+	TargetUserID *uuid.UUID
+	SessionID    *uuid.UUID
+	AuthMethod   string
+
+	Outcome       AuditOutcome
+	FailureReason string
+
+	IPAddress string
+	Traits    map[string]interface{}
 }
 
 // AuditLogEntry is the database model for audit log entries.
@@ -90,22 +227,103 @@ func (AuditLogEntry) TableName() string {
 	return tableName
 }
 
+// NewAuditLogEntry records a successful action performed by actor.
 func NewAuditLogEntry(r *http.Request, tx *storage.Connection, actor *User, action AuditAction, ipAddress string, traits map[string]interface{}) error {
+	return NewAuditLogEvent(r, tx, &AuditEvent{
+		Action:    action,
+		Actor:     actor,
+		IPAddress: ipAddress,
+		Traits:    traits,
+	})
+}
+
+// NewAuditLogEvent records event in the audit log.
+func NewAuditLogEvent(r *http.Request, tx *storage.Connection, event *AuditEvent) error {
 	id := uuid.Must(uuid.NewV4())
 
-	username := actor.GetEmail()
+	ac := GetAuditContext(r.Context())
 
-	if actor.GetPhone() != "" {
-		username = actor.GetPhone()
+	actorType := event.ActorType
+	if actorType == "" {
+		actorType = ac.ActorType
+	}
+	if actorType == "" {
+		actorType = AuditActorUser
+	}
+
+	outcome := event.Outcome
+	if outcome == "" {
+		outcome = AuditOutcomeSuccess
 	}
 
 	payload := map[string]interface{}{
-		"actor_id":       actor.ID,
-		"actor_via_sso":  actor.IsSSOUser,
-		"actor_username": username,
-		"action":         action,
-		"log_type":       ActionLogTypeMap[action],
+		"schema_version": AuditLogSchemaVersion,
+		"actor_type":     actorType,
+		"action":         event.Action,
+		"log_type":       ActionLogTypeMap[event.Action],
+		"outcome":        outcome,
+	}
+
+	username := event.ActorUsername
+	if actor := event.Actor; actor != nil {
+		username = actor.GetEmail()
+		if actor.GetPhone() != "" {
+			username = actor.GetPhone()
+		}
+		payload["actor_id"] = actor.ID
+		payload["actor_via_sso"] = actor.IsSSOUser
+		if name, ok := actor.UserMetaData["full_name"]; ok {
+			payload["actor_name"] = name
+		}
+	}
+	payload["actor_username"] = username
+
+	targetUserID := event.TargetUserID
+	if targetUserID == nil {
+		// admin actions have historically recorded the target in the traits
+		if id, ok := event.Traits["user_id"].(uuid.UUID); ok {
+			targetUserID = &id
+		} else if actorType == AuditActorUser && event.Actor != nil {
+			targetUserID = &event.Actor.ID
+		}
+	}
+	if targetUserID != nil {
+		payload["target_user_id"] = *targetUserID
+	}
+
+	sessionID := event.SessionID
+	if sessionID == nil {
+		sessionID = ac.SessionID
+	}
+	if sessionID != nil {
+		payload["session_id"] = *sessionID
+	}
+
+	authMethod := event.AuthMethod
+	if authMethod == "" {
+		authMethod = ac.AuthMethod
+	}
+	if authMethod != "" {
+		payload["auth_method"] = authMethod
+	}
+
+	if ac.Impersonator != "" {
+		payload["impersonator"] = ac.Impersonator
 	}
+
+	if ac.ServiceClientID != nil {
+		payload["service_client_id"] = *ac.ServiceClientID
+	}
+
+	if event.FailureReason != "" {
+		payload["failure_reason"] = event.FailureReason
+	}
+
+	ipAddress := event.IPAddress
+	if ipAddress == "" {
+		ipAddress = utilities.GetIPAddress(r)
+	}
+
 	l := AuditLogEntry{
 		ID:        id,
 		Payload:   JSONMap(payload),
@@ -116,12 +334,8 @@ func NewAuditLogEntry(r *http.Request, tx *storage.Connection, actor *User, acti
 		"auth_event": logrus.Fields(payload),
 	})
 
-	if name, ok := actor.UserMetaData["full_name"]; ok {
-		l.Payload["actor_name"] = name
-	}
-
-	if traits != nil {
-		l.Payload["traits"] = traits
+	if event.Traits != nil {
+		l.Payload["traits"] = event.Traits
 	}
 
 	if err := tx.Create(&l); err != nil {
@@ -131,20 +345,37 @@ func NewAuditLogEntry(r *http.Request, tx *storage.Connection, actor *User, acti
 	return nil
 }
 
-func FindAuditLogEntries(tx *storage.Connection, filterColumns []string, filterValue string, pageParams *Pagination) ([]*AuditLogEntry, error) {
-	q := tx.Q().Order("created_at desc").Where("instance_id = ?", uuid.Nil)
+// AuditLogFilter narrows down the entries returned by FindAuditLogEntries.
+// Zero values mean "don't filter on this attribute".
+type AuditLogFilter struct {
+	// Columns and Value are the legacy substring search over a set of
+	// payload fields.
+	Columns []string
+	Value   string
+
+	Actions      []string
+	ActorID      *uuid.UUID
+	TargetUserID *uuid.UUID
+	IPAddress    string
+	ActorType    string
+	Outcome      string
 
-	if len(filterColumns) > 0 && filterValue != "" {
-		lf := "%" + filterValue + "%"
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+func (f *AuditLogFilter) apply(q *pop.Query) *pop.Query {
+	if len(f.Columns) > 0 && f.Value != "" {
+		lf := "%" + f.Value + "%"
 
 		builder := bytes.NewBufferString("(")
-		values := make([]interface{}, len(filterColumns))
+		values := make([]interface{}, len(f.Columns))
 
-		for idx, col := range filterColumns {
+		for idx, col := range f.Columns {
 			builder.WriteString(fmt.Sprintf("payload->>'%s' ILIKE ?", col))
 			values[idx] = lf
 
-			if idx+1 < len(filterColumns) {
+			if idx+1 < len(f.Columns) {
 				builder.WriteString(" OR ")
 			}
 		}
@@ -153,7 +384,79 @@ func FindAuditLogEntries(tx *storage.Connection, filterColumns []string, filterV
 		q = q.Where(builder.String(), values...)
 	}
 
+	if len(f.Actions) > 0 {
+		actions := make([]interface{}, len(f.Actions))
+		for i, action := range f.Actions {
+			actions[i] = action
+		}
+		q = q.Where("payload->>'action' in (?)", actions...)
+	}
+
+	if f.ActorID != nil {
+		q = q.Where("payload->>'actor_id' = ?", f.ActorID.String())
+	}
+
+	if f.TargetUserID != nil {
+		q = q.Where("coalesce(payload->>'target_user_id', payload->'traits'->>'user_id') = ?", f.TargetUserID.String())
+	}
+
+	if f.IPAddress != "" {
+		q = q.Where("ip_address = ?", f.IPAddress)
+	}
+
+	// entries written before actor types and outcomes were recorded are
+	// user actions that succeeded
+	if f.ActorType != "" {
+		q = q.Where("coalesce(payload->>'actor_type', 'user') = ?", f.ActorType)
+	}
+
+	if f.Outcome != "" {
+		q = q.Where("coalesce(payload->>'outcome', 'success') = ?", f.Outcome)
+	}
+
+	if f.CreatedAfter != nil {
+		q = q.Where("created_at >= ?", *f.CreatedAfter)
+	}
+
+	if f.CreatedBefore != nil {
+		q = q.Where("created_at < ?", *f.CreatedBefore)
+	}
+
+	return q
+}
+
+func FindAuditLogEntries(tx *storage.Connection, filter *AuditLogFilter, pageParams *Pagination) ([]*AuditLogEntry, error) {
+	q := tx.Q().Where("instance_id = ?", uuid.Nil)
+
+	if filter != nil {
+		q = filter.apply(q)
+	}
+
 	logs := []*AuditLogEntry{}
+
+	if pageParams != nil && pageParams.Keyset {
+		// entries without a created_at can't be paged through
+		q, _, err := applyKeyset(q.Where("created_at is not null"), nil, pageParams)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := q.All(&logs); err != nil {
+			return nil, err
+		}
+
+		pageParams.NextCursor = nil
+		if len(logs) > int(pageParams.PerPage) {
+			logs = logs[:pageParams.PerPage]
+			last := logs[len(logs)-1]
+			pageParams.NextCursor = &Cursor{Time: last.CreatedAt, ID: last.ID}
+		}
+
+		return logs, nil
+	}
+
+	q = q.Order("created_at desc")
+
 	var err error
 	if pageParams != nil {
 		err = q.Paginate(int(pageParams.Page), int(pageParams.PerPage)).All(&logs)
@@ -164,3 +467,19 @@ func FindAuditLogEntries(tx *storage.Connection, filterColumns []string, filterV
 
 	return logs, err
 }
+
+// FindAuditLogEntriesAfter returns up to limit entries in ascending
+// (created_at, id) order, starting settle before the cursor. The entries
+// just before the cursor are read again, as transactions still in flight
+// when the cursor was advanced may have committed entries there since.
+// Entries without a created_at can't be ordered and are left out.
+func FindAuditLogEntriesAfter(tx *storage.Connection, after Cursor, settle time.Duration, limit int) ([]*AuditLogEntry, error) {
+	q := tx.Q().Where("instance_id = ? and created_at is not null and (created_at, id) > (?, ?)", uuid.Nil, after.Time.Add(-settle), uuid.Nil)
+
+	logs := []*AuditLogEntry{}
+	if err := q.Order("created_at asc").Order("id asc").Limit(limit).All(&logs); err != nil {
+		return nil, errors.Wrap(err, "error finding audit log entries")
+	}
+
+	return logs, nil
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// ErrAuditLogExportCursorLocked is returned when another exporter leased
+// the audit log export cursor.
+var ErrAuditLogExportCursorLocked = errors.New("audit log export cursor is leased by another exporter")
+
+// AuditLogExportCursor records how far a named export consumer has read
+// the audit log. It is only advanced after a batch was delivered, which
+// gives at-least-once delivery.
+type AuditLogExportCursor struct {
+	Name          string     `json:"name" db:"name"`
+	LastCreatedAt *time.Time `json:"last_created_at" db:"last_created_at"`
+	LastID        *uuid.UUID `json:"last_id" db:"last_id"`
+	LeasedUntil   *time.Time `json:"leased_until" db:"leased_until"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+func (AuditLogExportCursor) TableName() string {
+	tableName := "audit_log_export_cursors"
+	return tableName
+}
+
+// Cursor returns the position of the export consumer. Until it exported
+// anything, that is the Unix epoch, before any entry.
+func (c *AuditLogExportCursor) Cursor() Cursor {
+	if c.LastCreatedAt == nil || c.LastID == nil {
+		return Cursor{Time: time.Unix(0, 0).UTC()}
+	}
+
+	return Cursor{Time: *c.LastCreatedAt, ID: *c.LastID}
+}
+
+// LeaseAuditLogExportCursor creates the named cursor if needed and leases
+// it for the duration, so that the entries following it can be sent
+// without holding a transaction open. If another exporter holds the lease,
+// ErrAuditLogExportCursorLocked is returned so that concurrent exporters
+// skip the round instead of exporting the same entries twice.
+func LeaseAuditLogExportCursor(tx *storage.Connection, name string, duration time.Duration) (*AuditLogExportCursor, error) {
+	tableName := AuditLogExportCursor{}.TableName()
+
+	if err := tx.RawQuery(fmt.Sprintf("insert into %q (name) values (?) on conflict do nothing", tableName), name).Exec(); err != nil {
+		return nil, errors.Wrap(err, "error creating audit log export cursor")
+	}
+
+	cursor := &AuditLogExportCursor{}
+	if err := tx.RawQuery(
+		fmt.Sprintf("update %q set leased_until = now() + interval '%d milliseconds' where name = ? and (leased_until is null or leased_until <= now()) returning *", tableName, duration.Milliseconds()),
+		name,
+	).First(cursor); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ErrAuditLogExportCursorLocked
+		}
+		return nil, errors.Wrap(err, "error leasing audit log export cursor")
+	}
+
+	return cursor, nil
+}
+
+// Release ends the lease on the cursor, and moves it to position if set.
+func (c *AuditLogExportCursor) Release(tx *storage.Connection, position *Cursor) error {
+	if position != nil {
+		c.LastCreatedAt = &position.Time
+		c.LastID = &position.ID
+	}
+	c.LeasedUntil = nil
+	c.UpdatedAt = time.Now()
+
+	return tx.RawQuery(
+		fmt.Sprintf("update %q set last_created_at = ?, last_id = ?, leased_until = null, updated_at = ? where name = ?", c.TableName()),
+		c.LastCreatedAt, c.LastID, c.UpdatedAt, c.Name,
+	).Exec()
+}
@@ -3,12 +3,14 @@ package models
 import (
 	"context"
 	"fmt"
-	"github.com/sirupsen/logrus"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/metric"
+	"slices"
+	"strings"
 	"sync/atomic"
 
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/observability"
@@ -27,7 +29,8 @@ type Cleanup struct {
 	cleanupAffectedRows atomic.Int64
 }
 
-func NewCleanup(config *conf.GlobalConfiguration) *Cleanup {
+// cleanupStatements returns the statements a cleanup runs with config.
+func cleanupStatements(config *conf.GlobalConfiguration) []string {
 	tableUsers := User{}.TableName()
 	tableRefreshTokens := RefreshToken{}.TableName()
 	tableSessions := Session{}.TableName()
@@ -35,14 +38,18 @@ func NewCleanup(config *conf.GlobalConfiguration) *Cleanup {
 	tableFlowStates := FlowState{}.TableName()
 	tableMFAChallenges := Challenge{}.TableName()
 	tableMFAFactors := Factor{}.TableName()
+	tableDeviceCodes := DeviceCode{}.TableName()
+	tableAPIKeys := APIKey{}.TableName()
+	tableServiceClientAssertions := ServiceClientAssertion{}.TableName()
+	tableDPoPProofs := DPoPProof{}.TableName()
 
-	c := &Cleanup{}
+	var statements []string
 
 	// These statements intentionally use SELECT ... FOR UPDATE SKIP LOCKED
 	// as this makes sure that only rows that are not being used in another
 	// transaction are deleted. These deletes are thus very quick and
 	// efficient, as they don't wait on other transactions.
-	c.cleanupStatements = append(c.cleanupStatements,
+	statements = append(statements,
 		fmt.Sprintf("delete from %q where id in (select id from %q where revoked is true and updated_at < now() - interval '24 hours' limit 100 for update skip locked);", tableRefreshTokens, tableRefreshTokens),
 		fmt.Sprintf("update %q set revoked = true, updated_at = now() where id in (select %q.id from %q join %q on %q.session_id = %q.id where %q.not_after < now() - interval '24 hours' and %q.revoked is false limit 100 for update skip locked);", tableRefreshTokens, tableRefreshTokens, tableRefreshTokens, tableSessions, tableRefreshTokens, tableSessions, tableSessions, tableRefreshTokens),
 		// sessions are deleted after 72 hours to allow refresh tokens
@@ -53,32 +60,62 @@ func NewCleanup(config *conf.GlobalConfiguration) *Cleanup {
 		fmt.Sprintf("delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' limit 100 for update skip locked);", tableFlowStates, tableFlowStates),
 		fmt.Sprintf("delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' limit 100 for update skip locked);", tableMFAChallenges, tableMFAChallenges),
 		fmt.Sprintf("delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' and status = 'unverified' limit 100 for update skip locked);", tableMFAFactors, tableMFAFactors),
+		fmt.Sprintf("delete from %q where id in (select id from %q where expires_at < now() - interval '24 hours' limit 100 for update skip locked);", tableDeviceCodes, tableDeviceCodes),
+		fmt.Sprintf("delete from %q where id in (select id from %q where expires_at < now() - interval '24 hours' limit 100 for update skip locked);", tableAPIKeys, tableAPIKeys),
+		fmt.Sprintf("delete from %q where (client_id, jti) in (select client_id, jti from %q where expires_at < now() limit 100 for update skip locked);", tableServiceClientAssertions, tableServiceClientAssertions),
+		fmt.Sprintf("delete from %q where (jkt, jti) in (select jkt, jti from %q where expires_at < now() limit 100 for update skip locked);", tableDPoPProofs, tableDPoPProofs),
 	)
 
-	if config.External.AnonymousUsers.Enabled {
-		// delete anonymous users older than 30 days
-		c.cleanupStatements = append(c.cleanupStatements,
-			fmt.Sprintf("delete from %q where id in (select id from %q where created_at < now() - interval '30 days' and is_anonymous is true limit 100 for update skip locked);", tableUsers, tableUsers),
+	if config.External.AnonymousUsers.Enabled && !config.Hook.AnonymousUserExpiry.Enabled {
+		// delete anonymous users that haven't been active for the
+		// retention period; with the anonymous user expiry hook
+		// enabled they're deleted by the API instead, which calls
+		// the hook first
+		retentionSeconds := int(config.External.AnonymousUsers.Retention.Seconds())
+
+		statements = append(statements,
+			fmt.Sprintf("delete from %q where id in (select id from %q where %s < now() - interval '%d seconds' and is_anonymous is true and %s limit %d for update skip locked);", tableUsers, tableUsers, anonymousUserLastActiveAt, retentionSeconds, anonymousUserNotRetained, config.External.AnonymousUsers.CleanupBatchSize),
 		)
 	}
 
+	if config.AuditLog.Retention > 0 {
+		tableAuditLogEntries := AuditLogEntry{}.TableName()
+		retentionSeconds := int(config.AuditLog.Retention.Seconds())
+
+		exported := ""
+		if config.AuditLog.Export.Enabled {
+			// entries that have not been exported yet must not be
+			// deleted, or the export would lose them; nor those in the
+			// settle window before the cursor, which is read again
+			exported = fmt.Sprintf(" and created_at <= coalesce((select last_created_at from %q where name = '%s'), '-infinity') - interval '%d seconds'", AuditLogExportCursor{}.TableName(), strings.ReplaceAll(config.AuditLog.Export.Name, "'", "''"), int(config.AuditLog.Export.Settle.Seconds()))
+		}
+
+		statements = append(statements, fmt.Sprintf("delete from %q where id in (select id from %q where created_at < now() - interval '%d seconds'%s limit 100 for update skip locked);", tableAuditLogEntries, tableAuditLogEntries, retentionSeconds, exported))
+	}
+
 	if config.Sessions.Timebox != nil {
 		timeboxSeconds := int((*config.Sessions.Timebox).Seconds())
 
-		c.cleanupStatements = append(c.cleanupStatements, fmt.Sprintf("delete from %q where id in (select id from %q where created_at + interval '%d seconds' < now() - interval '24 hours' limit 100 for update skip locked);", tableSessions, tableSessions, timeboxSeconds))
+		statements = append(statements, fmt.Sprintf("delete from %q where id in (select id from %q where created_at + interval '%d seconds' < now() - interval '24 hours' limit 100 for update skip locked);", tableSessions, tableSessions, timeboxSeconds))
 	}
 
 	if config.Sessions.InactivityTimeout != nil {
 		inactivitySeconds := int((*config.Sessions.InactivityTimeout).Seconds())
 
 		// delete sessions with a refreshed_at column
-		c.cleanupStatements = append(c.cleanupStatements, fmt.Sprintf("delete from %q where id in (select id from %q where refreshed_at is not null and refreshed_at + interval '%d seconds' < now() - interval '24 hours' limit 100 for update skip locked);", tableSessions, tableSessions, inactivitySeconds))
+		statements = append(statements, fmt.Sprintf("delete from %q where id in (select id from %q where refreshed_at is not null and refreshed_at + interval '%d seconds' < now() - interval '24 hours' limit 100 for update skip locked);", tableSessions, tableSessions, inactivitySeconds))
 
 		// delete sessions without a refreshed_at column by looking for
 		// unrevoked refresh_tokens
-		c.cleanupStatements = append(c.cleanupStatements, fmt.Sprintf("delete from %q where id in (select %q.id as id from %q, %q where %q.session_id = %q.id and %q.refreshed_at is null and %q.revoked is false and %q.updated_at + interval '%d seconds' < now() - interval '24 hours' limit 100 for update skip locked)", tableSessions, tableSessions, tableSessions, tableRefreshTokens, tableRefreshTokens, tableSessions, tableSessions, tableRefreshTokens, tableRefreshTokens, inactivitySeconds))
+		statements = append(statements, fmt.Sprintf("delete from %q where id in (select %q.id as id from %q, %q where %q.session_id = %q.id and %q.refreshed_at is null and %q.revoked is false and %q.updated_at + interval '%d seconds' < now() - interval '24 hours' limit 100 for update skip locked)", tableSessions, tableSessions, tableSessions, tableRefreshTokens, tableRefreshTokens, tableSessions, tableSessions, tableRefreshTokens, tableRefreshTokens, inactivitySeconds))
 	}
 
+	return statements
+}
+
+func NewCleanup(config *conf.GlobalConfiguration) *Cleanup {
+	c := &Cleanup{cleanupStatements: cleanupStatements(config)}
+
 	meter := otel.Meter("gotrue")
 
 	_, err := meter.Int64ObservableCounter(
@@ -97,6 +134,13 @@ func NewCleanup(config *conf.GlobalConfiguration) *Cleanup {
 	return c
 }
 
+// Matches reports whether the cleanup runs the same statements as one
+// created with config would, so it can be kept when the configuration is
+// reloaded.
+func (c *Cleanup) Matches(config *conf.GlobalConfiguration) bool {
+	return slices.Equal(c.cleanupStatements, cleanupStatements(config))
+}
+
 // Cleanup removes stale entities in the database. You can call it on each
 // request or as a periodic background job. It does quick lockless updates or
 // deletes, has an execution timeout and acquire timeout so that cleanups do
@@ -21,6 +21,8 @@ func TestCleanup(t *testing.T) {
 	globalConfig.Sessions.Timebox = &timebox
 	globalConfig.Sessions.InactivityTimeout = &inactivityTimeout
 	globalConfig.External.AnonymousUsers.Enabled = true
+	globalConfig.AuditLog.Retention = 90 * 24 * time.Hour
+	globalConfig.AuditLog.Export.Enabled = true
 
 	cleanup := NewCleanup(globalConfig)
 
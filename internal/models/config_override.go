@@ -0,0 +1,127 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// ConfigOverride is a configuration value set by an admin, which is
+// applied over the environment configuration. Key is the path of the
+// overridden field, such as external.github.enabled, and Value its JSON
+// encoded value. Values of secret fields are stored encrypted.
+type ConfigOverride struct {
+	Key       string    `json:"key" db:"key"`
+	Value     string    `json:"-" db:"value"`
+	Secret    bool      `json:"secret" db:"secret"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (ConfigOverride) TableName() string {
+	tableName := "config_overrides"
+	return tableName
+}
+
+func NewConfigOverride(key string, secret bool) *ConfigOverride {
+	return &ConfigOverride{
+		Key:    key,
+		Secret: secret,
+	}
+}
+
+// SetValue sets the JSON encoded value, encrypting it if requested.
+func (o *ConfigOverride) SetValue(value json.RawMessage, encrypt bool, encryptionKeyID, encryptionKey string) error {
+	o.Value = string(value)
+	if encrypt {
+		es, err := crypto.NewEncryptedString(o.Key, value, encryptionKeyID, encryptionKey)
+		if err != nil {
+			return err
+		}
+
+		o.Value = es.String()
+	}
+
+	return nil
+}
+
+// GetValue returns the JSON encoded value, decrypting it if needed.
+func (o *ConfigOverride) GetValue(decryptionKeys map[string]string) (json.RawMessage, error) {
+	if es := crypto.ParseEncryptedString(o.Value); es != nil {
+		bytes, err := es.Decrypt(o.Key, decryptionKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.RawMessage(bytes), nil
+	}
+
+	return json.RawMessage(o.Value), nil
+}
+
+// Save creates the override, or updates the value of an existing override
+// of the same key.
+func (o *ConfigOverride) Save(tx *storage.Connection) error {
+	now := time.Now()
+
+	return tx.RawQuery(
+		fmt.Sprintf("insert into %q (key, value, secret, created_at, updated_at) values (?, ?, ?, ?, ?) on conflict (key) do update set value = excluded.value, secret = excluded.secret, updated_at = excluded.updated_at returning *", o.TableName()),
+		o.Key, o.Value, o.Secret, now, now,
+	).First(o)
+}
+
+// Delete removes the override.
+func (o *ConfigOverride) Delete(tx *storage.Connection) error {
+	return tx.RawQuery(fmt.Sprintf("delete from %q where key = ?", o.TableName()), o.Key).Exec()
+}
+
+// FindConfigOverrides returns all configuration overrides ordered by key.
+func FindConfigOverrides(tx *storage.Connection) ([]*ConfigOverride, error) {
+	overrides := []*ConfigOverride{}
+	if err := tx.Q().Order("key asc").All(&overrides); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return overrides, nil
+		}
+		return nil, errors.Wrap(err, "error finding config overrides")
+	}
+
+	return overrides, nil
+}
+
+// FindConfigOverrideByKey finds the configuration override of the key.
+func FindConfigOverrideByKey(tx *storage.Connection, key string) (*ConfigOverride, error) {
+	override := &ConfigOverride{}
+	if err := tx.Q().Where("key = ?", key).First(override); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ConfigOverrideNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding config override")
+	}
+
+	return override, nil
+}
+
+// LoadConfigOverrides returns the decrypted values of all configuration
+// overrides by key.
+func LoadConfigOverrides(tx *storage.Connection, decryptionKeys map[string]string) (map[string]json.RawMessage, error) {
+	overrides, err := FindConfigOverrides(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]json.RawMessage, len(overrides))
+	for _, override := range overrides {
+		value, err := override.GetValue(decryptionKeys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decrypting config override %q", override.Key)
+		}
+		values[override.Key] = value
+	}
+
+	return values, nil
+}
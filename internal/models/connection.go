@@ -1,7 +1,13 @@
 package models
 
 import (
+	"encoding/base64"
+	"strings"
+	"time"
+
 	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
 	"github.com/supabase/auth/internal/storage"
 )
 
@@ -9,6 +15,13 @@ type Pagination struct {
 	Page    uint64
 	PerPage uint64
 	Count   uint64
+
+	// Keyset switches the query from offset to keyset (cursor)
+	// pagination. After is the position to continue from, or nil for the
+	// first page. NextCursor is set by the query when more rows remain.
+	Keyset     bool
+	After      *Cursor
+	NextCursor *Cursor
 }
 
 func (p *Pagination) Offset() uint64 {
@@ -20,6 +33,8 @@ type SortDirection string
 const Ascending SortDirection = "ASC"
 const Descending SortDirection = "DESC"
 const CreatedAt = "created_at"
+const UpdatedAt = "updated_at"
+const LastSignInAt = "last_sign_in_at"
 
 type SortParams struct {
 	Fields []SortField
@@ -30,6 +45,78 @@ type SortField struct {
 	Dir  SortDirection
 }
 
+// Cursor identifies a position in a result set ordered by a timestamp
+// column, using the row ID to break ties.
+type Cursor struct {
+	Time time.Time
+	ID   uuid.UUID
+}
+
+// String encodes the cursor into an opaque, URL-safe value.
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Time.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()))
+}
+
+// ParseCursor decodes a cursor previously produced by Cursor.String.
+func ParseCursor(value string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed cursor")
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed cursor")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed cursor timestamp")
+	}
+
+	id, err := uuid.FromString(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed cursor id")
+	}
+
+	return &Cursor{Time: t, ID: id}, nil
+}
+
+// KeysetColumns are the columns that can be used to order a keyset
+// paginated query. They must be non-nullable timestamps.
+var KeysetColumns = map[string]bool{
+	CreatedAt: true,
+	UpdatedAt: true,
+}
+
+// applyKeyset orders q by the single sort field and restricts it to the
+// rows that follow the cursor in pageParams. One more row than requested is
+// fetched so that the caller can tell whether a next page exists.
+func applyKeyset(q *pop.Query, sortParams *SortParams, pageParams *Pagination) (*pop.Query, SortField, error) {
+	field := SortField{Name: CreatedAt, Dir: Descending}
+	if sortParams != nil && len(sortParams.Fields) > 0 {
+		if len(sortParams.Fields) > 1 {
+			return nil, field, errors.New("cursor pagination supports only one sort field")
+		}
+		field = sortParams.Fields[0]
+	}
+
+	if !KeysetColumns[field.Name] {
+		return nil, field, errors.Errorf("cursor pagination is not supported when sorting by %s", field.Name)
+	}
+
+	if pageParams.After != nil {
+		op := "<"
+		if field.Dir == Ascending {
+			op = ">"
+		}
+
+		q = q.Where("("+field.Name+", id) "+op+" (?, ?)", pageParams.After.Time, pageParams.After.ID)
+	}
+
+	return q.Order(field.Name + " " + string(field.Dir)).Order("id " + string(field.Dir)).Limit(int(pageParams.PerPage) + 1), field, nil
+}
+
 // TruncateAll deletes all data from the database, as managed by GoTrue. Not
 // intended for use outside of tests.
 func TruncateAll(conn *storage.Connection) error {
@@ -49,6 +136,13 @@ func TruncateAll(conn *storage.Connection) error {
 			(&pop.Model{Value: SAMLRelayState{}}).TableName(),
 			(&pop.Model{Value: FlowState{}}).TableName(),
 			(&pop.Model{Value: OneTimeToken{}}).TableName(),
+			(&pop.Model{Value: AuditLogExportCursor{}}).TableName(),
+			(&pop.Model{Value: ConfigOverride{}}).TableName(),
+			(&pop.Model{Value: DeviceCode{}}).TableName(),
+			(&pop.Model{Value: ServiceClient{}}).TableName(),
+			(&pop.Model{Value: ServiceClientAssertion{}}).TableName(),
+			(&pop.Model{Value: DPoPProof{}}).TableName(),
+			(&pop.Model{Value: APIKey{}}).TableName(),
 		}
 
 		for _, tableName := range tables {
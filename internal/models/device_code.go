@@ -0,0 +1,181 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/storage"
+)
+
+type DeviceCodeStatus string
+
+const (
+	DeviceCodePending  DeviceCodeStatus = "pending"
+	DeviceCodeApproved DeviceCodeStatus = "approved"
+	DeviceCodeDenied   DeviceCodeStatus = "denied"
+)
+
+// userCodeAlphabet has no vowels, so that user codes don't spell words, and
+// no easily confused characters (RFC 8628 section 6.1).
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+const userCodeLength = 8
+
+// DeviceCode is a pending device authorization grant (RFC 8628). The device
+// polls with the device code, which is only stored hashed, while the user
+// approves the request by entering the user code in a signed in browser.
+type DeviceCode struct {
+	ID              uuid.UUID        `json:"id" db:"id"`
+	DeviceCodeHash  string           `json:"-" db:"device_code_hash"`
+	UserCode        string           `json:"user_code" db:"user_code"`
+	ClientID        *string          `json:"client_id,omitempty" db:"client_id"`
+	Status          DeviceCodeStatus `json:"status" db:"status"`
+	UserID          *uuid.UUID       `json:"user_id,omitempty" db:"user_id"`
+	PollingInterval int              `json:"polling_interval" db:"polling_interval"`
+	LastPolledAt    *time.Time       `json:"last_polled_at,omitempty" db:"last_polled_at"`
+	ExpiresAt       time.Time        `json:"expires_at" db:"expires_at"`
+	CreatedAt       time.Time        `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at" db:"updated_at"`
+}
+
+func (DeviceCode) TableName() string {
+	tableName := "device_codes"
+	return tableName
+}
+
+// NewDeviceCode creates a pending device code and returns it along with the
+// device code in the clear, which is only known to the device.
+func NewDeviceCode(clientID string, pollingInterval, expiry time.Duration) (*DeviceCode, string, error) {
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, "", err
+	}
+
+	deviceCode := crypto.SecureToken(32)
+
+	d := &DeviceCode{
+		ID:              uuid.Must(uuid.NewV4()),
+		DeviceCodeHash:  hashDeviceCode(deviceCode),
+		UserCode:        userCode,
+		Status:          DeviceCodePending,
+		PollingInterval: int(pollingInterval.Seconds()),
+		ExpiresAt:       time.Now().Add(expiry),
+	}
+
+	if clientID != "" {
+		d.ClientID = &clientID
+	}
+
+	return d, deviceCode, nil
+}
+
+func hashDeviceCode(deviceCode string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(deviceCode)))
+}
+
+func generateUserCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			b.WriteByte('-')
+		}
+
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", errors.Wrap(err, "error generating user code")
+		}
+
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+
+	return b.String(), nil
+}
+
+// NormalizeUserCode brings a user code as typed by a user, in any case and
+// with or without the dash, to its stored form.
+func NormalizeUserCode(userCode string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(userCode) {
+		if strings.ContainsRune(userCodeAlphabet, c) {
+			b.WriteRune(c)
+		}
+	}
+
+	code := b.String()
+	if len(code) != userCodeLength {
+		return code
+	}
+
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// FindDeviceCodeByDeviceCode finds a device code and locks it for the rest
+// of the transaction, so that concurrent polls don't both get tokens.
+func FindDeviceCodeByDeviceCode(tx *storage.Connection, deviceCode string) (*DeviceCode, error) {
+	obj := &DeviceCode{}
+	if err := tx.RawQuery(fmt.Sprintf("select * from %q where device_code_hash = ? limit 1 for update", obj.TableName()), hashDeviceCode(deviceCode)).First(obj); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, DeviceCodeNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding device code")
+	}
+
+	return obj, nil
+}
+
+// FindDeviceCodeByUserCode finds a device code and locks it for the rest of
+// the transaction, so that it isn't approved or denied concurrently, or
+// while the device is being given a session.
+func FindDeviceCodeByUserCode(tx *storage.Connection, userCode string) (*DeviceCode, error) {
+	obj := &DeviceCode{}
+	if err := tx.RawQuery(fmt.Sprintf("select * from %q where user_code = ? limit 1 for update", obj.TableName()), NormalizeUserCode(userCode)).First(obj); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, DeviceCodeNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding device code")
+	}
+
+	return obj, nil
+}
+
+func (d *DeviceCode) IsExpired() bool {
+	return time.Now().After(d.ExpiresAt)
+}
+
+// Approve grants the device a session for the user.
+func (d *DeviceCode) Approve(tx *storage.Connection, userID uuid.UUID) error {
+	d.Status = DeviceCodeApproved
+	d.UserID = &userID
+	return tx.UpdateOnly(d, "status", "user_id", "updated_at")
+}
+
+func (d *DeviceCode) Deny(tx *storage.Connection) error {
+	d.Status = DeviceCodeDenied
+	return tx.UpdateOnly(d, "status", "updated_at")
+}
+
+// RecordPoll records that the device polled, and reports whether it polled
+// sooner than the polling interval. In that case the interval is increased
+// by 5 seconds, as required by RFC 8628 section 3.5.
+func (d *DeviceCode) RecordPoll(tx *storage.Connection) (bool, error) {
+	now := time.Now()
+
+	slowDown := d.LastPolledAt != nil && now.Sub(*d.LastPolledAt) < time.Duration(d.PollingInterval)*time.Second
+	if slowDown {
+		d.PollingInterval += 5
+	}
+
+	d.LastPolledAt = &now
+
+	return slowDown, tx.UpdateOnly(d, "polling_interval", "last_polled_at", "updated_at")
+}
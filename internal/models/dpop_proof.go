@@ -0,0 +1,40 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// DPoPProof is a DPoP proof already used, kept until it expires so that it
+// can't be used again.
+type DPoPProof struct {
+	JKT       string    `json:"jkt" db:"jkt"`
+	JTI       string    `json:"jti" db:"jti"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (DPoPProof) TableName() string {
+	tableName := "dpop_proofs"
+	return tableName
+}
+
+// UseDPoPProof records that the proof with the jti, signed with the key
+// with thumbprint jkt, was used, and reports whether it wasn't used before.
+// A jti can be used again once the proof expired.
+func UseDPoPProof(tx *storage.Connection, jkt, jti string, expiresAt time.Time) (bool, error) {
+	table := DPoPProof{}.TableName()
+
+	count, err := tx.RawQuery(
+		fmt.Sprintf("insert into %q (jkt, jti, expires_at) values (?, ?, ?) on conflict (jkt, jti) do update set expires_at = excluded.expires_at, created_at = now() where %q.expires_at <= now()", table, table),
+		jkt, jti, expiresAt,
+	).ExecWithCount()
+	if err != nil {
+		return false, errors.Wrap(err, "error recording DPoP proof")
+	}
+
+	return count > 0, nil
+}
@@ -27,6 +27,14 @@ func IsNotFoundError(err error) bool {
 		return true
 	case OneTimeTokenNotFoundError, *OneTimeTokenNotFoundError:
 		return true
+	case ConfigOverrideNotFoundError, *ConfigOverrideNotFoundError:
+		return true
+	case DeviceCodeNotFoundError, *DeviceCodeNotFoundError:
+		return true
+	case ServiceClientNotFoundError, *ServiceClientNotFoundError:
+		return true
+	case APIKeyNotFoundError, *APIKeyNotFoundError:
+		return true
 	}
 	return false
 }
@@ -123,3 +131,34 @@ type UserEmailUniqueConflictError struct{}
 func (e UserEmailUniqueConflictError) Error() string {
 	return "User email unique constraint violated"
 }
+
+// ConfigOverrideNotFoundError represents when a configuration override is
+// not found.
+type ConfigOverrideNotFoundError struct{}
+
+func (e ConfigOverrideNotFoundError) Error() string {
+	return "Configuration override not found"
+}
+
+// DeviceCodeNotFoundError represents an error when a device code can't be
+// found.
+type DeviceCodeNotFoundError struct{}
+
+func (e DeviceCodeNotFoundError) Error() string {
+	return "Device code not found"
+}
+
+// ServiceClientNotFoundError represents an error when a service client
+// can't be found.
+type ServiceClientNotFoundError struct{}
+
+func (e ServiceClientNotFoundError) Error() string {
+	return "Service client not found"
+}
+
+// APIKeyNotFoundError represents an error when an API key can't be found.
+type APIKeyNotFoundError struct{}
+
+func (e APIKeyNotFoundError) Error() string {
+	return "API key not found"
+}
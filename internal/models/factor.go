@@ -47,6 +47,11 @@ const (
 	EmailChange
 	TokenRefresh
 	Anonymous
+	Impersonation
+	TokenExchange
+	DeviceAuthorization
+	APIKeyAuth
+	ClientCredentials
 )
 
 func (authMethod AuthenticationMethod) String() string {
@@ -75,6 +80,16 @@ func (authMethod AuthenticationMethod) String() string {
 		return "token_refresh"
 	case Anonymous:
 		return "anonymous"
+	case Impersonation:
+		return "impersonation"
+	case TokenExchange:
+		return "token_exchange"
+	case DeviceAuthorization:
+		return "device_code"
+	case APIKeyAuth:
+		return "api_key"
+	case ClientCredentials:
+		return "client_credentials"
 	}
 	return ""
 }
@@ -106,6 +121,16 @@ func ParseAuthenticationMethod(authMethod string) (AuthenticationMethod, error)
 		return EmailChange, nil
 	case "token_refresh":
 		return TokenRefresh, nil
+	case "impersonation":
+		return Impersonation, nil
+	case "token_exchange":
+		return TokenExchange, nil
+	case "device_code":
+		return DeviceAuthorization, nil
+	case "api_key":
+		return APIKeyAuth, nil
+	case "client_credentials":
+		return ClientCredentials, nil
 	}
 	return 0, fmt.Errorf("unsupported authentication method %q", authMethod)
 }
@@ -1,7 +1,11 @@
 package models
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -17,7 +21,16 @@ import (
 type RefreshToken struct {
 	ID int64 `db:"id"`
 
-	Token string `db:"token"`
+	// Token is the refresh token given to the client. It's only known
+	// when the token is issued or presented by the client, as only its
+	// hash is stored.
+	Token string `db:"-"`
+
+	// TokenHash is the SHA-256 hash of the refresh token, or the token
+	// itself for tokens issued before they were stored hashed.
+	TokenHash string             `db:"token"`
+	Hashed    bool               `db:"hashed"`
+	Salt      storage.NullString `db:"salt"`
 
 	UserID uuid.UUID `db:"user_id"`
 
@@ -36,6 +49,46 @@ func (RefreshToken) TableName() string {
 	return tableName
 }
 
+func (r *RefreshToken) AfterFind(tx *pop.Connection) error {
+	if !r.Hashed {
+		r.Token = r.TokenHash
+	}
+	return nil
+}
+
+// RecoverToken sets Token for a refresh token that was derived from the
+// refresh token parent, and reports whether it could be recovered.
+func (r *RefreshToken) RecoverToken(parent string) bool {
+	if r.Token != "" {
+		return true
+	}
+
+	if r.Salt == "" || parent == "" {
+		return false
+	}
+
+	token := deriveRefreshToken(parent, string(r.Salt))
+	if !hmac.Equal([]byte(hashRefreshToken(token)), []byte(r.TokenHash)) {
+		return false
+	}
+
+	r.Token = token
+	return true
+}
+
+func hashRefreshToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// deriveRefreshToken derives a child refresh token from the token of its
+// parent. As the parent token is never stored, the child can only be
+// derived again by a client presenting the parent.
+func deriveRefreshToken(parent, salt string) string {
+	mac := hmac.New(sha256.New, []byte(parent))
+	mac.Write([]byte(salt))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
 // GrantParams is used to pass session-specific parameters when issuing a new
 // refresh token to authenticated users.
 type GrantParams struct {
@@ -43,6 +96,7 @@ type GrantParams struct {
 
 	SessionNotAfter *time.Time
 	SessionTag      *string
+	SessionDPoPJKT  *string
 
 	UserAgent string
 	IP        string
@@ -67,8 +121,20 @@ func GrantRefreshTokenSwap(r *http.Request, tx *storage.Connection, user *User,
 			return errors.Wrap(terr, "error creating audit log entry")
 		}
 
+		if !token.Hashed {
+			// tokens issued before refresh tokens were stored
+			// hashed are upgraded when they are swapped
+			tokenHash := hashRefreshToken(token.Token)
+			if terr = tx.RawQuery("update "+token.TableName()+" set parent = ? where parent = ?", tokenHash, token.TokenHash).Exec(); terr != nil {
+				return errors.Wrap(terr, "error updating children of refresh token")
+			}
+
+			token.TokenHash = tokenHash
+			token.Hashed = true
+		}
+
 		token.Revoked = true
-		if terr = tx.UpdateOnly(token, "revoked"); terr != nil {
+		if terr = tx.UpdateOnly(token, "revoked", "token", "hashed"); terr != nil {
 			return terr
 		}
 
@@ -91,7 +157,7 @@ func RevokeTokenFamily(tx *storage.Connection, token *RefreshToken) error {
 			union
 			select r.id, r.user_id, r.token, r.revoked, r.parent from `+tablename+` r inner join token_family t on t.token = r.parent
 		)
-		update `+tablename+` r set revoked = true from token_family where token_family.id = r.id;`, token.Token).Exec()
+		update `+tablename+` r set revoked = true from token_family where token_family.id = r.id;`, token.TokenHash).Exec()
 	}
 	if err != nil {
 		if errors.Cause(err) == sql.ErrNoRows || errors.Is(err, sql.ErrNoRows) {
@@ -120,11 +186,18 @@ func createRefreshToken(tx *storage.Connection, user *User, oldToken *RefreshTok
 		UserID: user.ID,
 		Token:  crypto.SecureToken(),
 		Parent: "",
+		Hashed: true,
 	}
 	if oldToken != nil {
-		token.Parent = storage.NullString(oldToken.Token)
+		if oldToken.Token != "" {
+			salt := crypto.SecureToken()
+			token.Token = deriveRefreshToken(oldToken.Token, salt)
+			token.Salt = storage.NullString(salt)
+		}
+		token.Parent = storage.NullString(oldToken.TokenHash)
 		token.SessionId = oldToken.SessionId
 	}
+	token.TokenHash = hashRefreshToken(token.Token)
 
 	if token.SessionId == nil {
 		session, err := NewSession(user.ID, params.FactorID)
@@ -148,6 +221,10 @@ func createRefreshToken(tx *storage.Connection, user *User, oldToken *RefreshTok
 			session.Tag = params.SessionTag
 		}
 
+		if params.SessionDPoPJKT != nil {
+			session.DPoPJKT = params.SessionDPoPJKT
+		}
+
 		if err := tx.Create(session); err != nil {
 			return nil, errors.Wrap(err, "error creating new session")
 		}
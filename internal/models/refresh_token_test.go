@@ -62,6 +62,55 @@ func (ts *RefreshTokenTestSuite) TestGrantRefreshTokenSwap() {
 	require.Equal(ts.T(), u.ID, s.UserID)
 }
 
+func (ts *RefreshTokenTestSuite) TestTokenIsStoredHashed() {
+	u := ts.createUser()
+	r, err := GrantAuthenticatedUser(ts.db, u, GrantParams{})
+	require.NoError(ts.T(), err)
+
+	stored := &RefreshToken{}
+	require.NoError(ts.T(), ts.db.Find(stored, r.ID))
+	require.True(ts.T(), stored.Hashed)
+	require.Equal(ts.T(), hashRefreshToken(r.Token), stored.TokenHash)
+	require.Empty(ts.T(), stored.Token)
+
+	_, _, _, err = FindUserWithRefreshToken(ts.db, stored.TokenHash, false)
+	require.True(ts.T(), IsNotFoundError(err), "expected the hash not to be usable as a refresh token")
+
+	s, err := GrantRefreshTokenSwap(&http.Request{}, ts.db, u, r)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), r.TokenHash, s.Parent.String())
+
+	child := &RefreshToken{}
+	require.NoError(ts.T(), ts.db.Find(child, s.ID))
+	require.False(ts.T(), child.RecoverToken("not the parent"))
+	require.True(ts.T(), child.RecoverToken(r.Token))
+	require.Equal(ts.T(), s.Token, child.Token)
+}
+
+func (ts *RefreshTokenTestSuite) TestLegacyTokenIsUpgraded() {
+	u := ts.createUser()
+	r, err := GrantAuthenticatedUser(ts.db, u, GrantParams{})
+	require.NoError(ts.T(), err)
+
+	// store the token as it was before refresh tokens were hashed
+	require.NoError(ts.T(), ts.db.RawQuery("update refresh_tokens set token = ?, hashed = false where id = ?", r.Token, r.ID).Exec())
+
+	_, legacy, _, err := FindUserWithRefreshToken(ts.db, r.Token, false)
+	require.NoError(ts.T(), err)
+	require.False(ts.T(), legacy.Hashed)
+
+	s, err := GrantRefreshTokenSwap(&http.Request{}, ts.db, u, legacy)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), hashRefreshToken(r.Token), s.Parent.String())
+
+	_, upgraded, _, err := FindUserWithRefreshToken(ts.db, r.Token, false)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), r.ID, upgraded.ID)
+	require.True(ts.T(), upgraded.Hashed)
+	require.True(ts.T(), upgraded.Revoked)
+	require.Equal(ts.T(), hashRefreshToken(r.Token), upgraded.TokenHash)
+}
+
 func (ts *RefreshTokenTestSuite) TestLogout() {
 	u := ts.createUser()
 	r, err := GrantAuthenticatedUser(ts.db, u, GrantParams{})
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/storage"
+)
+
+const (
+	ServiceClientSecretAuth     = "client_secret"
+	ServiceClientPrivateKeyAuth = "private_key_jwt"
+)
+
+// ServiceClient is a backend service that obtains access tokens with the
+// client_credentials grant. It authenticates either with a secret, of which
+// only a hash is stored, or with JWTs signed by the private key matching
+// PublicKey. Tokens issued to it carry its Role and Scope.
+type ServiceClient struct {
+	ID         uuid.UUID  `json:"client_id" db:"id"`
+	Name       string     `json:"name" db:"name"`
+	Role       string     `json:"role" db:"role"`
+	Scope      string     `json:"scope" db:"scope"`
+	SecretHash *string    `json:"-" db:"secret_hash"`
+	PublicKey  *string    `json:"public_key,omitempty" db:"public_key"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+func (ServiceClient) TableName() string {
+	tableName := "service_clients"
+	return tableName
+}
+
+func NewServiceClient(name, role, scope string) *ServiceClient {
+	return &ServiceClient{
+		ID:    uuid.Must(uuid.NewV4()),
+		Name:  name,
+		Role:  role,
+		Scope: scope,
+	}
+}
+
+func hashServiceClientSecret(secret string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(secret)))
+}
+
+// GenerateSecret replaces the credentials of the client with a new secret,
+// which is returned in the clear and can't be retrieved afterwards.
+func (c *ServiceClient) GenerateSecret() string {
+	secret := crypto.SecureToken(32)
+	hash := hashServiceClientSecret(secret)
+
+	c.SecretHash = &hash
+	c.PublicKey = nil
+
+	return secret
+}
+
+// SetPublicKey replaces the credentials of the client with a PEM encoded
+// public key, used to verify the client's assertions.
+func (c *ServiceClient) SetPublicKey(publicKey string) {
+	c.PublicKey = &publicKey
+	c.SecretHash = nil
+}
+
+func (c *ServiceClient) VerifySecret(secret string) bool {
+	if c.SecretHash == nil || secret == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(*c.SecretHash), []byte(hashServiceClientSecret(secret))) == 1
+}
+
+// AuthMethod returns how the client authenticates at the token endpoint.
+func (c *ServiceClient) AuthMethod() string {
+	if c.PublicKey != nil {
+		return ServiceClientPrivateKeyAuth
+	}
+	return ServiceClientSecretAuth
+}
+
+func (c *ServiceClient) IsRevoked() bool {
+	return c.RevokedAt != nil
+}
+
+// Revoke stops the client from obtaining tokens. Tokens already issued to
+// it are rejected too.
+func (c *ServiceClient) Revoke(tx *storage.Connection) error {
+	now := time.Now()
+	c.RevokedAt = &now
+	return tx.UpdateOnly(c, "revoked_at", "updated_at")
+}
+
+func FindServiceClientByID(tx *storage.Connection, id uuid.UUID) (*ServiceClient, error) {
+	obj := &ServiceClient{}
+	if err := tx.Q().Where("id = ?", id).First(obj); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ServiceClientNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding service client")
+	}
+
+	return obj, nil
+}
+
+func FindServiceClients(tx *storage.Connection) ([]*ServiceClient, error) {
+	clients := []*ServiceClient{}
+	if err := tx.Q().Order("created_at asc").All(&clients); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return clients, nil
+		}
+		return nil, errors.Wrap(err, "error finding service clients")
+	}
+
+	return clients, nil
+}
+
+// ServiceClientAssertion is an assertion a service client authenticated
+// with, kept until it expires so that it can't be used again.
+type ServiceClientAssertion struct {
+	ClientID  uuid.UUID `json:"client_id" db:"client_id"`
+	JTI       string    `json:"jti" db:"jti"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (ServiceClientAssertion) TableName() string {
+	tableName := "service_client_assertions"
+	return tableName
+}
+
+// UseServiceClientAssertion records that the client used the assertion
+// with the jti, and reports whether it wasn't used before. An assertion
+// can be used again once it expired.
+func UseServiceClientAssertion(tx *storage.Connection, clientID uuid.UUID, jti string, expiresAt time.Time) (bool, error) {
+	table := ServiceClientAssertion{}.TableName()
+
+	count, err := tx.RawQuery(
+		fmt.Sprintf("insert into %q (client_id, jti, expires_at) values (?, ?, ?) on conflict (client_id, jti) do update set expires_at = excluded.expires_at, created_at = now() where %q.expires_at <= now()", table, table),
+		clientID, jti, expiresAt,
+	).ExecWithCount()
+	if err != nil {
+		return false, errors.Wrap(err, "error recording service client assertion")
+	}
+
+	return count > 0, nil
+}
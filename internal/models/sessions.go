@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"fmt"
+	"reflect"
 	"sort"
 	"strings"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/gobuffalo/pop/v6"
 	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/storage"
 )
 
@@ -75,6 +77,14 @@ type Session struct {
 	IP          *string    `json:"ip,omitempty" db:"ip"`
 
 	Tag *string `json:"tag" db:"tag"`
+
+	// Impersonator is the subject of the admin that created the session
+	// on behalf of the user.
+	Impersonator *string `json:"impersonator,omitempty" db:"impersonator"`
+
+	// DPoPJKT is the thumbprint of the DPoP key the session is bound to
+	// (RFC 9449).
+	DPoPJKT *string `json:"dpop_jkt,omitempty" db:"dpop_jkt"`
 }
 
 func (Session) TableName() string {
@@ -102,6 +112,12 @@ func (s *Session) LastRefreshedAt(refreshTokenTime *time.Time) time.Time {
 	return *refreshedAt
 }
 
+// IsImpersonated reports whether the session was created by an admin on
+// behalf of the user.
+func (s *Session) IsImpersonated() bool {
+	return s.Impersonator != nil
+}
+
 func (s *Session) UpdateOnlyRefreshInfo(tx *storage.Connection) error {
 	return tx.UpdateOnly(s, "refreshed_at", "user_agent", "ip")
 }
@@ -115,16 +131,107 @@ const (
 	SessionTimedOut                           = iota
 )
 
-func (s *Session) CheckValidity(now time.Time, refreshTokenTime *time.Time, timebox, inactivityTimeout *time.Duration) SessionValidityReason {
+// SessionPolicy holds the limits that apply to a session.
+type SessionPolicy struct {
+	Timebox           *time.Duration
+	InactivityTimeout *time.Duration
+	SinglePerUser     bool
+
+	// Matched is set when a configured session policy matched, rather
+	// than the global limits applying.
+	Matched bool
+}
+
+// DetermineSessionPolicy returns the limits of the first configured session
+// policy matching the user and the session tag, or the global limits if
+// none does.
+func DetermineSessionPolicy(config *conf.SessionsConfiguration, user *User, tag *string) SessionPolicy {
+	policy := SessionPolicy{
+		Timebox:           config.Timebox,
+		InactivityTimeout: config.InactivityTimeout,
+		SinglePerUser:     config.SinglePerUser,
+	}
+
+	for _, p := range config.Policies {
+		if !sessionPolicyMatches(p, user, tag) {
+			continue
+		}
+
+		policy.Matched = true
+		if p.Timebox != nil {
+			policy.Timebox = p.Timebox
+		}
+		if p.InactivityTimeout != nil {
+			policy.InactivityTimeout = p.InactivityTimeout
+		}
+		if p.SinglePerUser != nil {
+			policy.SinglePerUser = *p.SinglePerUser
+		}
+		break
+	}
+
+	return policy
+}
+
+func sessionPolicyMatches(p conf.SessionPolicy, user *User, tag *string) bool {
+	if p.Role != "" && p.Role != user.Role {
+		return false
+	}
+
+	if p.Tag != "" && (tag == nil || *tag != p.Tag) {
+		return false
+	}
+
+	if p.SSOProvider != "" {
+		found := false
+		for _, identity := range user.Identities {
+			if identity.Provider == "sso:"+p.SSOProvider {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for key, expected := range p.AppMetadata {
+		actual, ok := user.AppMetaData[key]
+		if !ok {
+			return false
+		}
+
+		if reflect.DeepEqual(actual, expected) {
+			continue
+		}
+
+		found := false
+		if values, ok := actual.([]interface{}); ok {
+			for _, value := range values {
+				if reflect.DeepEqual(value, expected) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Session) CheckValidity(now time.Time, refreshTokenTime *time.Time, policy SessionPolicy) SessionValidityReason {
 	if s.NotAfter != nil && now.After(*s.NotAfter) {
 		return SessionPastNotAfter
 	}
 
-	if timebox != nil && *timebox != 0 && now.After(s.CreatedAt.Add(*timebox)) {
+	if policy.Timebox != nil && *policy.Timebox != 0 && now.After(s.CreatedAt.Add(*policy.Timebox)) {
 		return SessionPastTimebox
 	}
 
-	if inactivityTimeout != nil && *inactivityTimeout != 0 && now.After(s.LastRefreshedAt(refreshTokenTime).Add(*inactivityTimeout)) {
+	if policy.InactivityTimeout != nil && *policy.InactivityTimeout != 0 && now.After(s.LastRefreshedAt(refreshTokenTime).Add(*policy.InactivityTimeout)) {
 		return SessionTimedOut
 	}
 
@@ -102,3 +102,65 @@ func (ts *SessionsTestSuite) TestCalculateAALAndAMR() {
 	}
 	require.True(ts.T(), found)
 }
+
+func TestDetermineSessionPolicy(t *testing.T) {
+	eightHours := 8 * time.Hour
+	fifteenMinutes := 15 * time.Minute
+	ninetyDays := 90 * 24 * time.Hour
+	singlePerUser := true
+
+	config := &conf.SessionsConfiguration{
+		Timebox: &ninetyDays,
+		Policies: conf.SessionPolicies{
+			{Role: "admin", Timebox: &eightHours, InactivityTimeout: &fifteenMinutes},
+			{AppMetadata: map[string]interface{}{"roles": "support"}, SinglePerUser: &singlePerUser},
+			{Tag: "kiosk", InactivityTimeout: &fifteenMinutes},
+			{SSOProvider: "11111111-2222-3333-4444-555555555555", Timebox: &eightHours},
+		},
+	}
+
+	kiosk := "kiosk"
+
+	cases := []struct {
+		desc     string
+		user     *User
+		tag      *string
+		expected SessionPolicy
+	}{
+		{
+			desc:     "No match",
+			user:     &User{Role: "authenticated"},
+			expected: SessionPolicy{Timebox: &ninetyDays},
+		},
+		{
+			desc:     "Role",
+			user:     &User{Role: "admin"},
+			expected: SessionPolicy{Timebox: &eightHours, InactivityTimeout: &fifteenMinutes, Matched: true},
+		},
+		{
+			desc:     "App metadata list",
+			user:     &User{Role: "authenticated", AppMetaData: JSONMap{"roles": []interface{}{"billing", "support"}}},
+			expected: SessionPolicy{Timebox: &ninetyDays, SinglePerUser: true, Matched: true},
+		},
+		{
+			desc:     "Tag",
+			user:     &User{Role: "authenticated"},
+			tag:      &kiosk,
+			expected: SessionPolicy{Timebox: &ninetyDays, InactivityTimeout: &fifteenMinutes, Matched: true},
+		},
+		{
+			desc:     "SSO provider",
+			user:     &User{Role: "authenticated", Identities: []Identity{{Provider: "sso:11111111-2222-3333-4444-555555555555"}}},
+			expected: SessionPolicy{Timebox: &eightHours, Matched: true},
+		},
+		{
+			desc:     "First match wins",
+			user:     &User{Role: "admin", AppMetaData: JSONMap{"roles": "support"}},
+			expected: SessionPolicy{Timebox: &eightHours, InactivityTimeout: &fifteenMinutes, Matched: true},
+		},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.expected, DetermineSessionPolicy(config, c.user, c.tag), c.desc)
+	}
+}
@@ -232,6 +232,22 @@ func FindSSOProviderByDomain(tx *storage.Connection, domain string) (*SSOProvide
 	return &ssoProvider, nil
 }
 
+// FindAllSSODomains returns the domains of all SSO providers, sorted.
+func FindAllSSODomains(tx *storage.Connection) ([]string, error) {
+	var domains []SSODomain
+
+	if err := tx.Q().Order("domain asc").All(&domains); err != nil {
+		return nil, errors.Wrap(err, "error loading all SSO domains")
+	}
+
+	names := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		names = append(names, domain.Domain)
+	}
+
+	return names, nil
+}
+
 func FindAllSAMLProviders(tx *storage.Connection) ([]SSOProvider, error) {
 	var providers []SSOProvider
 
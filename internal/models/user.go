@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -68,6 +69,11 @@ type User struct {
 	DeletedAt   *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
 	IsAnonymous bool       `json:"is_anonymous" db:"is_anonymous"`
 
+	AnonymousRemindedAt    *time.Time `json:"-" db:"anonymous_reminded_at"`
+	AnonymousRetainedUntil *time.Time `json:"-" db:"anonymous_retained_until"`
+
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty" db:"deletion_scheduled_at"`
+
 	DONTUSEINSTANCEID uuid.UUID `json:"-" db:"instance_id"`
 }
 
@@ -566,13 +572,14 @@ func FindUserByID(tx *storage.Connection, id uuid.UUID) (*User, error) {
 // lock, a IsNotFound(err) error will be returned.
 func FindUserWithRefreshToken(tx *storage.Connection, token string, forUpdate bool) (*User, *RefreshToken, *Session, error) {
 	refreshToken := &RefreshToken{}
+	tokenHash := hashRefreshToken(token)
 
 	if forUpdate {
 		// pop does not provide us with a way to execute FOR UPDATE
 		// queries which lock the rows affected by the query from
 		// being accessed by any other transaction that also uses FOR
 		// UPDATE
-		if err := tx.RawQuery(fmt.Sprintf("SELECT * FROM %q WHERE token = ? LIMIT 1 FOR UPDATE SKIP LOCKED;", refreshToken.TableName()), token).First(refreshToken); err != nil {
+		if err := tx.RawQuery(fmt.Sprintf("SELECT * FROM %q WHERE (hashed AND token = ?) OR (NOT hashed AND token = ?) LIMIT 1 FOR UPDATE SKIP LOCKED;", refreshToken.TableName()), tokenHash, token).First(refreshToken); err != nil {
 			if errors.Cause(err) == sql.ErrNoRows {
 				return nil, nil, nil, RefreshTokenNotFoundError{}
 			}
@@ -582,12 +589,13 @@ func FindUserWithRefreshToken(tx *storage.Connection, token string, forUpdate bo
 	}
 
 	// once the rows are locked (if forUpdate was true), we can query again using pop
-	if err := tx.Where("token = ?", token).First(refreshToken); err != nil {
+	if err := tx.Where("(hashed and token = ?) or (not hashed and token = ?)", tokenHash, token).First(refreshToken); err != nil {
 		if errors.Cause(err) == sql.ErrNoRows {
 			return nil, nil, nil, RefreshTokenNotFoundError{}
 		}
 		return nil, nil, nil, errors.Wrap(err, "error finding refresh token")
 	}
+	refreshToken.Token = token
 
 	user, err := FindUserByID(tx, refreshToken.UserID)
 	if err != nil {
@@ -618,15 +626,128 @@ func FindUserWithRefreshToken(tx *storage.Connection, token string, forUpdate bo
 	return user, refreshToken, session, nil
 }
 
+// UserFilter narrows down the users returned by FindUsersInAudience. Zero
+// values mean "don't filter on this attribute".
+type UserFilter struct {
+	// Query is matched as a substring against the email and full name.
+	Query string
+
+	Provider    string
+	Confirmed   *bool
+	Banned      *bool
+	IsAnonymous *bool
+	HasMFA      *bool
+
+	CreatedAfter     *time.Time
+	CreatedBefore    *time.Time
+	LastSignInAfter  *time.Time
+	LastSignInBefore *time.Time
+
+	// AppMetaData must be contained in the user's app_metadata.
+	AppMetaData map[string]interface{}
+}
+
+func (f *UserFilter) apply(q *pop.Query) (*pop.Query, error) {
+	if f.Query != "" {
+		lf := "%" + f.Query + "%"
+		// we must specify the collation in order to get case insensitive search for the JSON column
+		q = q.Where("(email LIKE ? OR raw_user_meta_data->>'full_name' ILIKE ?)", lf, lf)
+	}
+
+	if f.Provider != "" {
+		q = q.Where("id in (select user_id from "+(&pop.Model{Value: Identity{}}).TableName()+" where provider = ?)", f.Provider)
+	}
+
+	if f.Confirmed != nil {
+		if *f.Confirmed {
+			q = q.Where("confirmed_at is not null")
+		} else {
+			q = q.Where("confirmed_at is null")
+		}
+	}
+
+	if f.Banned != nil {
+		if *f.Banned {
+			q = q.Where("banned_until > now()")
+		} else {
+			q = q.Where("(banned_until is null or banned_until <= now())")
+		}
+	}
+
+	if f.IsAnonymous != nil {
+		q = q.Where("is_anonymous = ?", *f.IsAnonymous)
+	}
+
+	if f.HasMFA != nil {
+		clause := "exists"
+		if !*f.HasMFA {
+			clause = "not exists"
+		}
+		q = q.Where(clause+" (select 1 from "+(&pop.Model{Value: Factor{}}).TableName()+" f where f.user_id = users.id and f.status = ?)", FactorStateVerified.String())
+	}
+
+	if f.CreatedAfter != nil {
+		q = q.Where("created_at >= ?", *f.CreatedAfter)
+	}
+
+	if f.CreatedBefore != nil {
+		q = q.Where("created_at < ?", *f.CreatedBefore)
+	}
+
+	if f.LastSignInAfter != nil {
+		q = q.Where("last_sign_in_at >= ?", *f.LastSignInAfter)
+	}
+
+	if f.LastSignInBefore != nil {
+		q = q.Where("last_sign_in_at < ?", *f.LastSignInBefore)
+	}
+
+	if len(f.AppMetaData) > 0 {
+		contains, err := json.Marshal(f.AppMetaData)
+		if err != nil {
+			return nil, errors.Wrap(err, "error encoding app_metadata filter")
+		}
+		q = q.Where("raw_app_meta_data @> ?::jsonb", string(contains))
+	}
+
+	return q, nil
+}
+
 // FindUsersInAudience finds users with the matching audience.
-func FindUsersInAudience(tx *storage.Connection, aud string, pageParams *Pagination, sortParams *SortParams, filter string) ([]*User, error) {
+func FindUsersInAudience(tx *storage.Connection, aud string, pageParams *Pagination, sortParams *SortParams, filter *UserFilter) ([]*User, error) {
 	users := []*User{}
 	q := tx.Q().Where("instance_id = ? and aud = ?", uuid.Nil, aud)
 
-	if filter != "" {
-		lf := "%" + filter + "%"
-		// we must specify the collation in order to get case insensitive search for the JSON column
-		q = q.Where("(email LIKE ? OR raw_user_meta_data->>'full_name' ILIKE ?)", lf, lf)
+	if filter != nil {
+		var err error
+		if q, err = filter.apply(q); err != nil {
+			return nil, err
+		}
+	}
+
+	if pageParams != nil && pageParams.Keyset {
+		q, field, err := applyKeyset(q, sortParams, pageParams)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := q.All(&users); err != nil {
+			return nil, err
+		}
+
+		pageParams.NextCursor = nil
+		if len(users) > int(pageParams.PerPage) {
+			users = users[:pageParams.PerPage]
+			last := users[len(users)-1]
+
+			cursor := &Cursor{Time: last.CreatedAt, ID: last.ID}
+			if field.Name == UpdatedAt {
+				cursor.Time = last.UpdatedAt
+			}
+			pageParams.NextCursor = cursor
+		}
+
+		return users, nil
 	}
 
 	if sortParams != nil && len(sortParams.Fields) > 0 {
@@ -764,6 +885,93 @@ func (u *User) RemoveUnconfirmedIdentities(tx *storage.Connection, identity *Ide
 	return nil
 }
 
+// MergeAnonymousUser moves the identities and user metadata of the
+// anonymous user into u, keeping what u already has. Identities of providers
+// u already has an identity with are left on the anonymous user, which the
+// caller is expected to delete afterwards.
+func (u *User) MergeAnonymousUser(tx *storage.Connection, anonymous *User) error {
+	if !anonymous.IsAnonymous {
+		return errors.New("only anonymous users can be merged")
+	}
+
+	identities, terr := FindIdentitiesByUserID(tx, u.ID)
+	if terr != nil {
+		return terr
+	}
+	providers := make(map[string]bool, len(identities))
+	for _, i := range identities {
+		providers[i.Provider] = true
+	}
+
+	anonymousIdentities, terr := FindIdentitiesByUserID(tx, anonymous.ID)
+	if terr != nil {
+		return terr
+	}
+	for _, i := range anonymousIdentities {
+		if providers[i.Provider] {
+			continue
+		}
+		i.UserID = u.ID
+		if terr := tx.UpdateOnly(i, "user_id"); terr != nil {
+			return terr
+		}
+	}
+
+	updates := make(map[string]interface{})
+	for key, value := range anonymous.UserMetaData {
+		if _, ok := u.UserMetaData[key]; !ok && value != nil {
+			updates[key] = value
+		}
+	}
+	if len(updates) > 0 {
+		if terr := u.UpdateUserMetaData(tx, updates); terr != nil {
+			return terr
+		}
+	}
+
+	return u.UpdateAppMetaDataProviders(tx)
+}
+
+// ScheduleDeletion schedules the deletion of the user at the provided time.
+func (u *User) ScheduleDeletion(tx *storage.Connection, at time.Time) error {
+	u.DeletionScheduledAt = &at
+	return tx.UpdateOnly(u, "deletion_scheduled_at")
+}
+
+// CancelDeletion cancels a scheduled deletion of the user.
+func (u *User) CancelDeletion(tx *storage.Connection) error {
+	u.DeletionScheduledAt = nil
+	return tx.UpdateOnly(u, "deletion_scheduled_at")
+}
+
+// FindUsersDueForDeletion finds at most limit users whose scheduled
+// deletion is due at the provided time.
+func FindUsersDueForDeletion(tx *storage.Connection, now time.Time, limit int) ([]*User, error) {
+	users := []*User{}
+	if err := tx.Q().Where("instance_id = ? and deletion_scheduled_at <= ? and deleted_at is null", uuid.Nil, now).Order("deletion_scheduled_at asc").Limit(limit).All(&users); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return users, nil
+		}
+		return nil, errors.Wrap(err, "error finding users due for deletion")
+	}
+	return users, nil
+}
+
+// LockUserDueForDeletion locks the user with id if its scheduled deletion
+// is due at the provided time. If the user is locked by another
+// transaction, or the deletion was cancelled, a UserNotFoundError is
+// returned.
+func LockUserDueForDeletion(tx *storage.Connection, id uuid.UUID, now time.Time) (*User, error) {
+	user := &User{}
+	if err := tx.RawQuery(fmt.Sprintf("select * from %q where id = ? and deletion_scheduled_at <= ? and deleted_at is null for update skip locked", user.TableName()), id, now).First(user); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, UserNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error locking user due for deletion")
+	}
+	return user, nil
+}
+
 // SoftDeleteUser performs a soft deletion on the user by obfuscating and clearing certain fields
 func (u *User) SoftDeleteUser(tx *storage.Connection) error {
 	u.Email = storage.NullString(obfuscateEmail(u, u.GetEmail()))
@@ -822,6 +1030,10 @@ func (u *User) SoftDeleteUser(tx *storage.Connection) error {
 		return err
 	}
 
+	if err := RevokeAPIKeysForUser(tx, u.ID); err != nil {
+		return err
+	}
+
 	if err := Logout(tx, u.ID); err != nil {
 		return err
 	}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// UserExport is everything stored about a user, as returned to a subject
+// access request. Passwords, factor secrets, codes and token hashes are left
+// out.
+type UserExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+
+	// User includes the user's identities with their provider data, and
+	// factors.
+	User *User `json:"user"`
+
+	Sessions        []*SessionExport      `json:"sessions"`
+	APIKeys         []*APIKey             `json:"api_keys"`
+	OneTimeTokens   []*OneTimeTokenExport `json:"one_time_tokens"`
+	MFAChallenges   []*Challenge          `json:"mfa_challenges"`
+	FlowStates      []*FlowStateExport    `json:"flow_states"`
+	DeviceCodes     []*DeviceCodeExport   `json:"device_codes"`
+	AuditLogEntries []*AuditLogEntry      `json:"audit_log_entries"`
+}
+
+// SessionExport is a session of an exported user. Unlike sessions in other
+// responses it includes the ID, which audit log entries refer to.
+type SessionExport struct {
+	ID uuid.UUID `json:"id"`
+	*Session
+}
+
+// OneTimeTokenExport is the metadata of a one-time token of an exported
+// user, without the token hash.
+type OneTimeTokenExport struct {
+	ID        uuid.UUID `json:"id"`
+	TokenType string    `json:"token_type"`
+	RelatesTo string    `json:"relates_to"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FlowStateExport is a PKCE flow of an exported user, without the auth
+// code, code challenge and provider tokens.
+type FlowStateExport struct {
+	ID                   uuid.UUID  `json:"id"`
+	AuthenticationMethod string     `json:"authentication_method"`
+	CodeChallengeMethod  string     `json:"code_challenge_method"`
+	ProviderType         string     `json:"provider_type"`
+	AuthCodeIssuedAt     *time.Time `json:"auth_code_issued_at"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+// DeviceCodeExport is a device code the exported user approved or denied,
+// without the device and user codes.
+type DeviceCodeExport struct {
+	ID           uuid.UUID        `json:"id"`
+	ClientID     *string          `json:"client_id,omitempty"`
+	Status       DeviceCodeStatus `json:"status"`
+	LastPolledAt *time.Time       `json:"last_polled_at,omitempty"`
+	ExpiresAt    time.Time        `json:"expires_at"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+}
+
+// NewUserExport collects everything stored about user.
+func NewUserExport(tx *storage.Connection, user *User, now time.Time) (*UserExport, error) {
+	u := *user
+	if err := tx.Load(&u, "Identities", "Factors"); err != nil {
+		return nil, errors.Wrap(err, "error loading user identities and factors")
+	}
+
+	export := &UserExport{
+		ExportedAt:      now,
+		User:            &u,
+		Sessions:        []*SessionExport{},
+		OneTimeTokens:   []*OneTimeTokenExport{},
+		MFAChallenges:   []*Challenge{},
+		FlowStates:      []*FlowStateExport{},
+		DeviceCodes:     []*DeviceCodeExport{},
+		AuditLogEntries: []*AuditLogEntry{},
+	}
+
+	sessions := []*Session{}
+	if err := tx.Eager("AMRClaims").Where("user_id = ?", user.ID).Order("created_at asc").All(&sessions); err != nil && errors.Cause(err) != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "error finding user sessions")
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, &SessionExport{ID: session.ID, Session: session})
+	}
+
+	apiKeys, err := FindAPIKeysByUserID(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	export.APIKeys = apiKeys
+
+	tokens := []*OneTimeToken{}
+	if err := tx.Where("user_id = ?", user.ID).Order("created_at asc").All(&tokens); err != nil && errors.Cause(err) != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "error finding user one-time tokens")
+	}
+	for _, token := range tokens {
+		export.OneTimeTokens = append(export.OneTimeTokens, &OneTimeTokenExport{
+			ID:        token.ID,
+			TokenType: token.TokenType.String(),
+			RelatesTo: token.RelatesTo,
+			CreatedAt: token.CreatedAt,
+			UpdatedAt: token.UpdatedAt,
+		})
+	}
+
+	// challenges hold the IP address they were created from
+	if err := tx.Where(fmt.Sprintf("factor_id in (select id from %q where user_id = ?)", Factor{}.TableName()), user.ID).Order("created_at asc").All(&export.MFAChallenges); err != nil && errors.Cause(err) != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "error finding user MFA challenges")
+	}
+
+	flowStates := []*FlowState{}
+	if err := tx.Where("user_id = ?", user.ID).Order("created_at asc").All(&flowStates); err != nil && errors.Cause(err) != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "error finding user flow states")
+	}
+	for _, flowState := range flowStates {
+		export.FlowStates = append(export.FlowStates, &FlowStateExport{
+			ID:                   flowState.ID,
+			AuthenticationMethod: flowState.AuthenticationMethod,
+			CodeChallengeMethod:  flowState.CodeChallengeMethod,
+			ProviderType:         flowState.ProviderType,
+			AuthCodeIssuedAt:     flowState.AuthCodeIssuedAt,
+			CreatedAt:            flowState.CreatedAt,
+			UpdatedAt:            flowState.UpdatedAt,
+		})
+	}
+
+	deviceCodes := []*DeviceCode{}
+	if err := tx.Where("user_id = ?", user.ID).Order("created_at asc").All(&deviceCodes); err != nil && errors.Cause(err) != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "error finding user device codes")
+	}
+	for _, deviceCode := range deviceCodes {
+		export.DeviceCodes = append(export.DeviceCodes, &DeviceCodeExport{
+			ID:           deviceCode.ID,
+			ClientID:     deviceCode.ClientID,
+			Status:       deviceCode.Status,
+			LastPolledAt: deviceCode.LastPolledAt,
+			ExpiresAt:    deviceCode.ExpiresAt,
+			CreatedAt:    deviceCode.CreatedAt,
+			UpdatedAt:    deviceCode.UpdatedAt,
+		})
+	}
+
+	// entries the user performed, and entries about the user, found with
+	// the audit_log_entries_actor_id_idx and
+	// audit_log_entries_target_user_id_idx indexes
+	if err := tx.Q().Where("instance_id = ? and (payload->>'actor_id' = ? or coalesce(payload->>'target_user_id', payload->'traits'->>'user_id') = ?)", uuid.Nil, user.ID.String(), user.ID.String()).Order("created_at asc").All(&export.AuditLogEntries); err != nil && errors.Cause(err) != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "error finding user audit log entries")
+	}
+
+	return export, nil
+}
@@ -92,6 +92,14 @@ func IsRedirectURLValid(config *conf.GlobalConfiguration, redirectURL string) bo
 		return true
 	}
 
+	// The hosted UI pages link to each other, for example from the password
+	// recovery email to the password reset page
+	if config.HostedUI.Enabled && rerr == nil {
+		if ui, err := url.Parse(strings.TrimSuffix(config.API.ExternalURL, "/") + "/ui/"); err == nil && refurl.Scheme == ui.Scheme && refurl.Host == ui.Host && strings.HasPrefix(refurl.Path, ui.Path) {
+			return true
+		}
+	}
+
 	// For case when user came from mobile app or other permitted resource - redirect back
 	for _, pattern := range config.URIAllowListMap {
 		if pattern.Match(redirectURL) {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"os"
 	"os/signal"
 	"sync"
 	"syscall"
@@ -18,7 +19,7 @@ func init() {
 }
 
 func main() {
-	execCtx, execCancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGHUP, syscall.SIGINT)
+	execCtx, execCancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer execCancel()
 
 	go func() {
@@ -26,6 +27,27 @@ func main() {
 		logrus.Info("received graceful shutdown signal")
 	}()
 
+	// SIGHUP reloads the configuration of the API server and shuts down
+	// every other command
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go func() {
+		for {
+			select {
+			case <-execCtx.Done():
+				return
+
+			case <-hup:
+				if !cmd.Reload() {
+					execCancel()
+					return
+				}
+			}
+		}
+	}()
+
 	// command is expected to obey the cancellation signal on execCtx and
 	// block while it is running
 	if err := cmd.RootCommand().ExecuteContext(execCtx); err != nil {
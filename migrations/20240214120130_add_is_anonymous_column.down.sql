@@ -0,0 +1,5 @@
+do $$ begin
+  drop index if exists {{ index .Options "Namespace" }}.users_is_anonymous_idx;
+
+  alter table {{ index .Options "Namespace" }}.users drop column if exists is_anonymous;
+end $$;
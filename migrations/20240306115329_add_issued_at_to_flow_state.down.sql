@@ -0,0 +1,3 @@
+do $$ begin
+alter table {{ index .Options "Namespace" }}.flow_state drop column if exists auth_code_issued_at;
+end $$
@@ -0,0 +1,3 @@
+do $$ begin
+alter table {{ index .Options "Namespace" }}.saml_providers drop column if exists name_id_format;
+end $$
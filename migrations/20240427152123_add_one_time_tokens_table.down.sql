@@ -0,0 +1,5 @@
+do $$ begin
+  drop table if exists {{ index .Options "Namespace" }}.one_time_tokens;
+
+  drop type if exists one_time_token_type;
+end $$;
@@ -0,0 +1,5 @@
+do $$ begin
+  drop table if exists {{ index .Options "Namespace" }}.audit_log_export_cursors;
+
+  drop index if exists {{ index .Options "Namespace" }}.audit_log_entries_created_at_id_idx;
+end $$;
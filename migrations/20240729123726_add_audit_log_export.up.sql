@@ -0,0 +1,14 @@
+do $$ begin
+  create index if not exists audit_log_entries_created_at_id_idx on {{ index .Options "Namespace" }}.audit_log_entries (created_at, id);
+
+  create table if not exists {{ index .Options "Namespace" }}.audit_log_export_cursors (
+    name text primary key,
+    last_created_at timestamp with time zone null,
+    last_id uuid null,
+    updated_at timestamp with time zone not null default now()
+  );
+
+  comment on table {{ index .Options "Namespace" }}.audit_log_export_cursors is 'Auth: Position of each audit log export consumer.';
+
+  alter table {{ index .Options "Namespace" }}.audit_log_export_cursors enable row level security;
+end $$;
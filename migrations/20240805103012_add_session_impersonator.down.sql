@@ -0,0 +1,3 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.sessions drop column if exists impersonator;
+end $$;
@@ -0,0 +1,5 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.sessions add column if not exists impersonator text null;
+
+  comment on column {{ index .Options "Namespace" }}.sessions.impersonator is 'Auth: Set to the subject of the admin that created the session on behalf of the user. Such sessions cannot be refreshed and cannot change credentials or MFA.';
+end $$;
@@ -0,0 +1,13 @@
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.config_overrides (
+    key text primary key,
+    value text not null,
+    secret boolean not null default false,
+    created_at timestamp with time zone not null default now(),
+    updated_at timestamp with time zone not null default now()
+  );
+
+  comment on table {{ index .Options "Namespace" }}.config_overrides is 'Auth: Configuration set by admins, applied over the environment configuration. Secrets are encrypted.';
+
+  alter table {{ index .Options "Namespace" }}.config_overrides enable row level security;
+end $$;
@@ -0,0 +1,23 @@
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.device_codes (
+    id uuid primary key,
+    device_code_hash text not null,
+    user_code text not null,
+    client_id text null,
+    status text not null default 'pending' check (status in ('pending', 'approved', 'denied')),
+    user_id uuid null references {{ index .Options "Namespace" }}.users (id) on delete cascade,
+    polling_interval integer not null,
+    last_polled_at timestamp with time zone null,
+    expires_at timestamp with time zone not null,
+    created_at timestamp with time zone not null default now(),
+    updated_at timestamp with time zone not null default now()
+  );
+
+  create unique index if not exists device_codes_device_code_hash_key on {{ index .Options "Namespace" }}.device_codes (device_code_hash);
+  create unique index if not exists device_codes_user_code_key on {{ index .Options "Namespace" }}.device_codes (user_code);
+  create index if not exists device_codes_expires_at_idx on {{ index .Options "Namespace" }}.device_codes (expires_at);
+
+  comment on table {{ index .Options "Namespace" }}.device_codes is 'Auth: Pending device authorization grants (RFC 8628). Device codes are stored hashed.';
+
+  alter table {{ index .Options "Namespace" }}.device_codes enable row level security;
+end $$;
@@ -0,0 +1,3 @@
+do $$ begin
+  drop table if exists {{ index .Options "Namespace" }}.service_clients;
+end $$;
@@ -0,0 +1,18 @@
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.service_clients (
+    id uuid primary key,
+    name text not null,
+    role text not null,
+    scope text not null default '',
+    secret_hash text null,
+    public_key text null,
+    revoked_at timestamp with time zone null,
+    created_at timestamp with time zone not null default now(),
+    updated_at timestamp with time zone not null default now(),
+    constraint service_clients_credentials check (secret_hash is not null or public_key is not null)
+  );
+
+  comment on table {{ index .Options "Namespace" }}.service_clients is 'Auth: Backend services that obtain access tokens with the client_credentials grant. Secrets are stored hashed.';
+
+  alter table {{ index .Options "Namespace" }}.service_clients enable row level security;
+end $$;
@@ -0,0 +1,20 @@
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.api_keys (
+    id uuid primary key,
+    user_id uuid not null references {{ index .Options "Namespace" }}.users(id) on delete cascade,
+    name text not null,
+    key_prefix text not null,
+    key_hash text not null unique,
+    scope text not null default '',
+    expires_at timestamp with time zone null,
+    last_used_at timestamp with time zone null,
+    created_at timestamp with time zone not null default now(),
+    updated_at timestamp with time zone not null default now()
+  );
+
+  create index if not exists api_keys_user_id_idx on {{ index .Options "Namespace" }}.api_keys (user_id);
+
+  comment on table {{ index .Options "Namespace" }}.api_keys is 'Auth: Personal API keys of users, exchanged for short-lived access tokens. Keys are stored hashed.';
+
+  alter table {{ index .Options "Namespace" }}.api_keys enable row level security;
+end $$;
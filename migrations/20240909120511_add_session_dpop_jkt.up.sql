@@ -0,0 +1,5 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.sessions add column if not exists dpop_jkt text null;
+
+  comment on column {{ index .Options "Namespace" }}.sessions.dpop_jkt is 'Auth: JWK SHA-256 thumbprint of the DPoP key the session is bound to. Refreshing the session requires a DPoP proof signed with that key.';
+end $$;
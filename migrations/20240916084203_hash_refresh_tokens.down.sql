@@ -0,0 +1,14 @@
+-- Older versions look refresh tokens up by their plaintext value, so hashed
+-- refresh tokens would silently stop working after rolling back. Refuse to
+-- roll back while any exist. To roll back anyway, delete them first, which
+-- signs out every session refreshed since the upgrade:
+--
+--   delete from refresh_tokens where hashed is true;
+do $$ begin
+  if exists (select 1 from {{ index .Options "Namespace" }}.refresh_tokens where hashed is true) then
+    raise exception 'refresh_tokens holds hashed refresh tokens, which older versions cannot look up. Delete them with "delete from refresh_tokens where hashed is true" to roll back, which signs out their sessions.';
+  end if;
+
+  alter table {{ index .Options "Namespace" }}.refresh_tokens drop column if exists salt;
+  alter table {{ index .Options "Namespace" }}.refresh_tokens drop column if exists hashed;
+end $$;
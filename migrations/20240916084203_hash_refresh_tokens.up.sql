@@ -0,0 +1,7 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.refresh_tokens add column if not exists hashed boolean not null default false;
+  alter table {{ index .Options "Namespace" }}.refresh_tokens add column if not exists salt text null;
+
+  comment on column {{ index .Options "Namespace" }}.refresh_tokens.hashed is 'Auth: Whether the token column holds the SHA-256 hash of the refresh token. Existing plaintext tokens are hashed the next time they are refreshed.';
+  comment on column {{ index .Options "Namespace" }}.refresh_tokens.salt is 'Auth: Random value the refresh token was derived from its parent with, so that a client retrying a refresh can be given the same token again.';
+end $$;
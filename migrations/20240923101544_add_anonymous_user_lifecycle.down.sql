@@ -0,0 +1,4 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.users drop column if exists anonymous_retained_until;
+  alter table {{ index .Options "Namespace" }}.users drop column if exists anonymous_reminded_at;
+end $$;
@@ -0,0 +1,7 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.users add column if not exists anonymous_reminded_at timestamptz null;
+  alter table {{ index .Options "Namespace" }}.users add column if not exists anonymous_retained_until timestamptz null;
+
+  comment on column {{ index .Options "Namespace" }}.users.anonymous_reminded_at is 'Auth: When the anonymous user expiry hook was last called with a reminder for this anonymous user.';
+  comment on column {{ index .Options "Namespace" }}.users.anonymous_retained_until is 'Auth: The anonymous user expiry hook rejected deleting this anonymous user, which is kept until then.';
+end $$;
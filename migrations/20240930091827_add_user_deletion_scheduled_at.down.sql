@@ -0,0 +1,4 @@
+do $$ begin
+  drop index if exists {{ index .Options "Namespace" }}.users_deletion_scheduled_at_idx;
+  alter table {{ index .Options "Namespace" }}.users drop column if exists deletion_scheduled_at;
+end $$;
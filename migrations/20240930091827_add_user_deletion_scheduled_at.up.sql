@@ -0,0 +1,7 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.users add column if not exists deletion_scheduled_at timestamptz null;
+
+  create index if not exists users_deletion_scheduled_at_idx on {{ index .Options "Namespace" }}.users (deletion_scheduled_at) where deletion_scheduled_at is not null;
+
+  comment on column {{ index .Options "Namespace" }}.users.deletion_scheduled_at is 'Auth: When the user is deleted, after requesting the deletion of their account. Signing in before then cancels the deletion.';
+end $$;
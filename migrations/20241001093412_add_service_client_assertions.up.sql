@@ -0,0 +1,15 @@
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.service_client_assertions (
+    client_id uuid not null references {{ index .Options "Namespace" }}.service_clients(id) on delete cascade,
+    jti text not null,
+    expires_at timestamp with time zone not null,
+    created_at timestamp with time zone not null default now(),
+    primary key (client_id, jti)
+  );
+
+  create index if not exists service_client_assertions_expires_at_idx on {{ index .Options "Namespace" }}.service_client_assertions (expires_at);
+
+  comment on table {{ index .Options "Namespace" }}.service_client_assertions is 'Auth: The jti of client assertions already used by service clients, kept until they expire so that they can''t be replayed.';
+
+  alter table {{ index .Options "Namespace" }}.service_client_assertions enable row level security;
+end $$;
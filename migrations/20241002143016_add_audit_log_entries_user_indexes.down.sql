@@ -0,0 +1,5 @@
+-- no-transaction
+
+drop index concurrently if exists {{ index .Options "Namespace" }}.audit_log_entries_target_user_id_idx;
+
+drop index concurrently if exists {{ index .Options "Namespace" }}.audit_log_entries_actor_id_idx;
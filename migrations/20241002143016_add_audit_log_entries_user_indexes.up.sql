@@ -0,0 +1,8 @@
+-- no-transaction
+-- finds the audit log entries of a user for user data exports. The indexes
+-- are built concurrently, so that audit_log_entries isn't locked against
+-- writes while they are built, which takes a while on large audit logs.
+
+create index concurrently if not exists audit_log_entries_actor_id_idx on {{ index .Options "Namespace" }}.audit_log_entries ((payload->>'actor_id'));
+
+create index concurrently if not exists audit_log_entries_target_user_id_idx on {{ index .Options "Namespace" }}.audit_log_entries ((coalesce(payload->>'target_user_id', payload->'traits'->>'user_id')));
@@ -0,0 +1,15 @@
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.dpop_proofs (
+    jkt text not null,
+    jti text not null,
+    expires_at timestamp with time zone not null,
+    created_at timestamp with time zone not null default now(),
+    primary key (jkt, jti)
+  );
+
+  create index if not exists dpop_proofs_expires_at_idx on {{ index .Options "Namespace" }}.dpop_proofs (expires_at);
+
+  comment on table {{ index .Options "Namespace" }}.dpop_proofs is 'Auth: The jti of DPoP proofs already used, by the JWK SHA-256 thumbprint of their key, kept until they expire so that they can''t be replayed.';
+
+  alter table {{ index .Options "Namespace" }}.dpop_proofs enable row level security;
+end $$;
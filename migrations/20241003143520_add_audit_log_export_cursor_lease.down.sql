@@ -0,0 +1,3 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.audit_log_export_cursors drop column if exists leased_until;
+end $$;
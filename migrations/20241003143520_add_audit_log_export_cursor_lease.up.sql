@@ -0,0 +1,5 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.audit_log_export_cursors add column if not exists leased_until timestamp with time zone null;
+
+  comment on column {{ index .Options "Namespace" }}.audit_log_export_cursors.leased_until is 'Auth: Until when an exporter sends the entries following the cursor. Other exporters skip the cursor until then.';
+end $$;
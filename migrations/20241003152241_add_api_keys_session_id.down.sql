@@ -0,0 +1,3 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.api_keys drop column if exists session_id;
+end $$;
@@ -0,0 +1,7 @@
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.api_keys add column if not exists session_id uuid null references {{ index .Options "Namespace" }}.sessions(id) on delete set null;
+
+  create index if not exists api_keys_session_id_idx on {{ index .Options "Namespace" }}.api_keys (session_id);
+
+  comment on column {{ index .Options "Namespace" }}.api_keys.session_id is 'Auth: The session of the access tokens obtained with the key. Exchanging the key again reuses it.';
+end $$;
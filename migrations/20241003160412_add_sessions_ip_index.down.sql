@@ -0,0 +1,3 @@
+-- no-transaction
+
+drop index concurrently if exists {{ index .Options "Namespace" }}.sessions_ip_idx;
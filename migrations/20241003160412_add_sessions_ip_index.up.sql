@@ -0,0 +1,6 @@
+-- no-transaction
+-- counts the anonymous sessions created from an IP address, to limit them.
+-- The index is built concurrently, so that sessions isn't locked against
+-- writes while it is built.
+
+create index concurrently if not exists sessions_ip_idx on {{ index .Options "Namespace" }}.sessions (ip);